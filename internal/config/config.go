@@ -1,92 +1,486 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/svosadtsia/csync/internal/schedule"
 )
 
 // Config represents the application configuration
 type Config struct {
-	GoogleDrive GoogleDriveConfig `json:"google_drive"`
-	PCloud      PCloudConfig      `json:"pcloud"`
-	General     GeneralConfig     `json:"general"`
-	Optional    *OptionalConfig   `json:"optional,omitempty"`
+	GoogleDrive GoogleDriveConfig `json:"google_drive" yaml:"google_drive"`
+	PCloud      PCloudConfig      `json:"pcloud" yaml:"pcloud"`
+	S3          S3Config          `json:"s3" yaml:"s3"`
+	SFTP        SFTPConfig        `json:"sftp" yaml:"sftp"`
+	OneDrive    OneDriveConfig    `json:"onedrive" yaml:"onedrive"`
+	WebDAV      WebDAVConfig      `json:"webdav" yaml:"webdav"`
+	B2          B2Config          `json:"b2" yaml:"b2"`
+	General     GeneralConfig     `json:"general" yaml:"general"`
+	Optional    *OptionalConfig   `json:"optional,omitempty" yaml:"optional,omitempty"`
+
+	// Named sync profiles, each overriding the source, provider and
+	// destinations above; selected per run with Manager.SetProfile
+	Profiles map[string]ProfileConfig `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+
+	path string // File the configuration was loaded from, if any
+}
+
+// ProfileConfig is a named sync job. Its non-empty fields replace the
+// top-level ones; everything else is inherited.
+type ProfileConfig struct {
+	SourcePath  string            `json:"source_path,omitempty" yaml:"source_path,omitempty"`
+	Provider    string            `json:"provider,omitempty" yaml:"provider,omitempty"` // gdrive, pcloud, s3, sftp, onedrive, webdav, b2 or all
+	GoogleDrive FolderDestination `json:"google_drive,omitempty" yaml:"google_drive,omitempty"`
+	PCloud      FolderDestination `json:"pcloud,omitempty" yaml:"pcloud,omitempty"`
+	S3          S3Destination     `json:"s3,omitempty" yaml:"s3,omitempty"`
+	SFTP        SFTPDestination   `json:"sftp,omitempty" yaml:"sftp,omitempty"`
+	OneDrive    FolderDestination `json:"onedrive,omitempty" yaml:"onedrive,omitempty"`
+	WebDAV      WebDAVDestination `json:"webdav,omitempty" yaml:"webdav,omitempty"`
+	B2          S3Destination     `json:"b2,omitempty" yaml:"b2,omitempty"`
+}
+
+// FolderDestination overrides a Google Drive, pCloud or OneDrive destination folder
+type FolderDestination struct {
+	FolderID        string `json:"folder_id,omitempty" yaml:"folder_id,omitempty"`
+	DestinationPath string `json:"destination_path,omitempty" yaml:"destination_path,omitempty"`
+}
+
+// S3Destination overrides the S3 or B2 bucket and key prefix
+type S3Destination struct {
+	Bucket string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+}
+
+// SFTPDestination overrides the SFTP destination folder
+type SFTPDestination struct {
+	RemoteBasePath string `json:"remote_base_path,omitempty" yaml:"remote_base_path,omitempty"`
 }
 
 // GoogleDriveConfig contains Google Drive API configuration
 type GoogleDriveConfig struct {
 	// Required fields
-	CredentialsPath string   `json:"credentials_path"`
-	TokenPath       string   `json:"token_path"`
-	Scopes          []string `json:"scopes,omitempty"`
+	CredentialsPath string   `json:"credentials_path" yaml:"credentials_path"`
+	TokenPath       string   `json:"token_path" yaml:"token_path"` // Not used with a service account
+	Scopes          []string `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+
+	// Optional authentication settings
+	AuthMode           string `json:"auth_mode,omitempty" yaml:"auth_mode,omitempty"`                     // oauth or service_account, detected from the credentials file when empty
+	ImpersonateSubject string `json:"impersonate_subject,omitempty" yaml:"impersonate_subject,omitempty"` // User a service account acts as with domain-wide delegation
+
+	// DuplicatePolicy picks the file to use when a folder holds several
+	// with the same name: keep_newest (default), update_first or error
+	DuplicatePolicy string `json:"duplicate_policy,omitempty" yaml:"duplicate_policy,omitempty"`
 
 	// Optional fields - specify either folder_id OR destination_path
-	FolderID        string            `json:"folder_id,omitempty"`        // Specific folder ID
-	DestinationPath string            `json:"destination_path,omitempty"` // Folder path like "/backups/documents"
-	Metadata        map[string]string `json:"metadata,omitempty"`
+	FolderID        string            `json:"folder_id,omitempty" yaml:"folder_id,omitempty"`               // Specific folder ID
+	DestinationPath string            `json:"destination_path,omitempty" yaml:"destination_path,omitempty"` // Folder path like "/backups/documents"
+	Metadata        map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 }
 
+// Sync directions
+const (
+	SyncPush          = "push"
+	SyncPull          = "pull"
+	SyncBidirectional = "bidirectional"
+)
+
+// When the daemon calls the notification webhook
+const (
+	NotifyAlways  = "always"
+	NotifyFailure = "failure"
+	NotifyNever   = "never"
+)
+
+// Notification webhook payload formats
+const (
+	WebhookJSON  = "json"
+	WebhookSlack = "slack"
+)
+
+// Client-side compression algorithms
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Google Drive authentication modes
+const (
+	GoogleDriveOAuth          = "oauth"
+	GoogleDriveServiceAccount = "service_account"
+)
+
+// Google Drive duplicate name policies
+const (
+	DuplicateKeepNewest  = "keep_newest"
+	DuplicateUpdateFirst = "update_first"
+	DuplicateError       = "error"
+)
+
 // PCloudConfig contains pCloud API configuration
 type PCloudConfig struct {
 	// Required fields - can be set via environment variables
-	Username string `json:"username,omitempty"` // Can use PCLOUD_USERNAME env var
-	Password string `json:"password,omitempty"` // Can use PCLOUD_PASSWORD env var
-	APIHost  string `json:"api_host,omitempty"`
+	Username string `json:"username,omitempty" yaml:"username,omitempty"` // Can use PCLOUD_USERNAME env var
+	Password string `json:"password,omitempty" yaml:"password,omitempty"` // Can use PCLOUD_PASSWORD env var
+	APIHost  string `json:"api_host,omitempty" yaml:"api_host,omitempty"` // Overrides region
+	Region   string `json:"region,omitempty" yaml:"region,omitempty"`     // us or eu, detected from the account when empty
+
+	// Optional fields - specify either folder_id OR destination_path
+	FolderID        string `json:"folder_id,omitempty" yaml:"folder_id,omitempty"`               // Specific folder ID
+	DestinationPath string `json:"destination_path,omitempty" yaml:"destination_path,omitempty"` // Folder path like "/backups/photos"
+}
+
+// S3Config contains Amazon S3 (or S3-compatible storage) configuration
+type S3Config struct {
+	// Required fields - credentials can be set via environment variables
+	Bucket          string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Region          string `json:"region,omitempty" yaml:"region,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty" yaml:"access_key_id,omitempty"`         // Can use AWS_ACCESS_KEY_ID env var
+	SecretAccessKey string `json:"secret_access_key,omitempty" yaml:"secret_access_key,omitempty"` // Can use AWS_SECRET_ACCESS_KEY env var
+
+	// Optional fields
+	Prefix   string `json:"prefix,omitempty" yaml:"prefix,omitempty"`     // Key prefix like "backups/documents"
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"` // Custom endpoint URL for MinIO and other S3-compatible stores
+}
+
+// SFTPConfig contains SFTP server configuration. Either a private key or a
+// password (or both) must be set.
+type SFTPConfig struct {
+	// Required fields
+	Host           string `json:"host,omitempty" yaml:"host,omitempty"`
+	User           string `json:"user,omitempty" yaml:"user,omitempty"`
+	PrivateKeyPath string `json:"private_key_path,omitempty" yaml:"private_key_path,omitempty"`
+	Password       string `json:"password,omitempty" yaml:"password,omitempty"` // Can use SFTP_PASSWORD env var
+
+	// Optional fields
+	Port                  int    `json:"port,omitempty" yaml:"port,omitempty"`                                         // Defaults to 22
+	RemoteBasePath        string `json:"remote_base_path,omitempty" yaml:"remote_base_path,omitempty"`                 // Folder path like "/srv/backups"
+	KnownHostsPath        string `json:"known_hosts_path,omitempty" yaml:"known_hosts_path,omitempty"`                 // Defaults to ~/.ssh/known_hosts
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key,omitempty" yaml:"insecure_ignore_host_key,omitempty"` // Skip host key verification
+}
+
+// WebDAVDestination overrides the WebDAV destination folder
+type WebDAVDestination struct {
+	RemoteBasePath string `json:"remote_base_path,omitempty" yaml:"remote_base_path,omitempty"`
+}
+
+// OneDriveConfig contains Microsoft OneDrive (Graph API) configuration
+type OneDriveConfig struct {
+	// Required fields - the secret can be set via an environment variable
+	ClientID     string `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty" yaml:"client_secret,omitempty"` // Can use ONEDRIVE_CLIENT_SECRET env var
+	TokenPath    string `json:"token_path,omitempty" yaml:"token_path,omitempty"`
 
 	// Optional fields - specify either folder_id OR destination_path
-	FolderID        string `json:"folder_id,omitempty"`        // Specific folder ID
-	DestinationPath string `json:"destination_path,omitempty"` // Folder path like "/backups/photos"
+	TenantID        string `json:"tenant_id,omitempty" yaml:"tenant_id,omitempty"`               // Defaults to "common"
+	RedirectURL     string `json:"redirect_url,omitempty" yaml:"redirect_url,omitempty"`         // Registered redirect URI, defaults to http://localhost
+	FolderID        string `json:"folder_id,omitempty" yaml:"folder_id,omitempty"`               // Specific folder (drive item) ID
+	DestinationPath string `json:"destination_path,omitempty" yaml:"destination_path,omitempty"` // Folder path like "/backups/documents"
+}
+
+// WebDAVConfig contains WebDAV server (Nextcloud, ownCloud) configuration
+type WebDAVConfig struct {
+	// Required fields - the password can be set via an environment variable
+	URL      string `json:"url,omitempty" yaml:"url,omitempty"` // Like "https://cloud.example.com/remote.php/dav/files/me"
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"` // App password; can use WEBDAV_PASSWORD env var
+
+	// Optional fields
+	RemoteBasePath string `json:"remote_base_path,omitempty" yaml:"remote_base_path,omitempty"` // Folder path like "/backups"
+}
+
+// B2Config contains Backblaze B2 configuration
+type B2Config struct {
+	// Required fields - credentials can be set via environment variables
+	KeyID          string `json:"key_id,omitempty" yaml:"key_id,omitempty"`                   // Can use B2_APPLICATION_KEY_ID env var
+	ApplicationKey string `json:"application_key,omitempty" yaml:"application_key,omitempty"` // Can use B2_APPLICATION_KEY env var
+	Bucket         string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+
+	// Optional fields
+	Prefix string `json:"prefix,omitempty" yaml:"prefix,omitempty"` // File name prefix like "backups/documents"
 }
 
 // GeneralConfig contains general application settings
 type GeneralConfig struct {
 	// Required/Core settings
-	SourcePath     string   `json:"source_path"` // Local directory to sync from
-	MaxConcurrency int      `json:"max_concurrency"`
-	RetryAttempts  int      `json:"retry_attempts"`
-	ChunkSizeBytes int64    `json:"chunk_size_bytes"`
-	IgnorePatterns []string `json:"ignore_patterns"`
+	SourcePath     string   `json:"source_path" yaml:"source_path"`               // Local directory to sync from
+	Provider       string   `json:"provider,omitempty" yaml:"provider,omitempty"` // Default provider: gdrive, pcloud, s3, sftp, onedrive, webdav, b2 or all
+	MaxConcurrency int      `json:"max_concurrency" yaml:"max_concurrency"`
+	RetryAttempts  int      `json:"retry_attempts" yaml:"retry_attempts"`
+	ChunkSizeBytes int64    `json:"chunk_size_bytes" yaml:"chunk_size_bytes"`
+	IgnorePatterns []string `json:"ignore_patterns" yaml:"ignore_patterns"`
 
 	// Optional settings
-	IncludePatterns []string `json:"include_patterns,omitempty"`
+	IncludePatterns []string `json:"include_patterns,omitempty" yaml:"include_patterns,omitempty"`
+
+	// ForceInclude re-includes matching paths regardless of any ignore,
+	// include, size or quarantine rule. It is evaluated last.
+	ForceInclude []string `json:"force_include,omitempty" yaml:"force_include,omitempty"`
+
+	// Content categories (image, video, audio, text, archive, other) sniffed
+	// from the first bytes of each file that passes the pattern filters.
+	// Opt-in, since it reads from every file.
+	IncludeContentTypes []string `json:"include_content_types,omitempty" yaml:"include_content_types,omitempty"`
+	ExcludeContentTypes []string `json:"exclude_content_types,omitempty" yaml:"exclude_content_types,omitempty"`
+
+	// Files smaller than MinFileSize or larger than MaxFileSize bytes are
+	// skipped after the pattern filters; 0 means no bound
+	MinFileSize int64 `json:"min_file_size,omitempty" yaml:"min_file_size,omitempty"`
+	MaxFileSize int64 `json:"max_file_size,omitempty" yaml:"max_file_size,omitempty"`
+
+	// ModifiedSince skips files last modified before a cutoff: an RFC3339
+	// timestamp, or a duration like "24h" counted back from the sync start
+	ModifiedSince string `json:"modified_since,omitempty" yaml:"modified_since,omitempty"`
+
+	// FollowSymlinks syncs the targets of symlinks in place of the links,
+	// which are skipped otherwise
+	FollowSymlinks bool `json:"follow_symlinks,omitempty" yaml:"follow_symlinks,omitempty"`
+
+	// HashAlgorithm is the content hash computed for every file: md5, sha1
+	// or sha256. Unset, it is the provider's preferred reported hash (md5
+	// for providers that report none), so content is compared rather than
+	// size and modification time.
+	HashAlgorithm string `json:"hash_algorithm,omitempty" yaml:"hash_algorithm,omitempty"`
+
+	// Files still being written. InProgressPatterns are never synced, and a
+	// file is skipped while a sibling named file+suffix exists for any of
+	// LockSuffixes (data.db while data.db-wal exists). Unset lists use
+	// the defaults; an empty list disables the check.
+	InProgressPatterns []string `json:"in_progress_patterns,omitempty" yaml:"in_progress_patterns,omitempty"`
+	LockSuffixes       []string `json:"lock_suffixes,omitempty" yaml:"lock_suffixes,omitempty"`
+
+	// Separate limits for cheap metadata calls (folder lookups/creation,
+	// existence checks) and byte transfers; both default to MaxConcurrency
+	MetadataConcurrency int `json:"metadata_concurrency,omitempty" yaml:"metadata_concurrency,omitempty"`
+	UploadConcurrency   int `json:"upload_concurrency,omitempty" yaml:"upload_concurrency,omitempty"`
+
+	// Named pattern profiles; the active one is merged over the lists above
+	PatternProfiles      map[string]PatternProfile `json:"pattern_profiles,omitempty" yaml:"pattern_profiles,omitempty"`
+	ActivePatternProfile string                    `json:"active_pattern_profile,omitempty" yaml:"active_pattern_profile,omitempty"`
+}
+
+// PatternProfile is a named set of ignore/include patterns that can be
+// selected per run, e.g. "documents" vs "full-disk"
+type PatternProfile struct {
+	IgnorePatterns  []string `json:"ignore_patterns,omitempty" yaml:"ignore_patterns,omitempty"`
+	IncludePatterns []string `json:"include_patterns,omitempty" yaml:"include_patterns,omitempty"`
 }
 
 // OptionalConfig contains all optional/advanced features
 type OptionalConfig struct {
 	// Daemon mode settings
-	Daemon *DaemonConfig `json:"daemon,omitempty"`
+	Daemon *DaemonConfig `json:"daemon,omitempty" yaml:"daemon,omitempty"`
 
 	// Logging settings
-	Logging *LoggingConfig `json:"logging,omitempty"`
+	Logging *LoggingConfig `json:"logging,omitempty" yaml:"logging,omitempty"`
 
 	// Advanced sync settings
-	Advanced *AdvancedConfig `json:"advanced,omitempty"`
+	Advanced *AdvancedConfig `json:"advanced,omitempty" yaml:"advanced,omitempty"`
+
+	// Webhook the daemon calls when a sync finishes
+	Notifications *NotificationsConfig `json:"notifications,omitempty" yaml:"notifications,omitempty"`
+}
+
+// NotificationsConfig contains the webhook the daemon calls after a sync
+type NotificationsConfig struct {
+	WebhookURL string `json:"webhook_url,omitempty" yaml:"webhook_url,omitempty"`
+	Format     string `json:"format,omitempty" yaml:"format,omitempty"`       // "json" (default) or "slack"
+	NotifyOn   string `json:"notify_on,omitempty" yaml:"notify_on,omitempty"` // "failure" (default), "always" or "never"
 }
 
 // DaemonConfig contains daemon-specific settings
 type DaemonConfig struct {
-	Enabled      bool   `json:"enabled"`
-	SyncInterval string `json:"sync_interval"`
-	WatchMode    bool   `json:"watch_mode"`
-	Background   bool   `json:"background"`
-	PidFile      string `json:"pid_file"`
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	SyncInterval string `json:"sync_interval" yaml:"sync_interval"` // Duration like "5m" or cron spec like "0 2 * * *"
+	WatchMode    bool   `json:"watch_mode" yaml:"watch_mode"`
+	Background   bool   `json:"background" yaml:"background"`
+	PidFile      string `json:"pid_file" yaml:"pid_file"`
+
+	// Up to how long each scheduled sync, and the initial one, is delayed
+	// at random so many machines on the same schedule don't sync at once
+	Jitter string `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+
+	// Backoff after unrecoverable errors (auth revoked, destination deleted)
+	MaxBackoff             string `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty"`                           // Upper bound for the backoff interval
+	MaxConsecutiveFailures int    `json:"max_consecutive_failures,omitempty" yaml:"max_consecutive_failures,omitempty"` // Exit after this many in a row (0 = never)
+
+	// How long shutdown waits for a running sync to stop after cancelling it
+	ShutdownTimeout string `json:"shutdown_timeout,omitempty" yaml:"shutdown_timeout,omitempty"`
+
+	// Address to serve Prometheus metrics on, e.g. ":9090"; empty disables
+	MetricsAddr string `json:"metrics_addr,omitempty" yaml:"metrics_addr,omitempty"`
+	// Address to serve /healthz and /status on; defaults to MetricsAddr
+	StatusAddr string `json:"status_addr,omitempty" yaml:"status_addr,omitempty"`
+
+	// Watch mode: patterns the watcher ignores on top of ignore_patterns,
+	// replacing its defaults (editor swap files, .git and the like), how
+	// long it drops repeated events for the same file, and how long the
+	// source must be quiet before the changes are synced together
+	WatchIgnorePatterns []string `json:"watch_ignore_patterns,omitempty" yaml:"watch_ignore_patterns,omitempty"`
+	WatchDebounce       string   `json:"watch_debounce,omitempty" yaml:"watch_debounce,omitempty"`
+	WatchQuietPeriod    string   `json:"watch_quiet_period,omitempty" yaml:"watch_quiet_period,omitempty"`
+
+	// Deprecated: PollInterval is ignored now that the watcher uses file
+	// system notifications. It's kept so existing configs still load.
+	PollInterval string `json:"poll_interval,omitempty" yaml:"poll_interval,omitempty"`
 }
 
 // LoggingConfig contains logging settings
 type LoggingConfig struct {
-	LogFile  string `json:"log_file,omitempty"`
-	LogLevel string `json:"log_level,omitempty"`
-	Verbose  bool   `json:"verbose,omitempty"`
+	LogFile  string `json:"log_file,omitempty" yaml:"log_file,omitempty"`
+	LogLevel string `json:"log_level,omitempty" yaml:"log_level,omitempty"` // debug, verbose, info (default), warn or error
+	Verbose  bool   `json:"verbose,omitempty" yaml:"verbose,omitempty"`
+	Format   string `json:"format,omitempty" yaml:"format,omitempty"` // "text" (default) or "json" for log lines and run summaries
+
+	// Rotate the log file once it reaches MaxLogSizeMB, keeping
+	// MaxLogBackups old files; 0 disables rotation
+	MaxLogSizeMB  int `json:"max_log_size_mb,omitempty" yaml:"max_log_size_mb,omitempty"`
+	MaxLogBackups int `json:"max_log_backups,omitempty" yaml:"max_log_backups,omitempty"`
 }
 
 // AdvancedConfig contains advanced sync settings
 type AdvancedConfig struct {
-	SkipExisting    bool     `json:"skip_existing,omitempty"`
-	DeleteRemoved   bool     `json:"delete_removed,omitempty"`
-	PreserveModTime bool     `json:"preserve_mod_time,omitempty"`
-	CustomUserAgent string   `json:"custom_user_agent,omitempty"`
-	ExcludeFolders  []string `json:"exclude_folders,omitempty"`
+	SkipExisting    bool     `json:"skip_existing,omitempty" yaml:"skip_existing,omitempty"`
+	DeleteRemoved   bool     `json:"delete_removed,omitempty" yaml:"delete_removed,omitempty"`
+	PreserveModTime bool     `json:"preserve_mod_time,omitempty" yaml:"preserve_mod_time,omitempty"`
+	CustomUserAgent string   `json:"custom_user_agent,omitempty" yaml:"custom_user_agent,omitempty"`
+	ExcludeFolders  []string `json:"exclude_folders,omitempty" yaml:"exclude_folders,omitempty"`
+
+	// VerifyDownloads re-hashes downloaded files and compares them with the
+	// provider's checksum (or size, when the provider has no comparable hash)
+	VerifyDownloads bool `json:"verify_downloads,omitempty" yaml:"verify_downloads,omitempty"`
+
+	// VerifyUploads compares the hash a provider returns for an upload with
+	// the local file's, deleting the upload and retrying it once on mismatch
+	VerifyUploads bool `json:"verify_uploads,omitempty" yaml:"verify_uploads,omitempty"`
+
+	// Permission/ownership tracking. PermissionChanges controls what happens
+	// when only mode/owner changed: "metadata" (default), "reupload" or "ignore"
+	PreservePermissions bool   `json:"preserve_permissions,omitempty" yaml:"preserve_permissions,omitempty"`
+	PermissionChanges   string `json:"permission_changes,omitempty" yaml:"permission_changes,omitempty"`
+
+	// DetectClockSkew measures the offset between the local clock and the
+	// provider's once per run and corrects mtime comparisons with it
+	DetectClockSkew bool `json:"detect_clock_skew,omitempty" yaml:"detect_clock_skew,omitempty"`
+
+	// StatePath is where per-provider sync state is persisted between runs
+	StatePath string `json:"state_path,omitempty" yaml:"state_path,omitempty"`
+
+	// ReportPath is where a JSON report of each sync is written: every
+	// file's outcome, counts, bytes and duration per provider
+	ReportPath string `json:"report_path,omitempty" yaml:"report_path,omitempty"`
+
+	// FailOnAnyError makes a sync fail if any file failed. By default a
+	// run succeeds as long as most files synced; the failed count is
+	// reported either way.
+	FailOnAnyError bool `json:"fail_on_any_error,omitempty" yaml:"fail_on_any_error,omitempty"`
+
+	// StopOnError aborts a sync at the first file that fails instead of
+	// carrying on with the rest and reporting every failure at the end
+	StopOnError bool `json:"stop_on_error,omitempty" yaml:"stop_on_error,omitempty"`
+
+	// ChecksumSidecars uploads a "<file>.md5" or "<file>.sha256" companion
+	// holding each file's checksum, for providers without a usable hash.
+	// One of "" (disabled), "md5" or "sha256".
+	ChecksumSidecars string `json:"checksum_sidecars,omitempty" yaml:"checksum_sidecars,omitempty"`
+
+	// FlattenStructure uploads every file into the destination root. The
+	// original paths are recorded in FlattenMapPath, and a copy is kept in
+	// the destination root, so restores can rebuild the tree.
+	FlattenStructure bool   `json:"flatten_structure,omitempty" yaml:"flatten_structure,omitempty"`
+	FlattenMapPath   string `json:"flatten_map_path,omitempty" yaml:"flatten_map_path,omitempty"`
+
+	// StabilizeWindow, a duration like "2s", makes a sync stat each file
+	// twice this far apart before uploading it and skip it until the next
+	// pass when its size or modification time changed in between. Empty
+	// disables the check.
+	StabilizeWindow string `json:"stabilize_window,omitempty" yaml:"stabilize_window,omitempty"`
+
+	// SyncMode is the direction files travel: push (default) uploads local
+	// changes, pull downloads remote ones and bidirectional does both,
+	// keeping a ".conflict" copy when both sides changed. Bidirectional
+	// sync needs StatePath to tell which side changed.
+	SyncMode string `json:"sync_mode,omitempty" yaml:"sync_mode,omitempty"`
+
+	// EncryptionPassphrase enables client-side encryption: file contents are
+	// encrypted with a key derived from it before upload and decrypted on
+	// download. CSYNC_ENCRYPTION_PASSPHRASE overrides it.
+	EncryptionPassphrase string `json:"encryption_passphrase,omitempty" yaml:"encryption_passphrase,omitempty"`
+
+	// Compression compresses files before upload (and before encryption):
+	// none (default), gzip or zstd. Already compressed file types are
+	// uploaded as they are.
+	Compression string `json:"compression,omitempty" yaml:"compression,omitempty"`
+
+	// PackSmallFiles bundles the files of a folder no larger than
+	// PackThresholdBytes (0 = 256 KiB) into one tar archive per folder, so
+	// many small files cost one upload instead of one each. Push mode only.
+	PackSmallFiles     bool  `json:"pack_small_files,omitempty" yaml:"pack_small_files,omitempty"`
+	PackThresholdBytes int64 `json:"pack_threshold_bytes,omitempty" yaml:"pack_threshold_bytes,omitempty"`
+
+	// APICallBudget caps the provider API requests made by a single sync
+	// (0 = unlimited). The run stops cleanly when it's used up.
+	APICallBudget int `json:"api_call_budget,omitempty" yaml:"api_call_budget,omitempty"`
+
+	// ResumableThresholdBytes is the file size above which Google Drive
+	// uploads use the resumable protocol (0 = chunk_size_bytes)
+	ResumableThresholdBytes int64 `json:"resumable_threshold_bytes,omitempty" yaml:"resumable_threshold_bytes,omitempty"`
+
+	// MaxUploadBytesPerSec caps the combined upload rate of all workers
+	// (0 = unlimited)
+	MaxUploadBytesPerSec int64 `json:"max_upload_bytes_per_sec,omitempty" yaml:"max_upload_bytes_per_sec,omitempty"`
+
+	// HashDuringUpload hashes files as they are uploaded rather than while
+	// scanning, so each is read once. It only applies when nothing compares
+	// files before uploading them: no skip_existing, state_path,
+	// checksum_sidecars or pack_small_files, and push mode.
+	HashDuringUpload bool `json:"hash_during_upload,omitempty" yaml:"hash_during_upload,omitempty"`
+
+	// PreSyncHook and PostSyncHook are shell commands run before and after
+	// each sync, with the source, provider and, after it, the outcome in
+	// CSYNC_* environment variables. A failing pre-sync hook aborts the
+	// sync; a failing post-sync hook is only logged.
+	PreSyncHook  string `json:"pre_sync_hook,omitempty" yaml:"pre_sync_hook,omitempty"`
+	PostSyncHook string `json:"post_sync_hook,omitempty" yaml:"post_sync_hook,omitempty"`
+}
+
+// DefaultInProgressPatterns match files that are being downloaded or written
+var DefaultInProgressPatterns = []string{
+	"*.part",
+	"*.partial",
+	"*.crdownload",
+	"*.download",
+	"*.lock",
+	"*.swp",
+	"~$*",
+	"*-journal",
+	"*-wal",
+	"*-shm",
+}
+
+// DefaultLockSuffixes mark a sibling file as being written, mostly by SQLite
+var DefaultLockSuffixes = []string{
+	"-journal",
+	"-wal",
+	".lock",
+}
+
+// PCloudHosts maps pCloud regions to their API hosts. Accounts only exist in
+// one region and the other region's host rejects their credentials.
+var PCloudHosts = map[string]string{
+	"us": "https://api.pcloud.com",
+	"eu": "https://eapi.pcloud.com",
 }
 
 // DefaultConfig returns a configuration with sensible defaults
@@ -130,7 +524,6 @@ func MinimalConfig() *Config {
 		PCloud: PCloudConfig{
 			Username: "your-email@example.com",
 			Password: "your-password",
-			APIHost:  "https://api.pcloud.com",
 		},
 		General: GeneralConfig{
 			SourcePath:     "./documents", // Example source path
@@ -182,6 +575,7 @@ func Load(path string) (*Config, error) {
 		}
 		fmt.Printf("Created default configuration file: %s\n", path)
 		fmt.Println("Please update the configuration with your API credentials before running csync.")
+		cfg.path = path
 		return cfg, nil
 	}
 
@@ -192,7 +586,12 @@ func Load(path string) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, &cfg)
+	} else {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
@@ -208,8 +607,11 @@ func Load(path string) (*Config, error) {
 		cfg.General.ChunkSizeBytes = defaultCfg.General.ChunkSizeBytes
 	}
 
-	// Apply environment variable overrides for sensitive data
+	// Expand ${VAR} references, then apply environment variable overrides
+	// for sensitive data
+	expandEnv(reflect.ValueOf(&cfg).Elem())
 	cfg.applyEnvOverrides()
+	cfg.path = path
 
 	return &cfg, nil
 }
@@ -224,6 +626,37 @@ func (c *Config) applyEnvOverrides() {
 		c.PCloud.Password = password
 	}
 
+	// S3 credentials
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		c.S3.AccessKeyID = accessKey
+	}
+	if secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY"); secretKey != "" {
+		c.S3.SecretAccessKey = secretKey
+	}
+
+	// SFTP password
+	if password := os.Getenv("SFTP_PASSWORD"); password != "" {
+		c.SFTP.Password = password
+	}
+
+	// B2 credentials
+	if keyID := os.Getenv("B2_APPLICATION_KEY_ID"); keyID != "" {
+		c.B2.KeyID = keyID
+	}
+	if key := os.Getenv("B2_APPLICATION_KEY"); key != "" {
+		c.B2.ApplicationKey = key
+	}
+
+	// WebDAV password
+	if password := os.Getenv("WEBDAV_PASSWORD"); password != "" {
+		c.WebDAV.Password = password
+	}
+
+	// OneDrive client secret
+	if secret := os.Getenv("ONEDRIVE_CLIENT_SECRET"); secret != "" {
+		c.OneDrive.ClientSecret = secret
+	}
+
 	// Google Drive credentials path (can be overridden)
 	if credsPath := os.Getenv("GOOGLE_CREDENTIALS_PATH"); credsPath != "" {
 		c.GoogleDrive.CredentialsPath = credsPath
@@ -231,11 +664,72 @@ func (c *Config) applyEnvOverrides() {
 	if tokenPath := os.Getenv("GOOGLE_TOKEN_PATH"); tokenPath != "" {
 		c.GoogleDrive.TokenPath = tokenPath
 	}
+
+	// Encryption passphrase
+	if passphrase := os.Getenv("CSYNC_ENCRYPTION_PASSPHRASE"); passphrase != "" {
+		if c.Optional == nil {
+			c.Optional = &OptionalConfig{}
+		}
+		if c.Optional.Advanced == nil {
+			c.Optional.Advanced = &AdvancedConfig{}
+		}
+		c.Optional.Advanced.EncryptionPassphrase = passphrase
+	}
+}
+
+// expandEnv replaces ${VAR} and $VAR references in every string of v with
+// the environment variable's value, like os.ExpandEnv. "$$" stands for a
+// literal "$".
+func expandEnv(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(os.Expand(v.String(), func(name string) string {
+			if name == "$" {
+				return "$"
+			}
+			return os.Getenv(name)
+		}))
+	case reflect.Pointer:
+		if !v.IsNil() {
+			expandEnv(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				expandEnv(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			expandEnv(v.Index(i))
+		}
+	case reflect.Map:
+		// Map values aren't addressable, so expand a copy and store it back
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			expandEnv(value)
+			v.SetMapIndex(key, value)
+		}
+	}
 }
 
-// Save writes the configuration to a file
+// Save writes the configuration to a file, as YAML for a .yaml or .yml
+// path and as JSON otherwise
 func (c *Config) Save(path string) error {
-	data, err := json.MarshalIndent(c, "", "  ")
+	var data []byte
+	var err error
+	if isYAML(path) {
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err = enc.Encode(c); err == nil {
+			err = enc.Close()
+		}
+		data = buf.Bytes()
+	} else {
+		data, err = json.MarshalIndent(c, "", "  ")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -247,21 +741,426 @@ func (c *Config) Save(path string) error {
 	return nil
 }
 
-// Validate checks if the configuration is valid
+// isYAML reports whether a config path is a YAML file by its extension.
+// Any other extension is read and written as JSON.
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate checks if the configuration is valid. The error lists every
+// problem found, one per line.
 func (c *Config) Validate() error {
+	var errs []error
+
 	if c.General.MaxConcurrency <= 0 {
-		return fmt.Errorf("max_concurrency must be greater than 0")
+		errs = append(errs, fmt.Errorf("max_concurrency must be greater than 0"))
+	}
+
+	if c.General.MetadataConcurrency < 0 || c.General.UploadConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("metadata_concurrency and upload_concurrency must be non-negative"))
 	}
 
 	if c.General.RetryAttempts < 0 {
-		return fmt.Errorf("retry_attempts must be non-negative")
+		errs = append(errs, fmt.Errorf("retry_attempts must be non-negative"))
 	}
 
 	if c.General.ChunkSizeBytes <= 0 {
-		return fmt.Errorf("chunk_size_bytes must be greater than 0")
+		errs = append(errs, fmt.Errorf("chunk_size_bytes must be greater than 0"))
 	}
 
-	return nil
+	if c.General.MinFileSize < 0 || c.General.MaxFileSize < 0 {
+		errs = append(errs, fmt.Errorf("min_file_size and max_file_size must be non-negative"))
+	}
+
+	if c.General.MaxFileSize > 0 && c.General.MinFileSize > c.General.MaxFileSize {
+		errs = append(errs, fmt.Errorf("min_file_size must not be greater than max_file_size"))
+	}
+
+	if _, err := c.GetModifiedSince(time.Now()); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.GetHashAlgorithm() {
+	case "md5", "sha1", "sha256":
+	default:
+		errs = append(errs, fmt.Errorf("hash_algorithm must be one of md5, sha1, sha256"))
+	}
+
+	adv := c.GetAdvanced()
+	switch adv.PermissionChanges {
+	case "", "metadata", "reupload", "ignore":
+	default:
+		errs = append(errs, fmt.Errorf("permission_changes must be one of metadata, reupload, ignore"))
+	}
+	if adv.APICallBudget < 0 {
+		errs = append(errs, fmt.Errorf("api_call_budget must be non-negative"))
+	}
+	if adv.ResumableThresholdBytes < 0 {
+		errs = append(errs, fmt.Errorf("resumable_threshold_bytes must be non-negative"))
+	}
+	if adv.PackThresholdBytes < 0 {
+		errs = append(errs, fmt.Errorf("pack_threshold_bytes must be non-negative"))
+	}
+	if adv.PackSmallFiles && adv.SyncMode != "" && adv.SyncMode != SyncPush {
+		errs = append(errs, fmt.Errorf("pack_small_files only works with sync_mode push"))
+	}
+	if adv.MaxUploadBytesPerSec < 0 {
+		errs = append(errs, fmt.Errorf("max_upload_bytes_per_sec must be non-negative"))
+	}
+
+	switch c.GetLogFormat() {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("logging format must be text or json"))
+	}
+
+	if opt := c.Optional; opt != nil && opt.Logging != nil &&
+		(opt.Logging.MaxLogSizeMB < 0 || opt.Logging.MaxLogBackups < 0) {
+		errs = append(errs, fmt.Errorf("max_log_size_mb and max_log_backups must be non-negative"))
+	}
+
+	switch c.GetLogLevel() {
+	case "debug", "verbose", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log_level must be one of debug, verbose, info, warn, error"))
+	}
+
+	switch adv.ChecksumSidecars {
+	case "", "md5", "sha256":
+	default:
+		errs = append(errs, fmt.Errorf("checksum_sidecars must be one of md5, sha256"))
+	}
+	if adv.PreservePermissions && adv.StatePath == "" {
+		errs = append(errs, fmt.Errorf("preserve_permissions requires state_path to track previous modes"))
+	}
+
+	if err := validProvider(c.General.Provider); err != nil {
+		errs = append(errs, err)
+	}
+	for name, profile := range c.Profiles {
+		if err := validProvider(profile.Provider); err != nil {
+			errs = append(errs, fmt.Errorf("profile %q: %w", name, err))
+		}
+	}
+
+	if name := c.General.ActivePatternProfile; name != "" {
+		if _, ok := c.General.PatternProfiles[name]; !ok {
+			errs = append(errs, fmt.Errorf("active_pattern_profile %q is not defined in pattern_profiles", name))
+		}
+	}
+
+	errs = append(errs, c.validateProviders()...)
+
+	if _, err := c.GetStabilizeWindow(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.GetAdvanced().Compression {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		errs = append(errs, fmt.Errorf("compression must be none, gzip or zstd"))
+	}
+
+	switch advanced := c.GetAdvanced(); advanced.SyncMode {
+	case "", SyncPush, SyncPull:
+	case SyncBidirectional:
+		if advanced.StatePath == "" {
+			errs = append(errs, fmt.Errorf("sync_mode bidirectional requires state_path"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("sync_mode must be push, pull or bidirectional"))
+	}
+
+	if c.IsDaemonMode() {
+		if _, err := schedule.Parse(c.GetSyncInterval()); err != nil {
+			errs = append(errs, fmt.Errorf("sync_interval is not a valid duration or cron spec: %w", err))
+		}
+		if d, err := time.ParseDuration(c.GetJitter()); err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("jitter %q is not a valid duration", c.GetJitter()))
+		}
+		if _, err := time.ParseDuration(c.GetMaxBackoff()); err != nil {
+			errs = append(errs, fmt.Errorf("max_backoff %q is not a valid duration", c.GetMaxBackoff()))
+		}
+		if _, err := time.ParseDuration(c.GetShutdownTimeout()); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown_timeout %q is not a valid duration", c.GetShutdownTimeout()))
+		}
+		if _, err := time.ParseDuration(c.GetWatchDebounce()); err != nil {
+			errs = append(errs, fmt.Errorf("watch_debounce %q is not a valid duration", c.GetWatchDebounce()))
+		}
+		if d, err := time.ParseDuration(c.GetWatchQuietPeriod()); err != nil || d <= 0 {
+			errs = append(errs, fmt.Errorf("watch_quiet_period %q is not a positive duration", c.GetWatchQuietPeriod()))
+		}
+	}
+
+	notifications := c.GetNotifications()
+	if notifications.WebhookURL != "" {
+		if u, err := url.Parse(notifications.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("notifications: webhook_url must be an http or https URL"))
+		}
+	}
+	switch notifications.Format {
+	case "", WebhookJSON, WebhookSlack:
+	default:
+		errs = append(errs, fmt.Errorf("notifications: format must be json or slack"))
+	}
+	switch c.GetNotifyOn() {
+	case NotifyAlways, NotifyFailure, NotifyNever:
+	default:
+		errs = append(errs, fmt.Errorf("notifications: notify_on must be always, failure or never"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// providerEnabled reports whether a provider will be synced to: the config
+// or one of its profiles selects it, or, with no single provider selected,
+// its section is filled in
+func (c *Config) providerEnabled(name string, section any) bool {
+	if c.General.Provider == name {
+		return true
+	}
+	for _, profile := range c.Profiles {
+		if profile.Provider == name {
+			return true
+		}
+	}
+	if c.General.Provider != "" && c.General.Provider != "all" {
+		return false
+	}
+	return !reflect.ValueOf(section).IsZero()
+}
+
+// validateProviders checks the required settings of every enabled provider
+func (c *Config) validateProviders() []error {
+	var errs []error
+
+	if c.providerEnabled("gdrive", c.GoogleDrive) {
+		switch c.GoogleDrive.AuthMode {
+		case "", GoogleDriveOAuth, GoogleDriveServiceAccount:
+		default:
+			errs = append(errs, fmt.Errorf("google_drive: auth_mode must be oauth or service_account"))
+		}
+		if c.GoogleDrive.CredentialsPath == "" {
+			errs = append(errs, fmt.Errorf("google_drive: credentials_path is required"))
+		}
+		if c.GoogleDrive.TokenPath == "" && c.GoogleDrive.AuthMode != GoogleDriveServiceAccount {
+			errs = append(errs, fmt.Errorf("google_drive: token_path is required unless auth_mode is service_account"))
+		}
+		if c.GoogleDrive.ImpersonateSubject != "" && c.GoogleDrive.AuthMode == GoogleDriveOAuth {
+			errs = append(errs, fmt.Errorf("google_drive: impersonate_subject needs a service account"))
+		}
+		switch c.GoogleDrive.DuplicatePolicy {
+		case "", DuplicateKeepNewest, DuplicateUpdateFirst, DuplicateError:
+		default:
+			errs = append(errs, fmt.Errorf("google_drive: duplicate_policy must be keep_newest, update_first or error"))
+		}
+		if c.GoogleDrive.FolderID != "" && c.GoogleDrive.DestinationPath != "" {
+			errs = append(errs, fmt.Errorf("google_drive: folder_id and destination_path are mutually exclusive"))
+		}
+	}
+
+	if c.providerEnabled("pcloud", c.PCloud) {
+		if c.PCloud.Username == "" || c.PCloud.Password == "" {
+			errs = append(errs, fmt.Errorf("pcloud: username and password (or PCLOUD_USERNAME and PCLOUD_PASSWORD) are required"))
+		}
+		if c.PCloud.FolderID != "" && c.PCloud.DestinationPath != "" {
+			errs = append(errs, fmt.Errorf("pcloud: folder_id and destination_path are mutually exclusive"))
+		}
+		if _, ok := PCloudHosts[c.PCloud.Region]; c.PCloud.Region != "" && !ok {
+			errs = append(errs, fmt.Errorf("pcloud: region must be us or eu"))
+		}
+	}
+
+	if c.providerEnabled("s3", c.S3) {
+		if c.S3.Bucket == "" {
+			errs = append(errs, fmt.Errorf("s3: bucket is required"))
+		}
+		if (c.S3.AccessKeyID == "") != (c.S3.SecretAccessKey == "") {
+			errs = append(errs, fmt.Errorf("s3: access_key_id and secret_access_key must be set together"))
+		}
+	}
+
+	if c.providerEnabled("sftp", c.SFTP) {
+		if c.SFTP.Host == "" || c.SFTP.User == "" {
+			errs = append(errs, fmt.Errorf("sftp: host and user are required"))
+		}
+		if c.SFTP.PrivateKeyPath == "" && c.SFTP.Password == "" {
+			errs = append(errs, fmt.Errorf("sftp: private_key_path or password (or SFTP_PASSWORD) is required"))
+		}
+	}
+
+	if c.providerEnabled("onedrive", c.OneDrive) {
+		if c.OneDrive.ClientID == "" || c.OneDrive.TokenPath == "" {
+			errs = append(errs, fmt.Errorf("onedrive: client_id and token_path are required"))
+		}
+		if c.OneDrive.FolderID != "" && c.OneDrive.DestinationPath != "" {
+			errs = append(errs, fmt.Errorf("onedrive: folder_id and destination_path are mutually exclusive"))
+		}
+	}
+
+	if c.providerEnabled("b2", c.B2) {
+		if c.B2.Bucket == "" {
+			errs = append(errs, fmt.Errorf("b2: bucket is required"))
+		}
+		if c.B2.KeyID == "" || c.B2.ApplicationKey == "" {
+			errs = append(errs, fmt.Errorf("b2: key_id and application_key (or B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY) are required"))
+		}
+	}
+
+	if c.providerEnabled("webdav", c.WebDAV) {
+		if c.WebDAV.URL == "" || c.WebDAV.Username == "" {
+			errs = append(errs, fmt.Errorf("webdav: url and username are required"))
+		} else if u, err := url.Parse(c.WebDAV.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webdav: url must be an http or https URL"))
+		}
+		if c.WebDAV.Password == "" {
+			errs = append(errs, fmt.Errorf("webdav: password (or WEBDAV_PASSWORD) is required"))
+		}
+	}
+
+	return errs
+}
+
+// validProvider checks a provider selection, which may be empty
+func validProvider(provider string) error {
+	switch provider {
+	case "", "gdrive", "pcloud", "s3", "sftp", "onedrive", "webdav", "b2", "all":
+		return nil
+	default:
+		return fmt.Errorf("provider must be one of gdrive, pcloud, s3, sftp, onedrive, webdav, b2, all")
+	}
+}
+
+// ResolveProfile returns the configuration with the named sync profile
+// merged over the top-level settings. An empty name returns c itself.
+func (c *Config) ResolveProfile(name string) (*Config, error) {
+	if name == "" {
+		return c, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+
+	resolved := *c
+	override := func(dst *string, value string) {
+		if value != "" {
+			*dst = value
+		}
+	}
+	override(&resolved.General.SourcePath, p.SourcePath)
+	override(&resolved.General.Provider, p.Provider)
+	// folder_id and destination_path are alternatives, so a profile
+	// setting either replaces both
+	if p.GoogleDrive != (FolderDestination{}) {
+		resolved.GoogleDrive.FolderID = p.GoogleDrive.FolderID
+		resolved.GoogleDrive.DestinationPath = p.GoogleDrive.DestinationPath
+	}
+	if p.PCloud != (FolderDestination{}) {
+		resolved.PCloud.FolderID = p.PCloud.FolderID
+		resolved.PCloud.DestinationPath = p.PCloud.DestinationPath
+	}
+	override(&resolved.S3.Bucket, p.S3.Bucket)
+	override(&resolved.S3.Prefix, p.S3.Prefix)
+	override(&resolved.SFTP.RemoteBasePath, p.SFTP.RemoteBasePath)
+	override(&resolved.WebDAV.RemoteBasePath, p.WebDAV.RemoteBasePath)
+	override(&resolved.B2.Bucket, p.B2.Bucket)
+	override(&resolved.B2.Prefix, p.B2.Prefix)
+	if p.OneDrive != (FolderDestination{}) {
+		resolved.OneDrive.FolderID = p.OneDrive.FolderID
+		resolved.OneDrive.DestinationPath = p.OneDrive.DestinationPath
+	}
+
+	return &resolved, nil
+}
+
+// ResolvePatterns returns the ignore and include patterns for the given
+// pattern profile. The top-level lists are always applied; the profile's
+// lists are appended to them. An empty name selects active_pattern_profile.
+func (c *Config) ResolvePatterns(profile string) (ignore, include []string, err error) {
+	ignore = append(ignore, c.General.IgnorePatterns...)
+	include = append(include, c.General.IncludePatterns...)
+
+	if profile == "" {
+		profile = c.General.ActivePatternProfile
+	}
+	if profile == "" {
+		return ignore, include, nil
+	}
+
+	p, ok := c.General.PatternProfiles[profile]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown pattern profile: %s", profile)
+	}
+
+	ignore = append(ignore, p.IgnorePatterns...)
+	include = append(include, p.IncludePatterns...)
+	return ignore, include, nil
+}
+
+// GetModifiedSince returns the modified_since cutoff for a sync starting at
+// now, or the zero time when it is unset
+func (c *Config) GetModifiedSince(now time.Time) (time.Time, error) {
+	value := c.General.ModifiedSince
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("modified_since must not be a negative duration")
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("modified_since must be an RFC3339 timestamp or a duration like 24h: %q", value)
+	}
+	return t, nil
+}
+
+// GetHashAlgorithm returns the content hash algorithm or default
+func (c *Config) GetHashAlgorithm() string {
+	if c.General.HashAlgorithm != "" {
+		return c.General.HashAlgorithm
+	}
+	return "md5"
+}
+
+// GetInProgressPatterns returns the patterns for files being written or default
+func (c *Config) GetInProgressPatterns() []string {
+	if c.General.InProgressPatterns != nil {
+		return c.General.InProgressPatterns
+	}
+	return DefaultInProgressPatterns
+}
+
+// GetLockSuffixes returns the sibling lockfile suffixes or default
+func (c *Config) GetLockSuffixes() []string {
+	if c.General.LockSuffixes != nil {
+		return c.General.LockSuffixes
+	}
+	return DefaultLockSuffixes
+}
+
+// GetMetadataConcurrency returns the worker count for metadata operations
+func (c *Config) GetMetadataConcurrency() int {
+	if c.General.MetadataConcurrency > 0 {
+		return c.General.MetadataConcurrency
+	}
+	return c.General.MaxConcurrency
+}
+
+// GetUploadConcurrency returns the worker count for file transfers
+func (c *Config) GetUploadConcurrency() int {
+	if c.General.UploadConcurrency > 0 {
+		return c.General.UploadConcurrency
+	}
+	return c.General.MaxConcurrency
 }
 
 // IsDaemonMode returns true if daemon mode is enabled
@@ -277,6 +1176,84 @@ func (c *Config) GetSyncInterval() string {
 	return "5m" // default
 }
 
+// GetJitter returns the most a scheduled sync is delayed at random or default
+func (c *Config) GetJitter() string {
+	if c.Optional != nil && c.Optional.Daemon != nil && c.Optional.Daemon.Jitter != "" {
+		return c.Optional.Daemon.Jitter
+	}
+	return "0s" // default
+}
+
+// GetMaxBackoff returns the maximum backoff interval after unrecoverable errors or default
+func (c *Config) GetMaxBackoff() string {
+	if c.Optional != nil && c.Optional.Daemon != nil && c.Optional.Daemon.MaxBackoff != "" {
+		return c.Optional.Daemon.MaxBackoff
+	}
+	return "1h" // default
+}
+
+// GetShutdownTimeout returns how long the daemon waits for a running sync
+// to stop on shutdown or default
+func (c *Config) GetShutdownTimeout() string {
+	if c.Optional != nil && c.Optional.Daemon != nil && c.Optional.Daemon.ShutdownTimeout != "" {
+		return c.Optional.Daemon.ShutdownTimeout
+	}
+	return "30s" // default
+}
+
+// GetWatchIgnorePatterns returns the patterns the file watcher ignores on
+// top of ignore_patterns, or nil for the watcher's defaults
+func (c *Config) GetWatchIgnorePatterns() []string {
+	if c.Optional != nil && c.Optional.Daemon != nil {
+		return c.Optional.Daemon.WatchIgnorePatterns
+	}
+	return nil
+}
+
+// GetWatchDebounce returns how long the file watcher drops repeated events
+// for the same file or default
+func (c *Config) GetWatchDebounce() string {
+	if c.Optional != nil && c.Optional.Daemon != nil && c.Optional.Daemon.WatchDebounce != "" {
+		return c.Optional.Daemon.WatchDebounce
+	}
+	return "2s" // default
+}
+
+// GetWatchQuietPeriod returns how long the source must be quiet in watch
+// mode before its changes are synced, or default
+func (c *Config) GetWatchQuietPeriod() string {
+	if c.Optional != nil && c.Optional.Daemon != nil && c.Optional.Daemon.WatchQuietPeriod != "" {
+		return c.Optional.Daemon.WatchQuietPeriod
+	}
+	return "1s" // default
+}
+
+// GetMetricsAddr returns the address the daemon serves metrics on, or ""
+func (c *Config) GetMetricsAddr() string {
+	if c.Optional != nil && c.Optional.Daemon != nil {
+		return c.Optional.Daemon.MetricsAddr
+	}
+	return ""
+}
+
+// GetStatusAddr returns the address the daemon serves its health and status
+// endpoints on, or "" to use the metrics address
+func (c *Config) GetStatusAddr() string {
+	if c.Optional != nil && c.Optional.Daemon != nil {
+		return c.Optional.Daemon.StatusAddr
+	}
+	return ""
+}
+
+// GetMaxConsecutiveFailures returns how many unrecoverable failures in a row
+// stop the daemon, or 0 to keep running
+func (c *Config) GetMaxConsecutiveFailures() int {
+	if c.Optional != nil && c.Optional.Daemon != nil {
+		return c.Optional.Daemon.MaxConsecutiveFailures
+	}
+	return 0
+}
+
 // IsWatchMode returns whether file watching is enabled
 func (c *Config) IsWatchMode() bool {
 	return c.Optional != nil && c.Optional.Daemon != nil && c.Optional.Daemon.WatchMode
@@ -295,6 +1272,101 @@ func (c *Config) GetPidFile() string {
 	return "csync.pid" // default
 }
 
+// GetAdvanced returns the advanced settings, or zero values if unset
+func (c *Config) GetAdvanced() AdvancedConfig {
+	if c.Optional != nil && c.Optional.Advanced != nil {
+		return *c.Optional.Advanced
+	}
+	return AdvancedConfig{}
+}
+
+// GetNotifications returns the notification settings, empty when unset
+func (c *Config) GetNotifications() NotificationsConfig {
+	if c.Optional != nil && c.Optional.Notifications != nil {
+		return *c.Optional.Notifications
+	}
+	return NotificationsConfig{}
+}
+
+// GetNotifyOn returns when the notification webhook is called or default
+func (c *Config) GetNotifyOn() string {
+	if notifyOn := c.GetNotifications().NotifyOn; notifyOn != "" {
+		return notifyOn
+	}
+	return NotifyFailure // default
+}
+
+// GetStabilizeWindow returns how long to watch a file for changes before
+// uploading it, 0 when disabled
+func (c *Config) GetStabilizeWindow() (time.Duration, error) {
+	value := c.GetAdvanced().StabilizeWindow
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("stabilize_window %q is not a valid duration", value)
+	}
+	return d, nil
+}
+
+// GetResumableThreshold returns the size above which uploads are resumable or default
+func (c *Config) GetResumableThreshold() int64 {
+	if threshold := c.GetAdvanced().ResumableThresholdBytes; threshold > 0 {
+		return threshold
+	}
+	return c.General.ChunkSizeBytes // default
+}
+
+// GetPackThreshold returns the size up to which files are packed or default
+func (c *Config) GetPackThreshold() int64 {
+	if threshold := c.GetAdvanced().PackThresholdBytes; threshold > 0 {
+		return threshold
+	}
+	return 256 * 1024 // default
+}
+
+// GetFlattenMapPath returns where the flatten mapping is stored or default:
+// beside the state file, or else beside the configuration file, so it
+// doesn't depend on the working directory
+func (c *Config) GetFlattenMapPath() string {
+	if path := c.GetAdvanced().FlattenMapPath; path != "" {
+		return path
+	}
+	if statePath := c.GetAdvanced().StatePath; statePath != "" {
+		return statePath + ".flatten"
+	}
+	if c.path != "" {
+		return filepath.Join(filepath.Dir(c.path), "flatten-map.json")
+	}
+	return "flatten-map.json"
+}
+
+// GetLogFormat returns the log output format or default
+func (c *Config) GetLogFormat() string {
+	if c.Optional != nil && c.Optional.Logging != nil && c.Optional.Logging.Format != "" {
+		return c.Optional.Logging.Format
+	}
+	return "text" // default
+}
+
+// GetLogLevel returns the minimum log level or default
+func (c *Config) GetLogLevel() string {
+	if c.Optional != nil && c.Optional.Logging != nil && c.Optional.Logging.LogLevel != "" {
+		return c.Optional.Logging.LogLevel
+	}
+	return "info" // default
+}
+
+// GetLogRotation returns the log size limit in bytes (0 for no rotation)
+// and the number of rotated files to keep
+func (c *Config) GetLogRotation() (maxBytes int64, backups int) {
+	if c.Optional != nil && c.Optional.Logging != nil {
+		return int64(c.Optional.Logging.MaxLogSizeMB) << 20, c.Optional.Logging.MaxLogBackups
+	}
+	return 0, 0
+}
+
 // GetLogFile returns the log file path or empty string
 func (c *Config) GetLogFile() string {
 	if c.Optional != nil && c.Optional.Logging != nil {
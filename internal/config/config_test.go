@@ -0,0 +1,330 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string // Messages expected in the error, none for a valid config
+	}{
+		{
+			name:   "defaults",
+			modify: func(cfg *Config) {},
+		},
+		{
+			name:   "max concurrency",
+			modify: func(cfg *Config) { cfg.General.MaxConcurrency = 0 },
+			want:   []string{"max_concurrency must be greater than 0"},
+		},
+		{
+			name:   "hash algorithm",
+			modify: func(cfg *Config) { cfg.General.HashAlgorithm = "crc32" },
+			want:   []string{"hash_algorithm must be one of md5, sha1, sha256"},
+		},
+		{
+			name:   "file size range",
+			modify: func(cfg *Config) { cfg.General.MinFileSize, cfg.General.MaxFileSize = 10, 5 },
+			want:   []string{"min_file_size must not be greater than max_file_size"},
+		},
+		{
+			name:   "unknown provider",
+			modify: func(cfg *Config) { cfg.General.Provider = "dropbox" },
+			want:   []string{"provider must be one of gdrive, pcloud, s3, sftp, onedrive, webdav, b2, all"},
+		},
+		{
+			name: "unknown profile provider",
+			modify: func(cfg *Config) {
+				cfg.Profiles = map[string]ProfileConfig{"photos": {Provider: "dropbox"}}
+			},
+			want: []string{`profile "photos": provider must be one of`},
+		},
+		{
+			name: "undefined pattern profile",
+			modify: func(cfg *Config) {
+				cfg.General.ActivePatternProfile = "work"
+			},
+			want: []string{`active_pattern_profile "work" is not defined in pattern_profiles`},
+		},
+		{
+			name: "bidirectional without state",
+			modify: func(cfg *Config) {
+				cfg.Optional = &OptionalConfig{Advanced: &AdvancedConfig{SyncMode: SyncBidirectional}}
+			},
+			want: []string{"sync_mode bidirectional requires state_path"},
+		},
+		{
+			name: "daemon interval",
+			modify: func(cfg *Config) {
+				cfg.Optional = &OptionalConfig{Daemon: &DaemonConfig{Enabled: true, SyncInterval: "soon"}}
+			},
+			want: []string{"sync_interval is not a valid duration or cron spec"},
+		},
+		{
+			name: "webhook url",
+			modify: func(cfg *Config) {
+				cfg.Optional = &OptionalConfig{Notifications: &NotificationsConfig{WebhookURL: "ftp://example.com"}}
+			},
+			want: []string{"notifications: webhook_url must be an http or https URL"},
+		},
+		{
+			name:   "selected provider without settings",
+			modify: func(cfg *Config) { cfg.General.Provider = "s3" },
+			want:   []string{"s3: bucket is required"},
+		},
+		{
+			name: "s3 keys set together",
+			modify: func(cfg *Config) {
+				cfg.General.Provider = "s3"
+				cfg.S3 = S3Config{Bucket: "backups", AccessKeyID: "key"}
+			},
+			want: []string{"s3: access_key_id and secret_access_key must be set together"},
+		},
+		{
+			name: "unselected provider is not checked",
+			modify: func(cfg *Config) {
+				cfg.General.Provider = "s3"
+				cfg.S3 = S3Config{Bucket: "backups"}
+				cfg.GoogleDrive = GoogleDriveConfig{TokenPath: "token.json"}
+			},
+		},
+		{
+			name: "filled in section is checked",
+			modify: func(cfg *Config) {
+				cfg.PCloud = PCloudConfig{Username: "me@example.com", Password: "secret", Region: "asia"}
+			},
+			want: []string{"pcloud: region must be us or eu"},
+		},
+		{
+			name: "profile enables provider",
+			modify: func(cfg *Config) {
+				cfg.Profiles = map[string]ProfileConfig{"offsite": {Provider: "sftp"}}
+			},
+			want: []string{"sftp: host and user are required", "sftp: private_key_path or password (or SFTP_PASSWORD) is required"},
+		},
+		{
+			name: "google drive service account",
+			modify: func(cfg *Config) {
+				cfg.GoogleDrive = GoogleDriveConfig{CredentialsPath: "sa.json", AuthMode: GoogleDriveServiceAccount}
+			},
+		},
+		{
+			name: "google drive impersonation",
+			modify: func(cfg *Config) {
+				cfg.GoogleDrive.AuthMode = GoogleDriveOAuth
+				cfg.GoogleDrive.ImpersonateSubject = "me@example.com"
+			},
+			want: []string{"google_drive: impersonate_subject needs a service account"},
+		},
+		{
+			name: "webdav url",
+			modify: func(cfg *Config) {
+				cfg.WebDAV = WebDAVConfig{URL: "ftp://example.com", Username: "me", Password: "secret"}
+			},
+			want: []string{"webdav: url must be an http or https URL"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected an error containing %q, got none", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got %q", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJoinsErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.General.MaxConcurrency = 0
+	cfg.General.ChunkSizeBytes = 0
+	cfg.General.HashAlgorithm = "crc32"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected an error, got none")
+	}
+	want := []string{
+		"max_concurrency must be greater than 0",
+		"chunk_size_bytes must be greater than 0",
+		"hash_algorithm must be one of md5, sha1, sha256",
+	}
+	if got := strings.Split(err.Error(), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected one problem per line %q, got %q", want, got)
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	cfg := DaemonModeConfig()
+	cfg.General.SourcePath = "/home/me/documents"
+	cfg.GoogleDrive.Metadata = map[string]string{"owner": "me"}
+	cfg.S3 = S3Config{Bucket: "backups", Prefix: "laptop"}
+	cfg.Profiles = map[string]ProfileConfig{
+		"photos": {SourcePath: "/home/me/photos", Provider: "s3", S3: S3Destination{Prefix: "photos"}},
+	}
+
+	for _, name := range []string{"csync.yaml", "csync.yml", "csync.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := cfg.Save(path); err != nil {
+				t.Fatalf("Failed to save config: %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read config: %v", err)
+			}
+			if got, want := strings.HasPrefix(string(data), "{"), !isYAML(path); got != want {
+				t.Errorf("Expected JSON %v, got %s", want, data)
+			}
+
+			loaded, err := Load(path)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			loaded.path = ""
+			if !reflect.DeepEqual(loaded, cfg) {
+				t.Errorf("Expected %+v after a round trip, got %+v", cfg, loaded)
+			}
+		})
+	}
+}
+
+func TestLoadCreatesDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "csync.yaml")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.General.MaxConcurrency != DefaultConfig().General.MaxConcurrency {
+		t.Errorf("Expected the default config, got %+v", cfg.General)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the default config to be written: %v", err)
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.General.SourcePath = "/home/me/documents"
+	cfg.General.Provider = "gdrive"
+	cfg.GoogleDrive.FolderID = "root-folder"
+	cfg.S3 = S3Config{Bucket: "backups", Prefix: "laptop"}
+	cfg.Profiles = map[string]ProfileConfig{
+		"photos": {
+			SourcePath:  "/home/me/photos",
+			GoogleDrive: FolderDestination{DestinationPath: "/Photos"},
+			S3:          S3Destination{Prefix: "photos"},
+		},
+	}
+
+	resolved, err := cfg.ResolveProfile("photos")
+	if err != nil {
+		t.Fatalf("Failed to resolve profile: %v", err)
+	}
+	if resolved.General.SourcePath != "/home/me/photos" {
+		t.Errorf("Expected the profile's source path, got %s", resolved.General.SourcePath)
+	}
+	if resolved.General.Provider != "gdrive" {
+		t.Errorf("Expected the provider to be inherited, got %s", resolved.General.Provider)
+	}
+	// folder_id and destination_path replace each other
+	if resolved.GoogleDrive.FolderID != "" || resolved.GoogleDrive.DestinationPath != "/Photos" {
+		t.Errorf("Expected only destination_path /Photos, got %+v", resolved.GoogleDrive)
+	}
+	if resolved.S3.Bucket != "backups" || resolved.S3.Prefix != "photos" {
+		t.Errorf("Expected bucket backups with prefix photos, got %+v", resolved.S3)
+	}
+	if cfg.General.SourcePath != "/home/me/documents" || cfg.GoogleDrive.FolderID != "root-folder" {
+		t.Error("Expected the top-level config to be left unchanged")
+	}
+
+	if same, err := cfg.ResolveProfile(""); err != nil || same != cfg {
+		t.Errorf("Expected no profile to return the config itself, got %v", err)
+	}
+	if _, err := cfg.ResolveProfile("missing"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("CSYNC_TEST_BUCKET", "backups")
+	t.Setenv("CSYNC_TEST_HOME", "/home/me")
+
+	cfg := &Config{
+		S3:      S3Config{Bucket: "${CSYNC_TEST_BUCKET}", Prefix: "$CSYNC_TEST_HOME/laptop"},
+		General: GeneralConfig{SourcePath: "$CSYNC_TEST_HOME/documents", IgnorePatterns: []string{"$$HOME", "${CSYNC_TEST_UNSET}x"}},
+		Optional: &OptionalConfig{Advanced: &AdvancedConfig{
+			StatePath: "${CSYNC_TEST_HOME}/.csync/state.json",
+		}},
+		Profiles: map[string]ProfileConfig{
+			"photos": {SourcePath: "${CSYNC_TEST_HOME}/photos"},
+		},
+	}
+	cfg.GoogleDrive.Metadata = map[string]string{"bucket": "$CSYNC_TEST_BUCKET"}
+	expandEnv(reflect.ValueOf(cfg).Elem())
+
+	for _, check := range []struct{ field, got, want string }{
+		{"braced", cfg.S3.Bucket, "backups"},
+		{"bare", cfg.S3.Prefix, "/home/me/laptop"},
+		{"nested struct", cfg.General.SourcePath, "/home/me/documents"},
+		{"escaped dollar", cfg.General.IgnorePatterns[0], "$HOME"},
+		{"unset variable", cfg.General.IgnorePatterns[1], "x"},
+		{"pointer field", cfg.Optional.Advanced.StatePath, "/home/me/.csync/state.json"},
+		{"map of structs", cfg.Profiles["photos"].SourcePath, "/home/me/photos"},
+		{"map of strings", cfg.GoogleDrive.Metadata["bucket"], "backups"},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s: expected %q, got %q", check.field, check.want, check.got)
+		}
+	}
+}
+
+func TestGetFlattenMapPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "csync.yaml")
+	if err := os.WriteFile(path, []byte("general:\n  max_concurrency: 2\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if got, want := cfg.GetFlattenMapPath(), filepath.Join(dir, "flatten-map.json"); got != want {
+		t.Errorf("Expected %s beside the config, got %s", want, got)
+	}
+
+	cfg.Optional = &OptionalConfig{Advanced: &AdvancedConfig{StatePath: "/var/lib/csync/state.json"}}
+	if got, want := cfg.GetFlattenMapPath(), "/var/lib/csync/state.json.flatten"; got != want {
+		t.Errorf("Expected %s beside the state file, got %s", want, got)
+	}
+
+	cfg.Optional.Advanced.FlattenMapPath = "/tmp/map.json"
+	if got := cfg.GetFlattenMapPath(); got != "/tmp/map.json" {
+		t.Errorf("Expected the configured path, got %s", got)
+	}
+
+	if got := (&Config{}).GetFlattenMapPath(); got != "flatten-map.json" {
+		t.Errorf("Expected flatten-map.json without a config file, got %s", got)
+	}
+}
@@ -2,36 +2,103 @@ package daemon
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/schedule"
 	"github.com/svosadtsia/csync/internal/sync"
 	"github.com/svosadtsia/csync/internal/watcher"
+	"github.com/svosadtsia/csync/pkg/utils"
 )
 
+const (
+	// maxIncrementalEvents is the largest batch synced path by path; bigger
+	// bursts fall back to a full sync
+	maxIncrementalEvents = 1000
+)
+
+// ErrAlreadyRunning is returned when another daemon holds the PID file
+var ErrAlreadyRunning = errors.New("csync daemon is already running")
+
+// errLocked is returned by lockFile when another process holds the lock
+var errLocked = errors.New("file is locked")
+
+// openPIDFile opens the PID file; tests replace it to race a daemon exiting
+var openPIDFile = os.OpenFile
+
 // Daemon represents a background sync daemon
 type Daemon struct {
 	config      *config.Config
+	configPath  string // File SIGHUP reloads the configuration from
 	syncManager *sync.Manager
 	watcher     *watcher.FileWatcher
 	pidFile     string
+	pidLock     *os.File // The PID file, held open and locked while the daemon runs
 	logFile     string
-	interval    time.Duration
+	schedule    schedule.Schedule // When scheduled syncs run
+	jitter      time.Duration     // Up to how long each scheduled sync is delayed at random
 	stopChan    chan struct{}
+	notifier    notifier // Tells systemd when the daemon is ready, a no-op outside it
+
+	maxBackoff  time.Duration // Cap for backoff after unrecoverable errors
+	maxFailures int           // Exit after this many unrecoverable failures (0 = never)
+	failures    int           // Current run of consecutive unrecoverable failures
+
+	syncMu          gosync.Mutex  // Held by a running sync so a reload or shutdown waits for it
+	syncing         atomic.Bool   // Whether a sync is in progress
+	shutdownTimeout time.Duration // How long shutdown waits for a running sync
+	quietPeriod     atomic.Int64  // Nanoseconds without file events before watched changes are synced
+
+	metrics  *metrics              // nil unless metrics_addr is set
+	webhooks gosync.WaitGroup      // Notification webhook calls in flight
+	reports  []sync.ProviderReport // Per-provider reports of the running sync
+
+	statusMu  gosync.Mutex // Guards the fields below, read by /status
+	started   time.Time
+	lastSync  time.Time
+	lastError string
+	nextSync  time.Time
 }
 
-// NewDaemon creates a new daemon instance
+// NewDaemon creates a new daemon instance. cfg should be the manager's
+// effective configuration, so a selected sync profile applies.
 func NewDaemon(cfg *config.Config, syncManager *sync.Manager) (*Daemon, error) {
-	interval, err := time.ParseDuration(cfg.GetSyncInterval())
+	sched, jitter, err := syncSchedule(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoff, err := time.ParseDuration(cfg.GetMaxBackoff())
+	if err != nil {
+		return nil, fmt.Errorf("invalid max backoff %s: %w", cfg.GetMaxBackoff(), err)
+	}
+
+	shutdownTimeout, err := time.ParseDuration(cfg.GetShutdownTimeout())
 	if err != nil {
-		return nil, fmt.Errorf("invalid sync interval %s: %w", cfg.GetSyncInterval(), err)
+		return nil, fmt.Errorf("invalid shutdown timeout %s: %w", cfg.GetShutdownTimeout(), err)
+	}
+
+	watch, err := watchConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	quietPeriod, err := time.ParseDuration(cfg.GetWatchQuietPeriod())
+	if err != nil {
+		return nil, fmt.Errorf("invalid watch quiet period %s: %w", cfg.GetWatchQuietPeriod(), err)
 	}
 
 	daemon := &Daemon{
@@ -39,13 +106,19 @@ func NewDaemon(cfg *config.Config, syncManager *sync.Manager) (*Daemon, error) {
 		syncManager: syncManager,
 		pidFile:     cfg.GetPidFile(),
 		logFile:     cfg.GetLogFile(),
-		interval:    interval,
+		schedule:    sched,
+		jitter:      jitter,
 		stopChan:    make(chan struct{}),
+		maxBackoff:  maxBackoff,
+		maxFailures: cfg.GetMaxConsecutiveFailures(),
+
+		shutdownTimeout: shutdownTimeout,
 	}
+	daemon.quietPeriod.Store(int64(quietPeriod))
 
 	// Initialize file watcher if watch mode is enabled
 	if cfg.IsWatchMode() {
-		watcher, err := watcher.NewFileWatcher(cfg)
+		watcher, err := watcher.NewFileWatcher(cfg, watch)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create file watcher: %w", err)
 		}
@@ -55,8 +128,51 @@ func NewDaemon(cfg *config.Config, syncManager *sync.Manager) (*Daemon, error) {
 	return daemon, nil
 }
 
-// Start starts the daemon process
+// watchConfig returns the file watcher settings of cfg: the watcher's
+// defaults with the configured ignore patterns and debounce time
+func watchConfig(cfg *config.Config) (watcher.WatchConfig, error) {
+	watch := watcher.DefaultWatchConfig()
+	if patterns := cfg.GetWatchIgnorePatterns(); patterns != nil {
+		watch.IgnorePatterns = patterns
+	}
+	debounce, err := time.ParseDuration(cfg.GetWatchDebounce())
+	if err != nil {
+		return watch, fmt.Errorf("invalid watch debounce %s: %w", cfg.GetWatchDebounce(), err)
+	}
+	watch.DebounceTime = debounce
+	return watch, nil
+}
+
+// syncSchedule returns when the daemon runs scheduled syncs and up to how
+// long it delays each at random
+func syncSchedule(cfg *config.Config) (schedule.Schedule, time.Duration, error) {
+	sched, err := schedule.Parse(cfg.GetSyncInterval())
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid sync interval: %w", err)
+	}
+	jitter, err := time.ParseDuration(cfg.GetJitter())
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid jitter %s: %w", cfg.GetJitter(), err)
+	}
+	return sched, jitter, nil
+}
+
+// SetConfigPath sets the file the configuration was loaded from, which
+// SIGHUP re-reads
+func (d *Daemon) SetConfigPath(path string) {
+	d.configPath = path
+}
+
+// Start starts the daemon process. An empty sourcePath or provider falls
+// back to the configured source_path and provider.
 func (d *Daemon) Start(ctx context.Context, sourcePath, provider string) error {
+	if sourcePath == "" {
+		sourcePath = d.config.General.SourcePath
+	}
+	if provider == "" {
+		provider = d.config.General.Provider
+	}
+
 	// Setup daemon logging
 	if err := d.setupLogging(); err != nil {
 		return fmt.Errorf("failed to setup logging: %w", err)
@@ -67,63 +183,182 @@ func (d *Daemon) Start(ctx context.Context, sourcePath, provider string) error {
 		return fmt.Errorf("failed to write PID file: %w", err)
 	}
 	defer d.removePIDFile()
+	d.recordStart()
+
+	// Webhook calls still in flight are finished before exiting, so the
+	// failure that stopped the daemon is still reported
+	defer d.webhooks.Wait()
 
 	log.Printf("Starting csync daemon (PID: %d)", os.Getpid())
-	log.Printf("Sync interval: %s", d.interval)
+	log.Printf("Sync schedule: %s", d.schedule)
+	if d.jitter > 0 {
+		log.Printf("Sync jitter: up to %s", d.jitter)
+	}
 	log.Printf("Source: %s", sourcePath)
 	log.Printf("Provider: %s", provider)
 
+	stopHTTP, err := d.startHTTP()
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+	defer stopHTTP()
+
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
+	// Syncs run in the background so signals are handled while one is in
+	// progress. Shutdown cancels syncCtx and waits for them to unwind.
+	syncCtx, cancelSync := context.WithCancel(ctx)
+	defer cancelSync()
+
 	// Start file watcher if enabled
 	if d.watcher != nil {
 		log.Println("Starting file watcher for real-time sync")
-		go d.runFileWatcher(ctx, sourcePath, provider)
+		go d.runFileWatcher(syncCtx, sourcePath, provider)
 	}
 
 	// Start periodic sync
-	ticker := time.NewTicker(d.interval)
-	defer ticker.Stop()
-
-	// Perform initial sync
-	log.Println("Performing initial sync...")
-	if err := d.performSync(ctx, sourcePath, provider); err != nil {
-		log.Printf("Initial sync failed: %v", err)
+	delay := d.nextDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	d.recordNextSync(delay)
+
+	syncDone := make(chan error, 1)
+	running := ""          // Kind of the sync in progress, "" for none
+	reloadPending := false // SIGHUP arrived during a sync
+	startSync := func(kind string) {
+		running = kind
+		go func() {
+			syncDone <- d.performSync(syncCtx, sourcePath, provider, nil, nil)
+		}()
+	}
+
+	// systemd is told the daemon is ready once the initial sync is done,
+	// and pinged from this loop so a hang stops the pings
+	d.notifier = newNotifier()
+	watchdog, stopWatchdog := d.notifier.watchdogTicks()
+	defer stopWatchdog()
+	ready := false
+
+	// Perform initial sync, after a jitter delay if there is one
+	var initial <-chan time.Time
+	if delay := d.jitterDelay(); delay > 0 {
+		log.Printf("Delaying initial sync by %s", delay.Round(time.Second))
+		initial = time.After(delay)
+	} else {
+		log.Println("Performing initial sync...")
+		startSync("Initial")
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Context cancelled, shutting down daemon")
+			d.shutdown(cancelSync)
 			return ctx.Err()
 
 		case <-d.stopChan:
 			log.Println("Stop signal received, shutting down daemon")
+			d.shutdown(cancelSync)
 			return nil
 
 		case sig := <-sigChan:
 			switch sig {
 			case syscall.SIGHUP:
-				log.Println("SIGHUP received, reloading configuration")
-				if err := d.reloadConfig(); err != nil {
-					log.Printf("Failed to reload config: %v", err)
+				if running != "" {
+					log.Println("SIGHUP received, reloading configuration once the running sync finishes")
+					reloadPending = true
+				} else {
+					log.Println("SIGHUP received, reloading configuration")
+					if err := d.reloadConfig(timer); err != nil {
+						log.Printf("Failed to reload config, keeping the current one: %v", err)
+					}
 				}
 			case syscall.SIGINT, syscall.SIGTERM:
 				log.Printf("%s received, shutting down daemon gracefully", sig)
+				d.shutdown(cancelSync)
 				return nil
 			}
 
-		case <-ticker.C:
+		case <-watchdog:
+			d.sendNotify("WATCHDOG=1")
+
+		case <-initial:
+			initial = nil
+			if running != "" {
+				log.Println("Scheduled sync already running, skipping initial sync")
+				continue
+			}
+			log.Println("Performing initial sync...")
+			startSync("Initial")
+
+		case <-timer.C:
+			if running != "" {
+				log.Println("Previous sync still running, skipping scheduled sync")
+				continue
+			}
+			d.logWatcherStats()
 			log.Println("Starting scheduled sync...")
-			if err := d.performSync(ctx, sourcePath, provider); err != nil {
-				log.Printf("Scheduled sync failed: %v", err)
+			startSync("Scheduled")
+
+		case err := <-syncDone:
+			if err != nil {
+				log.Printf("%s sync failed: %v", running, err)
+			}
+			running = ""
+			if err := d.scheduleNext(timer, err); err != nil {
+				d.shutdown(cancelSync)
+				return err
+			}
+			if !ready {
+				ready = true
+				d.sendNotify("READY=1")
+			}
+			if reloadPending {
+				reloadPending = false
+				log.Println("Reloading configuration")
+				if err := d.reloadConfig(timer); err != nil {
+					log.Printf("Failed to reload config, keeping the current one: %v", err)
+				}
 			}
 		}
 	}
 }
 
+// shutdown cancels running syncs and waits up to shutdownTimeout for them
+// to stop, so an upload in progress unwinds before the daemon exits
+func (d *Daemon) shutdown(cancelSync context.CancelFunc) {
+	d.sendNotify("STOPPING=1")
+	cancelSync()
+	if !d.syncing.Load() {
+		return
+	}
+
+	log.Printf("Waiting up to %s for the running sync to stop", d.shutdownTimeout)
+	stopped := make(chan struct{})
+	go func() {
+		d.syncMu.Lock()
+		d.syncMu.Unlock()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Println("Running sync stopped")
+	case <-time.After(d.shutdownTimeout):
+		log.Printf("Sync still running after %s, exiting anyway", d.shutdownTimeout)
+	}
+}
+
+// sendNotify sends state to systemd, logging a failure rather than
+// stopping the daemon over it
+func (d *Daemon) sendNotify(state string) {
+	if err := d.notifier.notify(state); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
+
 // Stop stops the daemon
 func (d *Daemon) Stop() {
 	close(d.stopChan)
@@ -132,63 +367,178 @@ func (d *Daemon) Stop() {
 	}
 }
 
-// performSync executes a sync operation
-func (d *Daemon) performSync(ctx context.Context, sourcePath, provider string) error {
+// performSync executes a sync operation. With non-nil paths only those
+// source-relative paths are synced, otherwise the whole tree; renames maps
+// the new paths of renamed files among them to their old ones.
+func (d *Daemon) performSync(ctx context.Context, sourcePath, provider string, paths []string, renames map[string]string) error {
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+	d.syncing.Store(true)
+	d.saveStatus()
+	defer func() {
+		d.syncing.Store(false)
+		d.saveStatus()
+	}()
+
 	start := time.Now()
-	log.Printf("Starting sync operation (provider: %s)", provider)
+	d.reports = nil
+	if paths != nil {
+		log.Printf("Starting sync of %d changed paths (provider: %s)", len(paths), provider)
+	} else {
+		log.Printf("Starting sync operation (provider: %s)", provider)
+	}
+
+	names := []string{provider}
+	if provider == "all" {
+		names = d.syncManager.ConfiguredProviders()
+	}
 
 	// Show destination paths
-	switch provider {
-	case "gdrive":
-		if d.config.GoogleDrive.DestinationPath != "" {
-			log.Printf("Google Drive destination: %s", d.config.GoogleDrive.DestinationPath)
-		}
-	case "pcloud":
-		if d.config.PCloud.DestinationPath != "" {
-			log.Printf("pCloud destination: %s", d.config.PCloud.DestinationPath)
-		}
-	case "all":
-		if d.config.GoogleDrive.DestinationPath != "" {
-			log.Printf("Google Drive destination: %s", d.config.GoogleDrive.DestinationPath)
-		}
-		if d.config.PCloud.DestinationPath != "" {
-			log.Printf("pCloud destination: %s", d.config.PCloud.DestinationPath)
+	for _, name := range names {
+		if destination := sync.ProviderDestination(d.config, name); destination != "" {
+			log.Printf("%s destination: %s", sync.ProviderDisplayName(name), destination)
 		}
 	}
 
+	// All providers sync at once from one scan
 	var err error
-	switch provider {
-	case "gdrive":
-		err = d.syncManager.SyncToGoogleDrive(ctx, sourcePath, false)
-	case "pcloud":
-		err = d.syncManager.SyncToPCloud(ctx, sourcePath, false)
-	case "all":
-		// Sync to both providers
-		if gdriveErr := d.syncManager.SyncToGoogleDrive(ctx, sourcePath, false); gdriveErr != nil {
-			log.Printf("Google Drive sync failed: %v", gdriveErr)
-			err = gdriveErr
-		}
-		if pcloudErr := d.syncManager.SyncToPCloud(ctx, sourcePath, false); pcloudErr != nil {
-			log.Printf("pCloud sync failed: %v", pcloudErr)
-			if err == nil {
-				err = pcloudErr
-			}
-		}
-	default:
-		return fmt.Errorf("unsupported provider: %s", provider)
+	if provider == "all" {
+		err = d.syncAll(ctx, sourcePath, paths, renames)
+	} else {
+		err = d.syncTo(ctx, provider, sourcePath, paths, renames)
 	}
 
 	duration := time.Since(start)
+	d.recordSync(err)
+	report := sync.NewSyncReport(start, d.reports...)
+	if path := d.config.GetAdvanced().ReportPath; path != "" {
+		if err := report.Save(path); err != nil {
+			log.Printf("Failed to write sync report: %v", err)
+		}
+	}
+	d.notifyWebhook(provider, sourcePath, report, err)
+	result := report.Result(provider)
 	if err != nil {
-		log.Printf("Sync completed with errors in %v: %v", duration, err)
+		log.Printf("Sync completed with errors in %v (%s): %v", duration, result, err)
 		return err
 	}
 
-	log.Printf("Sync completed successfully in %v", duration)
+	log.Printf("Sync completed successfully in %v: %s", duration, result)
 	return nil
 }
 
-// runFileWatcher runs the file watcher for real-time sync
+// syncTo syncs sourcePath to one provider: only paths when it isn't nil,
+// otherwise the whole tree. The run is recorded in the metrics.
+func (d *Daemon) syncTo(ctx context.Context, name, sourcePath string, paths []string, renames map[string]string) error {
+	start := time.Now()
+	var err error
+	if paths != nil {
+		err = d.syncManager.SyncPaths(ctx, name, sourcePath, paths, renames)
+	} else {
+		err = d.syncManager.Sync(ctx, name, sourcePath, false)
+	}
+
+	if d.metrics != nil {
+		d.metrics.record(name, time.Since(start), d.syncManager.LastSummary(), err)
+	}
+	d.reports = append(d.reports, d.syncManager.LastReport())
+	return err
+}
+
+// syncAll syncs sourcePath to every configured provider concurrently: only
+// paths when it isn't nil, otherwise the whole tree. Each provider's run is
+// recorded in the metrics.
+func (d *Daemon) syncAll(ctx context.Context, sourcePath string, paths []string, renames map[string]string) error {
+	var err error
+	if paths != nil {
+		err = d.syncManager.SyncAllPaths(ctx, sourcePath, paths, renames)
+	} else {
+		err = d.syncManager.SyncAll(ctx, sourcePath, false)
+	}
+	for _, result := range d.syncManager.LastResults() {
+		if result.Err != nil {
+			log.Printf("%s sync failed: %v", sync.ProviderDisplayName(result.Provider), result.Err)
+		}
+		if d.metrics != nil {
+			d.metrics.record(result.Provider, result.Report.Duration, result.Summary, result.Err)
+		}
+		d.reports = append(d.reports, result.Report)
+	}
+	return err
+}
+
+// scheduleNext resets the timer after a sync. Unrecoverable errors back off
+// exponentially up to maxBackoff so a revoked token doesn't fail every
+// interval forever; success and transient errors restore the normal
+// schedule. Returns an error once maxFailures unrecoverable failures have
+// happened in a row so a supervisor can restart the daemon.
+func (d *Daemon) scheduleNext(timer *time.Timer, err error) error {
+	delay := d.nextDelay()
+	if err == nil || !sync.IsPermanent(err) {
+		if d.failures > 0 {
+			log.Printf("Resuming normal sync schedule of %s", d.schedule)
+		}
+		d.failures = 0
+		timer.Reset(delay)
+		d.recordNextSync(delay)
+		return nil
+	}
+
+	d.failures++
+	if d.maxFailures > 0 && d.failures >= d.maxFailures {
+		return fmt.Errorf("giving up after %d consecutive unrecoverable sync failures: %w", d.failures, err)
+	}
+
+	limit := max(d.maxBackoff, delay)
+	backoff := delay
+	for i := 0; i < d.failures && backoff < limit; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, limit)
+
+	log.Printf("Unrecoverable sync error (%d in a row), next attempt in %s: %v", d.failures, backoff, err)
+	timer.Reset(backoff)
+	d.recordNextSync(backoff)
+	return nil
+}
+
+// nextDelay returns how long until the next scheduled sync, jitter included
+func (d *Daemon) nextDelay() time.Duration {
+	now := time.Now()
+	return max(d.schedule.Next(now).Sub(now), 0) + d.jitterDelay()
+}
+
+// jitterDelay returns a random delay of up to the configured jitter
+func (d *Daemon) jitterDelay() time.Duration {
+	if d.jitter <= 0 {
+		return 0
+	}
+	return rand.N(d.jitter)
+}
+
+// WatcherStats returns the file watcher's resource usage. The second result
+// is false when watch mode is disabled.
+func (d *Daemon) WatcherStats() (watcher.Stats, bool) {
+	if d.watcher == nil {
+		return watcher.Stats{}, false
+	}
+	return d.watcher.Stats(), true
+}
+
+// logWatcherStats logs the file watcher's resource usage, if it's running
+func (d *Daemon) logWatcherStats() {
+	stats, ok := d.WatcherStats()
+	if !ok {
+		return
+	}
+	log.Printf("Watcher: %d folders, %d scans, last scan %s, max %s, %d events emitted, %d coalesced, %d overflows",
+		stats.PathsWatched, stats.Scans, stats.LastScan, stats.MaxScan,
+		stats.EventsEmitted, stats.EventsCoalesced, stats.Overflows)
+}
+
+// runFileWatcher runs the file watcher for real-time sync. Events are
+// batched until the source has been quiet for the quiet period, and only
+// the changed paths are synced.
 func (d *Daemon) runFileWatcher(ctx context.Context, sourcePath, provider string) {
 	if d.watcher == nil {
 		return
@@ -200,26 +550,175 @@ func (d *Daemon) runFileWatcher(ctx context.Context, sourcePath, provider string
 		return
 	}
 
+	root, err := filepath.Abs(sourcePath)
+	if err != nil {
+		log.Printf("Failed to resolve watch path %s: %v", sourcePath, err)
+		return
+	}
+
 	// Listen for file events
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case event := <-d.watcher.Events():
-			log.Printf("File event: %s %s", event.Op, event.Name)
-			// Debounce file events to avoid excessive syncing
-			time.Sleep(1 * time.Second)
-			if err := d.performSync(ctx, sourcePath, provider); err != nil {
+		case event, ok := <-d.watcher.Events():
+			if !ok {
+				return
+			}
+			events := d.collectEvents(ctx, event)
+
+			paths, reason := syncSet(root, events)
+			var renames map[string]string
+			if reason != "" {
+				log.Printf("%d file events, running a full sync: %s", len(events), reason)
+				paths = nil
+			} else {
+				log.Printf("%d file events, syncing %d changed paths", len(events), len(paths))
+				renames = renameSet(root, events)
+			}
+			if err := d.performSync(ctx, sourcePath, provider, paths, renames); err != nil {
 				log.Printf("File watcher sync failed: %v", err)
 			}
-		case err := <-d.watcher.Errors():
+		case err, ok := <-d.watcher.Errors():
+			if !ok {
+				return
+			}
 			log.Printf("File watcher error: %v", err)
 		}
 	}
 }
 
+// collectEvents gathers first and the events that follow it until none
+// arrives for the quiet period, so a burst of changes is synced at once
+func (d *Daemon) collectEvents(ctx context.Context, first watcher.FileEvent) []watcher.FileEvent {
+	events := []watcher.FileEvent{first}
+	logEvent(first)
+
+	quiet := time.Duration(d.quietPeriod.Load())
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return events
+		case <-timer.C:
+			return events
+		case event, ok := <-d.watcher.Events():
+			if !ok {
+				return events
+			}
+			logEvent(event)
+			events = append(events, event)
+			timer.Reset(quiet)
+		case err, ok := <-d.watcher.Errors():
+			if ok {
+				log.Printf("File watcher error: %v", err)
+			}
+		}
+	}
+}
+
+// logEvent logs a file event, with the old name of a renamed file
+func logEvent(event watcher.FileEvent) {
+	if event.From != "" {
+		log.Printf("File event: %s %s -> %s", event.Op, event.From, event.Name)
+		return
+	}
+	log.Printf("File event: %s %s", event.Op, event.Name)
+}
+
+// syncSet reduces a batch of events below root to the smallest set of
+// source-relative paths that covers them, dropping paths inside another
+// changed folder. It returns a reason instead when the batch calls for a
+// full sync: a renamed folder, a change to root itself or more than
+// maxIncrementalEvents events.
+func syncSet(root string, events []watcher.FileEvent) ([]string, string) {
+	if len(events) > maxIncrementalEvents {
+		return nil, fmt.Sprintf("more than %d events", maxIncrementalEvents)
+	}
+
+	changed := make(map[string]bool)
+	for _, event := range events {
+		if event.Op == watcher.Rename && event.IsDir {
+			return nil, "folder renamed: " + event.Name
+		}
+		// A renamed file syncs under both names, so the sync can move it
+		names := []string{event.Name}
+		if event.From != "" {
+			names = append(names, event.From)
+		}
+		for _, name := range names {
+			rel, err := filepath.Rel(root, name)
+			if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return nil, "source folder changed"
+			}
+			changed[filepath.ToSlash(rel)] = true
+		}
+	}
+
+	var paths []string
+	for p := range changed {
+		covered := false
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if changed[dir] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, ""
+}
+
+// renameSet returns the source-relative old paths of the files events
+// report renamed within root, by new path. A file renamed again later in
+// the batch keeps its first name; one changed after its rename isn't
+// reported, as its content no longer matches the old name's.
+func renameSet(root string, events []watcher.FileEvent) map[string]string {
+	renames := make(map[string]string)
+	for _, event := range events {
+		to, err := filepath.Rel(root, event.Name)
+		if err != nil {
+			continue
+		}
+		to = filepath.ToSlash(to)
+		if event.Op != watcher.Rename || event.From == "" {
+			delete(renames, to)
+			continue
+		}
+		from, err := filepath.Rel(root, event.From)
+		if err != nil {
+			continue
+		}
+		from = filepath.ToSlash(from)
+		if earlier, ok := renames[from]; ok {
+			delete(renames, from)
+			from = earlier
+		}
+		if from != to {
+			renames[to] = from
+		}
+	}
+	return renames
+}
+
 // setupLogging configures logging for daemon mode
 func (d *Daemon) setupLogging() error {
+	format := d.config.GetLogFormat()
+	utils.SetFormat(format)
+	utils.SetProgressBars(false) // Nobody watches a daemon's terminal
+	if err := utils.SetLevel(d.config.GetLogLevel()); err != nil {
+		return err
+	}
+	if format == "json" {
+		log.SetOutput(utils.JSONWriter("info"))
+		log.SetFlags(0)
+	}
+
 	if d.logFile == "" {
 		return nil // Use default logging
 	}
@@ -230,20 +729,27 @@ func (d *Daemon) setupLogging() error {
 		return fmt.Errorf("failed to create log directory: %w", err)
 	}
 
-	// Open log file
-	logFile, err := os.OpenFile(d.logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	// Open log file, rotating it by size when configured
+	maxBytes, backups := d.config.GetLogRotation()
+	logFile, err := utils.OpenRotatingFile(d.logFile, maxBytes, backups)
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+		return err
 	}
 
-	// Set log output to file
-	log.SetOutput(logFile)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Set log output to file; in JSON mode the standard logger already
+	// writes through utils
+	utils.SetOutput(logFile)
+	if format != "json" {
+		log.SetOutput(logFile)
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	}
 
 	return nil
 }
 
-// writePIDFile writes the process ID to a file
+// writePIDFile locks the PID file and writes the process ID to it. It
+// fails with ErrAlreadyRunning while another daemon holds the file; a file
+// left behind by a daemon that is gone is taken over.
 func (d *Daemon) writePIDFile() error {
 	if d.pidFile == "" {
 		return nil
@@ -254,28 +760,157 @@ func (d *Daemon) writePIDFile() error {
 		return fmt.Errorf("failed to create PID directory: %w", err)
 	}
 
-	pid := os.Getpid()
-	pidStr := strconv.Itoa(pid)
+	f, locked, err := d.lockPIDFile()
+	if err != nil {
+		if errors.Is(err, errLocked) {
+			_, pid, _ := IsRunning(d.pidFile)
+			return fmt.Errorf("%w (PID %d holds %s)", ErrAlreadyRunning, pid, d.pidFile)
+		}
+		return err
+	}
 
-	if err := os.WriteFile(d.pidFile, []byte(pidStr), 0644); err != nil {
+	// Holding the lock proves no other daemon does; without locks only a
+	// live process tells a running daemon apart from a stale file
+	running, pid, _ := IsRunning(d.pidFile)
+	if running && !locked && pid != os.Getpid() {
+		f.Close()
+		return fmt.Errorf("%w (PID %d in %s)", ErrAlreadyRunning, pid, d.pidFile)
+	}
+	if pid != 0 && pid != os.Getpid() {
+		log.Printf("Taking over stale PID file %s of process %d", d.pidFile, pid)
+	}
+
+	pidStr := strconv.Itoa(os.Getpid())
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write PID file: %w", err)
+	}
+	if _, err := f.WriteAt([]byte(pidStr), 0); err != nil {
+		f.Close()
 		return fmt.Errorf("failed to write PID file: %w", err)
 	}
 
+	d.pidLock = f
 	return nil
 }
 
-// removePIDFile removes the PID file
+// lockPIDFile opens and locks the PID file, reporting whether the platform
+// supports the lock. A daemon exiting meanwhile may have removed the file
+// it opened, so once locked the file must still be the one at the path;
+// if it isn't, the path is opened again.
+func (d *Daemon) lockPIDFile() (*os.File, bool, error) {
+	for {
+		f, err := openPIDFile(d.pidFile, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to open PID file: %w", err)
+		}
+		locked, err := lockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, false, fmt.Errorf("failed to lock PID file: %w", err)
+		}
+		if !locked {
+			return f, false, nil
+		}
+
+		opened, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, false, fmt.Errorf("failed to stat PID file: %w", err)
+		}
+		if current, err := os.Stat(d.pidFile); err == nil && os.SameFile(opened, current) {
+			return f, true, nil
+		}
+		f.Close()
+	}
+}
+
+// removePIDFile removes the PID and status files and releases the lock.
+// The file is removed while still locked: a daemon that opened it just
+// before then locks a file that is no longer at the path, which
+// lockPIDFile notices, opening the path again.
 func (d *Daemon) removePIDFile() {
 	if d.pidFile != "" {
 		os.Remove(d.pidFile)
+		os.Remove(statusPath(d.pidFile))
+	}
+	if d.pidLock != nil {
+		d.pidLock.Close()
+		d.pidLock = nil
 	}
 }
 
-// reloadConfig reloads the daemon configuration
-func (d *Daemon) reloadConfig() error {
-	// Note: In a more sophisticated implementation, you might want to
-	// reload the config from file and update the daemon settings
-	log.Println("Configuration reload requested (not implemented yet)")
+// reloadConfig re-reads and validates the configuration file and applies
+// it: sync schedule, patterns, log level, destinations and provider
+// settings. Providers whose settings changed are rebuilt on their next
+// sync. On error the current configuration stays in effect.
+func (d *Daemon) reloadConfig(timer *time.Timer) error {
+	if d.configPath == "" {
+		return fmt.Errorf("configuration file path not known")
+	}
+	if _, err := os.Stat(d.configPath); err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg, err := config.Load(d.configPath)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	sched, jitter, err := syncSchedule(cfg)
+	if err != nil {
+		return err
+	}
+	maxBackoff, err := time.ParseDuration(cfg.GetMaxBackoff())
+	if err != nil {
+		return fmt.Errorf("invalid max backoff %s: %w", cfg.GetMaxBackoff(), err)
+	}
+	shutdownTimeout, err := time.ParseDuration(cfg.GetShutdownTimeout())
+	if err != nil {
+		return fmt.Errorf("invalid shutdown timeout %s: %w", cfg.GetShutdownTimeout(), err)
+	}
+	watch, err := watchConfig(cfg)
+	if err != nil {
+		return err
+	}
+	quietPeriod, err := time.ParseDuration(cfg.GetWatchQuietPeriod())
+	if err != nil {
+		return fmt.Errorf("invalid watch quiet period %s: %w", cfg.GetWatchQuietPeriod(), err)
+	}
+
+	// Wait for a running sync so it doesn't see the configuration change
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+
+	if err := d.syncManager.SetConfig(cfg); err != nil {
+		return err
+	}
+	d.config = d.syncManager.GetConfig()
+	if d.watcher != nil {
+		d.watcher.SetConfig(d.config, watch)
+	}
+	d.maxBackoff = maxBackoff
+	d.shutdownTimeout = shutdownTimeout
+	d.quietPeriod.Store(int64(quietPeriod))
+	d.maxFailures = d.config.GetMaxConsecutiveFailures()
+	if err := utils.SetLevel(d.config.GetLogLevel()); err != nil {
+		log.Printf("Failed to apply log level: %v", err)
+	}
+
+	if sched.String() != d.schedule.String() || jitter != d.jitter {
+		log.Printf("Sync schedule changed from %s to %s", d.schedule, sched)
+		d.schedule = sched
+		d.jitter = jitter
+		if d.failures == 0 {
+			delay := d.nextDelay()
+			timer.Reset(delay)
+			d.recordNextSync(delay)
+		}
+	}
+
+	log.Printf("Configuration reloaded from %s", d.configPath)
 	return nil
 }
 
@@ -307,9 +942,10 @@ func IsRunning(pidFile string) (bool, int, error) {
 		return false, pid, nil
 	}
 
-	// Send signal 0 to check if process exists
+	// Send signal 0 to check if process exists. EPERM means it does, but
+	// belongs to another user.
 	err = process.Signal(syscall.Signal(0))
-	if err != nil {
+	if err != nil && !errors.Is(err, syscall.EPERM) {
 		return false, pid, nil
 	}
 
@@ -0,0 +1,516 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/schedule"
+	"github.com/svosadtsia/csync/internal/sync"
+	"github.com/svosadtsia/csync/internal/watcher"
+)
+
+func TestSyncSet(t *testing.T) {
+	root := filepath.FromSlash("/src")
+	event := func(name string, op watcher.Operation, isDir bool) watcher.FileEvent {
+		return watcher.FileEvent{Name: filepath.Join(root, filepath.FromSlash(name)), Op: op, IsDir: isDir}
+	}
+
+	tests := []struct {
+		name     string
+		events   []watcher.FileEvent
+		expected []string
+		full     bool
+	}{
+		{
+			name:     "single file",
+			events:   []watcher.FileEvent{event("docs/a.txt", watcher.Write, false)},
+			expected: []string{"docs/a.txt"},
+		},
+		{
+			name: "duplicates and files inside a new folder collapse",
+			events: []watcher.FileEvent{
+				event("docs/a.txt", watcher.Write, false),
+				event("docs/a.txt", watcher.Write, false),
+				event("photos", watcher.Create, true),
+				event("photos/1.jpg", watcher.Create, false),
+				event("photos/2019/2.jpg", watcher.Create, false),
+			},
+			expected: []string{"docs/a.txt", "photos"},
+		},
+		{
+			name: "file rename syncs both names",
+			events: []watcher.FileEvent{
+				event("old.txt", watcher.Rename, false),
+				event("new.txt", watcher.Create, false),
+			},
+			expected: []string{"new.txt", "old.txt"},
+		},
+		{
+			name: "paired file rename syncs both names",
+			events: []watcher.FileEvent{{
+				Name: filepath.Join(root, "docs", "new.txt"),
+				From: filepath.Join(root, "old.txt"),
+				Op:   watcher.Rename,
+			}},
+			expected: []string{"docs/new.txt", "old.txt"},
+		},
+		{
+			name: "file renamed from outside the source",
+			events: []watcher.FileEvent{{
+				Name: filepath.Join(root, "new.txt"),
+				From: filepath.FromSlash("/elsewhere/old.txt"),
+				Op:   watcher.Rename,
+			}},
+			full: true,
+		},
+		{
+			name:   "folder rename",
+			events: []watcher.FileEvent{event("docs", watcher.Rename, true)},
+			full:   true,
+		},
+		{
+			name:   "outside the source",
+			events: []watcher.FileEvent{{Name: filepath.FromSlash("/elsewhere/a.txt"), Op: watcher.Write}},
+			full:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, reason := syncSet(root, tt.events)
+			if full := reason != ""; full != tt.full {
+				t.Fatalf("syncSet() full sync = %v (%q), expected %v", full, reason, tt.full)
+			}
+			if !tt.full && !reflect.DeepEqual(paths, tt.expected) {
+				t.Errorf("syncSet() = %v, expected %v", paths, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenameSet(t *testing.T) {
+	root := filepath.FromSlash("/src")
+	rename := func(from, to string) watcher.FileEvent {
+		return watcher.FileEvent{Name: filepath.Join(root, to), From: filepath.Join(root, from), Op: watcher.Rename}
+	}
+
+	events := []watcher.FileEvent{
+		rename("a.txt", "b.txt"),
+		rename("b.txt", "docs/c.txt"),
+		rename("d.txt", "e.txt"),
+		{Name: filepath.Join(root, "e.txt"), Op: watcher.Write},
+		{Name: filepath.Join(root, "f.txt"), Op: watcher.Rename},
+	}
+	expected := map[string]string{"docs/c.txt": "a.txt"}
+	if renames := renameSet(root, events); !reflect.DeepEqual(renames, expected) {
+		t.Errorf("renameSet() = %v, expected %v", renames, expected)
+	}
+}
+
+func TestSyncSetFallsBackOnBursts(t *testing.T) {
+	events := make([]watcher.FileEvent, maxIncrementalEvents+1)
+	for i := range events {
+		events[i] = watcher.FileEvent{Name: filepath.Join("/src", "f"), Op: watcher.Write}
+	}
+	if _, reason := syncSet("/src", events); reason == "" {
+		t.Errorf("syncSet() of %d events didn't fall back to a full sync", len(events))
+	}
+}
+
+func TestWatchIgnorePatterns(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.IgnorePatterns = []string{"*.log"}
+	watch, err := watchConfig(cfg)
+	if err != nil {
+		t.Fatalf("watchConfig() failed: %v", err)
+	}
+	if watch.DebounceTime != 2*time.Second || !reflect.DeepEqual(watch.IgnorePatterns, watcher.DefaultWatchConfig().IgnorePatterns) {
+		t.Errorf("Expected the watcher defaults, got %+v", watch)
+	}
+
+	cfg.Optional = &config.OptionalConfig{Daemon: &config.DaemonConfig{
+		WatchIgnorePatterns: []string{"*.bak"},
+		WatchDebounce:       "10ms",
+	}}
+	if watch, err = watchConfig(cfg); err != nil {
+		t.Fatalf("watchConfig() failed: %v", err)
+	}
+	if watch.DebounceTime != 10*time.Millisecond || !reflect.DeepEqual(watch.IgnorePatterns, []string{"*.bak"}) {
+		t.Errorf("Expected the configured settings, got %+v", watch)
+	}
+
+	dir := t.TempDir()
+	fw, err := watcher.NewFileWatcher(cfg, watch)
+	if err != nil {
+		t.Fatalf("NewFileWatcher() failed: %v", err)
+	}
+	defer fw.Stop()
+	if err := fw.AddPath(dir); err != nil {
+		t.Fatalf("AddPath() failed: %v", err)
+	}
+
+	// Files matching either list are ignored; only the last one is reported
+	for _, name := range []string{"notes.bak", "debug.log", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case event := <-fw.Events():
+		if filepath.Base(event.Name) != "notes.txt" {
+			t.Errorf("Expected an event for notes.txt, got %s", event.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event for notes.txt")
+	}
+
+	cfg.Optional.Daemon.WatchDebounce = "soon"
+	if _, err := watchConfig(cfg); err == nil {
+		t.Error("Expected an invalid watch_debounce to fail")
+	}
+}
+
+func TestCollectEventsWaitsForQuiet(t *testing.T) {
+	dir := t.TempDir()
+	fw, err := watcher.NewFileWatcher(config.DefaultConfig(), watcher.DefaultWatchConfig())
+	if err != nil {
+		t.Fatalf("NewFileWatcher() failed: %v", err)
+	}
+	defer fw.Stop()
+	if err := fw.AddPath(dir); err != nil {
+		t.Fatalf("AddPath() failed: %v", err)
+	}
+	d := &Daemon{watcher: fw}
+	d.quietPeriod.Store(int64(300 * time.Millisecond))
+
+	// Files saved a little apart, like a checkout, end up in one batch
+	const files = 5
+	go func() {
+		for i := range files {
+			os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.txt", i)), []byte("x"), 0644)
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+
+	first := <-fw.Events()
+	names := make(map[string]bool)
+	for _, event := range d.collectEvents(context.Background(), first) {
+		names[filepath.Base(event.Name)] = true
+	}
+	if len(names) != files {
+		t.Errorf("Expected one batch of %d files, got %v", files, names)
+	}
+}
+
+func TestShutdownWaitsForRunningSync(t *testing.T) {
+	d := &Daemon{shutdownTimeout: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Simulate a sync that stops shortly after being cancelled
+	d.syncMu.Lock()
+	d.syncing.Store(true)
+	go func() {
+		<-ctx.Done()
+		time.Sleep(50 * time.Millisecond)
+		d.syncing.Store(false)
+		d.syncMu.Unlock()
+	}()
+
+	start := time.Now()
+	d.shutdown(cancel)
+	if took := time.Since(start); took < 50*time.Millisecond || took >= d.shutdownTimeout {
+		t.Errorf("Expected shutdown to wait for the sync to stop, took %s", took)
+	}
+
+	// A sync that ignores cancellation is abandoned after the timeout
+	d.shutdownTimeout = 50 * time.Millisecond
+	d.syncMu.Lock()
+	d.syncing.Store(true)
+	start = time.Now()
+	d.shutdown(func() {})
+	if took := time.Since(start); took < d.shutdownTimeout || took > time.Second {
+		t.Errorf("Expected shutdown to give up after %s, took %s", d.shutdownTimeout, took)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	m.record("s3", 2*time.Second, sync.RunSummary{Uploaded: 3, UploadedBytes: 1024, Skipped: 5}, nil)
+	m.record("s3", time.Second, sync.RunSummary{Uploaded: 1, UploadedBytes: 10}, errors.New("boom"))
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`csync_syncs_total{provider="s3"} 2`,
+		`csync_sync_failures_total{provider="s3"} 1`,
+		`csync_last_sync_duration_seconds{provider="s3"} 1`,
+		`csync_files_uploaded_total{provider="s3"} 4`,
+		`csync_bytes_uploaded_total{provider="s3"} 1034`,
+		`csync_files_skipped_total{provider="s3"} 5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %s in metrics output:\n%s", want, body)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	d := &Daemon{}
+	d.recordNextSync(time.Minute)
+	d.recordSync(errors.New("upload failed"))
+
+	rec := httptest.NewRecorder()
+	d.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var status Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid status JSON %q: %v", rec.Body.String(), err)
+	}
+	if status.PID != os.Getpid() || status.WatchMode || status.Syncing {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.LastSync == nil || status.LastError != "upload failed" {
+		t.Errorf("Expected the failed sync to be reported, got %+v", status)
+	}
+	if status.NextSync == nil || !status.NextSync.After(*status.LastSync) {
+		t.Errorf("Expected the next sync to be scheduled, got %+v", status)
+	}
+}
+
+func TestReadStatus(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "csync.pid")
+	d := &Daemon{pidFile: pidFile}
+	if err := d.writePIDFile(); err != nil {
+		t.Fatal(err)
+	}
+	d.recordStart()
+	d.recordSync(errors.New("upload failed"))
+	d.recordNextSync(time.Minute)
+
+	ps, err := ReadStatus(pidFile)
+	if err != nil {
+		t.Fatalf("ReadStatus() failed: %v", err)
+	}
+	if !ps.Running || ps.Stale || ps.PID != os.Getpid() {
+		t.Errorf("Expected this process to be running, got %+v", ps)
+	}
+	if ps.Status == nil || ps.Status.Started == nil || ps.Status.LastError != "upload failed" || ps.Status.NextSync == nil {
+		t.Fatalf("Expected the saved status, got %+v", ps.Status)
+	}
+	if out := ps.String(); !strings.Contains(out, "Uptime:") || !strings.Contains(out, "failed: upload failed") {
+		t.Errorf("Unexpected description:\n%s", out)
+	}
+
+	// A PID file naming a process that has exited is stale
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+	if ps, err = ReadStatus(pidFile); err != nil {
+		t.Fatalf("ReadStatus() failed: %v", err)
+	}
+	if ps.Running || !ps.Stale || ps.Status != nil {
+		t.Errorf("Expected a stale PID file without status, got %+v", ps)
+	}
+
+	d.removePIDFile()
+	if ps, err = ReadStatus(pidFile); err != nil || ps.Running || ps.Stale {
+		t.Errorf("Expected no daemon, got %+v, %v", ps, err)
+	}
+	if _, err := os.Stat(statusPath(pidFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the status file to be removed, got %v", err)
+	}
+}
+
+func TestPIDFileLock(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "run", "csync.pid")
+
+	// A PID file left by a daemon that has exited is taken over
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Dir(pidFile), 0755)
+	os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+
+	first := &Daemon{pidFile: pidFile}
+	if err := first.writePIDFile(); err != nil {
+		t.Fatalf("Expected the stale PID file to be taken over, got %v", err)
+	}
+	if locked, _ := lockFile(first.pidLock); !locked {
+		t.Skip("PID file locks not supported")
+	}
+	if data, _ := os.ReadFile(pidFile); string(data) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Expected PID file to hold %d, got %q", os.Getpid(), data)
+	}
+
+	// A second daemon on the same PID file is refused while the first runs
+	second := &Daemon{pidFile: pidFile}
+	if err := second.writePIDFile(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("Expected ErrAlreadyRunning, got %v", err)
+	}
+	if _, err := os.Stat(pidFile); err != nil {
+		t.Errorf("Expected the refused daemon to leave the PID file, got %v", err)
+	}
+
+	first.removePIDFile()
+	if err := second.writePIDFile(); err != nil {
+		t.Fatalf("Expected the PID file to be free once removed, got %v", err)
+	}
+
+	// A daemon that opened the file just before the running one removed it
+	// locks the file at the path, so a third one is still refused
+	defer func() { openPIDFile = os.OpenFile }()
+	opens := 0
+	openPIDFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		f, err := os.OpenFile(name, flag, perm)
+		if opens++; opens == 1 {
+			second.removePIDFile()
+		}
+		return f, err
+	}
+	third := &Daemon{pidFile: pidFile}
+	if err := third.writePIDFile(); err != nil {
+		t.Fatalf("Expected the PID file to be free once removed, got %v", err)
+	}
+	defer third.removePIDFile()
+	if opens != 2 {
+		t.Errorf("Expected the removed file to be opened again, got %d opens", opens)
+	}
+	openPIDFile = os.OpenFile
+	if err := (&Daemon{pidFile: pidFile}).writePIDFile(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("Expected ErrAlreadyRunning, got %v", err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if n := newNotifier(); n.addr != nil || n.notify("READY=1") != nil {
+		t.Errorf("Expected no notifications without NOTIFY_SOCKET, got %+v", n)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Unix datagram sockets not supported: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	n := newNotifier()
+	if n.watchdog != 15*time.Second {
+		t.Errorf("Expected pings every 15s, got %s", n.watchdog)
+	}
+	if err := n.notify("READY=1"); err != nil {
+		t.Fatalf("notify() failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if k, err := conn.Read(buf); err != nil || string(buf[:k]) != "READY=1" {
+		t.Errorf("Expected READY=1, got %q, %v", buf[:k], err)
+	}
+
+	// A watchdog meant for another process isn't pinged
+	t.Setenv("WATCHDOG_PID", "1")
+	if n := newNotifier(); n.watchdog != 0 {
+		t.Errorf("Expected no watchdog for another PID, got %s", n.watchdog)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	d := &Daemon{schedule: schedule.Interval(time.Hour), jitter: time.Minute, maxBackoff: 4 * time.Hour}
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	nextIn := func() time.Duration {
+		d.statusMu.Lock()
+		defer d.statusMu.Unlock()
+		return time.Until(d.nextSync)
+	}
+
+	if err := d.scheduleNext(timer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if next := nextIn(); next < 59*time.Minute || next > 61*time.Minute {
+		t.Errorf("Expected the next sync within the hour's jitter, got %s", next)
+	}
+
+	// Unrecoverable errors back off from the scheduled delay
+	if err := d.scheduleNext(timer, sync.Permanent(errors.New("token revoked"))); err != nil {
+		t.Fatal(err)
+	}
+	if next := nextIn(); next < 119*time.Minute || next > 123*time.Minute {
+		t.Errorf("Expected to back off to 2h, got %s", next)
+	}
+
+	// A cron schedule waits for its next matching minute
+	d.schedule, _ = schedule.Parse("*/30 * * * *")
+	d.jitter = 0
+	if err := d.scheduleNext(timer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if next := nextIn(); next <= 0 || next > 30*time.Minute {
+		t.Errorf("Expected the next sync within 30m, got %s", next)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	bodies := make(chan map[string]any, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer server.Close()
+
+	notifications := &config.NotificationsConfig{WebhookURL: server.URL}
+	cfg := &config.Config{Optional: &config.OptionalConfig{Notifications: notifications}}
+	d := &Daemon{config: cfg}
+	report := sync.NewSyncReport(time.Now(), sync.ProviderReport{Provider: "s3", Uploaded: 2, Failed: 1, Bytes: 2048})
+
+	// By default only failures are reported
+	d.notifyWebhook("s3", "/data", report, nil)
+	d.notifyWebhook("s3", "/data", report, errors.New("access denied"))
+	d.webhooks.Wait()
+	if len(bodies) != 1 {
+		t.Fatalf("Expected one webhook call, got %d", len(bodies))
+	}
+	body := <-bodies
+	if body["status"] != "failure" || body["provider"] != "s3" || body["source"] != "/data" || body["uploaded"] != 2.0 || body["failed"] != 1.0 || body["error"] != "access denied" {
+		t.Errorf("Unexpected payload: %v", body)
+	}
+
+	notifications.NotifyOn = config.NotifyAlways
+	notifications.Format = config.WebhookSlack
+	d.notifyWebhook("s3", "/data", report, nil)
+	d.webhooks.Wait()
+	if body := <-bodies; !strings.Contains(fmt.Sprint(body["text"]), "csync synced /data to") {
+		t.Errorf("Expected a Slack message, got %v", body)
+	}
+
+	notifications.NotifyOn = config.NotifyNever
+	d.notifyWebhook("s3", "/data", report, errors.New("access denied"))
+	d.webhooks.Wait()
+	if len(bodies) != 0 {
+		t.Errorf("Expected no webhook call with notify_on never, got %d", len(bodies))
+	}
+}
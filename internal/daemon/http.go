@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startHTTP starts serving metrics on metrics_addr and the health and
+// status endpoints on status_addr, which defaults to the metrics listener.
+// It returns a function that stops the servers.
+func (d *Daemon) startHTTP() (func(), error) {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	metricsAddr := d.config.GetMetricsAddr()
+	if metricsAddr != "" {
+		d.metrics = newMetrics()
+		mux(metricsAddr).Handle("/metrics", d.metrics.handler())
+	}
+
+	statusAddr := d.config.GetStatusAddr()
+	if statusAddr == "" {
+		statusAddr = metricsAddr
+	}
+	if statusAddr != "" {
+		m := mux(statusAddr)
+		m.HandleFunc("/healthz", d.handleHealth)
+		m.HandleFunc("/status", d.handleStatus)
+	}
+
+	var servers []*http.Server
+	stop := func() {
+		for _, server := range servers {
+			stopServer(server)
+		}
+	}
+	for addr, m := range muxes {
+		server, err := serveHTTP(addr, m)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return stop, nil
+}
+
+// serveHTTP starts serving handler on addr. The listener is opened before
+// returning so a bad or busy address is reported.
+func serveHTTP(addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server on %s failed: %v", listener.Addr(), err)
+		}
+	}()
+	log.Printf("Serving HTTP on %s", listener.Addr())
+	return server, nil
+}
+
+// stopServer shuts an HTTP server down, giving open requests a moment to
+// finish
+func stopServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop HTTP server: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/svosadtsia/csync/internal/sync"
+)
+
+// metrics are the daemon's Prometheus metrics, all labeled by provider
+type metrics struct {
+	registry      *prometheus.Registry
+	syncs         *prometheus.CounterVec
+	failures      *prometheus.CounterVec
+	lastDuration  *prometheus.GaugeVec
+	filesUploaded *prometheus.CounterVec
+	bytesUploaded *prometheus.CounterVec
+	filesSkipped  *prometheus.CounterVec
+}
+
+// newMetrics creates the daemon's metrics in their own registry
+func newMetrics() *metrics {
+	labels := []string{"provider"}
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		syncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csync_syncs_total",
+			Help: "Syncs run by the daemon.",
+		}, labels),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csync_sync_failures_total",
+			Help: "Syncs that returned an error.",
+		}, labels),
+		lastDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "csync_last_sync_duration_seconds",
+			Help: "Duration of the most recent sync.",
+		}, labels),
+		filesUploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csync_files_uploaded_total",
+			Help: "Files uploaded.",
+		}, labels),
+		bytesUploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csync_bytes_uploaded_total",
+			Help: "Bytes of file content uploaded.",
+		}, labels),
+		filesSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "csync_files_skipped_total",
+			Help: "Files not uploaded because they were unchanged or filtered out.",
+		}, labels),
+	}
+	m.registry.MustRegister(m.syncs, m.failures, m.lastDuration, m.filesUploaded, m.bytesUploaded, m.filesSkipped)
+	return m
+}
+
+// record counts one sync to provider that took took and ended with err
+func (m *metrics) record(provider string, took time.Duration, summary sync.RunSummary, err error) {
+	m.syncs.WithLabelValues(provider).Inc()
+	if err != nil {
+		m.failures.WithLabelValues(provider).Inc()
+	}
+	m.lastDuration.WithLabelValues(provider).Set(took.Seconds())
+	m.filesUploaded.WithLabelValues(provider).Add(float64(summary.Uploaded))
+	m.bytesUploaded.WithLabelValues(provider).Add(float64(summary.UploadedBytes))
+	m.filesSkipped.WithLabelValues(provider).Add(float64(summary.Skipped))
+}
+
+// handler serves the metrics in the Prometheus exposition format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
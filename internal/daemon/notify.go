@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// notifier sends service manager notifications, like systemd's
+// sd_notify(3), to the datagram socket named by $NOTIFY_SOCKET. Without
+// the variable it sends nothing, so daemons not run by systemd with
+// Type=notify are unaffected.
+type notifier struct {
+	addr     *net.UnixAddr
+	watchdog time.Duration // Interval to send WATCHDOG=1 at, 0 for none
+}
+
+// newNotifier reads the notification socket and watchdog timeout the
+// service manager passed in the environment
+func newNotifier() notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return notifier{}
+	}
+	n := notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+
+	// Ping at half the timeout, as sd_watchdog_enabled(3) recommends. A
+	// watchdog meant for another process is left alone.
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return n
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return n
+	}
+	n.watchdog = time.Duration(usec) * time.Microsecond / 2
+	return n
+}
+
+// notify sends state, such as "READY=1", to the service manager
+func (n notifier) notify(state string) error {
+	if n.addr == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to send %q to notify socket: %w", state, err)
+	}
+	return nil
+}
+
+// watchdogTicks returns a channel to send WATCHDOG=1 on and a function
+// stopping it. The channel is nil, and never ready, without a watchdog.
+func (n notifier) watchdogTicks() (<-chan time.Time, func()) {
+	if n.addr == nil || n.watchdog <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(n.watchdog)
+	return ticker.C, ticker.Stop
+}
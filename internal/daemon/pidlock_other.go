@@ -0,0 +1,11 @@
+//go:build !unix
+
+package daemon
+
+import "os"
+
+// lockFile doesn't lock on platforms without flock; the PID in the file
+// is all that tells a running daemon apart from a stale file
+func lockFile(f *os.File) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,198 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Status is the daemon state reported at /status and saved next to the PID
+// file
+type Status struct {
+	PID       int        `json:"pid"`
+	WatchMode bool       `json:"watch_mode"`
+	Syncing   bool       `json:"syncing"`
+	Started   *time.Time `json:"started,omitempty"`    // When the daemon started
+	LastSync  *time.Time `json:"last_sync,omitempty"`  // When the most recent sync finished
+	LastError string     `json:"last_error,omitempty"` // Its error, empty if it succeeded
+	NextSync  *time.Time `json:"next_sync,omitempty"`  // When the next scheduled sync starts
+}
+
+// Status returns a snapshot of the daemon's state
+func (d *Daemon) Status() Status {
+	d.statusMu.Lock()
+	defer d.statusMu.Unlock()
+
+	status := Status{
+		PID:       os.Getpid(),
+		WatchMode: d.watcher != nil,
+		Syncing:   d.syncing.Load(),
+		LastError: d.lastError,
+	}
+	if !d.started.IsZero() {
+		started := d.started
+		status.Started = &started
+	}
+	if !d.lastSync.IsZero() {
+		lastSync := d.lastSync
+		status.LastSync = &lastSync
+	}
+	if !d.nextSync.IsZero() {
+		nextSync := d.nextSync
+		status.NextSync = &nextSync
+	}
+	return status
+}
+
+// recordStart notes when the daemon started and saves its first status
+func (d *Daemon) recordStart() {
+	d.statusMu.Lock()
+	d.started = time.Now()
+	d.statusMu.Unlock()
+	d.saveStatus()
+}
+
+// recordSync notes a finished sync for the status endpoint. performSync
+// saves the status once the sync is over.
+func (d *Daemon) recordSync(err error) {
+	d.statusMu.Lock()
+	defer d.statusMu.Unlock()
+
+	d.lastSync = time.Now()
+	d.lastError = ""
+	if err != nil {
+		d.lastError = err.Error()
+	}
+}
+
+// recordNextSync notes when the timer fires next
+func (d *Daemon) recordNextSync(after time.Duration) {
+	d.statusMu.Lock()
+	d.nextSync = time.Now().Add(after)
+	d.statusMu.Unlock()
+	d.saveStatus()
+}
+
+// statusPath returns the file a daemon saves its status to: csync.pid's
+// is csync.status.json
+func statusPath(pidFile string) string {
+	return strings.TrimSuffix(pidFile, filepath.Ext(pidFile)) + ".status.json"
+}
+
+// saveStatus writes the daemon's status next to its PID file, for
+// ReadStatus. The file is replaced in one step so readers never see half
+// of it.
+func (d *Daemon) saveStatus() {
+	if d.pidFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(d.Status(), "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode status: %v", err)
+		return
+	}
+
+	path := statusPath(d.pidFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Failed to write status file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		log.Printf("Failed to write status file: %v", err)
+	}
+}
+
+// ProcessStatus is what ReadStatus finds out about a daemon
+type ProcessStatus struct {
+	Running bool    // The process in the PID file is alive
+	PID     int     // PID from the PID file, 0 without one
+	Stale   bool    // The PID file names a process that is gone
+	Status  *Status // What the daemon last saved, nil if unknown
+}
+
+// ReadStatus reports on the daemon using pidFile from the PID file and the
+// status saved next to it, without contacting the daemon. A PID file left
+// behind by a daemon that died is reported as stale, with the status that
+// daemon saved last.
+func ReadStatus(pidFile string) (ProcessStatus, error) {
+	running, pid, err := IsRunning(pidFile)
+	if err != nil {
+		return ProcessStatus{}, err
+	}
+	ps := ProcessStatus{Running: running, PID: pid, Stale: pid != 0 && !running}
+	if pid == 0 {
+		return ps, nil
+	}
+
+	data, err := os.ReadFile(statusPath(pidFile))
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return ps, fmt.Errorf("failed to read status file: %w", err)
+	}
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return ps, fmt.Errorf("invalid status file: %w", err)
+	}
+	// A status from an earlier daemon says nothing about this one
+	if status.PID == pid {
+		ps.Status = &status
+	}
+	return ps, nil
+}
+
+// String describes the daemon for the command line
+func (ps ProcessStatus) String() string {
+	var b strings.Builder
+	switch {
+	case ps.Running:
+		fmt.Fprintf(&b, "Daemon is running (PID %d)\n", ps.PID)
+	case ps.Stale:
+		fmt.Fprintf(&b, "Daemon is not running (stale PID file for %d)\n", ps.PID)
+	default:
+		return "Daemon is not running\n"
+	}
+
+	status := ps.Status
+	if status == nil {
+		return b.String()
+	}
+	if status.Started != nil && ps.Running {
+		fmt.Fprintf(&b, "Uptime: %s\n", time.Since(*status.Started).Round(time.Second))
+	}
+	if status.Syncing && ps.Running {
+		b.WriteString("Syncing now\n")
+	}
+	switch {
+	case status.LastSync == nil:
+		b.WriteString("Last sync: none yet\n")
+	case status.LastError != "":
+		fmt.Fprintf(&b, "Last sync: %s, failed: %s\n", status.LastSync.Format(time.RFC3339), status.LastError)
+	default:
+		fmt.Fprintf(&b, "Last sync: %s, succeeded\n", status.LastSync.Format(time.RFC3339))
+	}
+	if status.NextSync != nil && ps.Running {
+		fmt.Fprintf(&b, "Next sync: %s\n", status.NextSync.Format(time.RFC3339))
+	}
+	return b.String()
+}
+
+// handleHealth answers liveness probes
+func (d *Daemon) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleStatus reports the daemon's state as JSON
+func (d *Daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Status())
+}
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/sync"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// webhookTimeout bounds a webhook call, so a slow endpoint can't pile up
+// calls from one sync after another
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is the JSON posted to the webhook after a sync
+type webhookPayload struct {
+	Status     string        `json:"status"`   // "success" or "failure"
+	Provider   string        `json:"provider"` // A provider name or "all"
+	Source     string        `json:"source"`
+	Host       string        `json:"host,omitempty"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration_ns"`
+	Uploaded   int           `json:"uploaded"`
+	Downloaded int           `json:"downloaded,omitempty"`
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Bytes      int64         `json:"bytes"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// notifyWebhook posts the outcome of a sync to the configured webhook, when
+// notify_on asks for it. The call runs in the background and failures are
+// only logged, so it never holds up the next sync.
+func (d *Daemon) notifyWebhook(provider, sourcePath string, report sync.SyncReport, err error) {
+	notifications := d.config.GetNotifications()
+	switch notifyOn := d.config.GetNotifyOn(); {
+	case notifications.WebhookURL == "", notifyOn == config.NotifyNever:
+		return
+	case notifyOn == config.NotifyFailure && err == nil:
+		return
+	}
+
+	payload := webhookPayload{
+		Status:     "success",
+		Provider:   provider,
+		Source:     sourcePath,
+		Started:    report.Started,
+		Duration:   report.Duration,
+		Uploaded:   report.Uploaded,
+		Downloaded: report.Downloaded,
+		Skipped:    report.Skipped,
+		Failed:     report.Failed,
+		Bytes:      report.Bytes,
+	}
+	if err != nil {
+		payload.Status, payload.Error = "failure", err.Error()
+	}
+	payload.Host, _ = os.Hostname()
+
+	var body any = payload
+	if notifications.Format == config.WebhookSlack {
+		body = map[string]string{"text": slackText(payload)}
+	}
+	data, jsonErr := json.Marshal(body)
+	if jsonErr != nil {
+		log.Printf("Failed to encode webhook payload: %v", jsonErr)
+		return
+	}
+
+	d.webhooks.Add(1)
+	go func() {
+		defer d.webhooks.Done()
+		if err := postWebhook(notifications.WebhookURL, data); err != nil {
+			log.Printf("Failed to call notification webhook: %v", err)
+		}
+	}()
+}
+
+// postWebhook posts data as JSON to url
+func postWebhook(url string, data []byte) error {
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// slackText describes a sync's outcome for a Slack incoming webhook
+func slackText(p webhookPayload) string {
+	where := p.Provider
+	if p.Provider != "all" {
+		where = sync.ProviderDisplayName(p.Provider)
+	}
+	if p.Host != "" {
+		where += " on " + p.Host
+	}
+
+	counts := fmt.Sprintf("%d uploaded (%s), %d skipped, %d failed", p.Uploaded, utils.FormatBytes(p.Bytes), p.Skipped, p.Failed)
+	if p.Status != "success" {
+		return fmt.Sprintf(":x: csync sync of %s to %s failed after %s: %s\n%s", p.Source, where, p.Duration.Round(time.Second), p.Error, counts)
+	}
+	return fmt.Sprintf(":white_check_mark: csync synced %s to %s in %s\n%s", p.Source, where, p.Duration.Round(time.Second), counts)
+}
@@ -0,0 +1,733 @@
+package b2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+const (
+	// authURL is where accounts are authorized; it returns the API and
+	// download URLs for everything else
+	authURL = "https://api.backblazeb2.com"
+
+	// folderMarker is the empty file B2's web UI uses to keep a folder
+	folderMarker = ".bzEmpty"
+
+	// emptySHA1 is the SHA1 of no content
+	emptySHA1 = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+
+	// sha1AtEnd in place of a SHA1 tells B2 the hex SHA1 follows the data
+	sha1AtEnd = "hex_digits_at_end"
+
+	// defaultPartSize is used when authorization doesn't recommend one
+	defaultPartSize = 100 << 20
+)
+
+// Client represents a Backblaze B2 client using the native B2 API
+type Client struct {
+	config  *config.B2Config
+	http    *http.Client
+	authURL string
+	limiter *throttle.Limiter
+
+	mu       gosync.Mutex
+	auth     authorization
+	bucketID string
+	uploads  []*uploadURL // Idle upload URLs, each used by one upload at a time
+}
+
+// authorization is the result of b2_authorize_account
+type authorization struct {
+	AccountID           string `json:"accountId"`
+	Token               string `json:"authorizationToken"`
+	APIURL              string `json:"apiUrl"`
+	DownloadURL         string `json:"downloadUrl"`
+	RecommendedPartSize int64  `json:"recommendedPartSize"`
+	Allowed             struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+}
+
+// uploadURL is the result of b2_get_upload_url or b2_get_upload_part_url
+type uploadURL struct {
+	URL   string `json:"uploadUrl"`
+	Token string `json:"authorizationToken"`
+}
+
+// fileInfo is a B2 file version
+type fileInfo struct {
+	FileID          string            `json:"fileId"`
+	FileName        string            `json:"fileName"`
+	Action          string            `json:"action"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentSHA1     string            `json:"contentSha1"`
+	FileInfo        map[string]string `json:"fileInfo"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+}
+
+// Object describes a stored file. Path is relative to the configured prefix.
+type Object struct {
+	ID           string
+	Path         string
+	Size         int64
+	SHA1         string // Empty when B2 doesn't know it
+	LastModified time.Time
+	IsDir        bool
+}
+
+// APIError is an error response from the B2 API
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("b2 API error (%d %s): %s", e.Status, e.Code, e.Message)
+}
+
+// HTTPStatusCode returns the HTTP status of the response
+func (e *APIError) HTTPStatusCode() int {
+	return e.Status
+}
+
+// IsNotFound reports whether err is B2's response for a missing file or bucket
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// isExpired reports whether err is B2's response for an expired
+// authorization token, which is fixed by authorizing again
+func isExpired(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized && apiErr.Code == "expired_auth_token"
+}
+
+// NewClient creates a new B2 client
+func NewClient(ctx context.Context, cfg *config.B2Config) (*Client, error) {
+	return NewClientWithTransport(ctx, cfg, nil)
+}
+
+// NewClientWithTransport creates a B2 client whose API requests go through
+// transport (nil for the default)
+func NewClientWithTransport(ctx context.Context, cfg *config.B2Config, transport http.RoundTripper) (*Client, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("b2 bucket is required")
+	}
+	if cfg.KeyID == "" || cfg.ApplicationKey == "" {
+		return nil, fmt.Errorf("b2 key_id and application_key are required")
+	}
+
+	client := &Client{
+		config:  cfg,
+		http:    &http.Client{Transport: transport},
+		authURL: authURL,
+	}
+	if err := client.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	utils.LogVerbose("Successfully connected to B2 bucket %s", cfg.Bucket)
+	return client, nil
+}
+
+// authorize gets an account authorization token and looks up the bucket
+func (c *Client) authorize(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.authURL+"/b2api/v2/b2_authorize_account", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create authorization request: %w", err)
+	}
+	req.SetBasicAuth(c.config.KeyID, c.config.ApplicationKey)
+
+	var auth authorization
+	if err := c.send(req, &auth); err != nil {
+		return fmt.Errorf("failed to authorize account: %w", err)
+	}
+
+	c.mu.Lock()
+	c.auth = auth
+	c.uploads = nil // Tokens of the old URLs may have expired too
+	bucketID := c.bucketID
+	c.mu.Unlock()
+	if bucketID != "" {
+		return nil
+	}
+
+	// Keys restricted to one bucket name it
+	if auth.Allowed.BucketID != "" && auth.Allowed.BucketName == c.config.Bucket {
+		bucketID = auth.Allowed.BucketID
+	} else {
+		var buckets struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}
+		request := map[string]any{"accountId": auth.AccountID, "bucketName": c.config.Bucket}
+		if err := c.call(ctx, "b2_list_buckets", request, &buckets); err != nil {
+			return fmt.Errorf("failed to look up bucket %s: %w", c.config.Bucket, err)
+		}
+		if len(buckets.Buckets) == 0 {
+			return fmt.Errorf("bucket %s not found", c.config.Bucket)
+		}
+		bucketID = buckets.Buckets[0].BucketID
+	}
+
+	c.mu.Lock()
+	c.bucketID = bucketID
+	c.mu.Unlock()
+	return nil
+}
+
+// call sends a B2 API request, authorizing again once if the token expired
+func (c *Client) call(ctx context.Context, endpoint string, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		c.mu.Lock()
+		auth := c.auth
+		c.mu.Unlock()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, auth.APIURL+"/b2api/v2/"+endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Authorization", auth.Token)
+
+		err = c.send(req, response)
+		if isExpired(err) && attempt == 0 {
+			if err := c.authorize(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		return err
+	}
+}
+
+// send sends req and decodes the response into out (which may be nil).
+// Error responses are returned as an *APIError.
+func (c *Client) send(req *http.Request, out any) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(apiErr)
+		apiErr.Status = resp.StatusCode
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// SetUploadLimiter limits the rate at which uploads send data. The limiter
+// may be shared with other clients.
+func (c *Client) SetUploadLimiter(limiter *throttle.Limiter) {
+	c.limiter = limiter
+}
+
+// Key returns the file name for a path relative to the configured prefix
+func (c *Client) Key(relPath string) string {
+	return strings.TrimPrefix(path.Join(c.config.Prefix, filepath.ToSlash(relPath)), "/")
+}
+
+// relPath strips the configured prefix from a file name
+func (c *Client) relPath(key string) string {
+	prefix := strings.Trim(c.config.Prefix, "/")
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+}
+
+// getUploadURL returns an idle upload URL, fetching a new one when every
+// URL is in use. B2 upload URLs take one upload at a time, so concurrent
+// uploads each get their own.
+func (c *Client) getUploadURL(ctx context.Context) (*uploadURL, error) {
+	c.mu.Lock()
+	if n := len(c.uploads); n > 0 {
+		upload := c.uploads[n-1]
+		c.uploads = c.uploads[:n-1]
+		c.mu.Unlock()
+		return upload, nil
+	}
+	bucketID := c.bucketID
+	c.mu.Unlock()
+
+	upload := &uploadURL{}
+	if err := c.call(ctx, "b2_get_upload_url", map[string]any{"bucketId": bucketID}, upload); err != nil {
+		return nil, fmt.Errorf("failed to get upload URL: %w", err)
+	}
+	return upload, nil
+}
+
+// putUploadURL returns an upload URL to the pool after a successful upload
+func (c *Client) putUploadURL(upload *uploadURL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploads = append(c.uploads, upload)
+}
+
+// Upload uploads a local file to the file for relPath. sum is the SHA1 of
+// its content, or "" to compute it: while sending the file, or beforehand
+// for files larger than the recommended part size, which are uploaded as
+// B2 large files.
+func (c *Client) Upload(ctx context.Context, localPath, relPath, sum string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	progress := utils.NewProgress(relPath, info.Size())
+	defer progress.Done()
+
+	modified := strconv.FormatInt(info.ModTime().UnixMilli(), 10)
+	c.mu.Lock()
+	partSize := c.auth.RecommendedPartSize
+	c.mu.Unlock()
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if info.Size() > partSize {
+		// A large file's SHA1 goes with the request starting it
+		if sum == "" {
+			if sum, err = sha1Sum(io.NewSectionReader(file, 0, info.Size())); err != nil {
+				return fmt.Errorf("failed to hash file: %w", err)
+			}
+		}
+		return c.uploadLarge(ctx, file, info.Size(), partSize, c.Key(relPath), sum, modified, progress)
+	}
+
+	body := func() io.Reader {
+		return progress.Reader(c.limiter.Reader(ctx, io.NewSectionReader(file, 0, info.Size())))
+	}
+	if err := c.uploadFile(ctx, c.Key(relPath), body, info.Size(), sum, modified); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	return nil
+}
+
+// uploadFile sends a file with b2_upload_file. With an empty sum the SHA1
+// is taken from the body as it's sent and follows it. A failure on the
+// upload URL itself is retried once with a fresh URL, as B2 asks.
+func (c *Client) uploadFile(ctx context.Context, key string, body func() io.Reader, size int64, sum, modified string) error {
+	for attempt := 0; ; attempt++ {
+		upload, err := c.getUploadURL(ctx)
+		if err != nil {
+			return err
+		}
+
+		data, length, header := body(), size, sum
+		if sum == "" {
+			data, length, header = withSHA1(data), size+sha1.Size*2, sha1AtEnd
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.URL, data)
+		if err != nil {
+			return fmt.Errorf("failed to create upload request: %w", err)
+		}
+		req.ContentLength = length
+		req.Header.Set("Authorization", upload.Token)
+		req.Header.Set("X-Bz-File-Name", encodeName(key))
+		req.Header.Set("Content-Type", "b2/x-auto")
+		req.Header.Set("X-Bz-Content-Sha1", header)
+		if modified != "" {
+			req.Header.Set("X-Bz-Info-src_last_modified_millis", modified)
+		}
+
+		err = c.send(req, nil)
+		if err == nil {
+			c.putUploadURL(upload)
+			return nil
+		}
+		// The URL is dropped: B2 wants a new one after any failure
+		if attempt > 0 || !retryOnNewURL(err) {
+			return err
+		}
+		utils.LogDebug("Retrying upload of %s with a new upload URL: %v", key, err)
+	}
+}
+
+// retryOnNewURL reports whether an upload failure is one B2 says to retry
+// with a new upload URL
+func retryOnNewURL(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return true // Connection failures
+	}
+	return apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusRequestTimeout ||
+		apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= 500
+}
+
+// uploadLarge sends a file in parts with the large file API. The whole
+// file's SHA1 is kept as the large_file_sha1 file info, since B2 has none
+// for large files. The upload is cancelled if a part fails.
+func (c *Client) uploadLarge(ctx context.Context, file *os.File, size, partSize int64, key, sum, modified string, progress *utils.Progress) error {
+	c.mu.Lock()
+	bucketID := c.bucketID
+	c.mu.Unlock()
+
+	var large fileInfo
+	request := map[string]any{
+		"bucketId":    bucketID,
+		"fileName":    key,
+		"contentType": "b2/x-auto",
+		"fileInfo":    map[string]string{"large_file_sha1": sum, "src_last_modified_millis": modified},
+	}
+	if err := c.call(ctx, "b2_start_large_file", request, &large); err != nil {
+		return fmt.Errorf("failed to start large file: %w", err)
+	}
+
+	if err := c.uploadParts(ctx, file, size, partSize, large.FileID, progress); err != nil {
+		if cancelErr := c.call(context.Background(), "b2_cancel_large_file", map[string]any{"fileId": large.FileID}, nil); cancelErr != nil {
+			utils.LogDebug("Failed to cancel large file %s: %v", key, cancelErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// uploadParts sends each part of a large file and finishes it
+func (c *Client) uploadParts(ctx context.Context, file *os.File, size, partSize int64, fileID string, progress *utils.Progress) error {
+	var upload uploadURL
+	if err := c.call(ctx, "b2_get_upload_part_url", map[string]any{"fileId": fileID}, &upload); err != nil {
+		return fmt.Errorf("failed to get upload part URL: %w", err)
+	}
+
+	var sums []string
+	for offset, part := int64(0), 1; offset < size; offset, part = offset+partSize, part+1 {
+		n := min(partSize, size-offset)
+		sum, err := sha1Sum(io.NewSectionReader(file, offset, n))
+		if err != nil {
+			return fmt.Errorf("failed to hash part %d: %w", part, err)
+		}
+
+		body := progress.Reader(c.limiter.Reader(ctx, io.NewSectionReader(file, offset, n)))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, upload.URL, body)
+		if err != nil {
+			return fmt.Errorf("failed to create part request: %w", err)
+		}
+		req.ContentLength = n
+		req.Header.Set("Authorization", upload.Token)
+		req.Header.Set("X-Bz-Part-Number", strconv.Itoa(part))
+		req.Header.Set("X-Bz-Content-Sha1", sum)
+		if err := c.send(req, nil); err != nil {
+			return fmt.Errorf("failed to upload part %d: %w", part, err)
+		}
+		sums = append(sums, sum)
+	}
+
+	request := map[string]any{"fileId": fileID, "partSha1Array": sums}
+	if err := c.call(ctx, "b2_finish_large_file", request, nil); err != nil {
+		return fmt.Errorf("failed to finish large file: %w", err)
+	}
+	return nil
+}
+
+// CreateFolder uploads an empty folder marker so empty folders survive
+func (c *Client) CreateFolder(ctx context.Context, relPath string) error {
+	key := c.Key(path.Join(filepath.ToSlash(relPath), folderMarker))
+	body := func() io.Reader { return bytes.NewReader(nil) }
+	if err := c.uploadFile(ctx, key, body, 0, emptySHA1, ""); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", relPath, err)
+	}
+	return nil
+}
+
+// Stat returns the latest version of the file for relPath, or nil if it
+// doesn't exist
+func (c *Client) Stat(ctx context.Context, relPath string) (*Object, error) {
+	key := c.Key(relPath)
+	files, _, err := c.listFileNames(ctx, key, key, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if len(files) == 0 || files[0].FileName != key {
+		return nil, nil
+	}
+
+	obj := c.object(files[0])
+	return &obj, nil
+}
+
+// List returns every file below relPath ("" for the prefix root). Folder
+// markers are reported as folders.
+func (c *Client) List(ctx context.Context, relPath string) ([]Object, error) {
+	prefix := c.Key(relPath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var objects []Object
+	start := ""
+	for {
+		files, next, err := c.listFileNames(ctx, prefix, start, 1000)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		for _, file := range files {
+			objects = append(objects, c.object(file))
+		}
+		if next == "" {
+			return objects, nil
+		}
+		start = next
+	}
+}
+
+// listFileNames returns up to count of the latest file versions with
+// prefix, from start on, and the name to continue from ("" at the end)
+func (c *Client) listFileNames(ctx context.Context, prefix, start string, count int) ([]fileInfo, string, error) {
+	c.mu.Lock()
+	bucketID := c.bucketID
+	c.mu.Unlock()
+
+	var response struct {
+		Files        []fileInfo `json:"files"`
+		NextFileName *string    `json:"nextFileName"`
+	}
+	request := map[string]any{"bucketId": bucketID, "prefix": prefix, "maxFileCount": count}
+	if start != "" {
+		request["startFileName"] = start
+	}
+	if err := c.call(ctx, "b2_list_file_names", request, &response); err != nil {
+		return nil, "", err
+	}
+
+	var files []fileInfo
+	for _, file := range response.Files {
+		if file.Action == "upload" {
+			files = append(files, file)
+		}
+	}
+	next := ""
+	if response.NextFileName != nil {
+		next = *response.NextFileName
+	}
+	return files, next, nil
+}
+
+// object converts a B2 file version
+func (c *Client) object(file fileInfo) Object {
+	obj := Object{
+		ID:           file.FileID,
+		Path:         c.relPath(file.FileName),
+		Size:         file.ContentLength,
+		LastModified: time.UnixMilli(file.UploadTimestamp),
+	}
+	if path.Base(obj.Path) == folderMarker {
+		obj.Path = path.Dir(obj.Path)
+		obj.Size = 0
+		obj.IsDir = true
+		return obj
+	}
+
+	obj.SHA1 = strings.TrimPrefix(file.ContentSHA1, "unverified:")
+	if obj.SHA1 == "none" || obj.SHA1 == "" {
+		obj.SHA1 = file.FileInfo["large_file_sha1"]
+	}
+	return obj
+}
+
+// Delete removes every version of the file for relPath. Without such a
+// file relPath is treated as a folder and everything below it is removed.
+// Deleting a missing path succeeds.
+func (c *Client) Delete(ctx context.Context, relPath string) error {
+	key := c.Key(relPath)
+	deleted, err := c.deleteVersions(ctx, key, func(name string) bool { return name == key })
+	if err != nil || deleted > 0 {
+		return err
+	}
+
+	prefix := key + "/"
+	_, err = c.deleteVersions(ctx, prefix, func(string) bool { return true })
+	return err
+}
+
+// deleteVersions deletes every version with prefix whose name matches and
+// returns how many were deleted
+func (c *Client) deleteVersions(ctx context.Context, prefix string, match func(name string) bool) (int, error) {
+	c.mu.Lock()
+	bucketID := c.bucketID
+	c.mu.Unlock()
+
+	deleted := 0
+	request := map[string]any{"bucketId": bucketID, "prefix": prefix, "startFileName": prefix, "maxFileCount": 1000}
+	for {
+		var response struct {
+			Files        []fileInfo `json:"files"`
+			NextFileName *string    `json:"nextFileName"`
+			NextFileID   *string    `json:"nextFileId"`
+		}
+		if err := c.call(ctx, "b2_list_file_versions", request, &response); err != nil {
+			return deleted, fmt.Errorf("failed to list versions of %s: %w", prefix, err)
+		}
+
+		for _, file := range response.Files {
+			if !match(file.FileName) {
+				continue
+			}
+			versionRequest := map[string]any{"fileName": file.FileName, "fileId": file.FileID}
+			if err := c.call(ctx, "b2_delete_file_version", versionRequest, nil); err != nil && !IsNotFound(err) {
+				return deleted, fmt.Errorf("failed to delete %s: %w", file.FileName, err)
+			}
+			deleted++
+		}
+
+		if response.NextFileName == nil {
+			return deleted, nil
+		}
+		request["startFileName"] = *response.NextFileName
+		if response.NextFileID != nil {
+			request["startFileId"] = *response.NextFileID
+		}
+	}
+}
+
+// Copy copies a file on the server side
+func (c *Client) Copy(ctx context.Context, srcRelPath, dstRelPath string) error {
+	src, err := c.Stat(ctx, srcRelPath)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return fmt.Errorf("failed to copy %s: file not found", srcRelPath)
+	}
+
+	request := map[string]any{"sourceFileId": src.ID, "fileName": c.Key(dstRelPath)}
+	if err := c.call(ctx, "b2_copy_file", request, nil); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcRelPath, dstRelPath, err)
+	}
+	return nil
+}
+
+// Move copies a file to its new name and deletes the original. B2 has no
+// rename, so a failure in between leaves both files in place.
+func (c *Client) Move(ctx context.Context, srcRelPath, dstRelPath string) error {
+	if err := c.Copy(ctx, srcRelPath, dstRelPath); err != nil {
+		return err
+	}
+	return c.Delete(ctx, srcRelPath)
+}
+
+// Open returns the content of the file for relPath starting at offset
+func (c *Client) Open(ctx context.Context, relPath string, offset int64) (io.ReadCloser, error) {
+	for attempt := 0; ; attempt++ {
+		c.mu.Lock()
+		auth := c.auth
+		c.mu.Unlock()
+
+		fileURL := auth.DownloadURL + "/file/" + encodeName(c.config.Bucket) + "/" + encodeName(c.Key(relPath))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create download request: %w", err)
+		}
+		req.Header.Set("Authorization", auth.Token)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", relPath, err)
+		}
+		if resp.StatusCode < 300 {
+			if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+				resp.Body.Close()
+				return nil, fmt.Errorf("failed to download %s: server ignored the range request", relPath)
+			}
+			return resp.Body, nil
+		}
+
+		apiErr := &APIError{}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(apiErr)
+		apiErr.Status = resp.StatusCode
+		resp.Body.Close()
+		if isExpired(apiErr) && attempt == 0 {
+			if err := c.authorize(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, fmt.Errorf("failed to download %s: %w", relPath, apiErr)
+	}
+}
+
+// encodeName percent-encodes a file name for B2, keeping slashes
+func encodeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || strings.IndexByte("-._~/", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// withSHA1 returns r followed by the hex-encoded SHA1 of its content
+func withSHA1(r io.Reader) io.Reader {
+	hash := sha1.New()
+	return io.MultiReader(io.TeeReader(r, hash), &sumReader{hash: hash})
+}
+
+// sumReader reads the hex-encoded sum of hash, taken on the first read
+type sumReader struct {
+	hash hash.Hash
+	sum  io.Reader
+}
+
+func (r *sumReader) Read(p []byte) (int, error) {
+	if r.sum == nil {
+		r.sum = strings.NewReader(hex.EncodeToString(r.hash.Sum(nil)))
+	}
+	return r.sum.Read(p)
+}
+
+// sha1Sum returns the hex-encoded SHA1 of r's content
+func sha1Sum(r io.Reader) (string, error) {
+	hash := sha1.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,608 @@
+package onedrive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+const (
+	// graphURL is the Microsoft Graph API root
+	graphURL = "https://graph.microsoft.com/v1.0"
+
+	// defaultTenant lets both personal and work or school accounts sign in
+	defaultTenant = "common"
+
+	// simpleUploadLimit is the largest file sent with a single PUT; larger
+	// files use an upload session
+	simpleUploadLimit = 4 << 20
+
+	// chunkSize is the size of each upload session request. Graph requires
+	// a multiple of 320 KiB.
+	chunkSize = 32 * 320 << 10
+)
+
+// scopes are the delegated permissions csync asks for
+var scopes = []string{"Files.ReadWrite", "offline_access"}
+
+// Client represents a OneDrive client using the Microsoft Graph API
+type Client struct {
+	config  *config.OneDriveConfig
+	http    *http.Client // Authorized client, for Graph requests
+	upload  *http.Client // Plain client, for pre-authenticated upload URLs
+	baseURL string
+	limiter *throttle.Limiter
+
+	mu      gosync.Mutex
+	folders map[string]string // IDs of folders known to exist, by full path
+}
+
+// Item describes a drive item. Path is relative to the destination folder.
+type Item struct {
+	ID           string
+	Path         string
+	Size         int64
+	LastModified time.Time
+	IsDir        bool
+}
+
+// driveItem is a Graph driveItem resource
+type driveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	Folder               *struct{} `json:"folder,omitempty"`
+}
+
+// APIError is an error response from the Graph API
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("graph API error (%d)", e.StatusCode)
+	}
+	return fmt.Sprintf("graph API error (%d %s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// HTTPStatusCode returns the HTTP status of the response
+func (e *APIError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// IsNotFound reports whether err is Graph's response for a missing item
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// NewClient creates a new OneDrive client
+func NewClient(ctx context.Context, cfg *config.OneDriveConfig) (*Client, error) {
+	return NewClientWithTransport(ctx, cfg, nil)
+}
+
+// NewClientWithTransport creates a OneDrive client whose API requests go
+// through transport (nil for the default)
+func NewClientWithTransport(ctx context.Context, cfg *config.OneDriveConfig, transport http.RoundTripper) (*Client, error) {
+	if cfg.ClientID == "" || cfg.TokenPath == "" {
+		return nil, fmt.Errorf("onedrive client_id and token_path are required")
+	}
+
+	tenant := cfg.TenantID
+	if tenant == "" {
+		tenant = defaultTenant
+	}
+	redirectURL := cfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = "http://localhost"
+	}
+
+	endpoint := microsoft.AzureADEndpoint(tenant)
+	endpoint.AuthStyle = oauth2.AuthStyleInParams
+	oauthConfig := &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+
+	httpClient, err := getClient(oauthConfig, cfg.TokenPath, transport)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get OAuth2 client: %w", err)
+	}
+
+	client := &Client{
+		config:  cfg,
+		http:    httpClient,
+		upload:  &http.Client{Transport: transport},
+		baseURL: graphURL,
+		folders: make(map[string]string),
+	}
+
+	// Check the drive is reachable with the cached token
+	if err := client.do(ctx, http.MethodGet, client.baseURL+"/me/drive", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to access drive: %w", err)
+	}
+
+	utils.LogVerbose("Successfully connected to OneDrive (destination_path: '%s', folder_id: '%s')", cfg.DestinationPath, cfg.FolderID)
+	return client, nil
+}
+
+// SetUploadLimiter limits the rate at which uploads send data. The limiter
+// may be shared with other clients.
+func (c *Client) SetUploadLimiter(limiter *throttle.Limiter) {
+	c.limiter = limiter
+}
+
+// fullPath prefixes a path with the configured destination path
+func (c *Client) fullPath(relPath string) string {
+	return strings.Trim(path.Join(c.config.DestinationPath, filepath.ToSlash(relPath)), "/")
+}
+
+// itemURL returns the Graph URL addressing the item at a full path below
+// the configured folder, or the drive root
+func (c *Client) itemURL(fullPath string) string {
+	base := c.baseURL + "/me/drive/root"
+	if c.config.FolderID != "" {
+		base = c.baseURL + "/me/drive/items/" + url.PathEscape(c.config.FolderID)
+	}
+	if fullPath == "" {
+		return base
+	}
+
+	segments := strings.Split(fullPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return base + ":/" + strings.Join(segments, "/") + ":"
+}
+
+// Upload uploads a local file to relPath, creating parent folders as needed.
+// Small files are sent in one request, larger ones through an upload session.
+func (c *Client) Upload(ctx context.Context, localPath, relPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	fullPath := c.fullPath(relPath)
+	if _, err := c.ensureFolder(ctx, path.Dir(fullPath)); err != nil {
+		return fmt.Errorf("failed to create parent folders: %w", err)
+	}
+
+	progress := utils.NewProgress(relPath, info.Size())
+	defer progress.Done()
+
+	if info.Size() <= simpleUploadLimit {
+		body := progress.Reader(c.limiter.Reader(ctx, file))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.itemURL(fullPath)+"/content", body)
+		if err != nil {
+			return fmt.Errorf("failed to create upload request: %w", err)
+		}
+		req.ContentLength = info.Size()
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if err := c.send(c.http, req, nil); err != nil {
+			return fmt.Errorf("failed to upload file: %w", err)
+		}
+		return nil
+	}
+
+	return c.uploadSession(ctx, file, info.Size(), fullPath, progress)
+}
+
+// uploadSession sends a large file in chunks through a Graph upload
+// session. The session is cancelled if a chunk fails.
+func (c *Client) uploadSession(ctx context.Context, file *os.File, size int64, fullPath string, progress *utils.Progress) error {
+	request := map[string]any{
+		"item": map[string]any{"@microsoft.graph.conflictBehavior": "replace"},
+	}
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.itemURL(fullPath)+"/createUploadSession", request, &session); err != nil {
+		return fmt.Errorf("failed to create upload session: %w", err)
+	}
+
+	for offset := int64(0); offset < size; offset += chunkSize {
+		n := min(chunkSize, size-offset)
+		chunk := progress.Reader(c.limiter.Reader(ctx, io.NewSectionReader(file, offset, n)))
+
+		// The upload URL is pre-authenticated and must not get a token
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to create chunk request: %w", err)
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, size))
+		if err := c.send(c.upload, req, nil); err != nil {
+			c.cancelSession(session.UploadURL)
+			return fmt.Errorf("failed to upload chunk at offset %d: %w", offset, err)
+		}
+	}
+
+	return nil
+}
+
+// cancelSession deletes an unfinished upload session so its chunks are
+// discarded
+func (c *Client) cancelSession(uploadURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, uploadURL, nil)
+	if err != nil {
+		return
+	}
+	if err := c.send(c.upload, req, nil); err != nil {
+		utils.LogDebug("Failed to cancel upload session: %v", err)
+	}
+}
+
+// CreateFolder creates a folder and any missing parents. Folders that
+// already exist are left as they are.
+func (c *Client) CreateFolder(ctx context.Context, relPath string) error {
+	if _, err := c.ensureFolder(ctx, c.fullPath(relPath)); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", relPath, err)
+	}
+	return nil
+}
+
+// ensureFolder creates each missing folder of fullPath through its parent's
+// children endpoint and returns the folder's ID
+func (c *Client) ensureFolder(ctx context.Context, fullPath string) (string, error) {
+	if fullPath == "." {
+		fullPath = ""
+	}
+
+	c.mu.Lock()
+	id, ok := c.folders[fullPath]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	if fullPath == "" {
+		var root driveItem
+		if err := c.do(ctx, http.MethodGet, c.itemURL(""), nil, &root); err != nil {
+			return "", err
+		}
+		id = root.ID
+	} else {
+		parentID, err := c.ensureFolder(ctx, path.Dir(fullPath))
+		if err != nil {
+			return "", err
+		}
+
+		request := map[string]any{
+			"name":                              path.Base(fullPath),
+			"folder":                            map[string]any{},
+			"@microsoft.graph.conflictBehavior": "fail",
+		}
+		var folder driveItem
+		err = c.do(ctx, http.MethodPost, c.baseURL+"/me/drive/items/"+url.PathEscape(parentID)+"/children", request, &folder)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			// Already exists, possibly created by a concurrent upload
+			err = c.do(ctx, http.MethodGet, c.itemURL(fullPath), nil, &folder)
+		}
+		if err != nil {
+			return "", err
+		}
+		if folder.Folder == nil {
+			return "", fmt.Errorf("%s exists and is not a folder", fullPath)
+		}
+		id = folder.ID
+	}
+
+	c.mu.Lock()
+	c.folders[fullPath] = id
+	c.mu.Unlock()
+	return id, nil
+}
+
+// Stat returns the item at relPath, or nil if it doesn't exist
+func (c *Client) Stat(ctx context.Context, relPath string) (*Item, error) {
+	var item driveItem
+	err := c.do(ctx, http.MethodGet, c.itemURL(c.fullPath(relPath)), nil, &item)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item info: %w", err)
+	}
+
+	result := newItem(filepath.ToSlash(relPath), item)
+	return &result, nil
+}
+
+// Delete removes the item at relPath, with its contents if it is a folder.
+// Deleting a missing item succeeds.
+func (c *Client) Delete(ctx context.Context, relPath string) error {
+	fullPath := c.fullPath(relPath)
+	err := c.do(ctx, http.MethodDelete, c.itemURL(fullPath), nil, nil)
+	if err != nil && !IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %w", relPath, err)
+	}
+
+	c.mu.Lock()
+	for folder := range c.folders {
+		if folder == fullPath || strings.HasPrefix(folder, fullPath+"/") {
+			delete(c.folders, folder)
+		}
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Move moves or renames an item, replacing any item at dstRelPath
+func (c *Client) Move(ctx context.Context, srcRelPath, dstRelPath string) error {
+	dstPath := c.fullPath(dstRelPath)
+	parentID, err := c.ensureFolder(ctx, path.Dir(dstPath))
+	if err != nil {
+		return fmt.Errorf("failed to create parent folders: %w", err)
+	}
+
+	request := map[string]any{
+		"parentReference": map[string]any{"id": parentID},
+		"name":            path.Base(dstPath),
+	}
+	moveURL := c.itemURL(c.fullPath(srcRelPath)) + "?@microsoft.graph.conflictBehavior=replace"
+	if err := c.do(ctx, http.MethodPatch, moveURL, request, nil); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", srcRelPath, dstRelPath, err)
+	}
+
+	return nil
+}
+
+// List returns every item below relPath ("" for the destination folder)
+func (c *Client) List(ctx context.Context, relPath string) ([]Item, error) {
+	var items []Item
+	if err := c.list(ctx, filepath.ToSlash(relPath), &items); err != nil {
+		return nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	return items, nil
+}
+
+// list appends the items below relPath to items, following pagination and
+// descending into folders
+func (c *Client) list(ctx context.Context, relPath string, items *[]Item) error {
+	next := c.itemURL(c.fullPath(relPath)) + "/children"
+	for next != "" {
+		var page struct {
+			Value    []driveItem `json:"value"`
+			NextLink string      `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return err
+		}
+
+		for _, child := range page.Value {
+			item := newItem(path.Join(relPath, child.Name), child)
+			*items = append(*items, item)
+			if item.IsDir {
+				if err := c.list(ctx, item.Path, items); err != nil {
+					return err
+				}
+			}
+		}
+		next = page.NextLink
+	}
+	return nil
+}
+
+// Open returns the content of the file at relPath starting at offset
+func (c *Client) Open(ctx context.Context, relPath string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.itemURL(c.fullPath(relPath))+"/content", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", relPath, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: %w", relPath, apiError(resp))
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: server ignored the range request", relPath)
+	}
+
+	return resp.Body, nil
+}
+
+// do sends a JSON request (body may be nil) with the authorized client and
+// decodes the response into out (which may be nil)
+func (c *Client) do(ctx context.Context, method, rawURL string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(c.http, req, out)
+}
+
+// send sends req with client and decodes the response into out (which may
+// be nil). Error responses are returned as an *APIError.
+func (c *Client) send(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// apiError reads a Graph error response
+func apiError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	return &APIError{StatusCode: resp.StatusCode, Code: body.Error.Code, Message: body.Error.Message}
+}
+
+// newItem converts a driveItem found at relPath
+func newItem(relPath string, item driveItem) Item {
+	return Item{
+		ID:           item.ID,
+		Path:         relPath,
+		Size:         item.Size,
+		LastModified: item.LastModifiedDateTime,
+		IsDir:        item.Folder != nil,
+	}
+}
+
+// getClient returns an authorized client, asking for a token on the first
+// run. Refreshed tokens are written back to tokenFile, since Microsoft
+// rotates refresh tokens.
+func getClient(config *oauth2.Config, tokenFile string, transport http.RoundTripper) (*http.Client, error) {
+	token, err := tokenFromFile(tokenFile)
+	if err != nil {
+		token, err = getTokenFromWeb(config)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get token from web: %w", err)
+		}
+		if err := saveToken(tokenFile, token); err != nil {
+			return nil, fmt.Errorf("unable to save token: %w", err)
+		}
+	}
+
+	ctx := context.Background()
+	if transport != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	}
+	source := &savingTokenSource{
+		source: config.TokenSource(ctx, token),
+		path:   tokenFile,
+		last:   token.AccessToken,
+	}
+	return oauth2.NewClient(ctx, oauth2.ReuseTokenSource(token, source)), nil
+}
+
+// savingTokenSource writes each newly refreshed token to the token file
+type savingTokenSource struct {
+	source oauth2.TokenSource
+	path   string
+
+	mu   gosync.Mutex
+	last string // Access token last saved
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.AccessToken != s.last {
+		s.last = token.AccessToken
+		if err := saveToken(s.path, token); err != nil {
+			utils.LogWarn("Failed to save refreshed OneDrive token: %v", err)
+		}
+	}
+	return token, nil
+}
+
+// getTokenFromWeb requests a token from the web
+func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
+	fmt.Printf("Go to the following link in your browser, sign in, then type the \"code\" parameter of the URL you are sent to:\n%v\n", authURL)
+
+	var authCode string
+	fmt.Print("Enter authorization code: ")
+	if _, err := fmt.Scan(&authCode); err != nil {
+		return nil, fmt.Errorf("unable to read authorization code: %w", err)
+	}
+
+	token, err := config.Exchange(context.Background(), authCode)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from web: %w", err)
+	}
+
+	return token, nil
+}
+
+// tokenFromFile retrieves a token from a local file
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	token := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(token)
+	return token, err
+}
+
+// saveToken saves a token to a file path
+func saveToken(path string, token *oauth2.Token) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %w", err)
+	}
+	defer f.Close()
+
+	return json.NewEncoder(f).Encode(token)
+}
@@ -0,0 +1,338 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// defaultRegion is used when no region is configured
+const defaultRegion = "us-east-1"
+
+// Client represents an S3 client
+type Client struct {
+	config  *config.S3Config
+	api     *awss3.Client
+	limiter *throttle.Limiter
+}
+
+// Object describes a stored object. Path is relative to the configured prefix.
+type Object struct {
+	Path         string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+	IsDir        bool
+}
+
+// NewClient creates a new S3 client
+func NewClient(ctx context.Context, cfg *config.S3Config) (*Client, error) {
+	return NewClientWithTransport(ctx, cfg, nil)
+}
+
+// NewClientWithTransport creates an S3 client whose API requests go through
+// transport (nil for the default)
+func NewClientWithTransport(ctx context.Context, cfg *config.S3Config, transport http.RoundTripper) (*Client, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	opts := awss3.Options{
+		Region:      region,
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient:  &http.Client{Transport: transport},
+	}
+
+	if cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+		creds := aws.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			Source:          "csync config",
+		}
+		opts.Credentials = aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return creds, nil
+		}))
+	}
+
+	if cfg.Endpoint != "" {
+		// S3-compatible stores generally don't support virtual-hosted
+		// buckets or the newer default request checksums
+		opts.BaseEndpoint = aws.String(cfg.Endpoint)
+		opts.UsePathStyle = true
+		opts.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		opts.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}
+
+	client := &Client{
+		config: cfg,
+		api:    awss3.New(opts),
+	}
+
+	// Check the bucket is reachable with the configured credentials
+	if _, err := client.api.HeadBucket(ctx, &awss3.HeadBucketInput{Bucket: aws.String(cfg.Bucket)}); err != nil {
+		return nil, fmt.Errorf("failed to access bucket %s: %w", cfg.Bucket, err)
+	}
+
+	utils.LogVerbose("Successfully connected to S3 bucket %s", cfg.Bucket)
+	return client, nil
+}
+
+// Key returns the object key for a path relative to the configured prefix
+func (c *Client) Key(relPath string) string {
+	return strings.TrimPrefix(path.Join(c.config.Prefix, filepath.ToSlash(relPath)), "/")
+}
+
+// relPath strips the configured prefix from an object key
+func (c *Client) relPath(key string) string {
+	prefix := strings.Trim(c.config.Prefix, "/")
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+}
+
+// SetUploadLimiter limits the rate at which uploads send data. The limiter
+// may be shared with other clients.
+func (c *Client) SetUploadLimiter(limiter *throttle.Limiter) {
+	c.limiter = limiter
+}
+
+// Upload uploads a local file to the object for relPath
+func (c *Client) Upload(ctx context.Context, localPath, relPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	progress := utils.NewProgress(relPath, info.Size())
+	defer progress.Done()
+
+	_, err = c.api.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:        aws.String(c.config.Bucket),
+		Key:           aws.String(c.Key(relPath)),
+		Body:          progress.Reader(c.limiter.Reader(ctx, file)),
+		ContentLength: aws.Int64(info.Size()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
+}
+
+// CreateFolder creates a zero-byte "folder/" key so empty folders survive
+func (c *Client) CreateFolder(ctx context.Context, relPath string) error {
+	_, err := c.api.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket:        aws.String(c.config.Bucket),
+		Key:           aws.String(c.Key(relPath) + "/"),
+		Body:          bytes.NewReader(nil),
+		ContentLength: aws.Int64(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", relPath, err)
+	}
+
+	return nil
+}
+
+// Delete removes the object for relPath. Without such an object relPath
+// is treated as a folder and every key below it is removed. Deleting a
+// missing path succeeds.
+func (c *Client) Delete(ctx context.Context, relPath string) error {
+	obj, err := c.Stat(ctx, relPath)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		return c.deleteKey(ctx, c.Key(relPath))
+	}
+
+	objects, err := c.List(ctx, relPath)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		key := c.Key(obj.Path)
+		if obj.IsDir {
+			key += "/"
+		}
+		if err := c.deleteKey(ctx, key); err != nil {
+			return err
+		}
+	}
+	return c.deleteKey(ctx, c.Key(relPath)+"/")
+}
+
+// deleteKey removes a single object
+func (c *Client) deleteKey(ctx context.Context, key string) error {
+	_, err := c.api.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(c.config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Stat returns the object for relPath, or nil if it doesn't exist
+func (c *Client) Stat(ctx context.Context, relPath string) (*Object, error) {
+	out, err := c.api.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(c.config.Bucket),
+		Key:    aws.String(c.Key(relPath)),
+	})
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object info: %w", err)
+	}
+
+	return &Object{
+		Path:         filepath.ToSlash(relPath),
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+// List returns every object below relPath ("" for the prefix root).
+// Folder keys are reported with IsDir set.
+func (c *Client) List(ctx context.Context, relPath string) ([]Object, error) {
+	prefix := c.Key(relPath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var objects []Object
+	paginator := awss3.NewListObjectsV2Paginator(c.api, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(c.config.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if key == prefix {
+				continue
+			}
+			objects = append(objects, Object{
+				Path:         strings.TrimSuffix(c.relPath(key), "/"),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				LastModified: aws.ToTime(obj.LastModified),
+				IsDir:        strings.HasSuffix(key, "/"),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+// Open returns the content of the object for relPath starting at offset
+func (c *Client) Open(ctx context.Context, relPath string, offset int64) (io.ReadCloser, error) {
+	input := &awss3.GetObjectInput{
+		Bucket: aws.String(c.config.Bucket),
+		Key:    aws.String(c.Key(relPath)),
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	out, err := c.api.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", relPath, err)
+	}
+
+	return out.Body, nil
+}
+
+// Copy copies an object on the server side
+func (c *Client) Copy(ctx context.Context, srcRelPath, dstRelPath string) error {
+	_, err := c.api.CopyObject(ctx, &awss3.CopyObjectInput{
+		Bucket:     aws.String(c.config.Bucket),
+		Key:        aws.String(c.Key(dstRelPath)),
+		CopySource: aws.String(c.copySource(srcRelPath)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcRelPath, dstRelPath, err)
+	}
+
+	return nil
+}
+
+// Move copies an object to its new key and deletes the original. S3 has no
+// rename, so a failure in between leaves both objects in place.
+func (c *Client) Move(ctx context.Context, srcRelPath, dstRelPath string) error {
+	if err := c.Copy(ctx, srcRelPath, dstRelPath); err != nil {
+		return err
+	}
+	return c.Delete(ctx, srcRelPath)
+}
+
+// SetMetadata replaces the user metadata of an object without re-uploading it
+func (c *Client) SetMetadata(ctx context.Context, relPath string, metadata map[string]string) error {
+	_, err := c.api.CopyObject(ctx, &awss3.CopyObjectInput{
+		Bucket:            aws.String(c.config.Bucket),
+		Key:               aws.String(c.Key(relPath)),
+		CopySource:        aws.String(c.copySource(relPath)),
+		Metadata:          metadata,
+		MetadataDirective: types.MetadataDirectiveReplace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metadata of %s: %w", relPath, err)
+	}
+
+	return nil
+}
+
+// copySource returns the URL-encoded "bucket/key" source of a copy
+func (c *Client) copySource(relPath string) string {
+	segments := strings.Split(c.config.Bucket+"/"+c.Key(relPath), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// IsNotFound reports whether err is S3's response for a missing object or bucket
+func IsNotFound(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
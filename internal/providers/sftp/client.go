@@ -0,0 +1,367 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// tmpSuffix marks an upload that hasn't been renamed into place yet
+const tmpSuffix = ".csync-tmp"
+
+// Client represents an SFTP client
+type Client struct {
+	config  *config.SFTPConfig
+	conn    *ssh.Client
+	sftp    *sftp.Client
+	limiter *throttle.Limiter
+}
+
+// NewClient connects to the configured SFTP server
+func NewClient(cfg *config.SFTPConfig) (*Client, error) {
+	if cfg.Host == "" || cfg.User == "" {
+		return nil, fmt.Errorf("sftp host and user are required")
+	}
+
+	auth, err := authMethods(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := hostKeyCallback(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(cfg.Host, strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", cfg.Host, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+
+	utils.LogVerbose("Successfully connected to %s@%s", cfg.User, cfg.Host)
+	return &Client{
+		config: cfg,
+		conn:   conn,
+		sftp:   client,
+	}, nil
+}
+
+// authMethods returns the SSH auth methods for the configured key and password
+func authMethods(cfg *config.SFTPConfig) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if cfg.PrivateKeyPath != "" {
+		key, err := os.ReadFile(cfg.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if cfg.Password != "" {
+		methods = append(methods, ssh.Password(cfg.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("sftp private_key_path or password is required")
+	}
+	return methods, nil
+}
+
+// hostKeyCallback verifies the server against known_hosts unless disabled
+func hostKeyCallback(cfg *config.SFTPConfig) (ssh.HostKeyCallback, error) {
+	if cfg.InsecureIgnoreHostKey {
+		utils.LogInfo("Warning: SFTP host key verification is disabled")
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	knownHostsPath := cfg.KnownHostsPath
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find known_hosts: %w", err)
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// Close ends the SFTP session and the SSH connection
+func (c *Client) Close() error {
+	c.sftp.Close()
+	return c.conn.Close()
+}
+
+// RemotePath returns the remote path for a relative path. Without a
+// RemoteBasePath it's relative to the login directory.
+func (c *Client) RemotePath(relPath string) string {
+	remotePath := path.Join(c.config.RemoteBasePath, filepath.ToSlash(relPath))
+	if remotePath == "" {
+		return "."
+	}
+	return remotePath
+}
+
+// SetUploadLimiter limits the rate at which uploads send data. The limiter
+// may be shared with other clients.
+func (c *Client) SetUploadLimiter(limiter *throttle.Limiter) {
+	c.limiter = limiter
+}
+
+// Upload writes a local file to relPath. The content goes to a temporary
+// file first and is renamed into place, so readers never see a partial file.
+func (c *Client) Upload(ctx context.Context, localPath, relPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	local, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer local.Close()
+
+	info, err := local.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	remotePath := c.RemotePath(relPath)
+	if err := c.sftp.MkdirAll(path.Dir(remotePath)); err != nil {
+		return fmt.Errorf("failed to create parent folders: %w", err)
+	}
+
+	tmpPath := remotePath + tmpSuffix
+	remote, err := c.sftp.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file: %w", err)
+	}
+
+	progress := utils.NewProgress(relPath, info.Size())
+	defer progress.Done()
+	if _, err := remote.ReadFrom(progress.Reader(c.limiter.Reader(ctx, local))); err != nil {
+		remote.Close()
+		c.sftp.Remove(tmpPath)
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	if err := remote.Close(); err != nil {
+		c.sftp.Remove(tmpPath)
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	if err := c.sftp.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		utils.LogVerbose("Failed to set modification time of %s: %v", relPath, err)
+	}
+
+	if err := c.replace(tmpPath, remotePath); err != nil {
+		c.sftp.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// replace renames oldPath over newPath. Servers without the posix-rename
+// extension can't overwrite on rename, so the target is removed first.
+func (c *Client) replace(oldPath, newPath string) error {
+	if err := c.sftp.PosixRename(oldPath, newPath); err == nil {
+		return nil
+	}
+
+	if err := c.sftp.Remove(newPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %w", newPath, err)
+	}
+	if err := c.sftp.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", oldPath, newPath, err)
+	}
+	return nil
+}
+
+// CreateFolder creates a folder and any missing parents
+func (c *Client) CreateFolder(ctx context.Context, relPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.sftp.MkdirAll(c.RemotePath(relPath)); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", relPath, err)
+	}
+	return nil
+}
+
+// FileExists checks if a file or folder exists at relPath
+func (c *Client) FileExists(ctx context.Context, relPath string) (bool, error) {
+	info, err := c.Stat(ctx, relPath)
+	return info != nil, err
+}
+
+// Stat returns information about relPath, or nil if it doesn't exist
+func (c *Client) Stat(ctx context.Context, relPath string) (os.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	info, err := c.sftp.Stat(c.RemotePath(relPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+	return info, nil
+}
+
+// Delete removes a file, or a folder with everything in it
+func (c *Client) Delete(ctx context.Context, relPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	remotePath := c.RemotePath(relPath)
+	info, err := c.sftp.Stat(remotePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+
+	if info.IsDir() {
+		err = c.sftp.RemoveAll(remotePath)
+	} else {
+		err = c.sftp.Remove(remotePath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", relPath, err)
+	}
+	return nil
+}
+
+// Rename moves a file or folder, replacing an existing file at the target
+func (c *Client) Rename(ctx context.Context, srcRelPath, dstRelPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	dst := c.RemotePath(dstRelPath)
+	if err := c.sftp.MkdirAll(path.Dir(dst)); err != nil {
+		return fmt.Errorf("failed to create parent folders: %w", err)
+	}
+	return c.replace(c.RemotePath(srcRelPath), dst)
+}
+
+// Entry is a file or folder found by List. Path is relative to the remote
+// base path.
+type Entry struct {
+	Path string
+	Info os.FileInfo
+}
+
+// List recursively lists everything below relPath ("" for the base path)
+func (c *Client) List(ctx context.Context, relPath string) ([]Entry, error) {
+	root := c.RemotePath(relPath)
+	base := c.RemotePath("")
+
+	var entries []Entry
+	walker := c.sftp.Walk(root)
+	for walker.Step() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := walker.Err(); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", walker.Path(), err)
+		}
+		if walker.Path() == root || strings.HasSuffix(walker.Path(), tmpSuffix) {
+			continue
+		}
+
+		rel := walker.Path()
+		if base != "." {
+			rel = strings.TrimPrefix(strings.TrimPrefix(rel, base), "/")
+		}
+		entries = append(entries, Entry{Path: rel, Info: walker.Stat()})
+	}
+
+	return entries, nil
+}
+
+// Open returns the content of the file at relPath starting at offset
+func (c *Client) Open(ctx context.Context, relPath string, offset int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := c.sftp.Open(c.RemotePath(relPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", relPath, err)
+	}
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to seek %s: %w", relPath, err)
+		}
+	}
+	return f, nil
+}
+
+// SetMetadata applies a file mode and, where the server allows it,
+// ownership to relPath
+func (c *Client) SetMetadata(ctx context.Context, relPath string, mode os.FileMode, uid, gid int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	remotePath := c.RemotePath(relPath)
+	if err := c.sftp.Chmod(remotePath, mode.Perm()); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", relPath, err)
+	}
+
+	// Only root can give files away, so a failed chown isn't an error
+	if err := c.sftp.Chown(remotePath, uid, gid); err != nil {
+		utils.LogVerbose("Failed to set owner of %s: %v", relPath, err)
+	}
+	return nil
+}
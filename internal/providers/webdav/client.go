@@ -0,0 +1,522 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// propfindBody asks for the properties csync uses
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getetag/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+// Client represents a WebDAV client
+type Client struct {
+	config   *config.WebDAVConfig
+	http     *http.Client
+	root     *url.URL // Server URL
+	basePath string   // Remote base path below root, "" for none
+	limiter  *throttle.Limiter
+
+	mu      gosync.Mutex
+	folders map[string]bool   // Folders known to exist, by path below root
+	etags   map[string]string // ETags of files uploaded by this client, by relative path
+}
+
+// Resource describes a file or folder. Path is relative to the remote base
+// path.
+type Resource struct {
+	Path         string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	IsDir        bool
+}
+
+// StatusError is an unexpected HTTP response from the server
+type StatusError struct {
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Method, e.Status)
+}
+
+// HTTPStatusCode returns the HTTP status of the response
+func (e *StatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+// IsNotFound reports whether err is the server's response for a missing
+// resource
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// NewClient creates a new WebDAV client
+func NewClient(ctx context.Context, cfg *config.WebDAVConfig) (*Client, error) {
+	return NewClientWithTransport(ctx, cfg, nil)
+}
+
+// NewClientWithTransport creates a WebDAV client whose requests go through
+// transport (nil for the default)
+func NewClientWithTransport(ctx context.Context, cfg *config.WebDAVConfig, transport http.RoundTripper) (*Client, error) {
+	if cfg.URL == "" || cfg.Username == "" {
+		return nil, fmt.Errorf("webdav url and username are required")
+	}
+
+	root, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webdav url: %w", err)
+	}
+	root.Path = strings.TrimSuffix(path.Join("/", root.Path), "/")
+	root.RawPath = ""
+
+	client := &Client{
+		config:   cfg,
+		http:     &http.Client{Transport: transport},
+		root:     root,
+		basePath: cleanPath(cfg.RemoteBasePath),
+		folders:  make(map[string]bool),
+		etags:    make(map[string]string),
+	}
+
+	// Check the server is reachable with the configured credentials
+	req, err := client.newRequest(ctx, "PROPFIND", client.resourceURL("", true), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "0")
+	resp, err := client.do(req, http.StatusMultiStatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access %s: %w", cfg.URL, err)
+	}
+	resp.Body.Close()
+
+	utils.LogVerbose("Successfully connected to WebDAV server %s as %s", root.Host, cfg.Username)
+	return client, nil
+}
+
+// SetUploadLimiter limits the rate at which uploads send data. The limiter
+// may be shared with other clients.
+func (c *Client) SetUploadLimiter(limiter *throttle.Limiter) {
+	c.limiter = limiter
+}
+
+// cleanPath normalizes a relative path, "" for the base path
+func cleanPath(relPath string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(relPath)), "/")
+}
+
+// fullPath returns the path of relPath below the server URL
+func (c *Client) fullPath(relPath string) string {
+	return strings.TrimPrefix(path.Join(c.basePath, cleanPath(relPath)), "/")
+}
+
+// resourceURL returns the URL of a path below the server URL, with a
+// trailing slash for folders
+func (c *Client) resourceURL(fullPath string, folder bool) string {
+	u := *c.root
+	u.Path = path.Join(c.root.Path, "/", fullPath)
+	if folder && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+// Upload writes a local file to relPath with PUT, creating parent folders
+// as needed
+func (c *Client) Upload(ctx context.Context, localPath, relPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	relPath = cleanPath(relPath)
+	fullPath := c.fullPath(relPath)
+	if err := c.ensureFolder(ctx, path.Dir(fullPath)); err != nil {
+		return fmt.Errorf("failed to create parent folders: %w", err)
+	}
+
+	progress := utils.NewProgress(relPath, info.Size())
+	defer progress.Done()
+
+	req, err := c.newRequest(ctx, http.MethodPut, c.resourceURL(fullPath, false), progress.Reader(c.limiter.Reader(ctx, file)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// Nextcloud and ownCloud keep the local modification time
+	req.Header.Set("X-OC-Mtime", strconv.FormatInt(info.ModTime().Unix(), 10))
+
+	resp, err := c.do(req, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+	resp.Body.Close()
+
+	c.mu.Lock()
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.etags[relPath] = normalizeETag(etag)
+	} else {
+		delete(c.etags, relPath)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// CreateFolder creates a folder and any missing parents
+func (c *Client) CreateFolder(ctx context.Context, relPath string) error {
+	if err := c.ensureFolder(ctx, c.fullPath(relPath)); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", relPath, err)
+	}
+	return nil
+}
+
+// ensureFolder issues MKCOL for each segment of fullPath, including the
+// remote base path, not known to exist. A 405 response means the folder is
+// already there.
+func (c *Client) ensureFolder(ctx context.Context, fullPath string) error {
+	if fullPath == "." || fullPath == "" {
+		return nil
+	}
+
+	folder := ""
+	for _, segment := range strings.Split(fullPath, "/") {
+		folder = path.Join(folder, segment)
+
+		c.mu.Lock()
+		exists := c.folders[folder]
+		c.mu.Unlock()
+		if exists {
+			continue
+		}
+
+		req, err := c.newRequest(ctx, "MKCOL", c.resourceURL(folder, true), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		c.mu.Lock()
+		c.folders[folder] = true
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// FileExists reports whether a file exists at relPath with the given size
+// (any size when size is negative). A file this client uploaded must also
+// still have the ETag the upload returned.
+func (c *Client) FileExists(ctx context.Context, relPath string, size int64) (bool, error) {
+	resource, err := c.Stat(ctx, relPath)
+	if err != nil || resource == nil || resource.IsDir {
+		return false, err
+	}
+	if size >= 0 && resource.Size != size {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	etag, ok := c.etags[cleanPath(relPath)]
+	c.mu.Unlock()
+	if ok && resource.ETag != "" && resource.ETag != etag {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Stat returns the resource at relPath, or nil if it doesn't exist
+func (c *Client) Stat(ctx context.Context, relPath string) (*Resource, error) {
+	resources, err := c.propfind(ctx, relPath, "0")
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+	if len(resources) == 0 {
+		return nil, nil
+	}
+	return &resources[0], nil
+}
+
+// Delete removes a file, or a folder with everything in it. Deleting a
+// missing path succeeds.
+func (c *Client) Delete(ctx context.Context, relPath string) error {
+	relPath = cleanPath(relPath)
+	req, err := c.newRequest(ctx, http.MethodDelete, c.resourceURL(c.fullPath(relPath), false), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", relPath, err)
+	}
+	resp.Body.Close()
+
+	c.forget(relPath)
+	return nil
+}
+
+// Copy copies a file or folder on the server, replacing the target
+func (c *Client) Copy(ctx context.Context, srcRelPath, dstRelPath string) error {
+	if err := c.transfer(ctx, "COPY", srcRelPath, dstRelPath); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcRelPath, dstRelPath, err)
+	}
+	return nil
+}
+
+// Move moves a file or folder on the server, replacing the target
+func (c *Client) Move(ctx context.Context, srcRelPath, dstRelPath string) error {
+	if err := c.transfer(ctx, "MOVE", srcRelPath, dstRelPath); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", srcRelPath, dstRelPath, err)
+	}
+	c.forget(cleanPath(srcRelPath))
+	return nil
+}
+
+// transfer sends a COPY or MOVE request
+func (c *Client) transfer(ctx context.Context, method, srcRelPath, dstRelPath string) error {
+	dst := cleanPath(dstRelPath)
+	if err := c.ensureFolder(ctx, path.Dir(c.fullPath(dst))); err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, method, c.resourceURL(c.fullPath(srcRelPath), false), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", c.resourceURL(c.fullPath(dst), false))
+	req.Header.Set("Overwrite", "T")
+	resp, err := c.do(req, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	c.mu.Lock()
+	delete(c.etags, dst)
+	c.mu.Unlock()
+	return nil
+}
+
+// forget drops what the client knows about relPath and everything below it
+func (c *Client) forget(relPath string) {
+	fullPath := c.fullPath(relPath)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for folder := range c.folders {
+		if folder == fullPath || strings.HasPrefix(folder, fullPath+"/") {
+			delete(c.folders, folder)
+		}
+	}
+	for file := range c.etags {
+		if file == relPath || strings.HasPrefix(file, relPath+"/") {
+			delete(c.etags, file)
+		}
+	}
+}
+
+// List recursively lists everything below relPath ("" for the base path).
+// Folders are listed one level at a time, since many servers refuse
+// "Depth: infinity".
+func (c *Client) List(ctx context.Context, relPath string) ([]Resource, error) {
+	var resources []Resource
+	pending := []string{cleanPath(relPath)}
+	for len(pending) > 0 {
+		folder := pending[0]
+		pending = pending[1:]
+
+		children, err := c.propfind(ctx, folder, "1")
+		if IsNotFound(err) && len(resources) == 0 {
+			return nil, nil // Nothing uploaded yet
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", folder, err)
+		}
+		for _, child := range children {
+			if child.Path == folder {
+				continue
+			}
+			resources = append(resources, child)
+			if child.IsDir {
+				pending = append(pending, child.Path)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// Open returns the content of the file at relPath starting at offset
+func (c *Client) Open(ctx context.Context, relPath string, offset int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, c.resourceURL(c.fullPath(relPath), false), nil)
+	if err != nil {
+		return nil, err
+	}
+	expected := http.StatusOK
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		expected = http.StatusPartialContent
+	}
+
+	resp, err := c.do(req, expected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", relPath, err)
+	}
+	return resp.Body, nil
+}
+
+// multistatus is a WebDAV PROPFIND response
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				ETag          string `xml:"getetag"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// propfind returns the resource at relPath and, with depth "1", its
+// children
+func (c *Client) propfind(ctx context.Context, relPath, depth string) ([]Resource, error) {
+	req, err := c.newRequest(ctx, "PROPFIND", c.resourceURL(c.fullPath(relPath), false), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req, http.StatusMultiStatus)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %w", err)
+	}
+
+	var resources []Resource
+	for _, response := range status.Responses {
+		relPath, err := c.hrefPath(response.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		resource := Resource{Path: relPath}
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			resource.IsDir = resource.IsDir || prop.ResourceType.Collection != nil
+			if size, err := strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64); err == nil {
+				resource.Size = size
+			}
+			if prop.ETag != "" {
+				resource.ETag = normalizeETag(prop.ETag)
+			}
+			if modified, err := http.ParseTime(prop.LastModified); err == nil {
+				resource.LastModified = modified
+			}
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// hrefPath converts an href in a PROPFIND response to a path relative to
+// the base path
+func (c *Client) hrefPath(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid href %q: %w", href, err)
+	}
+
+	base := strings.TrimSuffix(path.Join(c.root.Path, "/", c.basePath), "/")
+	p := strings.TrimSuffix(u.Path, "/")
+	if p != base && !strings.HasPrefix(p, base+"/") {
+		return "", fmt.Errorf("href %q is outside %s", href, base)
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(p, base), "/"), nil
+}
+
+// newRequest creates an authenticated request
+func (c *Client) newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	req.SetBasicAuth(c.config.Username, c.config.Password)
+	return req, nil
+}
+
+// do sends req and returns the response if its status is one of expected.
+// Other responses are closed and returned as a *StatusError.
+func (c *Client) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	return nil, &StatusError{Method: req.Method, StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// normalizeETag strips the quotes and weak marker from an ETag
+func normalizeETag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
@@ -0,0 +1,68 @@
+package scanner
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Content hash algorithms the scanner can compute
+const (
+	HashMD5    = "md5"
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+)
+
+// NewHash returns a hash for one of the supported algorithms
+func NewHash(algo string) (hash.Hash, error) {
+	switch algo {
+	case HashMD5:
+		return md5.New(), nil
+	case HashSHA1:
+		return sha1.New(), nil
+	case HashSHA256:
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm: %s", algo)
+	}
+}
+
+// SetHashAlgorithm selects the content hash stored in FileInfo.Checksum.
+// MD5Hash is only filled in when it is md5, the default.
+func (s *Scanner) SetHashAlgorithm(algo string) error {
+	if _, err := NewHash(algo); err != nil {
+		return err
+	}
+	s.hashAlgorithm = algo
+	return nil
+}
+
+// SetHashing turns content hashing off, or back on. Files scanned without
+// it have no Checksum, for syncs that hash them while uploading instead.
+func (s *Scanner) SetHashing(enabled bool) {
+	s.noHash = !enabled
+}
+
+// CalculateChecksum returns the hex-encoded hash of a file's content
+func CalculateChecksum(filePath, algo string) (string, error) {
+	hash, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read file for hashing: %w", err)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,118 @@
+package scanner
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Content categories used by the content-type filter
+const (
+	CategoryImage   = "image"
+	CategoryVideo   = "video"
+	CategoryAudio   = "audio"
+	CategoryText    = "text"
+	CategoryArchive = "archive"
+	CategoryOther   = "other"
+)
+
+// sniffLen is how much of each file http.DetectContentType looks at
+const sniffLen = 512
+
+// archiveTypes are the archive formats http.DetectContentType recognizes
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-gzip":           true,
+	"application/x-rar-compressed": true,
+}
+
+// categoryOf maps a MIME type to a broad content category
+func categoryOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return CategoryImage
+	case strings.HasPrefix(mediaType, "video/"):
+		return CategoryVideo
+	case strings.HasPrefix(mediaType, "audio/"), mediaType == "application/ogg":
+		return CategoryAudio
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json":
+		return CategoryText
+	case archiveTypes[mediaType]:
+		return CategoryArchive
+	default:
+		return CategoryOther
+	}
+}
+
+// SniffCategory reads the start of a file and returns its content category
+func SniffCategory(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return categoryOf(http.DetectContentType(buf[:n])), nil
+}
+
+// SetContentTypeFilter enables content sniffing for files that pass the
+// pattern filters. With include set only files in those categories are
+// kept; files in an exclude category are always dropped.
+func (s *Scanner) SetContentTypeFilter(include, exclude []string) error {
+	for _, category := range append(append([]string(nil), include...), exclude...) {
+		switch category {
+		case CategoryImage, CategoryVideo, CategoryAudio, CategoryText, CategoryArchive, CategoryOther:
+		default:
+			return fmt.Errorf("unknown content category: %s", category)
+		}
+	}
+
+	s.includeTypes = toSet(include)
+	s.excludeTypes = toSet(exclude)
+	return nil
+}
+
+// contentTypeFiltered reports whether a file is dropped by the content-type
+// filter, and the category it was classified as
+func (s *Scanner) contentTypeFiltered(filePath string) (bool, string) {
+	if len(s.includeTypes) == 0 && len(s.excludeTypes) == 0 {
+		return false, ""
+	}
+
+	category, err := SniffCategory(filePath)
+	if err != nil {
+		// Unreadable files fail later with a clearer error
+		return false, ""
+	}
+
+	if s.excludeTypes[category] {
+		return true, category
+	}
+	if len(s.includeTypes) > 0 && !s.includeTypes[category] {
+		return true, category
+	}
+	return false, category
+}
+
+// toSet converts a list to a lookup set, or nil when it is empty
+func toSet(items []string) map[string]bool {
+	if len(items) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
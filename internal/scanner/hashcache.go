@@ -0,0 +1,125 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// HashCache remembers content hashes keyed by device, inode, size and
+// modification time, so files that haven't changed since they were last
+// hashed (in this run or a previous one) aren't read again. It is safe
+// for use by several scanners at once.
+type HashCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]string
+	used    map[string]bool // Keys looked up or stored since the last full scan began
+	full    bool            // The last scan covered the whole tree
+}
+
+// LoadHashCache reads a hash cache from path. A missing file yields an empty
+// cache, and an empty path an in-memory cache that is never saved.
+func LoadHashCache(path string) (*HashCache, error) {
+	cache := &HashCache{
+		path:    path,
+		entries: make(map[string]string),
+		used:    make(map[string]bool),
+	}
+	if path == "" {
+		return cache, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash cache: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		return nil, fmt.Errorf("failed to parse hash cache: %w", err)
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]string)
+	}
+
+	return cache, nil
+}
+
+// lookup returns the cached hash for key
+func (c *HashCache) lookup(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash, ok := c.entries[key]
+	if ok {
+		c.used[key] = true
+	}
+	return hash, ok
+}
+
+// beginScan starts tracking the entries a scan uses. A full scan starts
+// over, so entries it doesn't use can be dropped when the cache is saved;
+// a partial scan only adds to what earlier scans used.
+func (c *HashCache) beginScan(full bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if full {
+		c.used = make(map[string]bool)
+	}
+	c.full = full
+}
+
+// store records the hash for key
+func (c *HashCache) store(key, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = hash
+	c.used[key] = true
+}
+
+// Save writes the cache. After a full scan it keeps only the entries used
+// since that scan began, dropping those for files that no longer exist or
+// have changed; after a partial scan, which can't tell, it keeps them all.
+func (c *HashCache) Save() error {
+	if c.path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	if c.full {
+		kept := make(map[string]string, len(c.used))
+		for key := range c.used {
+			kept[key] = c.entries[key]
+		}
+		c.entries = kept
+	}
+	data, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal hash cache: %w", err)
+	}
+
+	if err := os.WriteFile(c.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write hash cache: %w", err)
+	}
+
+	return nil
+}
+
+// hashCacheKey identifies a file's content by inode and change markers.
+// The second result is false when the platform has no inode information.
+// Hashes other than MD5 are stored under the key prefixed with "<algo>:".
+func hashCacheKey(info os.FileInfo) (string, bool) {
+	dev, ino, ok := fileID(info)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d:%d:%d", dev, ino, info.Size(), info.ModTime().UnixNano()), true
+}
@@ -0,0 +1,145 @@
+//go:build unix
+
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashCacheReuseAndHardlinks(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_hashcache_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "src")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create source dir: %v", err)
+	}
+
+	original := filepath.Join(srcDir, "a.txt")
+	if err := os.WriteFile(original, []byte("shared content"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Link(original, filepath.Join(srcDir, "b.txt")); err != nil {
+		t.Skipf("Hardlinks not supported: %v", err)
+	}
+
+	cachePath := filepath.Join(tempDir, "hashes.json")
+	cache, err := LoadHashCache(cachePath)
+	if err != nil {
+		t.Fatalf("LoadHashCache failed: %v", err)
+	}
+
+	scanner := NewScanner(nil, nil)
+	scanner.SetHashCache(cache)
+	files, err := scanner.Scan(srcDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(files))
+	}
+	if files[0].HardlinkOf != "" || files[1].HardlinkOf != "a.txt" {
+		t.Errorf("Expected b.txt to be detected as a hardlink of a.txt, got %q and %q",
+			files[0].HardlinkOf, files[1].HardlinkOf)
+	}
+	if files[0].MD5Hash != files[1].MD5Hash {
+		t.Errorf("Hardlinks have different hashes: %s and %s", files[0].MD5Hash, files[1].MD5Hash)
+	}
+
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reloaded, err := LoadHashCache(cachePath)
+	if err != nil {
+		t.Fatalf("LoadHashCache failed: %v", err)
+	}
+
+	info, err := os.Stat(original)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	key, _ := hashCacheKey(info)
+	if hash, ok := reloaded.lookup(key); !ok || hash != files[0].MD5Hash {
+		t.Errorf("Expected cached hash %s after reload, got %q (found %v)", files[0].MD5Hash, hash, ok)
+	}
+}
+
+func TestHashCachePartialScan(t *testing.T) {
+	srcDir := t.TempDir()
+	os.MkdirAll(filepath.Join(srcDir, "docs"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(srcDir, "docs", "b.txt"), []byte("b"), 0644)
+
+	cachePath := filepath.Join(t.TempDir(), "hashes.json")
+	cache, _ := LoadHashCache(cachePath)
+	scanner := NewScanner(nil, nil)
+	scanner.SetHashCache(cache)
+	if _, err := scanner.Scan(srcDir); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// A new process whose first scan covers only docs keeps a.txt's hash
+	cache, _ = LoadHashCache(cachePath)
+	scanner = NewScanner(nil, nil)
+	scanner.SetHashCache(cache)
+	if _, err := scanner.ScanPaths(srcDir, []string{"docs"}); err != nil {
+		t.Fatalf("ScanPaths failed: %v", err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reloaded, _ := LoadHashCache(cachePath)
+	for _, name := range []string{"a.txt", filepath.Join("docs", "b.txt")} {
+		info, _ := os.Stat(filepath.Join(srcDir, name))
+		key, _ := hashCacheKey(info)
+		if _, ok := reloaded.lookup(key); !ok {
+			t.Errorf("Expected %s to stay cached after a partial scan", name)
+		}
+	}
+}
+
+func TestHashCachePrunesChangedFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	path := filepath.Join(srcDir, "a.txt")
+	os.WriteFile(path, []byte("first"), 0644)
+
+	// One cache across several scans, as a daemon keeps it
+	cachePath := filepath.Join(t.TempDir(), "hashes.json")
+	cache, _ := LoadHashCache(cachePath)
+	scanner := NewScanner(nil, nil)
+	scanner.SetHashCache(cache)
+	for i, content := range []string{"first", "second", "third"} {
+		os.WriteFile(path, []byte(content), 0644)
+		modTime := time.Now().Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, modTime, modTime)
+		if _, err := scanner.Scan(srcDir); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		if err := cache.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	// Only the entry for the file as it is now survives
+	reloaded, _ := LoadHashCache(cachePath)
+	info, _ := os.Stat(path)
+	key, _ := hashCacheKey(info)
+	if _, ok := reloaded.lookup(key); !ok || len(reloaded.entries) != 1 {
+		t.Errorf("Expected only the current entry, got %v", reloaded.entries)
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("Expected stale entries to be dropped from memory too, got %v", cache.entries)
+	}
+}
@@ -0,0 +1,109 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the per-folder file of gitignore-style ignore patterns
+// the scanner honors, in the source root and in any folder below it
+const IgnoreFileName = ".csyncignore"
+
+// ignoreRule is one ignore pattern and where it came from
+type ignoreRule struct {
+	pattern string // Without the leading "!"
+	negate  bool   // Re-includes paths an earlier rule ignored
+	base    string // Folder of the ignore file the rule came from, "" for the source root
+	source  string // Reported as the pattern responsible for a skip
+}
+
+// newIgnoreRule parses a pattern. A leading "!" negates it; "\!" and "\#"
+// stand for a literal "!" or "#".
+func newIgnoreRule(pattern, base, source string) ignoreRule {
+	rule := ignoreRule{base: base, source: source}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	rule.pattern = pattern
+	return rule
+}
+
+// parseIgnoreFile reads the rules of the ignore file at filePath, which
+// lies in folder base. Blank lines and lines starting with "#" are skipped.
+func parseIgnoreFile(filePath, base string) ([]ignoreRule, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	name := path.Join(base, IgnoreFileName)
+	var rules []ignoreRule
+	lines := bufio.NewScanner(file)
+	for lineNo := 1; lines.Scan(); lineNo++ {
+		line := strings.TrimRight(lines.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, newIgnoreRule(line, base, fmt.Sprintf("%s:%d: %s", name, lineNo, line)))
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// loadIgnoreFile adds the rules of the ignore file in folder relDir (""
+// for the root), if it has one and it hasn't been loaded this scan. Rules
+// from deeper folders come later, so they take precedence.
+func (s *Scanner) loadIgnoreFile(rootPath, relDir string) error {
+	relDir = filepath.ToSlash(relDir)
+	if relDir == "." {
+		relDir = ""
+	}
+	if s.ignoreLoaded[relDir] {
+		return nil
+	}
+	s.ignoreLoaded[relDir] = true
+
+	rules, err := parseIgnoreFile(filepath.Join(rootPath, filepath.FromSlash(relDir), IgnoreFileName), relDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path.Join(relDir, IgnoreFileName), err)
+	}
+
+	s.rules = append(s.rules, rules...)
+	return nil
+}
+
+// loadIgnoreFiles loads the ignore files of the root and of every folder
+// above relPath
+func (s *Scanner) loadIgnoreFiles(rootPath, relPath string) error {
+	if err := s.loadIgnoreFile(rootPath, ""); err != nil {
+		return err
+	}
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	for i := 1; i < len(parts); i++ {
+		if err := s.loadIgnoreFile(rootPath, strings.Join(parts[:i], "/")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetIgnoreFiles forgets the rules loaded from ignore files, keeping the
+// configured ones
+func (s *Scanner) resetIgnoreFiles() {
+	s.rules = s.rules[:len(s.ignorePatterns):len(s.ignorePatterns)]
+	s.ignoreLoaded = make(map[string]bool)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package scanner
+
+import "os"
+
+// fileOwner returns zero IDs on platforms without POSIX ownership
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	return 0, 0
+}
+
+// fileID reports no inode information on platforms without it
+func fileID(info os.FileInfo) (dev, ino uint64, ok bool) {
+	return 0, 0, false
+}
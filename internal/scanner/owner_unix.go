@@ -0,0 +1,24 @@
+//go:build unix
+
+package scanner
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owning user and group IDs of a file
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return 0, 0
+}
+
+// fileID returns the device and inode numbers identifying a file's content
+func fileID(info os.FileInfo) (dev, ino uint64, ok bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), uint64(stat.Ino), true
+	}
+	return 0, 0, false
+}
@@ -1,39 +1,187 @@
 package scanner
 
 import (
-	"crypto/md5"
 	"fmt"
-	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/svosadtsia/csync/pkg/utils"
 )
 
 // FileInfo represents metadata about a file to be synced
 type FileInfo struct {
-	Path         string    // Relative path from sync root
-	AbsolutePath string    // Absolute path on filesystem
-	Size         int64     // File size in bytes
-	ModTime      time.Time // Last modification time
-	IsDir        bool      // Whether this is a directory
-	MD5Hash      string    // MD5 hash of file content (empty for directories)
+	Path          string      // Relative path from sync root
+	AbsolutePath  string      // Absolute path on filesystem
+	Size          int64       // File size in bytes
+	ModTime       time.Time   // Last modification time
+	IsDir         bool        // Whether this is a directory
+	MD5Hash       string      // MD5 hash of file content (empty for directories, or another algorithm)
+	Checksum      string      // Hash of file content using HashAlgorithm (empty for directories)
+	HashAlgorithm string      // Algorithm of Checksum: md5, sha1 or sha256
+	Mode          os.FileMode // Permission bits
+	UID           int         // Owner user ID (0 where unsupported)
+	GID           int         // Owner group ID (0 where unsupported)
+	HardlinkOf    string      // Path of an earlier scanned hardlink to the same content
+	Forced        bool        // Matched a force-include pattern; later filters must keep it
+}
+
+// SkipReason explains why a path was left out of a sync
+type SkipReason string
+
+const (
+	SkipIgnored        SkipReason = "ignored-by-pattern" // Matched an ignore pattern
+	SkipNotIncluded    SkipReason = "not-included"       // Matched no include pattern
+	SkipExcludedFolder SkipReason = "excluded-folder"    // An excluded folder, or inside an ignored one
+	SkipUnchanged      SkipReason = "unchanged"          // Remote copy is already up to date
+	SkipContentType    SkipReason = "content-type"       // Filtered by sniffed content category
+	SkipSize           SkipReason = "size"               // Smaller or larger than the size limits
+	SkipModified       SkipReason = "modified-before"    // Last modified before the cutoff
+	SkipSymlink        SkipReason = "symlink"            // A symlink that isn't followed, is broken or loops
+	SkipInProgress     SkipReason = "in-progress"        // Looks like a file still being written
+	SkipLocked         SkipReason = "locked"             // A sibling lockfile shows it's being written
+)
+
+// SkippedFile records a path that was filtered or skipped and the rule responsible
+type SkippedFile struct {
+	Path    string     // Relative path from sync root
+	IsDir   bool       // Whether this is a directory
+	Reason  SkipReason // Why the path was skipped
+	Pattern string     // The pattern (or content category) responsible, if any
 }
 
 // Scanner handles directory scanning with pattern matching
 type Scanner struct {
 	ignorePatterns  []string
 	includePatterns []string
+	rules           []ignoreRule    // ignorePatterns, then rules from ignore files
+	ignoreLoaded    map[string]bool // Folders whose ignore file has been read
+	forceInclude    []string
+	onlyInclude     []string // Ad-hoc patterns every path must also match
+	onlyExclude     []string // Ad-hoc patterns no path may match
+	excludeFolders  []string // Folders pruned with everything in them
+	root            string   // Root of the current scan, for absolute excludeFolders
+	inProgress      []string
+	lockSuffixes    []string
+	includeTypes    map[string]bool
+	excludeTypes    map[string]bool
+	minSize         int64     // Smallest file kept in bytes, 0 for no bound
+	maxSize         int64     // Largest file kept in bytes, 0 for no bound
+	modifiedSince   time.Time // Oldest modification time kept, zero for no bound
+	followSymlinks  bool
+	hashAlgorithm   string
+	noHash          bool // Leave files unhashed
+	skipped         []SkippedFile
+	hashCache       *HashCache
 }
 
 // NewScanner creates a new scanner with pattern filters
 func NewScanner(ignorePatterns, includePatterns []string) *Scanner {
+	rules := make([]ignoreRule, len(ignorePatterns))
+	for i, pattern := range ignorePatterns {
+		rules[i] = newIgnoreRule(pattern, "", pattern)
+	}
 	return &Scanner{
 		ignorePatterns:  ignorePatterns,
 		includePatterns: includePatterns,
+		rules:           rules,
+		ignoreLoaded:    make(map[string]bool),
+		hashAlgorithm:   HashMD5,
 	}
 }
 
+// SetForceInclude sets the allowlist of patterns that are synced even when an
+// ignore or include rule would exclude them. Ignored folders are still walked
+// while it is non-empty, so forced files inside them can be found.
+func (s *Scanner) SetForceInclude(patterns []string) {
+	s.forceInclude = patterns
+}
+
+// SetNarrowing restricts a scan to paths matching one of include, when it
+// isn't empty, and none of exclude. It applies on top of every other rule,
+// to force-included paths as well, so it only ever narrows a scan.
+func (s *Scanner) SetNarrowing(include, exclude []string) {
+	s.onlyInclude = include
+	s.onlyExclude = exclude
+}
+
+// narrowedBy reports whether SetNarrowing's patterns leave a path out, why
+// and the pattern responsible. Folders are only left out by exclude
+// patterns, so files inside them can still match an include pattern.
+func (s *Scanner) narrowedBy(relPath string, isDir bool) (SkipReason, string, bool) {
+	for _, pattern := range s.onlyExclude {
+		if s.matchPattern(pattern, relPath, isDir) {
+			return SkipIgnored, pattern, true
+		}
+	}
+	if len(s.onlyInclude) == 0 || isDir {
+		return "", "", false
+	}
+	for _, pattern := range s.onlyInclude {
+		if s.matchPattern(pattern, relPath, isDir) {
+			return "", "", false
+		}
+	}
+	return SkipNotIncluded, "", true
+}
+
+// SetExcludeFolders prunes folders from scans with everything in them,
+// ahead of every other rule. A plain folder name is excluded at any depth,
+// a path from the scanned root; absolute paths must lie inside the root.
+func (s *Scanner) SetExcludeFolders(folders []string) {
+	s.excludeFolders = folders
+}
+
+// SetInProgress skips files that are still being written: those matching one
+// of patterns, and those with a sibling lockfile, i.e. a file in the same
+// folder named after them plus one of lockSuffixes.
+func (s *Scanner) SetInProgress(patterns, lockSuffixes []string) {
+	s.inProgress = patterns
+	s.lockSuffixes = lockSuffixes
+}
+
+// SetSizeFilter skips files smaller than minSize or larger than maxSize
+// bytes. Zero means no bound; folders are never filtered by size.
+func (s *Scanner) SetSizeFilter(minSize, maxSize int64) {
+	s.minSize = minSize
+	s.maxSize = maxSize
+}
+
+// sizeFiltered reports whether a file of size bytes is outside the size
+// limits, and the limit it broke
+func (s *Scanner) sizeFiltered(size int64) (bool, string) {
+	if s.minSize > 0 && size < s.minSize {
+		return true, fmt.Sprintf("< %d bytes", s.minSize)
+	}
+	if s.maxSize > 0 && size > s.maxSize {
+		return true, fmt.Sprintf("> %d bytes", s.maxSize)
+	}
+	return false, ""
+}
+
+// SetModifiedSince skips files last modified before since. The zero time
+// keeps every file; folders are always walked.
+func (s *Scanner) SetModifiedSince(since time.Time) {
+	s.modifiedSince = since
+}
+
+// modifiedBefore reports whether a file is older than the cutoff, and the
+// cutoff it missed
+func (s *Scanner) modifiedBefore(modTime time.Time) (bool, string) {
+	if s.modifiedSince.IsZero() || !modTime.Before(s.modifiedSince) {
+		return false, ""
+	}
+	return true, s.modifiedSince.Format(time.RFC3339)
+}
+
+// SetHashCache makes the scanner reuse and record content hashes in cache.
+// A nil cache hashes every file.
+func (s *Scanner) SetHashCache(cache *HashCache) {
+	s.hashCache = cache
+}
+
 // ScanDirectory scans a directory and returns file information
 func ScanDirectory(rootPath string) ([]FileInfo, error) {
 	scanner := NewScanner(nil, nil)
@@ -42,9 +190,102 @@ func ScanDirectory(rootPath string) ([]FileInfo, error) {
 
 // Scan performs the directory scan with configured patterns
 func (s *Scanner) Scan(rootPath string) ([]FileInfo, error) {
-	var files []FileInfo
+	s.skipped = nil
+	s.root = rootPath
+	s.resetIgnoreFiles()
+	if s.hashCache != nil {
+		s.hashCache.beginScan(true)
+	}
+	w := newScanWalk(rootPath)
+
+	if err := filepath.Walk(rootPath, s.visit(rootPath, w)); err != nil {
+		return nil, fmt.Errorf("failed to scan directory: %w", err)
+	}
+
+	return w.files, nil
+}
+
+// ScanPaths scans only the given files and folders, relative to rootPath,
+// applying the same filters as Scan. Paths are reported relative to
+// rootPath. Paths that no longer exist, or that lie inside an ignored
+// folder, are left out. The paths shouldn't overlap.
+func (s *Scanner) ScanPaths(rootPath string, relPaths []string) ([]FileInfo, error) {
+	s.skipped = nil
+	s.root = rootPath
+	s.resetIgnoreFiles()
+	if s.hashCache != nil {
+		s.hashCache.beginScan(false)
+	}
+	w := newScanWalk(rootPath)
+
+	for _, relPath := range relPaths {
+		relPath = filepath.Clean(filepath.FromSlash(relPath))
+		if relPath == "." {
+			return s.Scan(rootPath)
+		}
+		if err := s.loadIgnoreFiles(rootPath, relPath); err != nil {
+			return nil, err
+		}
+		if s.insideIgnoredFolder(relPath) {
+			continue
+		}
+
+		path := filepath.Join(rootPath, relPath)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := filepath.Walk(path, s.visit(rootPath, w)); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", relPath, err)
+		}
+	}
 
-	err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+	return w.files, nil
+}
+
+// insideIgnoredFolder reports whether one of relPath's parent folders is
+// excluded or ignored, so a walk starting at relPath would never have
+// reached it
+func (s *Scanner) insideIgnoredFolder(relPath string) bool {
+	if _, ok := utils.ExcludedFolder(s.root, filepath.Dir(relPath), s.excludeFolders); ok {
+		return true
+	}
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if _, _, ok := s.narrowedBy(dir, true); ok {
+			return true
+		}
+	}
+	if s.forceIncluded(relPath, false) {
+		return false
+	}
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		if _, ok := s.ignoredBy(dir, true); ok && !s.forceIncluded(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanWalk accumulates the results of one scan
+type scanWalk struct {
+	files       []FileInfo
+	links       map[string]string // device:inode -> first path seen
+	ignoredDirs []string          // Ignored folders walked for forced files
+	followed    []string          // Source root and symlinked folders being walked, resolved
+}
+
+func newScanWalk(rootPath string) *scanWalk {
+	w := &scanWalk{links: make(map[string]string)}
+	if root, err := filepath.EvalSymlinks(rootPath); err == nil {
+		w.followed = append(w.followed, root)
+	}
+	return w
+}
+
+// visit returns the walk function that filters and records each path
+// below rootPath into w
+func (s *Scanner) visit(rootPath string, w *scanWalk) filepath.WalkFunc {
+	var visit filepath.WalkFunc
+	visit = func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("error accessing %s: %w", path, err)
 		}
@@ -57,65 +298,295 @@ func (s *Scanner) Scan(rootPath string) ([]FileInfo, error) {
 
 		// Skip root directory itself
 		if relPath == "." {
+			return s.loadIgnoreFile(rootPath, "")
+		}
+
+		// Inside an ignored folder only forced paths are kept
+		if len(w.ignoredDirs) > 0 && !s.forceIncluded(relPath, info.IsDir()) {
+			for _, dir := range w.ignoredDirs {
+				if strings.HasPrefix(relPath, dir+string(filepath.Separator)) {
+					return nil
+				}
+			}
+		}
+
+		// A followed symlink stands in for its target
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, targetInfo, ok := s.resolveLink(path, relPath, w)
+			if !ok {
+				return nil
+			}
+			if targetInfo.IsDir() {
+				return s.walkLink(path, target, w, visit)
+			}
+			info = targetInfo
+		}
+
+		// Excluded folders are pruned whatever else matches inside them
+		if info.IsDir() {
+			if folder, ok := utils.ExcludedFolder(rootPath, relPath, s.excludeFolders); ok {
+				s.skip(relPath, true, SkipExcludedFolder, folder)
+				return filepath.SkipDir
+			}
+		}
+
+		// Ad-hoc narrowing wins over every rule that would keep a path
+		if reason, pattern, ok := s.narrowedBy(relPath, info.IsDir()); ok {
+			s.skip(relPath, info.IsDir(), reason, pattern)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
+		forced := s.forceIncluded(relPath, info.IsDir())
+
 		// Apply ignore patterns
-		if s.shouldIgnore(relPath, info.IsDir()) {
+		if pattern, ok := s.ignoredBy(relPath, info.IsDir()); ok && !forced {
+			s.skip(relPath, info.IsDir(), SkipIgnored, pattern)
 			if info.IsDir() {
-				return filepath.SkipDir
+				if len(s.forceInclude) == 0 {
+					return filepath.SkipDir
+				}
+				w.ignoredDirs = append(w.ignoredDirs, relPath)
 			}
 			return nil
 		}
 
+		// Rules in a folder's ignore file apply to everything below it
+		if info.IsDir() {
+			if err := s.loadIgnoreFile(rootPath, relPath); err != nil {
+				return err
+			}
+		}
+
 		// Apply include patterns (if specified)
-		if !s.shouldInclude(relPath, info.IsDir()) {
+		if !forced && !s.shouldInclude(relPath, info.IsDir()) {
+			s.skip(relPath, info.IsDir(), SkipNotIncluded, "")
 			if info.IsDir() {
 				return nil // Don't skip directory, but don't include it
 			}
 			return nil
 		}
 
+		if !forced && !info.IsDir() {
+			if filtered, limit := s.sizeFiltered(info.Size()); filtered {
+				s.skip(relPath, false, SkipSize, limit)
+				return nil
+			}
+			if old, cutoff := s.modifiedBefore(info.ModTime()); old {
+				s.skip(relPath, false, SkipModified, cutoff)
+				return nil
+			}
+		}
+
+		if !forced && !info.IsDir() {
+			if reason, pattern, ok := s.inProgressBy(path, relPath); ok {
+				s.skip(relPath, false, reason, pattern)
+				return nil
+			}
+		}
+
+		// Sniff content last since it reads from every file
+		if !forced && !info.IsDir() {
+			if filtered, category := s.contentTypeFiltered(path); filtered {
+				s.skip(relPath, false, SkipContentType, category)
+				return nil
+			}
+		}
+
 		fileInfo := FileInfo{
 			Path:         filepath.ToSlash(relPath), // Use forward slashes for consistency
 			AbsolutePath: path,
 			Size:         info.Size(),
 			ModTime:      info.ModTime(),
 			IsDir:        info.IsDir(),
+			Mode:         info.Mode().Perm(),
+			Forced:       forced,
+		}
+		fileInfo.UID, fileInfo.GID = fileOwner(info)
+
+		if !info.IsDir() {
+			if dev, ino, ok := fileID(info); ok {
+				id := fmt.Sprintf("%d:%d", dev, ino)
+				if first, seen := w.links[id]; seen {
+					fileInfo.HardlinkOf = first
+				} else {
+					w.links[id] = fileInfo.Path
+				}
+			}
 		}
 
 		// Calculate MD5 hash for files (not directories)
-		if !info.IsDir() && info.Size() > 0 {
-			hash, err := s.calculateMD5(path)
+		if !info.IsDir() && info.Size() > 0 && !s.noHash {
+			hash, err := s.hashFile(path, info)
 			if err != nil {
 				// Log warning but continue processing
 				fmt.Printf("Warning: Failed to calculate MD5 for %s: %v\n", path, err)
 			} else {
-				fileInfo.MD5Hash = hash
+				fileInfo.Checksum = hash
+				fileInfo.HashAlgorithm = s.hashAlgorithm
+				if s.hashAlgorithm == HashMD5 {
+					fileInfo.MD5Hash = hash
+				}
 			}
 		}
 
-		files = append(files, fileInfo)
+		w.files = append(w.files, fileInfo)
 		return nil
+	}
+	return visit
+}
+
+// Skipped returns the paths filtered out by the most recent Scan. Files
+// inside an ignored folder are not listed individually; the folder is.
+func (s *Scanner) Skipped() []SkippedFile {
+	return s.skipped
+}
+
+// skip records a filtered path
+func (s *Scanner) skip(relPath string, isDir bool, reason SkipReason, pattern string) {
+	s.skipped = append(s.skipped, SkippedFile{
+		Path:    filepath.ToSlash(relPath),
+		IsDir:   isDir,
+		Reason:  reason,
+		Pattern: pattern,
 	})
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan directory: %w", err)
+// Explain reports whether the scanner would filter out relPath and which
+// rule is responsible. The path doesn't need to exist; parent folders are
+// checked the same way Scan would reach them.
+func (s *Scanner) Explain(relPath string, isDir bool) (SkippedFile, bool) {
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+
+	excludedDir := relPath
+	if !isDir {
+		excludedDir = path.Dir(relPath)
+	}
+	if folder, ok := utils.ExcludedFolder(s.root, excludedDir, s.excludeFolders); ok {
+		return SkippedFile{Path: relPath, IsDir: isDir, Reason: SkipExcludedFolder, Pattern: folder}, true
+	}
+
+	if s.forceIncluded(relPath, isDir) {
+		return SkippedFile{}, false
 	}
 
-	return files, nil
+	// Scan never descends into an ignored folder
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		dir := strings.Join(parts[:i], "/")
+		if pattern, ok := s.ignoredBy(dir, true); ok {
+			return SkippedFile{Path: relPath, IsDir: isDir, Reason: SkipExcludedFolder, Pattern: pattern}, true
+		}
+	}
+
+	if pattern, ok := s.ignoredBy(relPath, isDir); ok {
+		return SkippedFile{Path: relPath, IsDir: isDir, Reason: SkipIgnored, Pattern: pattern}, true
+	}
+
+	if !s.shouldInclude(relPath, isDir) {
+		return SkippedFile{Path: relPath, IsDir: isDir, Reason: SkipNotIncluded}, true
+	}
+
+	return SkippedFile{}, false
 }
 
-// shouldIgnore checks if a path should be ignored based on patterns
-func (s *Scanner) shouldIgnore(relPath string, isDir bool) bool {
-	for _, pattern := range s.ignorePatterns {
-		if matched := s.matchPattern(pattern, relPath, isDir); matched {
+// inProgressBy reports whether a file looks like it is being written, with
+// the reason and the pattern or lockfile responsible
+func (s *Scanner) inProgressBy(path, relPath string) (SkipReason, string, bool) {
+	name := filepath.Base(relPath)
+	for _, pattern := range s.inProgress {
+		if s.matchPattern(pattern, relPath, false) || s.matchPattern(pattern, name, false) {
+			return SkipInProgress, pattern, true
+		}
+	}
+
+	for _, suffix := range s.lockSuffixes {
+		if _, err := os.Lstat(path + suffix); err == nil {
+			return SkipLocked, filepath.Base(path) + suffix, true
+		}
+	}
+
+	return "", "", false
+}
+
+// forceIncluded checks if a path matches the force-include allowlist
+func (s *Scanner) forceIncluded(relPath string, isDir bool) bool {
+	for _, pattern := range s.forceInclude {
+		if s.matchPattern(pattern, relPath, isDir) {
 			return true
 		}
 	}
 	return false
 }
 
+// ExplainPath is Explain for a path under rootPath, also applying the
+// size, modification-time and content-type filters when the file exists
+func (s *Scanner) ExplainPath(rootPath, relPath string) (SkippedFile, bool) {
+	s.root = rootPath
+	s.resetIgnoreFiles()
+	if err := s.loadIgnoreFiles(rootPath, relPath); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	fullPath := filepath.Join(rootPath, filepath.FromSlash(relPath))
+	info, err := os.Stat(fullPath)
+	isDir := err == nil && info.IsDir()
+
+	if skipped, ok := s.Explain(relPath, isDir); ok {
+		return skipped, true
+	}
+
+	if linkInfo, err := os.Lstat(fullPath); err == nil && linkInfo.Mode()&os.ModeSymlink != 0 && !s.followSymlinks {
+		return SkippedFile{Path: filepath.ToSlash(relPath), Reason: SkipSymlink}, true
+	}
+
+	if err == nil && !isDir && !s.forceIncluded(relPath, false) {
+		if filtered, limit := s.sizeFiltered(info.Size()); filtered {
+			return SkippedFile{Path: filepath.ToSlash(relPath), Reason: SkipSize, Pattern: limit}, true
+		}
+		if old, cutoff := s.modifiedBefore(info.ModTime()); old {
+			return SkippedFile{Path: filepath.ToSlash(relPath), Reason: SkipModified, Pattern: cutoff}, true
+		}
+		if reason, pattern, ok := s.inProgressBy(fullPath, relPath); ok {
+			return SkippedFile{Path: filepath.ToSlash(relPath), Reason: reason, Pattern: pattern}, true
+		}
+		if filtered, category := s.contentTypeFiltered(fullPath); filtered {
+			return SkippedFile{Path: filepath.ToSlash(relPath), Reason: SkipContentType, Pattern: category}, true
+		}
+	}
+
+	return SkippedFile{}, false
+}
+
+// shouldIgnore checks if a path should be ignored based on patterns
+func (s *Scanner) shouldIgnore(relPath string, isDir bool) bool {
+	_, ok := s.ignoredBy(relPath, isDir)
+	return ok
+}
+
+// ignoredBy reports whether a path is ignored and the rule responsible.
+// Like gitignore, the last matching rule wins, so a later "!pattern"
+// re-includes a path an earlier rule ignored.
+func (s *Scanner) ignoredBy(relPath string, isDir bool) (string, bool) {
+	relPath = filepath.ToSlash(relPath)
+	for i := len(s.rules) - 1; i >= 0; i-- {
+		rule := s.rules[i]
+		p := relPath
+		if rule.base != "" {
+			if !strings.HasPrefix(p, rule.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(p, rule.base+"/")
+		}
+		if s.matchPattern(rule.pattern, p, isDir) {
+			return rule.source, !rule.negate
+		}
+	}
+	return "", false
+}
+
 // shouldInclude checks if a path should be included based on patterns
 func (s *Scanner) shouldInclude(relPath string, isDir bool) bool {
 	// If no include patterns specified, include everything
@@ -138,64 +609,108 @@ func (s *Scanner) shouldInclude(relPath string, isDir bool) bool {
 	return false
 }
 
-// matchPattern performs pattern matching using filepath.Match and custom logic
-func (s *Scanner) matchPattern(pattern, path string, isDir bool) bool {
-	// Convert to forward slashes for consistent matching
-	path = filepath.ToSlash(path)
+// matchPattern matches a path against a gitignore-style pattern. A pattern
+// without a slash matches a file or folder name at any depth; one with a
+// leading or inner slash is anchored to the root. A trailing slash only
+// matches folders. A path inside a matching folder matches as well. "**"
+// as a whole segment matches any number of folders, including none.
+func (s *Scanner) matchPattern(pattern, relPath string, isDir bool) bool {
+	relPath = filepath.ToSlash(relPath)
 	pattern = filepath.ToSlash(pattern)
 
-	// Handle directory-specific patterns (ending with /)
-	if strings.HasSuffix(pattern, "/") {
-		if !isDir {
-			return false
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+
+	// The path itself, then each of its parent folders
+	for p, dir := relPath, isDir; p != "." && p != "/" && p != ""; p, dir = path.Dir(p), true {
+		if dirOnly && !dir {
+			continue
+		}
+		name := p
+		if !anchored {
+			name = path.Base(p)
+		}
+		if globMatch(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
 		}
-		pattern = strings.TrimSuffix(pattern, "/")
 	}
 
-	// Try exact match first
-	if path == pattern {
-		return true
+	return false
+}
+
+// globMatch matches path segments against pattern segments. "**" matches
+// zero or more segments, except at the end of a pattern, where it needs at
+// least one so that "dir/**" matches what's inside dir but not dir itself.
+func globMatch(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(segments) > 0
+			}
+			for i := 0; i <= len(segments); i++ {
+				if globMatch(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
 	}
+	return len(segments) == 0
+}
 
-	// Try filepath.Match for shell-style patterns
-	if matched, err := filepath.Match(pattern, path); err == nil && matched {
-		return true
+// hashFile returns a file's MD5 hash, consulting the hash cache first
+func (s *Scanner) hashFile(path string, info os.FileInfo) (string, error) {
+	key, ok := hashCacheKey(info)
+	if s.hashCache == nil || !ok {
+		return s.calculateChecksum(path)
+	}
+	if s.hashAlgorithm != HashMD5 {
+		key = s.hashAlgorithm + ":" + key
 	}
 
-	// Check if path starts with pattern (for directory matching)
-	if strings.HasPrefix(path, pattern+"/") {
-		return true
+	if hash, ok := s.hashCache.lookup(key); ok {
+		return hash, nil
 	}
 
-	// Check if any parent directory matches the pattern
-	dir := filepath.Dir(path)
-	for dir != "." && dir != "/" {
-		if matched, err := filepath.Match(pattern, filepath.Base(dir)); err == nil && matched {
-			return true
-		}
-		dir = filepath.Dir(dir)
+	hash, err := s.calculateChecksum(path)
+	if err != nil {
+		return "", err
 	}
+	s.hashCache.store(key, hash)
+	return hash, nil
+}
 
-	return false
+// calculateChecksum computes the hash of a file with the scanner's algorithm
+func (s *Scanner) calculateChecksum(filePath string) (string, error) {
+	return CalculateChecksum(filePath, s.hashAlgorithm)
 }
 
 // calculateMD5 computes MD5 hash of a file
 func (s *Scanner) calculateMD5(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return "", fmt.Errorf("failed to read file for hashing: %w", err)
-	}
+	return CalculateMD5(filePath)
+}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+// CalculateMD5 computes the hex-encoded MD5 hash of a file's content
+func CalculateMD5(filePath string) (string, error) {
+	return CalculateChecksum(filePath, HashMD5)
 }
 
-// FilterByPatterns applies ignore and include patterns to a list of files
+// FilterByPatterns applies ignore and include patterns to a list of files.
+// With include patterns, hidden folders like .git are left out of the list,
+// as "*" doesn't reach them in a shell glob either; their files are still
+// checked one by one.
 func FilterByPatterns(files []FileInfo, ignorePatterns, includePatterns []string) []FileInfo {
 	scanner := NewScanner(ignorePatterns, includePatterns)
 	var filtered []FileInfo
@@ -204,6 +719,9 @@ func FilterByPatterns(files []FileInfo, ignorePatterns, includePatterns []string
 		if scanner.shouldIgnore(file.Path, file.IsDir) {
 			continue
 		}
+		if file.IsDir && len(includePatterns) > 0 && strings.HasPrefix(path.Base(file.Path), ".") {
+			continue
+		}
 		if !scanner.shouldInclude(file.Path, file.IsDir) {
 			continue
 		}
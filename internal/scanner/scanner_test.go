@@ -3,6 +3,7 @@ package scanner
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -196,6 +197,26 @@ func TestScannerWithPatterns(t *testing.T) {
 	}
 }
 
+func TestIncludePatternsInHiddenFolders(t *testing.T) {
+	tempDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tempDir, ".config"), 0755)
+	os.WriteFile(filepath.Join(tempDir, ".config", "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(tempDir, ".config", "b.log"), []byte("b"), 0644)
+
+	// Include patterns don't stop the scan at hidden folders
+	files, err := NewScanner(nil, []string{"*.txt"}).Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, file := range files {
+		got[file.Path] = true
+	}
+	if len(got) != 2 || !got[".config"] || !got[".config/a.txt"] {
+		t.Errorf("Expected .config and .config/a.txt, got %v", got)
+	}
+}
+
 func TestFilterByPatterns(t *testing.T) {
 	// Create test files
 	files := []FileInfo{
@@ -296,6 +317,25 @@ func TestMatchPattern(t *testing.T) {
 		{"**/temp", "temp", false, true},
 		{"**/temp", "dir/temp", false, true},
 		{"**/temp", "dir/subdir/temp", false, true},
+		{"**/temp", "temporary", false, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "x/a/b", false, false},
+		{"a/**/b", "a/x/bb", false, false},
+		{"**/c", "c", false, true},
+		{"**/c", "x/y/c", false, true},
+		{"**/c", "c/file.txt", false, true},
+		{"d/**", "d", true, false},
+		{"d/**", "d/file.txt", false, true},
+		{"d/**", "d/x/y/file.txt", false, true},
+		{"d/**", "e/d/file.txt", false, false},
+		{"logs/**/*.log", "logs/app.log", false, true},
+		{"logs/**/*.log", "logs/2024/01/app.log", false, true},
+		{"logs/**/*.log", "logs/2024/app.txt", false, false},
+		{"**/node_modules/", "web/node_modules", true, true},
+		{"**/node_modules/", "web/node_modules", false, false},
+		{"**/node_modules/", "web/node_modules/pkg/index.js", false, true},
 	}
 
 	for _, tt := range tests {
@@ -309,6 +349,527 @@ func TestMatchPattern(t *testing.T) {
 	}
 }
 
+func TestIgnoreFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_ignore_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	testFiles := map[string]string{
+		IgnoreFileName:                  "# build output\n*.log\n!keep.log\n/build\n\\#notes.txt\n",
+		"app.log":                       "",
+		"keep.log":                      "",
+		"#notes.txt":                    "",
+		"build/out.o":                   "",
+		"src/build/generated.go":        "",
+		"src/main.go":                   "",
+		"src/debug.log":                 "",
+		"docs/" + IgnoreFileName:        "*.pdf\n!keep.log\n*.log\n",
+		"docs/guide.pdf":                "",
+		"docs/guide.md":                 "",
+		"docs/keep.log":                 "",
+		"docs/drafts/" + IgnoreFileName: "!*.pdf\n",
+		"docs/drafts/draft.pdf":         "",
+	}
+	for relPath, content := range testFiles {
+		fullPath := filepath.Join(tempDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", fullPath, err)
+		}
+	}
+
+	scanner := NewScanner([]string{"*.o"}, nil)
+	files, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsDir {
+			got[file.Path] = true
+		}
+	}
+
+	expected := []string{
+		IgnoreFileName,
+		"keep.log",               // Re-included by a later negation
+		"src/build/generated.go", // "/build" only matches at the root
+		"src/main.go",
+		"docs/" + IgnoreFileName,
+		"docs/guide.md",
+		"docs/drafts/" + IgnoreFileName,
+		"docs/drafts/draft.pdf", // A deeper ignore file overrides a shallower one
+	}
+	for _, path := range expected {
+		if !got[path] {
+			t.Errorf("Expected %s in scan result, got %v", path, got)
+		}
+	}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d files, got %d: %v", len(expected), len(got), got)
+	}
+
+	skipped, ok := scanner.ExplainPath(tempDir, "docs/keep.log")
+	if !ok || skipped.Pattern != "docs/"+IgnoreFileName+":3: *.log" {
+		t.Errorf("ExplainPath(docs/keep.log) = %+v, %v; expected the later *.log rule to win", skipped, ok)
+	}
+}
+
+func TestForceInclude(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_force_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, relPath := range []string{"main.go", "app.log", "audit.log", "tmp/a.txt", "tmp/keep.txt"} {
+		fullPath := filepath.Join(tempDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(relPath), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", fullPath, err)
+		}
+	}
+
+	scanner := NewScanner([]string{"*.log", "tmp"}, nil)
+	scanner.SetForceInclude([]string{"audit.log", "tmp/keep.txt"})
+
+	files, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range files {
+		got[file.Path] = file.Forced
+	}
+
+	expected := map[string]bool{"main.go": false, "audit.log": true, "tmp/keep.txt": true}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	for path, forced := range expected {
+		if f, ok := got[path]; !ok || f != forced {
+			t.Errorf("Expected %s (forced=%v) in scan result, got %v", path, forced, got)
+		}
+	}
+}
+
+func TestNarrowing(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_narrow_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, relPath := range []string{"main.go", "notes.md", "audit.log", "docs/a.md", "docs/b.txt", "docs/drafts/c.md"} {
+		fullPath := filepath.Join(tempDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(relPath), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", fullPath, err)
+		}
+	}
+
+	// Narrowing applies on top of the configured rules, force_include too
+	scanner := NewScanner([]string{"*.log", "main.go"}, nil)
+	scanner.SetForceInclude([]string{"audit.log"})
+	scanner.SetNarrowing([]string{"*.md", "audit.log"}, []string{"drafts/", "audit.log"})
+
+	files, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	var got []string
+	for _, file := range files {
+		if !file.IsDir {
+			got = append(got, file.Path)
+		}
+	}
+	expected := []string{"docs/a.md", "notes.md"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// A path inside an excluded folder is left out of a partial scan
+	files, err = scanner.ScanPaths(tempDir, []string{"docs/drafts/c.md"})
+	if err != nil || len(files) != 0 {
+		t.Errorf("Expected nothing from the excluded folder, got %v, %v", files, err)
+	}
+}
+
+func TestExcludeFolders(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_exclude_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, relPath := range []string{
+		"main.go",
+		"src/app/build/out.o",     // "build" is excluded at any depth
+		"src/app/builder/keep.go", // ...but not folders that only start with it
+		"docs/old/a.txt",          // "docs/old" is excluded from the root
+		"docs/older/b.txt",        // ...and shares a prefix with this one
+		"cache/c.txt",             // Excluded by absolute path
+		"cache2/d.txt",
+	} {
+		fullPath := filepath.Join(tempDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(relPath), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", fullPath, err)
+		}
+	}
+
+	// Force-include rules can't reach into an excluded folder
+	scanner := NewScanner(nil, nil)
+	scanner.SetForceInclude([]string{"*.o"})
+	scanner.SetExcludeFolders([]string{"build", "/docs/old/", filepath.Join(tempDir, "cache")})
+
+	files, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsDir {
+			got[file.Path] = true
+		}
+	}
+	expected := []string{"main.go", "src/app/builder/keep.go", "docs/older/b.txt", "cache2/d.txt"}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	for _, path := range expected {
+		if !got[path] {
+			t.Errorf("Expected %s in scan result, got %v", path, got)
+		}
+	}
+
+	skipped := make(map[string]SkipReason)
+	for _, s := range scanner.Skipped() {
+		skipped[s.Path] = s.Reason
+	}
+	for _, dir := range []string{"src/app/build", "docs/old", "cache"} {
+		if skipped[dir] != SkipExcludedFolder {
+			t.Errorf("Expected %s to be skipped as an excluded folder, got %v", dir, skipped)
+		}
+	}
+
+	// Scanning changed paths leaves out those inside excluded folders
+	files, err = scanner.ScanPaths(tempDir, []string{"src/app/build/out.o", "docs/old", "docs/older/b.txt"})
+	if err != nil {
+		t.Fatalf("ScanPaths failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "docs/older/b.txt" {
+		t.Errorf("Expected only docs/older/b.txt, got %v", files)
+	}
+
+	if skip, ok := scanner.Explain("docs/old/new.txt", false); !ok || skip.Reason != SkipExcludedFolder || skip.Pattern != "/docs/old/" {
+		t.Errorf("Expected docs/old/new.txt to be explained by /docs/old/, got %v", skip)
+	}
+	if skip, ok := scanner.Explain("docs/older/new.txt", false); ok {
+		t.Errorf("Expected docs/older/new.txt to be kept, got %v", skip)
+	}
+}
+
+func TestContentTypeFilter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_sniff_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string][]byte{
+		"photo":     []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+		"notes":     []byte("plain text without an extension"),
+		"bundle.md": []byte("PK\x03\x04 mislabeled zip archive"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), content, 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", name, err)
+		}
+	}
+
+	scanner := NewScanner(nil, nil)
+	if err := scanner.SetContentTypeFilter([]string{CategoryImage, CategoryText}, nil); err != nil {
+		t.Fatalf("SetContentTypeFilter failed: %v", err)
+	}
+
+	result, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range result {
+		got[file.Path] = true
+	}
+	if !got["photo"] || !got["notes"] || got["bundle.md"] {
+		t.Errorf("Expected photo and notes only, got %v", got)
+	}
+
+	if err := scanner.SetContentTypeFilter([]string{"spreadsheet"}, nil); err == nil {
+		t.Error("Expected an error for an unknown content category")
+	}
+}
+
+func TestSizeFilter(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_size_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]int{
+		"tiny.txt":        10,
+		"small/ok.txt":    100,
+		"small/huge.bin":  1000,
+		"small/huge.log":  1000,
+		"forced/huge.bin": 1000,
+	}
+	for relPath, size := range files {
+		fullPath := filepath.Join(tempDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, make([]byte, size), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", relPath, err)
+		}
+	}
+
+	scanner := NewScanner([]string{"*.log"}, nil)
+	scanner.SetForceInclude([]string{"forced/huge.bin"})
+	scanner.SetSizeFilter(50, 500)
+
+	result, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range result {
+		got[file.Path] = true
+	}
+	for _, relPath := range []string{"small", "small/ok.txt", "forced", "forced/huge.bin"} {
+		if !got[relPath] {
+			t.Errorf("Expected %s to be scanned, got %v", relPath, got)
+		}
+	}
+	if got["tiny.txt"] || got["small/huge.bin"] {
+		t.Errorf("Expected files outside the size range to be skipped, got %v", got)
+	}
+
+	reasons := make(map[string]SkipReason)
+	for _, skipped := range scanner.Skipped() {
+		reasons[skipped.Path] = skipped.Reason
+	}
+	if reasons["tiny.txt"] != SkipSize || reasons["small/huge.bin"] != SkipSize {
+		t.Errorf("Expected size skips, got %v", reasons)
+	}
+	if reasons["small/huge.log"] != SkipIgnored {
+		t.Errorf("Expected patterns to apply before the size filter, got %v", reasons)
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_modified_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, relPath := range []string{"old.txt", "archive/new.txt", "archive/old.txt"} {
+		fullPath := filepath.Join(tempDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(relPath), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", relPath, err)
+		}
+	}
+	for _, relPath := range []string{"old.txt", "archive/old.txt", "archive"} {
+		if err := os.Chtimes(filepath.Join(tempDir, filepath.FromSlash(relPath)), old, old); err != nil {
+			t.Fatalf("Failed to set times on %s: %v", relPath, err)
+		}
+	}
+
+	scanner := NewScanner(nil, nil)
+	scanner.SetModifiedSince(time.Now().Add(-24 * time.Hour))
+
+	result, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range result {
+		got[file.Path] = true
+	}
+	if !got["archive"] || !got["archive/new.txt"] || got["old.txt"] || got["archive/old.txt"] {
+		t.Errorf("Expected the old folder and its new file only, got %v", got)
+	}
+	for _, skipped := range scanner.Skipped() {
+		if skipped.Reason != SkipModified {
+			t.Errorf("Expected %s to be skipped as %s, got %s", skipped.Path, SkipModified, skipped.Reason)
+		}
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_symlink_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	source := filepath.Join(tempDir, "source")
+	shared := filepath.Join(tempDir, "shared")
+	for _, dir := range []string{source, filepath.Join(shared, "nested")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(shared, "nested", "doc.txt"), []byte("shared"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	links := map[string]string{
+		filepath.Join(source, "shared"):           shared,
+		filepath.Join(source, "doc.txt"):          filepath.Join(shared, "nested", "doc.txt"),
+		filepath.Join(source, "broken"):           filepath.Join(tempDir, "missing"),
+		filepath.Join(shared, "nested", "parent"): shared,
+		filepath.Join(shared, "source"):           source,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("Symlinks not supported: %v", err)
+		}
+	}
+
+	scanner := NewScanner(nil, nil)
+	result, err := scanner.Scan(source)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("Expected symlinks to be skipped, got %v", result)
+	}
+	if len(scanner.Skipped()) != 3 {
+		t.Errorf("Expected 3 skipped symlinks, got %v", scanner.Skipped())
+	}
+
+	scanner.SetFollowSymlinks(true)
+	result, err = scanner.Scan(source)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]FileInfo)
+	for _, file := range result {
+		got[file.Path] = file
+	}
+	expected := []string{"doc.txt", "shared", "shared/nested", "shared/nested/doc.txt"}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	for _, relPath := range expected {
+		if _, ok := got[relPath]; !ok {
+			t.Errorf("Expected %s to be scanned", relPath)
+		}
+	}
+	if file := got["doc.txt"]; file.Size != int64(len("shared")) || file.MD5Hash == "" {
+		t.Errorf("Expected doc.txt to have its target's content, got %+v", file)
+	}
+}
+
+func TestInProgressFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_inprogress_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, relPath := range []string{"db/data.db", "db/data.db-wal", "db/idle.db", "video.mp4.part", "notes.txt"} {
+		fullPath := filepath.Join(tempDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(relPath), 0644); err != nil {
+			t.Fatalf("Failed to create file %s: %v", fullPath, err)
+		}
+	}
+
+	scanner := NewScanner(nil, nil)
+	scanner.SetInProgress([]string{"*.part", "*-wal"}, []string{"-wal"})
+
+	files, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	got := make(map[string]bool)
+	for _, file := range files {
+		if !file.IsDir {
+			got[file.Path] = true
+		}
+	}
+	if len(got) != 2 || !got["db/idle.db"] || !got["notes.txt"] {
+		t.Errorf("Expected only db/idle.db and notes.txt, got %v", got)
+	}
+
+	reasons := make(map[string]SkipReason)
+	for _, skipped := range scanner.Skipped() {
+		reasons[skipped.Path] = skipped.Reason
+	}
+	if reasons["db/data.db"] != SkipLocked {
+		t.Errorf("Expected db/data.db to be skipped as locked, got %q", reasons["db/data.db"])
+	}
+	if reasons["video.mp4.part"] != SkipInProgress {
+		t.Errorf("Expected video.mp4.part to be skipped as in-progress, got %q", reasons["video.mp4.part"])
+	}
+}
+
+func TestExplain(t *testing.T) {
+	scanner := NewScanner([]string{"*.tmp", "node_modules"}, []string{"*.go", "*.md"})
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		skipped bool
+		reason  SkipReason
+		pattern string
+	}{
+		{"main.go", false, false, "", ""},
+		{"cache.tmp", false, true, SkipIgnored, "*.tmp"},
+		{"node_modules/pkg/index.go", false, true, SkipExcludedFolder, "node_modules"},
+		{"notes.txt", false, true, SkipNotIncluded, ""},
+		{"src", true, false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			result, skipped := scanner.Explain(tt.path, tt.isDir)
+			if skipped != tt.skipped {
+				t.Fatalf("Explain(%q) skipped = %v, expected %v", tt.path, skipped, tt.skipped)
+			}
+			if result.Reason != tt.reason || result.Pattern != tt.pattern {
+				t.Errorf("Explain(%q) = (%q, %q), expected (%q, %q)",
+					tt.path, result.Reason, result.Pattern, tt.reason, tt.pattern)
+			}
+		})
+	}
+}
+
 func TestCalculateMD5(t *testing.T) {
 	// Create temporary file
 	tempDir, err := os.MkdirTemp("", "csync_md5_test")
@@ -337,6 +898,50 @@ func TestCalculateMD5(t *testing.T) {
 	}
 }
 
+func TestHashAlgorithm(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "csync_hash_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := os.WriteFile(filepath.Join(tempDir, "test.txt"), []byte("Hello, World!"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	scanner := NewScanner(nil, nil)
+	if err := scanner.SetHashAlgorithm(HashSHA256); err != nil {
+		t.Fatalf("SetHashAlgorithm failed: %v", err)
+	}
+
+	files, err := scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 file, got %d", len(files))
+	}
+
+	expected := "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+	if file := files[0]; file.Checksum != expected || file.HashAlgorithm != HashSHA256 || file.MD5Hash != "" {
+		t.Errorf("Expected sha256 %s and no MD5, got %+v", expected, file)
+	}
+
+	if err := scanner.SetHashAlgorithm("crc32"); err == nil {
+		t.Error("Expected an error for an unknown hash algorithm")
+	}
+
+	// With hashing off files are left for the upload to hash
+	scanner.SetHashing(false)
+	files, err = scanner.Scan(tempDir)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if file := files[0]; file.Checksum != "" || file.HashAlgorithm != "" || file.Size == 0 {
+		t.Errorf("Expected an unhashed file, got %+v", file)
+	}
+}
+
 func TestFileInfoFields(t *testing.T) {
 	// Create temporary file
 	tempDir, err := os.MkdirTemp("", "csync_fileinfo_test")
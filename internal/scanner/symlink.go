@@ -0,0 +1,79 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// SetFollowSymlinks makes Scan sync the targets of symlinks as if they were
+// regular files and folders at the link's place. Otherwise symlinks are
+// skipped.
+func (s *Scanner) SetFollowSymlinks(follow bool) {
+	s.followSymlinks = follow
+}
+
+// resolveLink returns the target of the symlink at path and its info, or
+// false if the link is skipped: when symlinks aren't followed, when it is
+// broken, or when it points back to a folder already being walked
+func (s *Scanner) resolveLink(path, relPath string, w *scanWalk) (string, os.FileInfo, bool) {
+	if !s.followSymlinks {
+		utils.LogVerbose("Skipping symlink %s", relPath)
+		s.skip(relPath, false, SkipSymlink, "")
+		return "", nil, false
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(target); err == nil {
+			if info.IsDir() && s.linkLoops(path, target, w) {
+				utils.LogVerbose("Skipping symlink %s: it loops back to %s", relPath, target)
+				s.skip(relPath, false, SkipSymlink, target)
+				return "", nil, false
+			}
+			return target, info, true
+		}
+	}
+
+	utils.LogVerbose("Skipping broken symlink %s: %v", relPath, err)
+	s.skip(relPath, false, SkipSymlink, "")
+	return "", nil, false
+}
+
+// linkLoops reports whether following a symlink at path to the folder
+// target would walk a folder that contains the link, the source root or
+// one of the link targets being walked, again
+func (s *Scanner) linkLoops(path, target string, w *scanWalk) bool {
+	if parent, err := filepath.EvalSymlinks(filepath.Dir(path)); err == nil && within(target, parent) {
+		return true
+	}
+	for _, followed := range w.followed {
+		if within(target, followed) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkLink walks the folder target of the symlink at path, reporting every
+// entry to visit under the link's path
+func (s *Scanner) walkLink(path, target string, w *scanWalk, visit filepath.WalkFunc) error {
+	w.followed = append(w.followed, target)
+	defer func() { w.followed = w.followed[:len(w.followed)-1] }()
+
+	return filepath.Walk(target, func(p string, info os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(target, p)
+		if relErr != nil {
+			return relErr
+		}
+		return visit(filepath.Join(path, rel), info, err)
+	})
+}
+
+// within reports whether p is dir or lies inside it
+func within(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,179 @@
+// Package schedule works out when the daemon syncs next, from a fixed
+// interval or a cron spec
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule says when the next run is due
+type Schedule interface {
+	// Next returns the first run after t, or the zero time if none is due
+	// within five years
+	Next(t time.Time) time.Time
+	String() string
+}
+
+// Parse reads spec as a Go duration, like "5m", or a cron spec of five
+// fields separated by spaces, like "0 2 * * *" for 2am daily
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.ContainsAny(spec, " \t") {
+		return parseCron(spec)
+	}
+
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a duration nor a cron spec", spec)
+	}
+	if d <= 0 {
+		return nil, fmt.Errorf("interval %q must be positive", spec)
+	}
+	return Interval(d), nil
+}
+
+// Interval runs a fixed time after the previous run
+type Interval time.Duration
+
+// Next returns t plus the interval
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i Interval) String() string {
+	return time.Duration(i).String()
+}
+
+// cron runs at the minutes matching a five-field cron spec: minute, hour,
+// day of month, month and day of week. Each field is a bit set of the
+// values it allows.
+type cron struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+
+	// Whether the day fields start with "*". When neither does, a day
+	// matching either one runs, as in cron(8).
+	anyDom, anyDow bool
+}
+
+// parseCron parses a five-field cron spec. Fields take "*", values,
+// ranges like "1-5", lists like "1,15" and steps like "*/15" or "8-18/2".
+// Day of week runs from 0 (Sunday) to 7 (Sunday again).
+func parseCron(spec string) (Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec %q must have 5 fields, got %d", spec, len(fields))
+	}
+
+	c := &cron{
+		spec:   strings.Join(fields, " "),
+		anyDom: strings.HasPrefix(fields[2], "*"),
+		anyDow: strings.HasPrefix(fields[4], "*"),
+	}
+	bounds := []struct {
+		name     string
+		set      *uint64
+		min, max int
+	}{
+		{"minute", &c.minute, 0, 59},
+		{"hour", &c.hour, 0, 23},
+		{"day of month", &c.dom, 1, 31},
+		{"month", &c.month, 1, 12},
+		{"day of week", &c.dow, 0, 7},
+	}
+	for i, b := range bounds {
+		set, err := parseField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron spec %q: %s: %w", spec, b.name, err)
+		}
+		*b.set = set
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 << 0
+	}
+
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron spec %q never runs", spec)
+	}
+	return c, nil
+}
+
+// parseField returns the bit set of values field allows between min and max
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			switch {
+			case isRange:
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			case step == 1:
+				hi = lo
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first matching minute after t, in t's location
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	loc := t.Location()
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether t's day of month and day of week allow a run
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cron) String() string {
+	return c.spec
+}
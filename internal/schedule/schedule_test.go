@@ -0,0 +1,59 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	s, err := Parse("5m")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	start := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	if next := s.Next(start); !next.Equal(start.Add(5 * time.Minute)) {
+		t.Errorf("Expected a run 5m later, got %s", next)
+	}
+
+	for _, spec := range []string{"", "0s", "-1m", "daily", "* * *", "60 * * * *", "0 2 * * 8", "5-1 * * * *", "*/0 * * * *", "0 0 31 2 *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected Parse(%q) to fail", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Thursday
+	start := time.Date(2025, 1, 2, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2025, 1, 3, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 2, 10, 30, 0, 0, time.UTC)},
+		{"17 10 * * *", time.Date(2025, 1, 3, 10, 17, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2025, 1, 2, 13, 0, 0, 0, time.UTC)},
+		{"30 1 1,15 * *", time.Date(2025, 1, 15, 1, 30, 0, 0, time.UTC)},
+		{"0 0 * 3 *", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 3 * * 0", time.Date(2025, 1, 5, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2025, 1, 5, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2025, 1, 3, 3, 0, 0, 0, time.UTC)},
+		// With both day fields set, either one matching runs
+		{"0 0 20 * 6", time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(start); !got.Equal(tt.want) {
+			t.Errorf("%q: expected next run at %s, got %s", tt.spec, tt.want, got)
+		}
+		if s.String() != tt.spec {
+			t.Errorf("Expected String() %q, got %q", tt.spec, s.String())
+		}
+	}
+}
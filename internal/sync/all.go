@@ -0,0 +1,230 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// ProviderResult is the outcome of one provider's sync in SyncAll
+type ProviderResult struct {
+	Provider string
+	Summary  RunSummary
+	Report   ProviderReport
+	Err      error
+}
+
+// LastResults returns the per-provider outcomes of the most recent SyncAll.
+// LastSummary, LastReport, FailedFiles and SkippedFiles combine them, while
+// LastDryRun only ever describes a dry run to one provider.
+func (m *Manager) LastResults() []ProviderResult {
+	return m.results
+}
+
+// SyncAll mirrors sourcePath to every configured provider at once. The
+// source is scanned once and shared by the providers, or once per hash
+// algorithm when they compare files differently. A failing provider doesn't
+// stop the others; their errors are combined. The API call budget covers
+// all providers together.
+func (m *Manager) SyncAll(ctx context.Context, sourcePath string, dryRun bool) error {
+	return m.syncAll(ctx, sourcePath, nil, nil, dryRun)
+}
+
+// SyncAllPaths syncs only the given paths of sourcePath to every configured
+// provider at once, like SyncPaths does for one provider. The paths are
+// scanned once for all providers.
+func (m *Manager) SyncAllPaths(ctx context.Context, sourcePath string, paths []string, renames map[string]string) error {
+	if paths == nil {
+		paths = []string{}
+	}
+	return m.syncAll(ctx, sourcePath, paths, renames, false)
+}
+
+// syncAll syncs to every configured provider concurrently. With non-nil
+// paths only those source-relative paths are scanned and synced, and
+// renames lists the files among them renamed from another path. The sync
+// hooks run once around the whole sync unless it's a dry run.
+func (m *Manager) syncAll(ctx context.Context, sourcePath string, paths []string, renames map[string]string, dryRun bool) (err error) {
+	m.budget.reset(m.config.GetAdvanced().APICallBudget)
+	start := time.Now()
+	names := m.ConfiguredProviders()
+	results := make([]ProviderResult, len(names))
+	m.results = nil
+
+	if !dryRun {
+		defer func() {
+			var reports []ProviderReport
+			for _, result := range m.results {
+				reports = append(reports, result.Report)
+			}
+			m.runPostSyncHook(ctx, "all", sourcePath, NewSyncReport(start, reports...), err)
+		}()
+		if err := m.runPreSyncHook(ctx, "all", sourcePath); err != nil {
+			return err
+		}
+	}
+
+	// Providers are created and scans made one at a time, as both update
+	// the manager; only the syncs themselves run concurrently
+	var state *SyncState
+	if statePath := m.config.GetAdvanced().StatePath; statePath != "" && !dryRun {
+		loaded, err := LoadState(statePath)
+		if err != nil {
+			return err
+		}
+		state = loaded
+	}
+
+	providers := make([]Provider, len(names))
+	scans := make([]*sourceScan, len(names))
+	byAlgorithm := make(map[string]*sourceScan)
+	for i, name := range names {
+		results[i].Provider = name
+		p, err := m.provider(ctx, name)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		providers[i] = p
+
+		algorithm := m.hashAlgorithm(p)
+		src, ok := byAlgorithm[algorithm]
+		if !ok {
+			src, err = m.scanSource(p, sourcePath, paths, dryRun)
+			if err != nil {
+				return err
+			}
+			src.state = state
+			src.renames = renames
+			byAlgorithm[algorithm] = src
+		}
+		scans[i] = src
+	}
+	utils.LogVerbose("Syncing %d providers from %d scans of %s", len(names), len(byAlgorithm), sourcePath)
+
+	var g errgroup.Group
+	runs := make([]runResult, len(names))
+	for i, name := range names {
+		if providers[i] == nil {
+			runs[i] = stoppedEarly(name, start, results[i].Err)
+			results[i].Report = runs[i].report
+			continue
+		}
+		g.Go(func() error {
+			res, err := m.runSync(ctx, name, providers[i], scans[i], start, dryRun)
+			runs[i] = res
+			results[i].Summary, results[i].Report, results[i].Err = res.summary, res.report, err
+			return nil
+		})
+	}
+	g.Wait()
+	m.results = results
+	m.record(mergeResults(runs, start, m.APICalls()), false)
+
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ProviderDisplayName(result.Provider), result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// mergeResults combines the runs of a sync to several providers into the
+// result of the whole sync, for provider "all". Failures and report entries
+// name their provider; skips the runs share through a scan are listed once.
+func mergeResults(runs []runResult, start time.Time, apiCalls int64) runResult {
+	merged := runResult{
+		summary: RunSummary{Provider: "all", APICalls: apiCalls},
+		report:  ProviderReport{Provider: "all", Duration: time.Since(start), Files: []FileReport{}},
+	}
+
+	seen := make(map[scanner.SkippedFile]bool)
+	var groups []SummaryGroup
+	var errs []string
+	for _, res := range runs {
+		name := ProviderDisplayName(res.report.Provider)
+		for _, skip := range res.skipped {
+			if !seen[skip] {
+				seen[skip] = true
+				merged.skipped = append(merged.skipped, skip)
+			}
+		}
+		for _, failure := range res.failed {
+			merged.failed = append(merged.failed, FileFailure{Path: failure.Path, Err: fmt.Errorf("%s: %w", name, failure.Err)})
+		}
+
+		merged.summary.Uploaded += res.summary.Uploaded
+		merged.summary.UploadedBytes += res.summary.UploadedBytes
+		merged.summary.Downloaded += res.summary.Downloaded
+		merged.summary.Skipped += res.summary.Skipped
+		merged.summary.Failed += res.summary.Failed
+		merged.summary.Warnings += res.summary.Warnings
+		groups = append(groups, res.summary.Groups...)
+
+		merged.report.Uploaded += res.report.Uploaded
+		merged.report.Downloaded += res.report.Downloaded
+		merged.report.Skipped += res.report.Skipped
+		merged.report.Failed += res.report.Failed
+		merged.report.Bytes += res.report.Bytes
+		for _, file := range res.report.Files {
+			file.Path = res.report.Provider + ":" + file.Path
+			merged.report.Files = append(merged.report.Files, file)
+		}
+		if res.report.Error != "" {
+			errs = append(errs, name+": "+res.report.Error)
+		}
+	}
+
+	merged.summary.Error = strings.Join(errs, "; ")
+	merged.report.Error = merged.summary.Error
+	merged.summary.Groups = mergeGroups(groups)
+	return merged
+}
+
+// mergeGroups combines summary groups of the same severity and kind,
+// errors first and then the largest group first, as summarize orders them
+func mergeGroups(groups []SummaryGroup) []SummaryGroup {
+	type key struct {
+		severity string
+		kind     ErrorKind
+	}
+	byKey := make(map[key]*SummaryGroup)
+	var merged []*SummaryGroup
+	for _, group := range groups {
+		k := key{group.Severity, group.Kind}
+		if existing, ok := byKey[k]; ok {
+			existing.Count += group.Count
+			existing.Paths = append(existing.Paths, group.Paths...)
+			continue
+		}
+		group.Paths = slices.Clone(group.Paths)
+		byKey[k] = &group
+		merged = append(merged, &group)
+	}
+
+	result := make([]SummaryGroup, 0, len(merged))
+	for _, group := range merged {
+		sort.Strings(group.Paths)
+		result = append(result, *group)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Severity != result[j].Severity {
+			return result[i].Severity == "error"
+		}
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Kind < result[j].Kind
+	})
+	return result
+}
@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+func TestSyncAll(t *testing.T) {
+	ctx := context.Background()
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "docs"), 0755)
+	os.WriteFile(filepath.Join(source, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(source, "docs", "b.txt"), []byte("b"), 0644)
+
+	cfg := &config.Config{
+		GoogleDrive: config.GoogleDriveConfig{CredentialsPath: "credentials.json"},
+		PCloud:      config.PCloudConfig{Username: "me@example.com"},
+	}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		StatePath: filepath.Join(t.TempDir(), "state.json"),
+	}}
+	manager := NewManager(cfg)
+
+	// Stand WebDAV servers in for gdrive and pcloud, counting the requests
+	// to each
+	requests := make(map[string]*atomic.Int64)
+	for _, name := range []string{"gdrive", "pcloud"} {
+		handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+		requests[name] = new(atomic.Int64)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests[name].Add(1)
+			handler.ServeHTTP(w, r)
+		}))
+		defer server.Close()
+		provider, err := newWebDAVProvider(ctx, &config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}, manager.transport(name))
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		manager.providers[name] = provider
+	}
+
+	for _, count := range requests {
+		count.Store(0)
+	}
+	if err := manager.SyncAll(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	results := manager.LastResults()
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Summary.Uploaded != 2 {
+			t.Errorf("%s: expected 2 uploads, got %d, %v", result.Provider, result.Summary.Uploaded, result.Err)
+		}
+		// Each provider reports its own requests, not those of both
+		if calls := requests[result.Provider].Load(); result.Summary.APICalls != calls || calls == 0 {
+			t.Errorf("%s: expected %d API calls, got %d", result.Provider, calls, result.Summary.APICalls)
+		}
+		info, err := manager.providers[result.Provider].GetFileInfo(ctx, "docs/b.txt")
+		if err != nil || info.Size != 1 {
+			t.Errorf("%s: expected docs/b.txt to be uploaded, got %v", result.Provider, err)
+		}
+	}
+
+	// The accessors describe the sync to both providers
+	summary := manager.LastSummary()
+	if summary.Provider != "all" || summary.Uploaded != 4 || summary.APICalls != results[0].Summary.APICalls+results[1].Summary.APICalls {
+		t.Errorf("Expected 4 uploads and the API calls of both providers, got %+v", summary)
+	}
+	if report := manager.LastReport(); report.Uploaded != 4 || len(report.Files) != 4 || report.Files[0].Path != "gdrive:a.txt" {
+		t.Errorf("Expected the files of both providers in the report, got %+v", report)
+	}
+
+	// Both providers recorded their files in the one state file
+	state, err := LoadState(cfg.GetAdvanced().StatePath)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	for _, name := range []string{"gdrive", "pcloud"} {
+		if _, ok := state.Get(name, "docs/b.txt"); !ok {
+			t.Errorf("Expected %s state for docs/b.txt", name)
+		}
+	}
+
+	// Changed paths are scanned once and synced to both
+	os.WriteFile(filepath.Join(source, "docs", "c.txt"), []byte("c"), 0644)
+	if err := manager.SyncAllPaths(ctx, source, []string{"docs/c.txt"}, nil); err != nil {
+		t.Fatalf("Failed to sync paths: %v", err)
+	}
+	for _, result := range manager.LastResults() {
+		if result.Summary.Uploaded != 1 {
+			t.Errorf("%s: expected only docs/c.txt to be uploaded, got %d uploads", result.Provider, result.Summary.Uploaded)
+		}
+	}
+
+	// A provider that can't sync doesn't stop the other
+	manager.providers["pcloud"] = &brokenUploads{Provider: manager.providers["pcloud"], broken: "a.txt"}
+	cfg.Optional.Advanced.FailOnAnyError = true
+	err = manager.SyncAll(ctx, source, false)
+	if !errors.Is(err, errBroken) {
+		t.Errorf("Expected the pCloud failure, got %v", err)
+	}
+	if results := manager.LastResults(); results[0].Err != nil || results[0].Summary.Uploaded != 3 {
+		t.Errorf("Expected gdrive to sync, got %d uploads, %v", results[0].Summary.Uploaded, results[0].Err)
+	}
+	failed := manager.FailedFiles()
+	if len(failed) != 1 || failed[0].Path != "a.txt" || !errors.Is(failed[0].Err, errBroken) || !strings.HasPrefix(failed[0].Err.Error(), "pCloud: ") {
+		t.Errorf("Expected pCloud's failed a.txt, got %v", failed)
+	}
+	if summary := manager.LastSummary(); summary.Failed != 1 || len(summary.Groups) != 1 || summary.Groups[0].Count != 1 {
+		t.Errorf("Expected one failure grouped in the summary, got %+v", summary)
+	}
+}
@@ -0,0 +1,185 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	b2client "github.com/svosadtsia/csync/internal/providers/b2"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+)
+
+// B2Provider implements the Provider interface for Backblaze B2
+type B2Provider struct {
+	client *b2client.Client
+}
+
+// NewB2Provider creates a new B2 provider
+func NewB2Provider(ctx context.Context, cfg *config.B2Config) (*B2Provider, error) {
+	return newB2Provider(ctx, cfg, nil)
+}
+
+func init() {
+	RegisterProvider("b2", ProviderFactory{
+		DisplayName: "B2",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := newB2Provider(ctx, &m.config.B2, m.transport("b2"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create B2 client: %w", err)
+			}
+			return client, nil
+		},
+		Settings:   func(cfg *config.Config) any { return cfg.B2 },
+		Configured: func(cfg *config.Config) bool { return cfg.B2.Bucket != "" },
+		Destination: func(cfg *config.Config) string {
+			if cfg.B2.Bucket == "" {
+				return ""
+			}
+			return fmt.Sprintf("b2://%s/%s", cfg.B2.Bucket, cfg.B2.Prefix)
+		},
+	})
+}
+
+// newB2Provider creates a B2 provider whose API requests go through
+// transport (nil for the default)
+func newB2Provider(ctx context.Context, cfg *config.B2Config, transport http.RoundTripper) (*B2Provider, error) {
+	client, err := b2client.NewClientWithTransport(ctx, cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &B2Provider{client: client}, nil
+}
+
+// Name returns the provider name
+func (p *B2Provider) Name() string {
+	return "B2"
+}
+
+// Capabilities reports the optional features B2 supports
+func (p *B2Provider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		Hashes:         []HashAlgorithm{HashSHA1},
+		ServerSideCopy: true,
+		Versioning:     true,
+		AtomicUpload:   true,
+		RangedDownload: true,
+	}
+}
+
+// Upload uploads a file to B2, reusing the scanned SHA1 when there is one
+// and otherwise leaving the client to take it while sending the file
+func (p *B2Provider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	sum := ""
+	if file.HashAlgorithm == string(HashSHA1) {
+		sum = file.Checksum
+	}
+	return p.client.Upload(ctx, file.AbsolutePath, remotePath, sum)
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *B2Provider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.client.SetUploadLimiter(limiter)
+}
+
+// CreateFolder uploads an empty folder marker
+func (p *B2Provider) CreateFolder(ctx context.Context, remotePath string) error {
+	return p.client.CreateFolder(ctx, remotePath)
+}
+
+// FileExists checks if a file exists in B2
+func (p *B2Provider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	obj, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return false, err
+	}
+	return obj != nil, nil
+}
+
+// GetFileInfo gets information about a file in B2
+func (p *B2Provider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	obj, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	info := b2FileInfo(*obj)
+	return &info, nil
+}
+
+// Delete deletes every version of a file, or everything below a folder
+func (p *B2Provider) Delete(ctx context.Context, remotePath string) error {
+	return p.client.Delete(ctx, remotePath)
+}
+
+// Copy copies a file on the server side
+func (p *B2Provider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Copy(ctx, srcRemotePath, dstRemotePath)
+}
+
+// Move copies a file to its new name and deletes the original
+func (p *B2Provider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Move(ctx, srcRemotePath, dstRemotePath)
+}
+
+// PublicLink is not supported: bucket settings decide what's public
+func (p *B2Provider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return "", &UnsupportedError{Provider: p.Name(), Feature: FeaturePublicLinks}
+}
+
+// List recursively lists files below remotePath ("" for the destination
+// root). Returned paths are relative to the destination root.
+func (p *B2Provider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	objects, err := p.client.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]RemoteFileInfo, 0, len(objects))
+	for _, obj := range objects {
+		files = append(files, b2FileInfo(obj))
+	}
+	return files, nil
+}
+
+// Download writes the content of a file to localPath
+func (p *B2Provider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of a file from offset onwards,
+// appending to the existing localPath
+func (p *B2Provider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	body, err := p.client.Open(ctx, remotePath, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if offset == 0 {
+		return writeLocalFile(localPath, body)
+	}
+	return appendLocalFile(localPath, body)
+}
+
+// UpdateMetadata is not supported: B2 file info can't change without a new
+// upload
+func (p *B2Provider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureMetadata}
+}
+
+// b2FileInfo converts a B2 file to a RemoteFileInfo
+func b2FileInfo(obj b2client.Object) RemoteFileInfo {
+	return RemoteFileInfo{
+		Path:     obj.Path,
+		Size:     obj.Size,
+		SHA1Hash: obj.SHA1,
+		Modified: obj.LastModified.UTC().Format(time.RFC3339Nano),
+		IsDir:    obj.IsDir,
+	}
+}
@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// fakeB2 serves the part of the B2 native API the B2 provider uses
+type fakeB2 struct {
+	mu        gosync.Mutex
+	files     map[string]string // SHA1 by file name
+	urls      int               // Upload URLs handed out
+	trailing  int               // Uploads sending their SHA1 after the data
+	busy      map[string]bool   // Upload URLs in use
+	sharedURL bool              // An upload URL was used concurrently
+}
+
+func (b *fakeB2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/b2api/v2/")
+	switch {
+	case endpoint == "b2_authorize_account":
+		json.NewEncoder(w).Encode(map[string]any{
+			"accountId": "account", "authorizationToken": "token",
+			"apiUrl": "http://" + r.Host, "downloadUrl": "http://" + r.Host,
+			"recommendedPartSize": 100 << 20,
+		})
+	case endpoint == "b2_list_buckets":
+		json.NewEncoder(w).Encode(map[string]any{"buckets": []map[string]string{{"bucketId": "bucket"}}})
+	case endpoint == "b2_get_upload_url":
+		b.mu.Lock()
+		b.urls++
+		id := b.urls
+		b.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"uploadUrl": fmt.Sprintf("http://%s/upload/%d", r.Host, id), "authorizationToken": "upload"})
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		b.mu.Lock()
+		if b.busy[r.URL.Path] {
+			b.sharedURL = true
+		}
+		b.busy[r.URL.Path] = true
+		b.mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		name, _ := url.PathUnescape(r.Header.Get("X-Bz-File-Name"))
+		want := r.Header.Get("X-Bz-Content-Sha1")
+		if want == "hex_digits_at_end" && len(body) >= 40 {
+			body, want = body[:len(body)-40], string(body[len(body)-40:])
+		}
+
+		b.mu.Lock()
+		b.busy[r.URL.Path] = false
+		if r.Header.Get("X-Bz-Content-Sha1") == "hex_digits_at_end" {
+			b.trailing++
+		}
+		if sum := sha1.Sum(body); hex.EncodeToString(sum[:]) != want {
+			b.mu.Unlock()
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"status": 400, "code": "bad_request", "message": "sha1 mismatch"})
+			return
+		}
+		b.files[name] = want
+		b.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"fileName": name})
+	case endpoint == "b2_list_file_names":
+		var request struct{ Prefix string }
+		json.NewDecoder(r.Body).Decode(&request)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		var files []map[string]any
+		for name, sum := range b.files {
+			if strings.HasPrefix(name, request.Prefix) {
+				files = append(files, map[string]any{"fileId": name, "fileName": name, "action": "upload", "contentLength": 5, "contentSha1": sum})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"files": files})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestB2Upload(t *testing.T) {
+	fake := &fakeB2{files: make(map[string]string), busy: make(map[string]bool)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	ctx := context.Background()
+	cfg := &config.B2Config{KeyID: "key", ApplicationKey: "secret", Bucket: "backups", Prefix: "docs"}
+	provider, err := newB2Provider(ctx, cfg, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	dir := t.TempDir()
+	var wg gosync.WaitGroup
+	for i := range 8 {
+		localPath := filepath.Join(dir, fmt.Sprintf("file %d.txt", i))
+		os.WriteFile(localPath, []byte(fmt.Sprintf("data%d", i)), 0644)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := provider.Upload(ctx, scanner.FileInfo{AbsolutePath: localPath}, filepath.Base(localPath)); err != nil {
+				t.Errorf("Failed to upload: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fake.sharedURL {
+		t.Error("Expected concurrent uploads to use separate upload URLs")
+	}
+	// Without a scanned SHA1 each upload sends the one taken while sending
+	if fake.trailing != 8 {
+		t.Errorf("Expected 8 uploads with the SHA1 after the data, got %d", fake.trailing)
+	}
+
+	info, err := provider.GetFileInfo(ctx, "file 3.txt")
+	if err != nil {
+		t.Fatalf("Failed to get file info: %v", err)
+	}
+	if sum := sha1.Sum([]byte("data3")); info.SHA1Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected SHA1 %q", info.SHA1Hash)
+	}
+
+	// Without a configured hash_algorithm files are compared by SHA1
+	manager := NewManager(&config.Config{})
+	if algo := manager.hashAlgorithm(provider); algo != "sha1" {
+		t.Errorf("Expected sha1, got %s", algo)
+	}
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	gosync "sync"
+	"sync/atomic"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// ErrBudgetExhausted is returned for API requests beyond api_call_budget
+var ErrBudgetExhausted = errors.New("API budget exhausted")
+
+// apiBudget counts provider API requests against an optional per-run limit
+type apiBudget struct {
+	limit atomic.Int64 // 0 = unlimited
+	used  atomic.Int64
+
+	mu    gosync.Mutex
+	calls map[string]*atomic.Int64 // Requests of the run, by provider
+}
+
+// reset starts a new run with the given limit
+func (b *apiBudget) reset(limit int) {
+	b.limit.Store(int64(limit))
+	b.used.Store(0)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, calls := range b.calls {
+		calls.Store(0)
+	}
+}
+
+// counter returns the request count of the named provider
+func (b *apiBudget) counter(name string) *atomic.Int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.calls == nil {
+		b.calls = make(map[string]*atomic.Int64)
+	}
+	calls, ok := b.calls[name]
+	if !ok {
+		calls = new(atomic.Int64)
+		b.calls[name] = calls
+	}
+	return calls
+}
+
+// take accounts for one request, failing once the limit is reached
+func (b *apiBudget) take() error {
+	used := b.used.Add(1)
+	if limit := b.limit.Load(); limit > 0 && used > limit {
+		b.used.Add(-1)
+		return fmt.Errorf("%w after %d calls", ErrBudgetExhausted, limit)
+	}
+	return nil
+}
+
+// budgetTransport counts every HTTP request a provider makes, against the
+// budget all providers share and in the provider's own count
+type budgetTransport struct {
+	base   http.RoundTripper
+	budget *apiBudget
+	calls  *atomic.Int64
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.budget.take(); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	t.calls.Add(1)
+	return t.base.RoundTrip(req)
+}
+
+// transport returns the HTTP transport the named provider uses when the
+// manager creates it
+func (m *Manager) transport(name string) http.RoundTripper {
+	base := &userAgentTransport{base: http.DefaultTransport, agent: m.userAgent()}
+	return &budgetTransport{base: base, budget: &m.budget, calls: m.budget.counter(name)}
+}
+
+// APICalls returns the number of provider API requests made by the current
+// or most recent sync, to all providers together
+func (m *Manager) APICalls() int64 {
+	return m.budget.used.Load()
+}
+
+// providerAPICalls returns the number of API requests the named provider
+// made during the current or most recent sync
+func (m *Manager) providerAPICalls(name string) int64 {
+	return m.budget.counter(name).Load()
+}
+
+// budgetStop explains a run cut short by the API budget. Completed files are
+// already in the sync state, so the next run picks up the rest.
+func (r *syncRun) budgetStop(err error, total int) error {
+	if !errors.Is(err, ErrBudgetExhausted) {
+		return err
+	}
+	remaining := total - int(r.processed.Load())
+	utils.LogError("[%s] API budget exhausted, %d files remaining", r.tag, remaining)
+	return fmt.Errorf("%w, %d files remaining", err, remaining)
+}
@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// clockProbeName is the remote file used to measure clock skew
+const clockProbeName = ".csync-clock-probe"
+
+// minClockSkew is the smallest skew worth correcting; anything below is
+// within the timestamp precision of the providers
+const minClockSkew = 2 * time.Second
+
+// remoteTimeLayouts are the timestamp formats providers report
+var remoteTimeLayouts = []string{
+	time.RFC3339Nano, // Google Drive
+	time.RFC1123Z,    // pCloud
+	time.RFC1123,
+}
+
+// parseRemoteTime parses a provider's modified timestamp
+func parseRemoteTime(value string) (time.Time, error) {
+	for _, layout := range remoteTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp: %q", value)
+}
+
+// estimateClockSkew uploads a tiny probe file and compares the provider's
+// recorded modification time with the local clock at upload time. The
+// result is how far the provider's clock runs ahead of the local one.
+func estimateClockSkew(ctx context.Context, p Provider) (time.Duration, error) {
+	tmp, err := os.CreateTemp("", "csync-clock-probe-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create probe file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString("csync clock probe\n"); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write probe file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write probe file: %w", err)
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat probe file: %w", err)
+	}
+
+	probe := scanner.FileInfo{
+		Path:         clockProbeName,
+		AbsolutePath: tmp.Name(),
+		Size:         info.Size(),
+		ModTime:      info.ModTime(),
+		Mode:         info.Mode().Perm(),
+	}
+
+	start := time.Now()
+	if err := p.Upload(ctx, probe, clockProbeName); err != nil {
+		return 0, fmt.Errorf("failed to upload probe file: %w", err)
+	}
+	// The server stamps the file somewhere within the request
+	local := start.Add(time.Since(start) / 2)
+
+	defer func() {
+		if err := p.Delete(ctx, clockProbeName); err != nil {
+			utils.LogVerbose("Failed to delete clock probe: %v", err)
+		}
+	}()
+
+	remote, err := p.GetFileInfo(ctx, clockProbeName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read probe file info: %w", err)
+	}
+
+	remoteTime, err := parseRemoteTime(remote.Modified)
+	if err != nil {
+		return 0, err
+	}
+
+	skew := remoteTime.Sub(local)
+	if skew.Abs() < minClockSkew {
+		return 0, nil
+	}
+	return skew, nil
+}
@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// shiftedClock stamps uploads with a clock running shift ahead of the local
+// one; any call besides the probe's upload, lookup and delete panics
+type shiftedClock struct {
+	Provider
+	shift    time.Duration
+	modified map[string]time.Time
+	deleted  []string
+}
+
+func (p *shiftedClock) Name() string { return "Test" }
+
+func (p *shiftedClock) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	p.modified[remotePath] = time.Now().Add(p.shift)
+	return nil
+}
+
+func (p *shiftedClock) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	modified, ok := p.modified[remotePath]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &RemoteFileInfo{Path: remotePath, Modified: modified.Format(time.RFC3339Nano)}, nil
+}
+
+func (p *shiftedClock) Delete(ctx context.Context, remotePath string) error {
+	p.deleted = append(p.deleted, remotePath)
+	return nil
+}
+
+func TestEstimateClockSkew(t *testing.T) {
+	tests := []struct {
+		name  string
+		shift time.Duration
+		want  time.Duration // Zero when the skew is below minClockSkew
+	}{
+		{"in sync", 0, 0},
+		{"slightly ahead", 1500 * time.Millisecond, 0},
+		{"slightly behind", -1500 * time.Millisecond, 0},
+		{"ahead", 5 * time.Second, 5 * time.Second},
+		{"behind", -time.Hour, -time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &shiftedClock{shift: tt.shift, modified: make(map[string]time.Time)}
+			skew, err := estimateClockSkew(context.Background(), provider)
+			if err != nil {
+				t.Fatalf("Failed to estimate clock skew: %v", err)
+			}
+			if tt.want == 0 && skew != 0 {
+				t.Errorf("Expected no skew, got %s", skew)
+			}
+			if diff := (skew - tt.want).Abs(); tt.want != 0 && diff > 100*time.Millisecond {
+				t.Errorf("Expected a skew of about %s, got %s", tt.want, skew)
+			}
+			if len(provider.deleted) != 1 || provider.deleted[0] != clockProbeName {
+				t.Errorf("Expected the probe to be deleted, got %v", provider.deleted)
+			}
+		})
+	}
+}
+
+func TestRemoteDiffersWithClockSkew(t *testing.T) {
+	// The provider's clock runs an hour behind: a copy uploaded a minute
+	// after the local change carries a timestamp 59 minutes before it
+	local := time.Now()
+	remote := &RemoteFileInfo{Path: "a.txt", Size: 4, Modified: local.Add(time.Minute - time.Hour).Format(time.RFC3339Nano)}
+	file := scanner.FileInfo{Path: "a.txt", Size: 4, ModTime: local}
+
+	if !remoteDiffers(&syncRun{}, file, remote) {
+		t.Error("Expected the copy to look out of date without skew correction")
+	}
+	run := &syncRun{clockSkew: -time.Hour}
+	if remoteDiffers(run, file, remote) {
+		t.Error("Expected the copy to be current once corrected for skew")
+	}
+	file.ModTime = local.Add(2 * time.Minute)
+	if !remoteDiffers(run, file, remote) {
+		t.Error("Expected a later local change to differ despite the skew")
+	}
+}
@@ -0,0 +1,274 @@
+package sync
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// compressionExtensions are the suffixes compressed files are stored with
+var compressionExtensions = map[string]string{
+	config.CompressionGzip: ".gz",
+	config.CompressionZstd: ".zst",
+}
+
+// precompressedExtensions are file types whose content is already
+// compressed, so compressing them again would only cost time
+var precompressedExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".avi": true, ".webm": true, ".m4v": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".jar": true, ".apk": true,
+	".enc": true,
+}
+
+// compressedProvider wraps a provider so compressible files are compressed
+// before upload and decompressed on download. Compressed files are stored
+// with the algorithm's extension.
+type compressedProvider struct {
+	Provider
+	algorithm string
+	extension string
+}
+
+// newCompressedProvider wraps p to compress with algorithm (gzip or zstd)
+func newCompressedProvider(p Provider, algorithm string) *compressedProvider {
+	return &compressedProvider{Provider: p, algorithm: algorithm, extension: compressionExtensions[algorithm]}
+}
+
+// storedName returns the remote name a file is stored under. Files with the
+// algorithm's own extension are compressed again so every stored name with
+// that extension is known to be compressed.
+func (p *compressedProvider) storedName(remotePath string) string {
+	ext := strings.ToLower(path.Ext(remotePath))
+	if precompressedExtensions[ext] && ext != p.extension {
+		return remotePath
+	}
+	return remotePath + p.extension
+}
+
+// Capabilities hides the remote hashes, which are of the compressed content,
+// and ranged downloads, which can't resume a decompressed file
+func (p *compressedProvider) Capabilities() ProviderCapabilities {
+	caps := p.Provider.Capabilities()
+	caps.Hashes = nil
+	caps.RangedDownload = false
+	return caps
+}
+
+// Upload compresses the file into a temporary file and uploads that instead
+func (p *compressedProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	stored := p.storedName(remotePath)
+	if stored == remotePath {
+		return p.Provider.Upload(ctx, file, remotePath)
+	}
+
+	tmp, err := os.CreateTemp("", "csync-*"+p.extension)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	src, err := os.Open(file.AbsolutePath)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	err = p.compress(tmp, src)
+	src.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to compress %s: %w", file.Path, err)
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to stat compressed file: %w", err)
+	}
+	if file.Size > 0 {
+		utils.LogVerbose("Compressed %s with %s: %d → %d bytes (%.0f%%)", file.Path, p.algorithm, file.Size, info.Size(), 100*float64(info.Size())/float64(file.Size))
+	}
+
+	compressed := file
+	compressed.AbsolutePath = tmp.Name()
+	compressed.Size = info.Size()
+	compressed.MD5Hash, compressed.Checksum, compressed.HashAlgorithm = "", "", ""
+	return p.Provider.Upload(ctx, compressed, stored)
+}
+
+// compress writes src to dst compressed with the provider's algorithm
+func (p *compressedProvider) compress(dst io.Writer, src io.Reader) error {
+	var w io.WriteCloser
+	if p.algorithm == config.CompressionZstd {
+		enc, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		w = enc
+	} else {
+		w = gzip.NewWriter(dst)
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// decompress writes the decompressed content of src to dst
+func (p *compressedProvider) decompress(dst io.Writer, src io.Reader) error {
+	var r io.Reader
+	if p.algorithm == config.CompressionZstd {
+		dec, err := zstd.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+		r = dec
+	} else {
+		dec, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer dec.Close()
+		r = dec
+	}
+	_, err := io.Copy(dst, r)
+	return err
+}
+
+// Download downloads a compressed file next to localPath and decompresses it
+func (p *compressedProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	stored := p.storedName(remotePath)
+	if stored == remotePath {
+		return p.Provider.Download(ctx, remotePath, localPath)
+	}
+
+	tmpPath := localPath + p.extension
+	defer os.Remove(tmpPath)
+	if err := p.Provider.Download(ctx, stored, tmpPath); err != nil {
+		return err
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open download: %w", err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	err = p.decompress(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(localPath)
+		return fmt.Errorf("failed to decompress %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// DownloadRange is not supported: compressed bytes can't be appended to a
+// partially decompressed file
+func (p *compressedProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureRangedDownload}
+}
+
+// FileExists checks for the stored file
+func (p *compressedProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	return p.Provider.FileExists(ctx, p.storedName(remotePath))
+}
+
+// GetFileInfo describes the stored file under its original name, falling
+// back to a folder of the same name
+func (p *compressedProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	stored := p.storedName(remotePath)
+	info, err := p.Provider.GetFileInfo(ctx, stored)
+	if err != nil {
+		if stored == remotePath {
+			return nil, err
+		}
+		if folder, folderErr := p.Provider.GetFileInfo(ctx, remotePath); folderErr == nil && folder.IsDir {
+			return folder, nil
+		}
+		return nil, err
+	}
+	original := p.originalInfo(*info)
+	return &original, nil
+}
+
+// Delete deletes the stored file, or a folder when there's none
+func (p *compressedProvider) Delete(ctx context.Context, remotePath string) error {
+	stored := p.storedName(remotePath)
+	err := p.Provider.Delete(ctx, stored)
+	if err != nil && stored != remotePath && p.Provider.Delete(ctx, remotePath) == nil {
+		return nil
+	}
+	return err
+}
+
+// Copy copies a stored file
+func (p *compressedProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.Provider.Copy(ctx, p.storedName(srcRemotePath), p.storedName(dstRemotePath))
+}
+
+// Move moves a stored file
+func (p *compressedProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.Provider.Move(ctx, p.storedName(srcRemotePath), p.storedName(dstRemotePath))
+}
+
+// PublicLink links the stored file
+func (p *compressedProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return p.Provider.PublicLink(ctx, p.storedName(remotePath))
+}
+
+// UpdateMetadata updates the metadata of the stored file
+func (p *compressedProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.Provider.UpdateMetadata(ctx, file, p.storedName(remotePath))
+}
+
+// List lists compressed files under their original names
+func (p *compressedProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	listing, err := p.Provider.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range listing {
+		if !info.IsDir {
+			listing[i] = p.originalInfo(info)
+		}
+	}
+	return listing, nil
+}
+
+// originalInfo describes a stored file under its original name. The
+// original size of a compressed file is unknown.
+func (p *compressedProvider) originalInfo(info RemoteFileInfo) RemoteFileInfo {
+	if !strings.HasSuffix(info.Path, p.extension) {
+		return info
+	}
+	info.Path = strings.TrimSuffix(info.Path, p.extension)
+	info.Size = SizeUnknown
+	info.MD5Hash, info.SHA1Hash, info.SHA256Hash = "", "", ""
+	return info
+}
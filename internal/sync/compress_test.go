@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+func TestCompressedProvider(t *testing.T) {
+	files := map[string]string{
+		"notes.txt":   strings.Repeat("compress me ", 1000),
+		"photo.jpg":   "not really a jpeg",
+		"archive.gz":  "not really gzipped",
+		"docs/readme": "nested",
+	}
+
+	earlier := time.Now().Add(-time.Hour)
+	for _, algorithm := range []string{config.CompressionGzip, config.CompressionZstd} {
+		server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+		defer server.Close()
+
+		ctx := context.Background()
+		cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+		cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{SkipExisting: true}}
+		plain, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		manager := NewManager(cfg)
+		manager.providers["webdav"] = newCompressedProvider(plain, algorithm)
+
+		source := t.TempDir()
+		for name, content := range files {
+			localPath := filepath.Join(source, filepath.FromSlash(name))
+			os.MkdirAll(filepath.Dir(localPath), 0755)
+			os.WriteFile(localPath, []byte(content), 0644)
+			os.Chtimes(localPath, earlier, earlier)
+		}
+		if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+			t.Fatalf("%s: failed to sync: %v", algorithm, err)
+		}
+
+		ext := compressionExtensions[algorithm]
+		listing, _ := plain.List(ctx, "")
+		var stored []string
+		for _, info := range listing {
+			if !info.IsDir {
+				stored = append(stored, info.Path)
+				if info.Path == "notes.txt"+ext && info.Size >= int64(len(files["notes.txt"])) {
+					t.Errorf("%s: expected notes.txt to shrink, got %d bytes", algorithm, info.Size)
+				}
+			}
+		}
+		sort.Strings(stored)
+		// gzip files are compressed again so every .gz name is known to be compressed
+		want := map[string]string{
+			config.CompressionGzip: "archive.gz.gz,docs/readme.gz,notes.txt.gz,photo.jpg",
+			config.CompressionZstd: "archive.gz,docs/readme.zst,notes.txt.zst,photo.jpg",
+		}[algorithm]
+		if strings.Join(stored, ",") != want {
+			t.Errorf("%s: expected %s stored, got %v", algorithm, want, stored)
+		}
+
+		// Unknown remote sizes don't make unchanged files look changed
+		if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+			t.Fatalf("%s: failed to sync: %v", algorithm, err)
+		}
+		if uploaded := manager.LastSummary().Uploaded; uploaded != 0 {
+			t.Errorf("%s: expected nothing to upload again, got %d", algorithm, uploaded)
+		}
+
+		dest := t.TempDir()
+		if err := manager.Restore(ctx, "webdav", dest); err != nil {
+			t.Fatalf("%s: failed to restore: %v", algorithm, err)
+		}
+		for name, content := range files {
+			if data, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); string(data) != content {
+				t.Errorf("%s: %s restored as %q", algorithm, name, data)
+			}
+		}
+	}
+}
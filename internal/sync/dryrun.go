@@ -0,0 +1,147 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// DryRunAction is what a sync would do with a file
+type DryRunAction string
+
+const (
+	DryRunNew       DryRunAction = "NEW"       // No remote copy yet
+	DryRunChanged   DryRunAction = "CHANGED"   // Remote copy differs in hash or size
+	DryRunUnchanged DryRunAction = "UNCHANGED" // Remote copy is up to date
+	DryRunDelete    DryRunAction = "DELETE"    // Remote file no longer exists locally
+)
+
+// DryRunEntry is one file in a dry run
+type DryRunEntry struct {
+	Action     DryRunAction `json:"action"`
+	RemotePath string       `json:"remote_path"`
+	Size       int64        `json:"size"`
+	IsDir      bool         `json:"is_dir,omitempty"`
+}
+
+// DryRunReport lists what the most recent dry run found, sorted by path
+type DryRunReport struct {
+	Provider string        `json:"provider"`
+	Entries  []DryRunEntry `json:"entries"`
+}
+
+// Count returns how many entries have action and their total size
+func (r DryRunReport) Count(action DryRunAction) (int, int64) {
+	count, bytes := 0, int64(0)
+	for _, entry := range r.Entries {
+		if entry.Action == action {
+			count++
+			bytes += entry.Size
+		}
+	}
+	return count, bytes
+}
+
+// LastDryRun returns the report of the most recent dry run to a single
+// provider; dry runs to all of them leave it as it was
+func (m *Manager) LastDryRun() DryRunReport {
+	return m.dryRun
+}
+
+// previewSync classifies files against their remote copies as new, changed
+// or unchanged and, when deleting removed files, finds the remote files a
+// sync would delete. Nothing is changed.
+func (m *Manager) previewSync(ctx context.Context, run *syncRun, files []scanner.FileInfo) (DryRunReport, error) {
+	report := DryRunReport{Provider: run.provider.Name()}
+
+	var uploads []scanner.FileInfo
+	for _, file := range files {
+		if !file.IsDir {
+			uploads = append(uploads, file)
+		}
+	}
+
+	entries := make([]DryRunEntry, len(uploads))
+	indexes := make([]int, len(uploads))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	err := runPool(ctx, m.config.GetMetadataConcurrency(), indexes, func(ctx context.Context, i int) error {
+		file := uploads[i]
+		remotePath := m.RemotePathFor(file)
+		entry := DryRunEntry{Action: DryRunUnchanged, RemotePath: remotePath, Size: file.Size}
+		remote, err := run.provider.GetFileInfo(ctx, remotePath)
+		switch {
+		case err != nil:
+			utils.LogDebug("previewSync: no remote copy of %s: %v", remotePath, err)
+			entry.Action = DryRunNew
+		case remoteDiffers(run, file, remote):
+			entry.Action = DryRunChanged
+		}
+		entries[i] = entry
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Entries = entries
+
+	if run.advanced.DeleteRemoved && !run.partial && len(uploads) > 0 {
+		remote, err := run.provider.List(ctx, "")
+		if err != nil {
+			return report, fmt.Errorf("failed to list remote files: %w", err)
+		}
+		keep := m.newRemoteKeepSet(run, files)
+
+		// Shallowest first, so a deleted folder's contents aren't listed
+		sort.Slice(remote, func(i, j int) bool {
+			return strings.Count(remote[i].Path, "/") < strings.Count(remote[j].Path, "/")
+		})
+		deletedFolders := make(map[string]bool)
+		for _, r := range remote {
+			if keep.keeps(r.Path) || insideDeletedFolder(r.Path, deletedFolders) {
+				continue
+			}
+			report.Entries = append(report.Entries, DryRunEntry{Action: DryRunDelete, RemotePath: r.Path, Size: max(r.Size, 0), IsDir: r.IsDir})
+			if r.IsDir {
+				deletedFolders[r.Path] = true
+			}
+		}
+	}
+
+	sort.Slice(report.Entries, func(i, j int) bool {
+		return report.Entries[i].RemotePath < report.Entries[j].RemotePath
+	})
+	return report, nil
+}
+
+// logDryRun prints each entry of a dry run and a summary of the counts.
+// Unchanged files are only listed in verbose mode.
+func logDryRun(tag string, report DryRunReport, skipExisting bool) {
+	for _, entry := range report.Entries {
+		line := fmt.Sprintf("[DRY RUN] %-9s %s (%s)", entry.Action, entry.RemotePath, utils.FormatBytes(entry.Size))
+		if entry.IsDir {
+			line = fmt.Sprintf("[DRY RUN] %-9s %s/", entry.Action, entry.RemotePath)
+		}
+		if entry.Action == DryRunUnchanged {
+			utils.LogVerbose("%s", line)
+		} else {
+			utils.LogInfo("%s", line)
+		}
+	}
+
+	newCount, newBytes := report.Count(DryRunNew)
+	changedCount, changedBytes := report.Count(DryRunChanged)
+	unchangedCount, unchangedBytes := report.Count(DryRunUnchanged)
+	deleteCount, deleteBytes := report.Count(DryRunDelete)
+	utils.LogInfo("[DRY RUN] [%s] %d new (%s), %d changed (%s), %d unchanged (%s), %d to delete (%s)", tag,
+		newCount, utils.FormatBytes(newBytes), changedCount, utils.FormatBytes(changedBytes),
+		unchangedCount, utils.FormatBytes(unchangedBytes), deleteCount, utils.FormatBytes(deleteBytes))
+	if !skipExisting && unchangedCount > 0 {
+		utils.LogInfo("[DRY RUN] [%s] skip_existing is off, so unchanged files would be uploaded again", tag)
+	}
+}
@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// listingProvider serves GetFileInfo and List from a fixed remote listing;
+// any other call panics
+type listingProvider struct {
+	Provider
+	remote []RemoteFileInfo
+}
+
+func (p *listingProvider) Name() string { return "Test" }
+
+func (p *listingProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{Hashes: []HashAlgorithm{HashMD5}}
+}
+
+func (p *listingProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	for _, r := range p.remote {
+		if r.Path == remotePath {
+			return &r, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (p *listingProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	return p.remote, nil
+}
+
+func TestPreviewSync(t *testing.T) {
+	provider := &listingProvider{remote: []RemoteFileInfo{
+		{Path: "docs", IsDir: true},
+		{Path: "docs/same.txt", Size: 4, MD5Hash: "aaaa"},
+		{Path: "docs/edited.txt", Size: 4, MD5Hash: "bbbb"},
+		{Path: "old", IsDir: true},
+		{Path: "old/gone.txt", Size: 9},
+		{Path: "removed.txt", Size: 7},
+	}}
+	files := []scanner.FileInfo{
+		{Path: "docs", IsDir: true},
+		{Path: "docs/same.txt", Size: 4, Checksum: "aaaa", HashAlgorithm: scanner.HashMD5, ModTime: time.Now()},
+		{Path: "docs/edited.txt", Size: 4, Checksum: "cccc", HashAlgorithm: scanner.HashMD5},
+		{Path: "new.txt", Size: 10},
+	}
+
+	m := NewManager(config.DefaultConfig())
+	run := &syncRun{provider: provider, advanced: config.AdvancedConfig{DeleteRemoved: true}}
+	report, err := m.previewSync(context.Background(), run, files)
+	if err != nil {
+		t.Fatalf("previewSync failed: %v", err)
+	}
+
+	actions := make(map[string]DryRunAction)
+	for _, entry := range report.Entries {
+		actions[entry.RemotePath] = entry.Action
+	}
+	expected := map[string]DryRunAction{
+		"docs/same.txt":   DryRunUnchanged,
+		"docs/edited.txt": DryRunChanged,
+		"new.txt":         DryRunNew,
+		"old":             DryRunDelete,
+		"removed.txt":     DryRunDelete,
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %v, got %v", expected, actions)
+	}
+
+	if count, bytes := report.Count(DryRunDelete); count != 2 || bytes != 7 {
+		t.Errorf("Expected 2 deletes of 7 bytes, got %d of %d", count, bytes)
+	}
+}
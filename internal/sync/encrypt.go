@@ -0,0 +1,388 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// encryptedSuffix is appended to the remote name of every encrypted file
+const encryptedSuffix = ".enc"
+
+// Encrypted files start with a header holding everything needed to decrypt
+// them besides the passphrase:
+//
+//	magic (4) | version (1) | scrypt log2 N, r, p (3) | salt (16) | nonce prefix (7)
+//
+// The content follows in chunks of encryptedChunkSize bytes, each sealed
+// with AES-256-GCM under a nonce of the prefix, the chunk's index and a flag
+// marking the last chunk, so chunks can't be reordered or cut off.
+const (
+	encryptedMagic      = "CSEN"
+	encryptedVersion    = 1
+	encryptedSaltSize   = 16
+	encryptedPrefixSize = 7
+	encryptedHeaderSize = 4 + 1 + 3 + encryptedSaltSize + encryptedPrefixSize
+	encryptedChunkSize  = 64 << 10
+	encryptedTagSize    = 16
+
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+// kdfParams are the scrypt parameters and salt a file's key was derived with
+type kdfParams struct {
+	logN, r, p byte
+	salt       [encryptedSaltSize]byte
+}
+
+// encryptedProvider wraps a provider so file contents are encrypted before
+// they leave the machine and decrypted when downloaded. Files are stored
+// with an .enc suffix; folder names stay readable.
+type encryptedProvider struct {
+	Provider
+	passphrase string
+	params     kdfParams // Used for every file uploaded by this process
+
+	mu   gosync.Mutex
+	keys map[kdfParams][]byte
+}
+
+// newEncryptedProvider wraps p to encrypt with a key derived from passphrase
+func newEncryptedProvider(p Provider, passphrase string) (*encryptedProvider, error) {
+	params := kdfParams{logN: scryptLogN, r: scryptR, p: scryptP}
+	if _, err := rand.Read(params.salt[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return &encryptedProvider{Provider: p, passphrase: passphrase, params: params, keys: make(map[kdfParams][]byte)}, nil
+}
+
+// key returns the key for params, deriving it once per salt
+func (p *encryptedProvider) key(params kdfParams) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[params]; ok {
+		return key, nil
+	}
+	if params.logN < 10 || params.logN > 30 {
+		return nil, fmt.Errorf("unsupported scrypt cost 2^%d", params.logN)
+	}
+	key, err := scrypt.Key([]byte(p.passphrase), params.salt[:], 1<<params.logN, int(params.r), int(params.p), 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	p.keys[params] = key
+	return key, nil
+}
+
+// Capabilities hides the remote hashes, which are of the encrypted content,
+// and the features that would expose or splice ciphertext
+func (p *encryptedProvider) Capabilities() ProviderCapabilities {
+	caps := p.Provider.Capabilities()
+	caps.Hashes = nil
+	caps.PublicLinks = false
+	caps.RangedDownload = false
+	return caps
+}
+
+// Upload encrypts the file into a temporary file and uploads that instead
+func (p *encryptedProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	key, err := p.key(p.params)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "csync-*"+encryptedSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	src, err := os.Open(file.AbsolutePath)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	err = encryptStream(tmp, src, key, p.params)
+	src.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s: %w", file.Path, err)
+	}
+
+	encrypted := file
+	encrypted.AbsolutePath = tmp.Name()
+	encrypted.Size = encryptedSize(file.Size)
+	encrypted.MD5Hash, encrypted.Checksum, encrypted.HashAlgorithm = "", "", ""
+	return p.Provider.Upload(ctx, encrypted, remotePath+encryptedSuffix)
+}
+
+// Download downloads the encrypted file next to localPath and decrypts it
+func (p *encryptedProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	tmpPath := localPath + encryptedSuffix
+	defer os.Remove(tmpPath)
+	if err := p.Provider.Download(ctx, remotePath+encryptedSuffix, tmpPath); err != nil {
+		return err
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open download: %w", err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+	dst, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	err = decryptStream(dst, src, p.key)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(localPath)
+		return fmt.Errorf("failed to decrypt %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// DownloadRange is not supported: ciphertext chunks can't be appended to a
+// partially decrypted file
+func (p *encryptedProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureRangedDownload}
+}
+
+// FileExists checks for the encrypted file
+func (p *encryptedProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	return p.Provider.FileExists(ctx, remotePath+encryptedSuffix)
+}
+
+// GetFileInfo describes the encrypted file as its plaintext, falling back
+// to a folder of the same name
+func (p *encryptedProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	info, err := p.Provider.GetFileInfo(ctx, remotePath+encryptedSuffix)
+	if err != nil {
+		if folder, folderErr := p.Provider.GetFileInfo(ctx, remotePath); folderErr == nil && folder.IsDir {
+			return folder, nil
+		}
+		return nil, err
+	}
+	plain := decryptedInfo(*info)
+	return &plain, nil
+}
+
+// Delete deletes the encrypted file, or a folder or unencrypted file when
+// there's none
+func (p *encryptedProvider) Delete(ctx context.Context, remotePath string) error {
+	err := p.Provider.Delete(ctx, remotePath+encryptedSuffix)
+	if err != nil && p.Provider.Delete(ctx, remotePath) == nil {
+		return nil
+	}
+	return err
+}
+
+// Copy copies an encrypted file
+func (p *encryptedProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.Provider.Copy(ctx, srcRemotePath+encryptedSuffix, dstRemotePath+encryptedSuffix)
+}
+
+// Move moves an encrypted file
+func (p *encryptedProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.Provider.Move(ctx, srcRemotePath+encryptedSuffix, dstRemotePath+encryptedSuffix)
+}
+
+// PublicLink links the encrypted file, which is only useful with the passphrase
+func (p *encryptedProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return p.Provider.PublicLink(ctx, remotePath+encryptedSuffix)
+}
+
+// UpdateMetadata updates the metadata of the encrypted file
+func (p *encryptedProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.Provider.UpdateMetadata(ctx, file, remotePath+encryptedSuffix)
+}
+
+// List lists encrypted files under their plaintext names and sizes. Files
+// without the suffix are listed as they are.
+func (p *encryptedProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	listing, err := p.Provider.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range listing {
+		if !info.IsDir && strings.HasSuffix(info.Path, encryptedSuffix) {
+			listing[i] = decryptedInfo(info)
+		}
+	}
+	return listing, nil
+}
+
+// decryptedInfo describes an encrypted remote file as its plaintext
+func decryptedInfo(info RemoteFileInfo) RemoteFileInfo {
+	info.Path = strings.TrimSuffix(info.Path, encryptedSuffix)
+	info.Size = decryptedSize(info.Size)
+	info.MD5Hash, info.SHA1Hash, info.SHA256Hash = "", "", ""
+	return info
+}
+
+// encryptedSize returns the size of a file of size plaintext bytes once encrypted
+func encryptedSize(size int64) int64 {
+	chunks := (size + encryptedChunkSize - 1) / encryptedChunkSize
+	if chunks == 0 {
+		chunks = 1 // An empty file still has its final chunk
+	}
+	return encryptedHeaderSize + size + chunks*encryptedTagSize
+}
+
+// decryptedSize reverses encryptedSize
+func decryptedSize(size int64) int64 {
+	body := size - encryptedHeaderSize
+	if body <= 0 {
+		return 0
+	}
+	full, rest := body/(encryptedChunkSize+encryptedTagSize), body%(encryptedChunkSize+encryptedTagSize)
+	if rest == 0 {
+		return full * encryptedChunkSize
+	}
+	return full*encryptedChunkSize + max(rest-encryptedTagSize, 0)
+}
+
+// chunkNonce returns the nonce of the chunk at index
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptedPrefixSize:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// newGCM returns AES-256-GCM for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptStream writes the header and the encrypted content of src to dst
+func encryptStream(dst io.Writer, src io.Reader, key []byte, params kdfParams) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, encryptedHeaderSize)
+	header = append(header, encryptedMagic...)
+	header = append(header, encryptedVersion, params.logN, params.r, params.p)
+	header = append(header, params.salt[:]...)
+	prefix := make([]byte, encryptedPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header = append(header, prefix...)
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	// Read one chunk ahead to know which chunk is the last
+	buf := make([]byte, encryptedChunkSize)
+	next := make([]byte, encryptedChunkSize)
+	n, err := io.ReadFull(src, buf)
+	for index := uint32(0); ; index++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		var m int
+		if !last {
+			m, err = io.ReadFull(src, next)
+			last = m == 0 && err == io.EOF
+		}
+		if _, werr := dst.Write(aead.Seal(nil, chunkNonce(prefix, index, last), buf[:n], nil)); werr != nil {
+			return werr
+		}
+		if last {
+			return nil
+		}
+		buf, next, n = next, buf, m
+	}
+}
+
+// decryptStream reads the header from src and writes the decrypted content
+// to dst, looking up the key for the header's parameters
+func decryptStream(dst io.Writer, src io.Reader, key func(kdfParams) ([]byte, error)) error {
+	header := make([]byte, encryptedHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return fmt.Errorf("missing encryption header: %w", err)
+	}
+	if !bytes.Equal(header[:4], []byte(encryptedMagic)) {
+		return errors.New("not an encrypted file")
+	}
+	if header[4] != encryptedVersion {
+		return fmt.Errorf("unsupported encryption version %d", header[4])
+	}
+	params := kdfParams{logN: header[5], r: header[6], p: header[7]}
+	copy(params.salt[:], header[8:])
+	prefix := header[8+encryptedSaltSize:]
+
+	k, err := key(params)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(k)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, encryptedChunkSize+encryptedTagSize)
+	next := make([]byte, encryptedChunkSize+encryptedTagSize)
+	n, err := io.ReadFull(src, buf)
+	for index := uint32(0); ; index++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if n == 0 {
+			return errors.New("encrypted file is truncated")
+		}
+		last := err != nil
+		var m int
+		if !last {
+			m, err = io.ReadFull(src, next)
+			last = m == 0 && err == io.EOF
+		}
+		plain, openErr := aead.Open(nil, chunkNonce(prefix, index, last), buf[:n], nil)
+		if openErr != nil {
+			return errors.New("wrong passphrase or corrupted file")
+		}
+		if _, werr := dst.Write(plain); werr != nil {
+			return werr
+		}
+		if last {
+			return nil
+		}
+		buf, next, n = next, buf, m
+	}
+}
@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+func TestEncryptStream(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	keyFor := func(kdfParams) ([]byte, error) { return key, nil }
+	params := kdfParams{logN: scryptLogN, r: scryptR, p: scryptP}
+
+	for _, size := range []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3 * encryptedChunkSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var encrypted bytes.Buffer
+		if err := encryptStream(&encrypted, bytes.NewReader(plain), key, params); err != nil {
+			t.Fatalf("%d bytes: failed to encrypt: %v", size, err)
+		}
+		if got := int64(encrypted.Len()); got != encryptedSize(int64(size)) || decryptedSize(got) != int64(size) {
+			t.Errorf("%d bytes: encrypted to %d, expected %d", size, got, encryptedSize(int64(size)))
+		}
+
+		var decrypted bytes.Buffer
+		if err := decryptStream(&decrypted, bytes.NewReader(encrypted.Bytes()), keyFor); err != nil || !bytes.Equal(decrypted.Bytes(), plain) {
+			t.Errorf("%d bytes: round trip failed: %v", size, err)
+		}
+
+		// Dropping the last chunk must not go unnoticed
+		if size > encryptedChunkSize {
+			truncated := encrypted.Bytes()[:encryptedHeaderSize+encryptedChunkSize+encryptedTagSize]
+			if err := decryptStream(&bytes.Buffer{}, bytes.NewReader(truncated), keyFor); err == nil {
+				t.Errorf("%d bytes: expected truncation to fail", size)
+			}
+		}
+	}
+
+	var encrypted bytes.Buffer
+	encryptStream(&encrypted, bytes.NewReader([]byte("secret")), key, params)
+	wrongKey := func(kdfParams) ([]byte, error) { return bytes.Repeat([]byte{8}, 32), nil }
+	if err := decryptStream(&bytes.Buffer{}, &encrypted, wrongKey); err == nil {
+		t.Error("Expected the wrong key to fail")
+	}
+}
+
+func TestEncryptedProvider(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{EncryptionPassphrase: "correct horse"}}
+	plain, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider, err := newEncryptedProvider(plain, "correct horse")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "docs"), 0755)
+	os.WriteFile(filepath.Join(source, "docs", "notes.txt"), []byte("my private notes"), 0644)
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	// The provider only sees ciphertext under a readable folder
+	download := filepath.Join(t.TempDir(), "raw")
+	if err := plain.Download(ctx, "docs/notes.txt.enc", download); err != nil {
+		t.Fatalf("Expected the encrypted file on the remote: %v", err)
+	}
+	if raw, _ := os.ReadFile(download); bytes.Contains(raw, []byte("private")) {
+		t.Error("Expected the remote copy to be encrypted")
+	}
+
+	info, err := provider.GetFileInfo(ctx, "docs/notes.txt")
+	if err != nil || info.Size != int64(len("my private notes")) {
+		t.Errorf("Expected the plaintext size, got %+v, %v", info, err)
+	}
+
+	dest := t.TempDir()
+	if err := manager.Restore(ctx, "webdav", dest); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "docs", "notes.txt")); string(data) != "my private notes" {
+		t.Errorf("Expected the restored file to be decrypted, got %q", data)
+	}
+}
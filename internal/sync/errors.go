@@ -0,0 +1,175 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// permanentError marks an error that will fail identically on every retry
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so IsPermanent reports true for it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err is unrecoverable without user action,
+// such as revoked credentials, a deleted destination or an unsupported
+// provider. Everything else (network failures, timeouts, 5xx) is treated
+// as transient.
+func IsPermanent(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return true
+	}
+
+	var unsupported *UnsupportedError
+	if errors.As(err, &unsupported) {
+		return true
+	}
+
+	var pcloudErr *PCloudError
+	if errors.As(err, &pcloudErr) {
+		return pcloudErr.Permanent()
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusUnauthorized, http.StatusNotFound:
+			return true
+		case http.StatusForbidden:
+			// Drive also reports rate limiting as 403
+			for _, item := range apiErr.Errors {
+				if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	// S3 reports throttling as 503 SlowDown, so every 4xx here is final
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.HTTPStatusCode() {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return true
+		}
+	}
+
+	return false
+}
+
+// httpStatusError is implemented by the HTTP response errors of S3, B2,
+// OneDrive and WebDAV
+type httpStatusError interface {
+	error
+	HTTPStatusCode() int
+}
+
+// ErrorKind is a broad category of sync failure, used to group errors in
+// the end-of-run summary
+type ErrorKind string
+
+const (
+	ErrorAuth       ErrorKind = "auth"
+	ErrorPermission ErrorKind = "permission"
+	ErrorQuota      ErrorKind = "quota"
+	ErrorNetwork    ErrorKind = "network"
+	ErrorNotFound   ErrorKind = "not-found"
+	ErrorOther      ErrorKind = "other"
+)
+
+// ClassifyError returns the kind of failure err represents
+func ClassifyError(err error) ErrorKind {
+	if err == nil {
+		return ErrorOther
+	}
+
+	if errors.Is(err, fs.ErrPermission) {
+		return ErrorPermission
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrorNotFound
+	}
+
+	var pcloudErr *PCloudError
+	if errors.As(err, &pcloudErr) {
+		switch pcloudErr.Result {
+		case 1000, 2000, 2094:
+			return ErrorAuth
+		case 2003:
+			return ErrorPermission
+		case 2008:
+			return ErrorQuota
+		case 2005, 2009:
+			return ErrorNotFound
+		}
+		return ErrorOther
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusUnauthorized:
+			return ErrorAuth
+		case apiErr.Code == http.StatusNotFound:
+			return ErrorNotFound
+		case apiErr.Code == http.StatusTooManyRequests:
+			return ErrorQuota
+		case apiErr.Code == http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				switch item.Reason {
+				case "storageQuotaExceeded", "quotaExceeded", "rateLimitExceeded", "userRateLimitExceeded":
+					return ErrorQuota
+				}
+			}
+			return ErrorPermission
+		case apiErr.Code >= 500:
+			return ErrorNetwork
+		}
+		return ErrorOther
+	}
+
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.HTTPStatusCode(); {
+		case code == http.StatusUnauthorized:
+			return ErrorAuth
+		case code == http.StatusForbidden:
+			return ErrorPermission
+		case code == http.StatusNotFound:
+			return ErrorNotFound
+		case code >= 500:
+			return ErrorNetwork
+		}
+		return ErrorOther
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return ErrorNetwork
+	}
+
+	return ErrorOther
+}
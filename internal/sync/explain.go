@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// SkippedFiles returns the files filtered out or skipped by the most recent
+// sync, each with the reason it wasn't uploaded
+func (m *Manager) SkippedFiles() []scanner.SkippedFile {
+	return m.skipped
+}
+
+// Explain reports which rule, if any, keeps relPath (relative to
+// sourcePath) out of a sync with the current patterns and pattern profile.
+// The second result is false when the path would be synced.
+func (m *Manager) Explain(sourcePath, relPath string) (scanner.SkippedFile, bool, error) {
+	ignore, include, err := m.config.ResolvePatterns(m.patternProfile)
+	if err != nil {
+		return scanner.SkippedFile{}, false, err
+	}
+
+	if filepath.IsAbs(relPath) {
+		rel, err := filepath.Rel(sourcePath, relPath)
+		if err != nil {
+			return scanner.SkippedFile{}, false, fmt.Errorf("failed to get relative path: %w", err)
+		}
+		relPath = rel
+	}
+
+	scn := scanner.NewScanner(ignore, include)
+	scn.SetForceInclude(m.config.General.ForceInclude)
+	scn.SetExcludeFolders(m.config.GetAdvanced().ExcludeFolders)
+	scn.SetInProgress(m.config.GetInProgressPatterns(), m.config.GetLockSuffixes())
+	scn.SetSizeFilter(m.config.General.MinFileSize, m.config.General.MaxFileSize)
+	since, err := m.config.GetModifiedSince(time.Now())
+	if err != nil {
+		return scanner.SkippedFile{}, false, err
+	}
+	scn.SetModifiedSince(since)
+	scn.SetFollowSymlinks(m.config.General.FollowSymlinks)
+	if err := scn.SetHashAlgorithm(m.config.GetHashAlgorithm()); err != nil {
+		return scanner.SkippedFile{}, false, err
+	}
+	if err := scn.SetContentTypeFilter(m.config.General.IncludeContentTypes, m.config.General.ExcludeContentTypes); err != nil {
+		return scanner.SkippedFile{}, false, err
+	}
+
+	skipped, ok := scn.ExplainPath(sourcePath, relPath)
+	return skipped, ok, nil
+}
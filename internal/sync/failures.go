@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// FileFailure records a file that failed to sync
+type FileFailure struct {
+	Path string
+	Err  error
+}
+
+// SyncFailuresError is returned when too many files failed to sync: any at
+// all with fail_on_any_error, otherwise more than half of them
+type SyncFailuresError struct {
+	Provider string
+	Failed   int
+	Total    int
+	Failures []FileFailure
+}
+
+func (e *SyncFailuresError) Error() string {
+	return fmt.Sprintf("%s: %d of %d files failed to sync", e.Provider, e.Failed, e.Total)
+}
+
+// Unwrap returns the error of every failed file, so errors.Is and
+// errors.As see through to them
+func (e *SyncFailuresError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = fmt.Errorf("%s: %w", f.Path, f.Err)
+	}
+	return errs
+}
+
+// FailedFiles returns the files that failed during the most recent sync
+func (m *Manager) FailedFiles() []FileFailure {
+	return m.failed
+}
+
+// fail records a file that failed without aborting the run
+func (r *syncRun) fail(path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = append(r.failed, FileFailure{Path: path, Err: err})
+}
+
+// recordFailure lets a run continue past a file that failed for a transient
+// reason. Unrecoverable errors and cancellation still stop the run, and with
+// stop_on_error so does any failure.
+func (r *syncRun) recordFailure(ctx context.Context, item syncItem, err error) error {
+	if err == nil {
+		r.processed.Add(1)
+		return nil
+	}
+	// The remote copy may be half written, so check it again next run
+	if r.state != nil {
+		r.state.Delete(r.name, item.file.Path)
+	}
+	if IsPermanent(err) || errors.Is(err, ErrBudgetExhausted) || ctx.Err() != nil {
+		return err
+	}
+	r.processed.Add(1)
+	utils.LogError("[%s] ✗ %s: %v", r.tag, item.remotePath, err)
+	r.fail(item.file.Path, err)
+	if r.advanced.StopOnError {
+		return fmt.Errorf("stopping at the first failure: %s: %w", item.file.Path, err)
+	}
+	return nil
+}
+
+// failureError decides whether the run's failures fail the whole sync
+func (r *syncRun) failureError(total int, strict bool) error {
+	failed := len(r.failed)
+	if failed == 0 {
+		return nil
+	}
+	if strict || failed*2 > total {
+		return &SyncFailuresError{Provider: r.provider.Name(), Failed: failed, Total: total, Failures: r.failed}
+	}
+	return nil
+}
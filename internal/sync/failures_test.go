@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+var errBroken = errors.New("broken upload")
+
+// brokenUploads fails every upload of one file
+type brokenUploads struct {
+	Provider
+	broken string
+}
+
+func (p *brokenUploads) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	if remotePath == p.broken {
+		return errBroken
+	}
+	return p.Provider.Upload(ctx, file, remotePath)
+}
+
+func TestFileFailures(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	source := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		os.WriteFile(filepath.Join(source, name), []byte(name), 0644)
+	}
+
+	run := func(advanced config.AdvancedConfig) (*Manager, error) {
+		ctx := context.Background()
+		cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+		cfg.Optional = &config.OptionalConfig{Advanced: &advanced}
+		provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		manager := NewManager(cfg)
+		manager.providers["webdav"] = &brokenUploads{Provider: provider, broken: "b.txt"}
+		return manager, manager.SyncToWebDAV(ctx, source, false)
+	}
+
+	// One failure in three doesn't fail the sync, and the others still upload
+	manager, err := run(config.AdvancedConfig{})
+	if err != nil {
+		t.Errorf("Expected the sync to succeed, got %v", err)
+	}
+	if s := manager.LastSummary(); s.Uploaded != 2 || s.Failed != 1 {
+		t.Errorf("Expected 2 uploaded and 1 failed, got %d and %d", s.Uploaded, s.Failed)
+	}
+
+	_, err = run(config.AdvancedConfig{FailOnAnyError: true})
+	var failures *SyncFailuresError
+	if !errors.As(err, &failures) || failures.Failed != 1 || !errors.Is(err, errBroken) {
+		t.Errorf("Expected the failed file in the sync error, got %v", err)
+	}
+
+	_, err = run(config.AdvancedConfig{StopOnError: true})
+	if errors.As(err, &failures) || !errors.Is(err, errBroken) {
+		t.Errorf("Expected the sync to stop at the failed file, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	gosync "sync"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// flattenMapName is the copy of the flatten map kept in the destination
+// root, so a restore on another machine can rebuild the original tree
+const flattenMapName = ".csync-flatten-map.json"
+
+// FlattenMap records the flattened remote name of every file synced with
+// flatten_structure, so restores can rebuild the original tree. Names are
+// stable: once assigned, a path keeps its remote name across runs.
+type FlattenMap struct {
+	path   string
+	mu     gosync.Mutex
+	Files  map[string]string `json:"files"` // Original relative path -> flattened remote name
+	locals map[string]string // Flattened remote name -> original relative path
+}
+
+// LoadFlattenMap reads the flatten mapping from path. A missing file yields
+// an empty mapping.
+func LoadFlattenMap(path string) (*FlattenMap, error) {
+	fm := &FlattenMap{
+		path:   path,
+		Files:  make(map[string]string),
+		locals: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flatten map: %w", err)
+	}
+
+	if err := json.Unmarshal(data, fm); err != nil {
+		return nil, fmt.Errorf("failed to parse flatten map: %w", err)
+	}
+	if fm.Files == nil {
+		fm.Files = make(map[string]string)
+	}
+	fm.index()
+
+	return fm, nil
+}
+
+// index rebuilds the reverse mapping from Files
+func (f *FlattenMap) index() {
+	f.locals = make(map[string]string, len(f.Files))
+	for local, remote := range f.Files {
+		f.locals[remote] = local
+	}
+}
+
+// Assign gives every path without a mapping a remote name. A file keeps its
+// base name unless that collides with another file's name, compared case-
+// insensitively since several providers are; then a suffix derived from a
+// hash of its full path is added, so the result doesn't depend on scan order.
+func (f *FlattenMap) Assign(paths []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	taken := make(map[string]bool, len(f.Files))
+	for _, remote := range f.Files {
+		taken[strings.ToLower(remote)] = true
+	}
+
+	var pending []string
+	for _, p := range paths {
+		if _, ok := f.Files[p]; !ok {
+			pending = append(pending, p)
+		}
+	}
+	sort.Strings(pending)
+
+	// Names shared by several new files are suffixed for all of them
+	counts := make(map[string]int)
+	for _, p := range pending {
+		counts[strings.ToLower(path.Base(p))]++
+	}
+
+	for _, p := range pending {
+		name := path.Base(p)
+		key := strings.ToLower(name)
+		if taken[key] || counts[key] > 1 {
+			name = flattenedName(p, 8)
+			if taken[strings.ToLower(name)] {
+				name = flattenedName(p, sha1.Size*2)
+			}
+		}
+		f.Files[p] = name
+		f.locals[name] = p
+		taken[strings.ToLower(name)] = true
+	}
+}
+
+// flattenedName inserts the first n hex digits of the path's SHA-1 before
+// the extension of its base name
+func flattenedName(p string, n int) string {
+	sum := sha1.Sum([]byte(p))
+	base := path.Base(p)
+	ext := path.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-" + hex.EncodeToString(sum[:])[:n] + ext
+}
+
+// Remote returns the flattened remote name for a relative path
+func (f *FlattenMap) Remote(localRelPath string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	remote, ok := f.Files[localRelPath]
+	return remote, ok
+}
+
+// Local returns the original relative path of a flattened remote name
+func (f *FlattenMap) Local(remotePath string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	local, ok := f.locals[remotePath]
+	return local, ok
+}
+
+// Merge adds the mappings of other for paths and remote names this map
+// doesn't have yet, as when a restore fetches the destination's copy
+func (f *FlattenMap) Merge(other *FlattenMap) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for local, remote := range other.Files {
+		if _, ok := f.Files[local]; ok {
+			continue
+		}
+		if _, ok := f.locals[remote]; ok {
+			continue
+		}
+		f.Files[local] = remote
+		f.locals[remote] = local
+	}
+}
+
+// Save writes the mapping back to the file it was loaded from
+func (f *FlattenMap) Save() error {
+	f.mu.Lock()
+	data, err := json.MarshalIndent(f, "", "  ")
+	f.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal flatten map: %w", err)
+	}
+
+	if err := os.WriteFile(f.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write flatten map: %w", err)
+	}
+
+	return nil
+}
+
+// loadFlattenMap returns the flatten mapping when flatten_structure is
+// enabled and no custom PathMapper overrides it, loading it on first use
+func (m *Manager) loadFlattenMap() (*FlattenMap, error) {
+	if !m.config.GetAdvanced().FlattenStructure || m.pathMapper != nil {
+		return nil, nil
+	}
+	if m.flattenMap == nil {
+		fm, err := LoadFlattenMap(m.config.GetFlattenMapPath())
+		if err != nil {
+			return nil, err
+		}
+		m.flattenMap = fm
+	}
+	return m.flattenMap, nil
+}
+
+// uploadFlattenMap puts the saved flatten map in the destination root. A
+// failure is only a warning: the files themselves are synced regardless.
+func (m *Manager) uploadFlattenMap(ctx context.Context, run *syncRun, flatten *FlattenMap) {
+	info, err := os.Stat(flatten.path)
+	if err != nil {
+		utils.LogError("Failed to upload flatten map: %v", err)
+		run.warn(flattenMapName, err)
+		return
+	}
+	file := scanner.FileInfo{Path: flattenMapName, AbsolutePath: flatten.path, Size: info.Size(), ModTime: info.ModTime()}
+	err = run.retry(ctx, "upload "+flattenMapName, func() error {
+		return run.provider.Upload(ctx, file, flattenMapName)
+	})
+	if err != nil {
+		utils.LogError("Failed to upload flatten map: %v", err)
+		run.warn(flattenMapName, err)
+	}
+}
+
+// fetchFlattenMap fills in the flatten map from the copy in p's
+// destination, so a restore can rebuild a tree this machine never synced
+func (m *Manager) fetchFlattenMap(ctx context.Context, p Provider, flatten *FlattenMap) error {
+	tmp, err := os.MkdirTemp("", "csync-flatten-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	localPath := filepath.Join(tmp, flattenMapName)
+	if err := m.download(ctx, p, flattenMapName, localPath); err != nil {
+		return err
+	}
+	remote, err := LoadFlattenMap(localPath)
+	if err != nil {
+		return err
+	}
+	flatten.Merge(remote)
+	return flatten.Save()
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+func TestFlattenMapLocal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flatten.json")
+	fm, err := LoadFlattenMap(path)
+	if err != nil {
+		t.Fatalf("Failed to load flatten map: %v", err)
+	}
+	fm.Assign([]string{"a/notes.txt", "b/notes.txt", "c/photo.jpg"})
+	if err := fm.Save(); err != nil {
+		t.Fatalf("Failed to save flatten map: %v", err)
+	}
+
+	loaded, err := LoadFlattenMap(path)
+	if err != nil {
+		t.Fatalf("Failed to reload flatten map: %v", err)
+	}
+	for _, local := range []string{"a/notes.txt", "b/notes.txt", "c/photo.jpg"} {
+		remote, ok := loaded.Remote(local)
+		if !ok {
+			t.Fatalf("Expected a remote name for %s", local)
+		}
+		if got, ok := loaded.Local(remote); !ok || got != local {
+			t.Errorf("Expected %s to map back to %s, got %q", remote, local, got)
+		}
+	}
+	if _, ok := loaded.Local("missing.txt"); ok {
+		t.Error("Expected no local path for an unknown remote name")
+	}
+}
+
+func TestFlattenRestoreElsewhere(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	newManager := func(mapPath string) *Manager {
+		t.Helper()
+		cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+		cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+			FlattenStructure: true,
+			FlattenMapPath:   mapPath,
+		}}
+		provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		manager := NewManager(cfg)
+		manager.providers["webdav"] = provider
+		return manager
+	}
+
+	source := t.TempDir()
+	names := []string{"docs/notes.txt", "work/notes.txt", "photos/c.jpg"}
+	for _, name := range names {
+		localPath := filepath.Join(source, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, []byte(name), 0644)
+	}
+	if err := newManager(filepath.Join(t.TempDir(), "flatten.json")).SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	// Another machine has no map of its own and relies on the uploaded copy
+	manager := newManager(filepath.Join(t.TempDir(), "flatten.json"))
+	exists, err := manager.providers["webdav"].FileExists(ctx, flattenMapName)
+	if err != nil || !exists {
+		t.Fatalf("Expected the flatten map to be uploaded, got %v, %v", exists, err)
+	}
+	dest := t.TempDir()
+	if err := manager.Restore(ctx, "webdav", dest); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("Expected %s to be restored: %v", name, err)
+		} else if string(data) != name {
+			t.Errorf("%s has content %q", name, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, flattenMapName)); !os.IsNotExist(err) {
+		t.Error("Expected the flatten map not to be restored as a file")
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -16,47 +19,90 @@ import (
 
 	"github.com/svosadtsia/csync/internal/config"
 	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
 )
 
+// folderMimeType is the MIME type Google Drive uses for folders
+const folderMimeType = "application/vnd.google-apps.folder"
+
 // GoogleDriveProvider implements the Provider interface for Google Drive
 type GoogleDriveProvider struct {
-	service  *drive.Service
-	config   *config.GoogleDriveConfig
-	folderID string
+	service    *drive.Service
+	httpClient *http.Client // Authorized client, for resumable uploads
+	config     *config.GoogleDriveConfig
+	folderID   string
+
+	// Resumable uploads, enabled by setResumable
+	resumableThreshold int64
+	chunkSize          int64
+	sessions           *uploadSessions
+
+	limiter *throttle.Limiter // Shared upload rate limit, nil for none
+
+	preserveModTime bool // Give uploads the local modification time
+	verifyUploads   bool // Compare each upload's md5Checksum with the local file
 }
 
 // NewGoogleDriveProvider creates a new Google Drive provider
-func NewGoogleDriveProvider(cfg *config.GoogleDriveConfig) (*GoogleDriveProvider, error) {
-	ctx := context.Background()
+func NewGoogleDriveProvider(ctx context.Context, cfg *config.GoogleDriveConfig) (*GoogleDriveProvider, error) {
+	return newGoogleDriveProvider(ctx, cfg, nil, defaultUserAgent())
+}
 
+func init() {
+	RegisterProvider("gdrive", ProviderFactory{
+		DisplayName: "Google Drive",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := newGoogleDriveProvider(ctx, &m.config.GoogleDrive, m.transport("gdrive"), m.userAgent())
+			if err != nil {
+				// Credential and token problems won't fix themselves
+				return nil, Permanent(fmt.Errorf("failed to create Google Drive client: %w", err))
+			}
+			sessions, err := m.loadUploadSessions()
+			if err != nil {
+				return nil, err
+			}
+			client.setResumable(m.config.GetResumableThreshold(), m.config.General.ChunkSizeBytes, sessions)
+			return client, nil
+		},
+		Settings:    func(cfg *config.Config) any { return cfg.GoogleDrive },
+		Configured:  func(cfg *config.Config) bool { return cfg.GoogleDrive.CredentialsPath != "" },
+		Destination: func(cfg *config.Config) string { return cfg.GoogleDrive.DestinationPath },
+	})
+}
+
+// newGoogleDriveProvider creates a Google Drive provider whose API requests
+// go through transport (nil for the default) and identify themselves with
+// userAgent
+func newGoogleDriveProvider(ctx context.Context, cfg *config.GoogleDriveConfig, transport http.RoundTripper, userAgent string) (*GoogleDriveProvider, error) {
 	// Read credentials file
 	credentials, err := os.ReadFile(cfg.CredentialsPath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read credentials file: %w", err)
 	}
 
-	// Parse credentials
-	oauthConfig, err := google.ConfigFromJSON(credentials, cfg.Scopes...)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse client secret file: %w", err)
+	// Use default scopes if none provided
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{drive.DriveFileScope}
 	}
 
-	// Get OAuth2 client
-	client, err := getClient(oauthConfig, cfg.TokenPath)
+	client, err := googleDriveClient(cfg, credentials, scopes, transport)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get OAuth2 client: %w", err)
+		return nil, err
 	}
 
 	// Create Drive service
-	service, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	service, err := drive.NewService(ctx, option.WithHTTPClient(client), option.WithUserAgent(userAgent))
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve Drive client: %w", err)
 	}
 
 	provider := &GoogleDriveProvider{
-		service:  service,
-		config:   cfg,
-		folderID: cfg.FolderID,
+		service:    service,
+		httpClient: client,
+		config:     cfg,
+		folderID:   cfg.FolderID,
 	}
 
 	// If no folder ID specified, use root
@@ -72,8 +118,29 @@ func (p *GoogleDriveProvider) Name() string {
 	return "Google Drive"
 }
 
+// Capabilities reports the optional features Google Drive supports
+func (p *GoogleDriveProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		Hashes:         []HashAlgorithm{HashMD5, HashSHA1, HashSHA256},
+		ServerSideCopy: true,
+		PublicLinks:    true,
+		Versioning:     true,
+		AtomicRename:   true,
+		AtomicUpload:   true,
+		Metadata:       true,
+		RangedDownload: true,
+	}
+}
+
+// fullPath prefixes a remote path with the configured destination path
+func (p *GoogleDriveProvider) fullPath(remotePath string) string {
+	return path.Join(p.config.DestinationPath, filepath.ToSlash(remotePath))
+}
+
 // Upload uploads a file to Google Drive
 func (p *GoogleDriveProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	remotePath = p.fullPath(remotePath)
+
 	// Open the local file
 	localFile, err := os.Open(file.AbsolutePath)
 	if err != nil {
@@ -83,16 +150,17 @@ func (p *GoogleDriveProvider) Upload(ctx context.Context, file scanner.FileInfo,
 
 	// Prepare the drive file metadata
 	driveFile := &drive.File{
-		Name: filepath.Base(remotePath),
+		Name: path.Base(remotePath),
 	}
 
-	// Set parent folder if specified
+	// Resolve the parent folder, creating it if needed
 	parentID, err := p.ensureParentFolders(ctx, remotePath)
 	if err != nil {
 		return fmt.Errorf("failed to ensure parent folders: %w", err)
 	}
-	if parentID != "" {
-		driveFile.Parents = []string{parentID}
+
+	if p.preserveModTime {
+		driveFile.ModifiedTime = file.ModTime.UTC().Format(time.RFC3339Nano)
 	}
 
 	// Add metadata if configured
@@ -104,49 +172,73 @@ func (p *GoogleDriveProvider) Upload(ctx context.Context, file scanner.FileInfo,
 		driveFile.Properties = properties
 	}
 
-	// Check if file already exists
-	existingFileID, err := p.findFile(ctx, filepath.Base(remotePath), parentID)
+	// Check if file already exists, preferring a duplicate with the same content
+	md5Sum := ""
+	if file.HashAlgorithm == string(HashMD5) {
+		md5Sum = file.Checksum
+	}
+	existingFileID, err := p.findMatchingFile(ctx, path.Base(remotePath), parentID, md5Sum)
 	if err != nil {
 		return fmt.Errorf("failed to check existing file: %w", err)
 	}
 
+	if existingFileID == "" {
+		driveFile.Parents = []string{parentID}
+	}
+
+	// Large files go up in chunks that survive a dropped connection
+	if p.sessions != nil && file.Size > p.resumableThreshold {
+		return p.resumableUpload(ctx, file, remotePath, driveFile, existingFileID)
+	}
+
+	progress := utils.NewProgress(remotePath, file.Size)
+	defer progress.Done()
+	hash := newUploadHash(file, HashMD5, p.verifyUploads)
+	media := progress.Reader(p.limiter.Reader(ctx, hash.reader(localFile, 0)))
+	var uploaded *drive.File
 	if existingFileID != "" {
-		// Update existing file
-		_, err = p.service.Files.Update(existingFileID, driveFile).
+		// Update existing file (Parents is not writable on update)
+		uploaded, err = p.service.Files.Update(existingFileID, driveFile).
 			Context(ctx).
-			Media(localFile).
+			Media(media).
+			Fields("id", "md5Checksum").
 			Do()
 		if err != nil {
 			return fmt.Errorf("failed to update file: %w", err)
 		}
 	} else {
 		// Create new file
-		_, err = p.service.Files.Create(driveFile).
+		uploaded, err = p.service.Files.Create(driveFile).
 			Context(ctx).
-			Media(localFile).
+			Media(media).
+			Fields("id", "md5Checksum").
 			Do()
 		if err != nil {
 			return fmt.Errorf("failed to create file: %w", err)
 		}
 	}
 
+	if p.verifyUploads {
+		return verifyUpload(hash.hashed(file), remotePath, HashMD5, uploaded.Md5Checksum)
+	}
 	return nil
 }
 
 // CreateFolder creates a folder in Google Drive
 func (p *GoogleDriveProvider) CreateFolder(ctx context.Context, remotePath string) error {
+	remotePath = p.fullPath(remotePath)
 	_, err := p.ensureParentFolders(ctx, remotePath+"/dummy")
 	return err
 }
 
 // FileExists checks if a file exists in Google Drive
 func (p *GoogleDriveProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
-	parentID, err := p.getParentFolderID(ctx, remotePath)
+	parentID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
 	if err != nil {
 		return false, err
 	}
 
-	fileName := filepath.Base(remotePath)
+	fileName := path.Base(remotePath)
 	fileID, err := p.findFile(ctx, fileName, parentID)
 	if err != nil {
 		return false, err
@@ -157,12 +249,12 @@ func (p *GoogleDriveProvider) FileExists(ctx context.Context, remotePath string)
 
 // GetFileInfo retrieves information about a remote file
 func (p *GoogleDriveProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
-	parentID, err := p.getParentFolderID(ctx, remotePath)
+	parentID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
 	if err != nil {
 		return nil, err
 	}
 
-	fileName := filepath.Base(remotePath)
+	fileName := path.Base(remotePath)
 	fileID, err := p.findFile(ctx, fileName, parentID)
 	if err != nil {
 		return nil, err
@@ -174,28 +266,30 @@ func (p *GoogleDriveProvider) GetFileInfo(ctx context.Context, remotePath string
 
 	file, err := p.service.Files.Get(fileID).
 		Context(ctx).
-		Fields("id,name,size,md5Checksum,modifiedTime").
+		Fields("id,name,size,md5Checksum,sha1Checksum,sha256Checksum,modifiedTime").
 		Do()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	return &RemoteFileInfo{
-		Path:     remotePath,
-		Size:     file.Size,
-		MD5Hash:  file.Md5Checksum,
-		Modified: file.ModifiedTime,
+		Path:       remotePath,
+		Size:       file.Size,
+		MD5Hash:    file.Md5Checksum,
+		SHA1Hash:   file.Sha1Checksum,
+		SHA256Hash: file.Sha256Checksum,
+		Modified:   file.ModifiedTime,
 	}, nil
 }
 
 // Delete removes a file or folder from Google Drive
 func (p *GoogleDriveProvider) Delete(ctx context.Context, remotePath string) error {
-	parentID, err := p.getParentFolderID(ctx, remotePath)
+	parentID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
 	if err != nil {
 		return err
 	}
 
-	fileName := filepath.Base(remotePath)
+	fileName := path.Base(remotePath)
 	fileID, err := p.findFile(ctx, fileName, parentID)
 	if err != nil {
 		return err
@@ -213,15 +307,265 @@ func (p *GoogleDriveProvider) Delete(ctx context.Context, remotePath string) err
 	return nil
 }
 
+// Copy duplicates a remote file server-side, replacing any existing file at
+// the destination
+func (p *GoogleDriveProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	srcParentID, err := p.getParentFolderID(ctx, p.fullPath(srcRemotePath))
+	if err != nil {
+		return err
+	}
+
+	srcID, err := p.findFile(ctx, path.Base(srcRemotePath), srcParentID)
+	if err != nil {
+		return err
+	}
+
+	if srcID == "" {
+		return fmt.Errorf("file not found: %s", srcRemotePath)
+	}
+
+	dstFullPath := p.fullPath(dstRemotePath)
+	dstParentID, err := p.ensureParentFolders(ctx, dstFullPath)
+	if err != nil {
+		return fmt.Errorf("failed to ensure parent folders: %w", err)
+	}
+
+	// Drive allows duplicate names, so replace rather than add alongside
+	existingID, err := p.findFile(ctx, path.Base(dstFullPath), dstParentID)
+	if err != nil {
+		return fmt.Errorf("failed to check existing file: %w", err)
+	}
+	if existingID != "" {
+		if err := p.service.Files.Delete(existingID).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("failed to replace existing file: %w", err)
+		}
+	}
+
+	_, err = p.service.Files.Copy(srcID, &drive.File{
+		Name:    path.Base(dstFullPath),
+		Parents: []string{dstParentID},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	return nil
+}
+
+// Move renames a remote file, moving it to another folder if needed
+func (p *GoogleDriveProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	srcParentID, err := p.getParentFolderID(ctx, p.fullPath(srcRemotePath))
+	if err != nil {
+		return err
+	}
+
+	fileID, err := p.findFile(ctx, path.Base(srcRemotePath), srcParentID)
+	if err != nil {
+		return err
+	}
+
+	if fileID == "" {
+		return fmt.Errorf("file not found: %s", srcRemotePath)
+	}
+
+	dstFullPath := p.fullPath(dstRemotePath)
+	dstParentID, err := p.ensureParentFolders(ctx, dstFullPath)
+	if err != nil {
+		return fmt.Errorf("failed to ensure parent folders: %w", err)
+	}
+
+	call := p.service.Files.Update(fileID, &drive.File{Name: path.Base(dstFullPath)}).Context(ctx)
+	if dstParentID != srcParentID {
+		call = call.AddParents(dstParentID).RemoveParents(srcParentID)
+	}
+	if _, err := call.Do(); err != nil {
+		return fmt.Errorf("failed to move file: %w", err)
+	}
+
+	return nil
+}
+
+// PublicLink shares a remote folder ("" for the destination root) with
+// anyone who has the link and returns the link. Sharing an already shared
+// folder returns the same link.
+func (p *GoogleDriveProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	folderID, err := p.getParentFolderID(ctx, path.Join(p.fullPath(remotePath), "dummy"))
+	if err != nil {
+		return "", err
+	}
+
+	perms, err := p.service.Permissions.List(folderID).
+		Context(ctx).
+		Fields("permissions(id,type,role)").
+		Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to list permissions: %w", err)
+	}
+
+	shared := false
+	for _, perm := range perms.Permissions {
+		if perm.Type == "anyone" {
+			shared = true
+			break
+		}
+	}
+
+	if !shared {
+		_, err := p.service.Permissions.Create(folderID, &drive.Permission{
+			Type: "anyone",
+			Role: "reader",
+		}).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to share folder: %w", err)
+		}
+	}
+
+	folder, err := p.service.Files.Get(folderID).
+		Context(ctx).
+		Fields("webViewLink").
+		Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to get folder link: %w", err)
+	}
+
+	return folder.WebViewLink, nil
+}
+
+// List recursively lists files and folders below remotePath ("" for the
+// destination root). Returned paths are relative to the destination root.
+func (p *GoogleDriveProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	folderID, err := p.getParentFolderID(ctx, path.Join(p.fullPath(remotePath), "dummy"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []RemoteFileInfo
+	if err := p.listFolder(ctx, folderID, strings.Trim(remotePath, "/"), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listFolder appends the contents of a folder, recursing into subfolders
+func (p *GoogleDriveProvider) listFolder(ctx context.Context, folderID, prefix string, files *[]RemoteFileInfo) error {
+	var subfolders []RemoteFileInfo
+	var subfolderIDs []string
+
+	query := fmt.Sprintf("'%s' in parents and trashed=false", folderID)
+	err := p.service.Files.List().
+		Context(ctx).
+		Q(query).
+		PageSize(1000).
+		Fields("nextPageToken, files(id,name,mimeType,size,md5Checksum,sha1Checksum,sha256Checksum,modifiedTime)").
+		Pages(ctx, func(list *drive.FileList) error {
+			for _, f := range list.Files {
+				info := RemoteFileInfo{
+					Path:       path.Join(prefix, f.Name),
+					Size:       f.Size,
+					MD5Hash:    f.Md5Checksum,
+					SHA1Hash:   f.Sha1Checksum,
+					SHA256Hash: f.Sha256Checksum,
+					Modified:   f.ModifiedTime,
+					IsDir:      f.MimeType == folderMimeType,
+				}
+				*files = append(*files, info)
+				if info.IsDir {
+					subfolders = append(subfolders, info)
+					subfolderIDs = append(subfolderIDs, f.Id)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("failed to list folder: %w", err)
+	}
+
+	for i, folder := range subfolders {
+		if err := p.listFolder(ctx, subfolderIDs[i], folder.Path, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Download writes the content of a remote file to localPath
+func (p *GoogleDriveProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of a remote file from offset onwards,
+// appending to the existing localPath
+func (p *GoogleDriveProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	parentID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
+	if err != nil {
+		return err
+	}
+
+	fileID, err := p.findFile(ctx, path.Base(remotePath), parentID)
+	if err != nil {
+		return err
+	}
+
+	if fileID == "" {
+		return fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	call := p.service.Files.Get(fileID).Context(ctx)
+	if offset > 0 {
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := call.Download()
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return writeDownload(localPath, offset, resp)
+}
+
+// UpdateMetadata stores the file's mode and ownership as Drive properties
+// without touching its content
+func (p *GoogleDriveProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	parentID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
+	if err != nil {
+		return err
+	}
+
+	fileID, err := p.findFile(ctx, path.Base(remotePath), parentID)
+	if err != nil {
+		return err
+	}
+
+	if fileID == "" {
+		return fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	driveFile := &drive.File{
+		Properties: map[string]string{
+			"csync_mode": fmt.Sprintf("%04o", file.Mode.Perm()),
+			"csync_uid":  strconv.Itoa(file.UID),
+			"csync_gid":  strconv.Itoa(file.GID),
+		},
+	}
+
+	if _, err := p.service.Files.Update(fileID, driveFile).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to update file metadata: %w", err)
+	}
+
+	return nil
+}
+
 // ensureParentFolders ensures all parent directories exist for a given path
 func (p *GoogleDriveProvider) ensureParentFolders(ctx context.Context, remotePath string) (string, error) {
-	dir := filepath.Dir(remotePath)
+	dir := path.Dir(remotePath)
 	if dir == "." || dir == "/" {
 		return p.folderID, nil
 	}
 
 	parentID := p.folderID
-	parts := strings.Split(filepath.ToSlash(dir), "/")
+	parts := strings.Split(dir, "/")
 
 	for _, part := range parts {
 		if part == "" {
@@ -238,7 +582,7 @@ func (p *GoogleDriveProvider) ensureParentFolders(ctx context.Context, remotePat
 			// Create folder
 			folder := &drive.File{
 				Name:     part,
-				MimeType: "application/vnd.google-apps.folder",
+				MimeType: folderMimeType,
 				Parents:  []string{parentID},
 			}
 
@@ -258,13 +602,13 @@ func (p *GoogleDriveProvider) ensureParentFolders(ctx context.Context, remotePat
 
 // getParentFolderID gets the parent folder ID for a given path
 func (p *GoogleDriveProvider) getParentFolderID(ctx context.Context, remotePath string) (string, error) {
-	dir := filepath.Dir(remotePath)
+	dir := path.Dir(remotePath)
 	if dir == "." || dir == "/" {
 		return p.folderID, nil
 	}
 
 	parentID := p.folderID
-	parts := strings.Split(filepath.ToSlash(dir), "/")
+	parts := strings.Split(dir, "/")
 
 	for _, part := range parts {
 		if part == "" {
@@ -288,26 +632,121 @@ func (p *GoogleDriveProvider) getParentFolderID(ctx context.Context, remotePath
 
 // findFile finds a file or folder by name in the specified parent folder
 func (p *GoogleDriveProvider) findFile(ctx context.Context, name, parentID string) (string, error) {
-	query := fmt.Sprintf("name='%s' and '%s' in parents and trashed=false", name, parentID)
+	return p.findMatchingFile(ctx, name, parentID, "")
+}
+
+// findMatchingFile finds a file or folder by name in the specified parent
+// folder. Drive allows several files with the same name in a folder; then
+// the one whose MD5 is md5Sum wins, and otherwise the duplicate policy
+// decides.
+func (p *GoogleDriveProvider) findMatchingFile(ctx context.Context, name, parentID, md5Sum string) (string, error) {
+	query := fmt.Sprintf("name='%s' and '%s' in parents and trashed=false", escapeDriveQuery(name), parentID)
 
-	fileList, err := p.service.Files.List().
+	var matches []*drive.File
+	err := p.service.Files.List().
 		Context(ctx).
 		Q(query).
-		Fields("files(id,name)").
-		Do()
+		PageSize(1000).
+		Fields("nextPageToken, files(id,name,md5Checksum,modifiedTime)").
+		Pages(ctx, func(list *drive.FileList) error {
+			matches = append(matches, list.Files...)
+			return nil
+		})
 	if err != nil {
 		return "", fmt.Errorf("failed to search for file: %w", err)
 	}
 
-	if len(fileList.Files) == 0 {
+	switch {
+	case len(matches) == 0:
 		return "", nil // File not found
+	case len(matches) == 1:
+		return matches[0].Id, nil
+	}
+
+	if md5Sum != "" {
+		for _, f := range matches {
+			if f.Md5Checksum == md5Sum {
+				return f.Id, nil
+			}
+		}
+	}
+
+	switch p.config.DuplicatePolicy {
+	case config.DuplicateError:
+		return "", fmt.Errorf("%d files named %s in the same folder", len(matches), name)
+	case config.DuplicateUpdateFirst:
+		return matches[0].Id, nil
+	default:
+		// RFC 3339 times in UTC sort as strings
+		newest := matches[0]
+		for _, f := range matches[1:] {
+			if f.ModifiedTime > newest.ModifiedTime {
+				newest = f
+			}
+		}
+		utils.LogVerbose("Using the newest of %d files named %s", len(matches), name)
+		return newest.Id, nil
 	}
+}
+
+// googleDriveClient builds the HTTP client for Drive requests. Service
+// account credentials sign their own tokens, so only OAuth client
+// credentials go through the token file and the web flow.
+func googleDriveClient(cfg *config.GoogleDriveConfig, credentials []byte, scopes []string, transport http.RoundTripper) (*http.Client, error) {
+	mode := cfg.AuthMode
+	if mode == "" {
+		mode = googleCredentialsType(credentials)
+	}
+
+	if mode != config.GoogleDriveServiceAccount {
+		oauthConfig, err := google.ConfigFromJSON(credentials, scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse client secret file: %w", err)
+		}
+
+		client, err := getClient(oauthConfig, cfg.TokenPath, transport)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get OAuth2 client: %w", err)
+		}
+		return client, nil
+	}
+
+	jwtConfig, err := google.JWTConfigFromJSON(credentials, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account file: %w", err)
+	}
+	jwtConfig.Subject = cfg.ImpersonateSubject
+
+	ctx := context.Background()
+	if transport != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	}
+	return jwtConfig.Client(ctx), nil
+}
+
+// googleCredentialsType reports the auth mode a Google credentials file is
+// for, from the "type" field service account keys carry
+func googleCredentialsType(credentials []byte) string {
+	var file struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(credentials, &file) == nil && file.Type == "service_account" {
+		return config.GoogleDriveServiceAccount
+	}
+	return config.GoogleDriveOAuth
+}
 
-	return fileList.Files[0].Id, nil
+// driveQueryEscaper escapes the characters that end or escape a string
+// literal in a Drive search query
+var driveQueryEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// escapeDriveQuery escapes s for use inside a quoted Drive query string
+func escapeDriveQuery(s string) string {
+	return driveQueryEscaper.Replace(s)
 }
 
-// getClient retrieves an OAuth2 client
-func getClient(config *oauth2.Config, tokenFile string) (*http.Client, error) {
+// getClient retrieves an OAuth2 client sending requests through transport
+func getClient(config *oauth2.Config, tokenFile string, transport http.RoundTripper) (*http.Client, error) {
 	token, err := tokenFromFile(tokenFile)
 	if err != nil {
 		token, err = getTokenFromWeb(config)
@@ -319,7 +758,11 @@ func getClient(config *oauth2.Config, tokenFile string) (*http.Client, error) {
 		}
 	}
 
-	return config.Client(context.Background(), token), nil
+	ctx := context.Background()
+	if transport != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+	}
+	return config.Client(ctx, token), nil
 }
 
 // getTokenFromWeb requests a token from the web
@@ -0,0 +1,320 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestGoogleDriveServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var subject string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			// The assertion is a JWT whose claims name the impersonated user
+			r.ParseForm()
+			parts := strings.Split(r.FormValue("assertion"), ".")
+			if len(parts) == 3 {
+				claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+				var set struct{ Sub string }
+				json.Unmarshal(claims, &set)
+				subject = set.Sub
+			}
+			json.NewEncoder(w).Encode(map[string]any{"access_token": "token", "token_type": "Bearer", "expires_in": 3600})
+		case r.Header.Get("Authorization") != "Bearer token":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"files": []any{}})
+		}
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "csync@example.iam.gserviceaccount.com",
+		"private_key":  string(keyPEM),
+		"token_uri":    "https://oauth2.googleapis.com/token",
+	})
+	credentialsPath := filepath.Join(t.TempDir(), "service-account.json")
+	os.WriteFile(credentialsPath, credentials, 0600)
+
+	// No token file and no web flow: the auth mode comes from the file
+	cfg := &config.GoogleDriveConfig{CredentialsPath: credentialsPath, ImpersonateSubject: "me@example.com"}
+	provider, err := newGoogleDriveProvider(context.Background(), cfg, rewriteTransport{target}, "")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	if exists, err := provider.FileExists(context.Background(), "missing.txt"); err != nil || exists {
+		t.Fatalf("Expected missing file, got %v, %v", exists, err)
+	}
+	if subject != "me@example.com" {
+		t.Errorf("Expected the token to impersonate me@example.com, got %q", subject)
+	}
+}
+
+func TestEscapeDriveQuery(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":    "report.pdf",
+		"O'Brien.pdf":   `O\'Brien.pdf`,
+		`back\slash`:    `back\\slash`,
+		`it\'s`:         `it\\\'s`,
+		"Résumé 日本.txt": "Résumé 日本.txt",
+	}
+	for name, want := range tests {
+		if got := escapeDriveQuery(name); got != want {
+			t.Errorf("escapeDriveQuery(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGoogleDriveDuplicates(t *testing.T) {
+	// Two pages of files that share a name
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			json.NewEncoder(w).Encode(map[string]any{"nextPageToken": "next", "files": []map[string]string{
+				{"id": "old", "md5Checksum": "aaaa", "modifiedTime": "2024-01-01T00:00:00.000Z"},
+			}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"files": []map[string]string{
+			{"id": "new", "md5Checksum": "bbbb", "modifiedTime": "2024-06-01T00:00:00.000Z"},
+		}})
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	ctx := context.Background()
+	service, err := drive.NewService(ctx, option.WithHTTPClient(&http.Client{Transport: rewriteTransport{target}}))
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+
+	tests := []struct {
+		policy string
+		md5Sum string
+		want   string
+	}{
+		{"", "", "new"},
+		{config.DuplicateUpdateFirst, "", "old"},
+		{config.DuplicateError, "", ""},
+		{config.DuplicateError, "aaaa", "old"},
+	}
+	for _, tt := range tests {
+		provider := &GoogleDriveProvider{service: service, config: &config.GoogleDriveConfig{DuplicatePolicy: tt.policy}}
+		id, err := provider.findMatchingFile(ctx, "report.pdf", "root", tt.md5Sum)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tt.policy, id)
+			}
+			continue
+		}
+		if err != nil || id != tt.want {
+			t.Errorf("%s with md5 %q: expected %s, got %q, %v", tt.policy, tt.md5Sum, tt.want, id, err)
+		}
+	}
+}
+
+// fakeDriveUploads serves Drive's file search and resumable upload
+// sessions. The session URIs it hands out point back at it.
+type fakeDriveUploads struct {
+	mu       gosync.Mutex
+	sessions map[string]*fakeDriveSession
+	started  int      // Sessions started
+	ranges   []string // Content-Range of each chunk, in order
+	failAt   int64    // Offset of a chunk to fail once with a 503, -1 for none
+	expireAt int64    // Offset of a chunk whose session expires, -1 for none
+	redirect bool     // Redirect the next chunk after reading part of it
+}
+
+type fakeDriveSession struct {
+	data    []byte
+	size    int64
+	expired bool
+}
+
+func (f *fakeDriveUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/drive/v3/files":
+		json.NewEncoder(w).Encode(map[string]any{"files": []any{}})
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/drive/v3/files":
+		size, _ := strconv.ParseInt(r.Header.Get("X-Upload-Content-Length"), 10, 64)
+		f.started++
+		id := fmt.Sprintf("/upload/session/%d", f.started)
+		f.sessions[id] = &fakeDriveSession{size: size}
+		w.Header().Set("Location", "https://www.googleapis.com"+id)
+	case r.Method == http.MethodPut && f.sessions[r.URL.Path] != nil:
+		session := f.sessions[r.URL.Path]
+		contentRange := r.Header.Get("Content-Range")
+		if session.expired {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if !strings.HasPrefix(contentRange, "bytes */") {
+			var start, end int64
+			fmt.Sscanf(contentRange, "bytes %d-%d/", &start, &end)
+			f.ranges = append(f.ranges, contentRange)
+			switch {
+			case f.redirect:
+				f.redirect = false
+				io.CopyN(io.Discard, r.Body, 100)
+				http.Redirect(w, r, r.URL.String(), http.StatusTemporaryRedirect)
+				return
+			case start == f.failAt:
+				f.failAt = -1
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			case start == f.expireAt:
+				f.expireAt = -1
+				session.expired = true
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			chunk, _ := io.ReadAll(r.Body)
+			session.data = append(session.data[:start], chunk...)
+		}
+		if int64(len(session.data)) == session.size {
+			sum := md5.Sum(session.data)
+			json.NewEncoder(w).Encode(map[string]string{"id": "uploaded", "md5Checksum": hex.EncodeToString(sum[:])})
+			return
+		}
+		if len(session.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(session.data)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// uploaded returns the content of the newest completed session
+func (f *fakeDriveUploads) uploaded() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	session := f.sessions[fmt.Sprintf("/upload/session/%d", f.started)]
+	if int64(len(session.data)) != session.size {
+		return nil
+	}
+	return session.data
+}
+
+func TestGoogleDriveResumableUpload(t *testing.T) {
+	fake := &fakeDriveUploads{sessions: make(map[string]*fakeDriveSession), failAt: -1, expireAt: -1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	ctx := context.Background()
+	client := &http.Client{Transport: rewriteTransport{target}}
+	service, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	sessions, err := loadUploadSessions(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatalf("Failed to load upload sessions: %v", err)
+	}
+	provider := &GoogleDriveProvider{service: service, httpClient: client, config: &config.GoogleDriveConfig{}, folderID: "root"}
+	provider.setResumable(1024, driveChunkAlign, sessions)
+	provider.setVerifyUploads(true)
+
+	// Three chunks, the last one short
+	data := make([]byte, 2*driveChunkAlign+1000)
+	rand.Read(data)
+	localPath := filepath.Join(t.TempDir(), "big.bin")
+	os.WriteFile(localPath, data, 0644)
+	info, _ := os.Stat(localPath)
+	file := scanner.FileInfo{Path: "big.bin", AbsolutePath: localPath, Size: info.Size(), ModTime: info.ModTime()}
+	second := fmt.Sprintf("bytes %d-%d/%d", driveChunkAlign, 2*driveChunkAlign-1, len(data))
+
+	// A redirected chunk is sent again from its start; a failed one stops
+	// the upload with its session kept
+	fake.redirect, fake.failAt = true, driveChunkAlign
+	if err := provider.Upload(ctx, file, "big.bin"); err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+	if _, ok := sessions.get("gdrive", file, "big.bin"); !ok {
+		t.Fatal("Expected the interrupted session to be kept")
+	}
+	if len(fake.ranges) != 3 || fake.ranges[0] != fake.ranges[1] || fake.ranges[2] != second {
+		t.Errorf("Expected the first chunk twice and then the second, got %v", fake.ranges)
+	}
+
+	// The next attempt asks the session how much arrived and goes on from there
+	fake.ranges = nil
+	if err := provider.Upload(ctx, file, "big.bin"); err != nil {
+		t.Fatalf("Failed to resume the upload: %v", err)
+	}
+	if got := fake.uploaded(); !bytes.Equal(got, data) {
+		t.Errorf("Expected the file to arrive whole, got %d of %d bytes", len(got), len(data))
+	}
+	if fake.started != 1 || len(fake.ranges) == 0 || fake.ranges[0] != second {
+		t.Errorf("Expected 1 session resumed at %q, got %d sessions and chunks %v", second, fake.started, fake.ranges)
+	}
+	if _, ok := sessions.get("gdrive", file, "big.bin"); ok {
+		t.Error("Expected the completed session to be forgotten")
+	}
+
+	// A session that expires mid-upload is dropped, and the next attempt
+	// starts over in a new one
+	fake.expireAt = driveChunkAlign
+	if err := provider.Upload(ctx, file, "big.bin"); err == nil {
+		t.Fatal("Expected the upload to fail")
+	}
+	if _, ok := sessions.get("gdrive", file, "big.bin"); ok {
+		t.Error("Expected the expired session to be forgotten")
+	}
+	if err := provider.Upload(ctx, file, "big.bin"); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	if got := fake.uploaded(); fake.started != 3 || !bytes.Equal(got, data) {
+		t.Errorf("Expected a third session with the whole file, got %d sessions and %d bytes", fake.started, len(got))
+	}
+
+	// So does one found expired when resuming
+	fake.failAt = driveChunkAlign
+	provider.Upload(ctx, file, "big.bin")
+	fake.mu.Lock()
+	fake.sessions[fmt.Sprintf("/upload/session/%d", fake.started)].expired = true
+	fake.mu.Unlock()
+	if err := provider.Upload(ctx, file, "big.bin"); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	if got := fake.uploaded(); fake.started != 5 || !bytes.Equal(got, data) {
+		t.Errorf("Expected a fifth session with the whole file, got %d sessions and %d bytes", fake.started, len(got))
+	}
+}
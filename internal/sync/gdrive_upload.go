@@ -0,0 +1,234 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// driveUploadURL is the Drive endpoint for media uploads
+const driveUploadURL = "https://www.googleapis.com/upload/drive/v3/files"
+
+// driveChunkAlign is the granularity Drive requires for resumable chunks
+const driveChunkAlign = 256 * 1024
+
+// setResumable makes uploads larger than threshold use the resumable
+// protocol in chunks of chunkSize, recording sessions so an interrupted
+// upload continues where it stopped
+func (p *GoogleDriveProvider) setResumable(threshold, chunkSize int64, sessions *uploadSessions) {
+	chunkSize -= chunkSize % driveChunkAlign
+	p.resumableThreshold = threshold
+	p.chunkSize = max(chunkSize, driveChunkAlign)
+	p.sessions = sessions
+}
+
+// setPreserveModTime makes uploads keep the local modification time
+func (p *GoogleDriveProvider) setPreserveModTime(preserve bool) {
+	p.preserveModTime = preserve
+}
+
+// setVerifyUploads makes uploads compare Drive's md5Checksum of the
+// uploaded content with the local file
+func (p *GoogleDriveProvider) setVerifyUploads(verify bool) {
+	p.verifyUploads = verify
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *GoogleDriveProvider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.limiter = limiter
+}
+
+// resumableUpload uploads a file in chunks through a resumable session,
+// continuing a previously interrupted session for the same file if there
+// is one. driveFile and existingFileID are as for a simple upload.
+func (p *GoogleDriveProvider) resumableUpload(ctx context.Context, file scanner.FileInfo, remotePath string, driveFile *drive.File, existingFileID string) error {
+	var offset int64
+	var uploaded drive.File // Filled in by the response completing the upload
+	session, ok := p.sessions.get("gdrive", file, remotePath)
+	if ok {
+		next, done, err := p.resumableStatus(ctx, session.ID, file.Size, &uploaded)
+		switch {
+		case err != nil:
+			utils.LogVerbose("Restarting upload of %s: %v", remotePath, err)
+			ok = false
+		case done:
+			return p.finishResumable(file, remotePath, &uploaded, nil)
+		default:
+			offset = next
+			utils.LogVerbose("Resuming upload of %s at %d of %d bytes", remotePath, offset, file.Size)
+		}
+	}
+
+	if !ok {
+		uri, err := p.startResumable(ctx, driveFile, existingFileID, file.Size)
+		if err != nil {
+			return fmt.Errorf("failed to start resumable upload: %w", err)
+		}
+		session.ID = uri
+		if err := p.sessions.set("gdrive", file, remotePath, uri); err != nil {
+			utils.LogError("Failed to record upload session for %s: %v", remotePath, err)
+		}
+	}
+
+	localFile, err := os.Open(file.AbsolutePath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer localFile.Close()
+
+	progress := utils.NewProgress(remotePath, file.Size)
+	defer progress.Done()
+	progress.Skip(offset)
+	hash := newUploadHash(file, HashMD5, p.verifyUploads)
+	hash.skip(localFile, offset)
+
+	for {
+		start, end := offset, min(offset+p.chunkSize, file.Size)
+		// A chunk that has to be sent again is read again from the file
+		chunk := func() io.Reader {
+			return p.limiter.Reader(ctx, hash.reader(io.NewSectionReader(localFile, start, end-start), start))
+		}
+		next, done, err := p.putChunk(ctx, session.ID, progress.Reader(chunk()), chunk, offset, end, file.Size, &uploaded)
+		if err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone) {
+				// The session expired; the next attempt starts a new one
+				p.sessions.remove("gdrive", file)
+			}
+			return fmt.Errorf("failed to upload %s at byte %d: %w", remotePath, offset, err)
+		}
+		if done {
+			break
+		}
+		offset = next
+		utils.LogVerbose("Uploaded %d of %d bytes of %s", offset, file.Size, remotePath)
+	}
+
+	return p.finishResumable(file, remotePath, &uploaded, hash)
+}
+
+// finishResumable forgets the session of a completed upload and verifies
+// the uploaded file if uploads are verified, against hash if the upload
+// took one
+func (p *GoogleDriveProvider) finishResumable(file scanner.FileInfo, remotePath string, uploaded *drive.File, hash *uploadHash) error {
+	if err := p.sessions.remove("gdrive", file); err != nil {
+		return err
+	}
+	if p.verifyUploads {
+		return verifyUpload(hash.hashed(file), remotePath, HashMD5, uploaded.Md5Checksum)
+	}
+	return nil
+}
+
+// startResumable opens a resumable session that creates a new file, or
+// replaces the content of existingFileID, and returns the session URI
+func (p *GoogleDriveProvider) startResumable(ctx context.Context, driveFile *drive.File, existingFileID string, size int64) (string, error) {
+	body, err := json.Marshal(driveFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal file metadata: %w", err)
+	}
+
+	// The response completing the upload describes the file with fields
+	method, url := http.MethodPost, driveUploadURL+"?uploadType=resumable&fields=id,md5Checksum"
+	if existingFileID != "" {
+		method, url = http.MethodPatch, driveUploadURL+"/"+existingFileID+"?uploadType=resumable&fields=id,md5Checksum"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return "", err
+	}
+
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", fmt.Errorf("no session URI in response")
+	}
+	return uri, nil
+}
+
+// putChunk sends bytes [offset, end) of the file and reports the offset
+// the server expects next, or whether the upload is complete. rewind
+// returns the chunk from its start again for the HTTP client to resend.
+// The uploaded file is decoded into done once complete.
+func (p *GoogleDriveProvider) putChunk(ctx context.Context, uri string, chunk io.Reader, rewind func() io.Reader, offset, end, size int64, done *drive.File) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, chunk)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = end - offset
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(rewind()), nil
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, size))
+
+	return p.resumableResponse(req, done)
+}
+
+// resumableStatus asks the server how much of a session's upload it has,
+// decoding the uploaded file into done if it has all of it
+func (p *GoogleDriveProvider) resumableStatus(ctx context.Context, uri string, size int64, done *drive.File) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+
+	return p.resumableResponse(req, done)
+}
+
+// resumableResponse sends a request to a resumable session. A 308 reports
+// the bytes received so far in its Range header; 200 or 201 means done,
+// with the uploaded file in the body.
+func (p *GoogleDriveProvider) resumableResponse(req *http.Request, done *drive.File) (int64, bool, error) {
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		if err := json.NewDecoder(resp.Body).Decode(done); err != nil && err != io.EOF {
+			return 0, true, fmt.Errorf("failed to decode upload response: %w", err)
+		}
+		return 0, true, nil
+	case http.StatusPermanentRedirect:
+		received := resp.Header.Get("Range") // "bytes=0-N", absent when nothing arrived
+		if received == "" {
+			return 0, false, nil
+		}
+		last, err := strconv.ParseInt(received[strings.LastIndex(received, "-")+1:], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid Range header %q", received)
+		}
+		return last + 1, false, nil
+	default:
+		return 0, false, googleapi.CheckResponse(resp)
+	}
+}
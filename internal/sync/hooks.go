@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// runPreSyncHook runs pre_sync_hook before a sync of sourcePath to
+// provider, "all" for every configured one. Its failure aborts the sync.
+func (m *Manager) runPreSyncHook(ctx context.Context, provider, sourcePath string) error {
+	command := m.config.GetAdvanced().PreSyncHook
+	if command == "" {
+		return nil
+	}
+	if err := runHook(ctx, "pre-sync", command, hookEnv(provider, sourcePath)); err != nil {
+		return fmt.Errorf("pre-sync hook failed: %w", err)
+	}
+	return nil
+}
+
+// runPostSyncHook runs post_sync_hook after a sync, passing it the outcome.
+// It still runs when the sync was cancelled; its failure is only logged.
+func (m *Manager) runPostSyncHook(ctx context.Context, provider, sourcePath string, report SyncReport, syncErr error) {
+	command := m.config.GetAdvanced().PostSyncHook
+	if command == "" {
+		return
+	}
+
+	result, errText := "success", ""
+	if syncErr != nil {
+		result, errText = "failure", syncErr.Error()
+	}
+	env := append(hookEnv(provider, sourcePath),
+		"CSYNC_RESULT="+result,
+		"CSYNC_ERROR="+errText,
+		"CSYNC_UPLOADED="+strconv.Itoa(report.Uploaded),
+		"CSYNC_DOWNLOADED="+strconv.Itoa(report.Downloaded),
+		"CSYNC_SKIPPED="+strconv.Itoa(report.Skipped),
+		"CSYNC_FAILED="+strconv.Itoa(report.Failed),
+		"CSYNC_BYTES="+strconv.FormatInt(report.Bytes, 10),
+	)
+	if err := runHook(context.WithoutCancel(ctx), "post-sync", command, env); err != nil {
+		utils.LogError("Post-sync hook failed: %v", err)
+	}
+}
+
+// hookEnv returns the environment every hook runs with
+func hookEnv(provider, sourcePath string) []string {
+	return append(os.Environ(),
+		"CSYNC_SOURCE="+sourcePath,
+		"CSYNC_PROVIDER="+provider,
+	)
+}
+
+// runHook runs command with the system shell. Its output is logged at
+// verbose level, and included in the error when it fails.
+func runHook(ctx context.Context, name, command string, env []string) error {
+	shell, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	cmd.Env = env
+
+	utils.LogVerbose("Running %s hook: %s", name, command)
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if err != nil {
+		if output != "" {
+			return fmt.Errorf("%w: %s", err, output)
+		}
+		return err
+	}
+	if output != "" {
+		utils.LogVerbose("%s hook output: %s", name, output)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ExportRemoteListing returns every file and folder stored under the named
+// provider's destination, with paths relative to the destination root
+func (m *Manager) ExportRemoteListing(ctx context.Context, providerName string) ([]RemoteFileInfo, error) {
+	p, err := m.provider(ctx, providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := p.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", p.Name(), err)
+	}
+
+	return listing, nil
+}
+
+// ExportRemoteListingToFile writes the remote listing as JSON to outPath,
+// or to stdout when outPath is "" or "-"
+func (m *Manager) ExportRemoteListingToFile(ctx context.Context, providerName, outPath string) error {
+	listing, err := m.ExportRemoteListing(ctx, providerName)
+	if err != nil {
+		return err
+	}
+
+	if outPath == "" || outPath == "-" {
+		return WriteListingJSON(os.Stdout, listing)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create listing file: %w", err)
+	}
+
+	if err := WriteListingJSON(f, listing); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// WriteListingJSON serializes a remote listing as an indented JSON array
+func WriteListingJSON(w io.Writer, listing []RemoteFileInfo) error {
+	if listing == nil {
+		listing = []RemoteFileInfo{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(listing); err != nil {
+		return fmt.Errorf("failed to encode listing: %w", err)
+	}
+
+	return nil
+}
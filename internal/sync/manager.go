@@ -3,61 +3,998 @@ package sync
 import (
 	"context"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/svosadtsia/csync/internal/config"
-	"github.com/svosadtsia/csync/internal/providers/gdrive"
-	"github.com/svosadtsia/csync/internal/providers/pcloud"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
 )
 
 // Manager handles synchronization operations across different cloud providers
 type Manager struct {
-	config       *config.Config
-	gdriveClient *gdrive.Client
-	pcloudClient *pcloud.Client
+	config         *config.Config // Effective configuration, with the profile applied
+	baseConfig     *config.Config // Configuration as loaded
+	profile        string
+	providers      map[string]Provider
+	patternProfile string
+	options        SyncOptions // Ad-hoc narrowing of the sync in progress
+	pathMapper     PathMapper
+	inverseMapper  InversePathMapper
+	skipped        []scanner.SkippedFile // Skips recorded by the most recent sync
+	hashCache      *scanner.HashCache    // Loaded on first sync with a state file
+	failed         []FileFailure         // Failures recorded by the most recent sync
+	flattenMap     *FlattenMap           // Loaded when flatten_structure is enabled
+	summary        RunSummary            // Errors and warnings of the most recent sync
+	dryRun         DryRunReport          // What the most recent dry run would do
+	report         ProviderReport        // Per-file outcome of the most recent sync
+	results        []ProviderResult      // Per-provider outcomes of the most recent SyncAll
+	budget         apiBudget             // API requests made by the current sync
+	uploadSessions *uploadSessions       // Interrupted chunked uploads, shared by providers
+	uploadLimiter  *throttle.Limiter     // Upload rate limit shared by all providers, nil for none
 }
 
+// PathMapper turns a source-relative path into the remote path used for
+// every provider operation on that file (upload, skip checks, deletes).
+// It is called for directories too, with info.IsDir set.
+type PathMapper func(localRelPath string, info scanner.FileInfo) (remotePath string)
+
+// InversePathMapper maps a remote path back to its source-relative path for
+// restores. It returns false when the mapping is lossy and the original
+// path can't be derived.
+type InversePathMapper func(remotePath string) (localRelPath string, ok bool)
+
 // NewManager creates a new sync manager with the given configuration
 func NewManager(cfg *config.Config) *Manager {
 	return &Manager{
-		config: cfg,
+		config:        cfg,
+		baseConfig:    cfg,
+		providers:     make(map[string]Provider),
+		uploadLimiter: throttle.NewLimiter(cfg.GetAdvanced().MaxUploadBytesPerSec),
 	}
 }
 
-// GetConfig returns the manager's configuration
+// GetConfig returns the manager's configuration, with the selected sync
+// profile applied
 func (m *Manager) GetConfig() *config.Config {
 	return m.config
 }
 
+// SetProfile selects the named sync profile for subsequent syncs: its
+// source path, provider and destinations replace the top-level ones. An
+// empty name restores the top-level configuration.
+func (m *Manager) SetProfile(name string) error {
+	cfg, err := m.baseConfig.ResolveProfile(name)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	m.profile = name
+	// Providers were created for the previous destinations
+	m.providers = make(map[string]Provider)
+	return nil
+}
+
+// SetConfig replaces the configuration, keeping the selected sync profile.
+// Providers whose settings changed are rebuilt on next use; the others keep
+// their clients.
+func (m *Manager) SetConfig(cfg *config.Config) error {
+	effective, err := cfg.ResolveProfile(m.profile)
+	if err != nil {
+		return err
+	}
+	for name := range m.providers {
+		if !reflect.DeepEqual(providerSettings(m.config, name), providerSettings(effective, name)) {
+			delete(m.providers, name)
+		}
+	}
+	m.baseConfig = cfg
+	m.config = effective
+	return nil
+}
+
+// Profile returns the selected sync profile, or "" for none
+func (m *Manager) Profile() string {
+	return m.profile
+}
+
+// stateKey is the key a provider's sync state is recorded under. Profiles
+// sync different folders, so each keeps its own state.
+func (m *Manager) stateKey(name string) string {
+	if m.profile == "" {
+		return name
+	}
+	return m.profile + "/" + name
+}
+
+// SetPatternProfile selects the pattern profile used for subsequent syncs,
+// overriding general.active_pattern_profile. An empty name restores the default.
+func (m *Manager) SetPatternProfile(name string) error {
+	if name != "" {
+		if _, ok := m.config.General.PatternProfiles[name]; !ok {
+			return fmt.Errorf("unknown pattern profile: %s", name)
+		}
+	}
+	m.patternProfile = name
+	return nil
+}
+
+// SetPathMapper installs a custom local-to-remote path mapping. A nil mapper
+// restores the identity mapping. inverse may be nil, in which case restores
+// write files at their remote paths.
+func (m *Manager) SetPathMapper(mapper PathMapper, inverse InversePathMapper) {
+	m.pathMapper = mapper
+	m.inverseMapper = inverse
+}
+
+// RemotePathFor returns the remote path a scanned file is synced to
+func (m *Manager) RemotePathFor(file scanner.FileInfo) string {
+	if m.pathMapper != nil {
+		return toRemotePath(m.pathMapper(file.Path, file))
+	}
+	if m.flattenMap != nil {
+		if remote, ok := m.flattenMap.Remote(file.Path); ok {
+			return remote
+		}
+	}
+	return file.Path
+}
+
+// toRemotePath turns a path built with the host's separator, as a PathMapper
+// using filepath functions returns on Windows, into the slash-separated form
+// every provider expects
+func toRemotePath(p string) string {
+	return replaceSeparator(p, filepath.Separator)
+}
+
+// replaceSeparator replaces sep in p with slashes
+func replaceSeparator(p string, sep byte) string {
+	if sep == '/' {
+		return p
+	}
+	return strings.ReplaceAll(p, string(sep), "/")
+}
+
+// LocalPathFor returns the source-relative path a remote file restores to.
+// The second result is false when a custom mapper has no inverse, in which
+// case the remote path itself is returned.
+func (m *Manager) LocalPathFor(remotePath string) (string, bool) {
+	if fm, err := m.loadFlattenMap(); err != nil {
+		utils.LogError("Failed to load flatten map: %v", err)
+		return remotePath, false
+	} else if fm != nil {
+		if local, ok := fm.Local(remotePath); ok {
+			return local, true
+		}
+		return remotePath, false
+	}
+	if m.pathMapper == nil {
+		return remotePath, true
+	}
+	if m.inverseMapper == nil {
+		return remotePath, false
+	}
+	return m.inverseMapper(remotePath)
+}
+
 // SyncToGoogleDrive syncs files to Google Drive
 func (m *Manager) SyncToGoogleDrive(ctx context.Context, sourcePath string, dryRun bool) error {
-	if m.gdriveClient == nil {
-		client, err := gdrive.NewClient(ctx, &m.config.GoogleDrive)
+	_, err := m.SyncWithResult(ctx, "gdrive", sourcePath, dryRun)
+	return err
+}
+
+// SyncToPCloud syncs files to pCloud
+func (m *Manager) SyncToPCloud(ctx context.Context, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, "pcloud", sourcePath, dryRun)
+	return err
+}
+
+// SyncToS3 syncs files to S3
+func (m *Manager) SyncToS3(ctx context.Context, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, "s3", sourcePath, dryRun)
+	return err
+}
+
+// SyncToSFTP syncs files to an SFTP server
+func (m *Manager) SyncToSFTP(ctx context.Context, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, "sftp", sourcePath, dryRun)
+	return err
+}
+
+// SyncToOneDrive syncs files to OneDrive
+func (m *Manager) SyncToOneDrive(ctx context.Context, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, "onedrive", sourcePath, dryRun)
+	return err
+}
+
+// SyncToWebDAV syncs files to a WebDAV server
+func (m *Manager) SyncToWebDAV(ctx context.Context, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, "webdav", sourcePath, dryRun)
+	return err
+}
+
+// SyncToB2 syncs files to Backblaze B2
+func (m *Manager) SyncToB2(ctx context.Context, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, "b2", sourcePath, dryRun)
+	return err
+}
+
+// SyncPaths syncs only the given files and folders of sourcePath to the
+// named provider, instead of the whole tree. Paths are relative to
+// sourcePath; ones that no longer exist locally have their remote copies
+// deleted when delete_removed is enabled. renames maps the new paths of
+// files known to be renamed to their old ones, so with delete_removed their
+// remote copies are moved rather than uploaded again.
+func (m *Manager) SyncPaths(ctx context.Context, providerName, sourcePath string, paths []string, renames map[string]string) error {
+	if paths == nil {
+		paths = []string{}
+	}
+	return m.syncProvider(ctx, providerName, sourcePath, paths, renames, false)
+}
+
+// SyncAndShare syncs sourcePath to the named provider and returns a public
+// link to the destination folder, reusing an existing link if there is one
+func (m *Manager) SyncAndShare(ctx context.Context, providerName, sourcePath string) (string, error) {
+	if err := m.syncProvider(ctx, providerName, sourcePath, nil, nil, false); err != nil {
+		return "", err
+	}
+
+	p, err := m.provider(ctx, providerName)
+	if err != nil {
+		return "", err
+	}
+	if err := RequireFeature(p, FeaturePublicLinks); err != nil {
+		return "", err
+	}
+
+	link, err := p.PublicLink(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to create public link: %w", err)
+	}
+
+	utils.LogInfo("Public link: %s", link)
+	return link, nil
+}
+
+// Capabilities reports the optional features supported by the named provider
+func (m *Manager) Capabilities(ctx context.Context, name string) (ProviderCapabilities, error) {
+	p, err := m.provider(ctx, name)
+	if err != nil {
+		return ProviderCapabilities{}, err
+	}
+	return p.Capabilities(), nil
+}
+
+// provider returns the named provider, creating and caching it on first use
+func (m *Manager) provider(ctx context.Context, name string) (Provider, error) {
+	if p, ok := m.providers[name]; ok {
+		return p, nil
+	}
+
+	p, err := m.newProvider(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if throttled, ok := p.(uploadThrottled); ok {
+		throttled.setUploadLimiter(m.uploadLimiter)
+	}
+	if preserver, ok := p.(modTimePreserver); ok {
+		preserver.setPreserveModTime(m.config.GetAdvanced().PreserveModTime)
+	}
+	if verifier, ok := p.(uploadVerifier); ok {
+		verifier.setVerifyUploads(m.config.GetAdvanced().VerifyUploads)
+	}
+	if passphrase := m.config.GetAdvanced().EncryptionPassphrase; passphrase != "" {
+		encrypted, err := newEncryptedProvider(p, passphrase)
 		if err != nil {
-			return fmt.Errorf("failed to create Google Drive client: %w", err)
+			return nil, err
+		}
+		p = encrypted
+	}
+	if algorithm := m.config.GetAdvanced().Compression; algorithm != "" && algorithm != config.CompressionNone {
+		p = newCompressedProvider(p, algorithm)
+	}
+
+	m.providers[name] = p
+	return p, nil
+}
+
+// uploadThrottled is implemented by providers whose uploads can share the
+// manager's rate limit
+type uploadThrottled interface {
+	setUploadLimiter(limiter *throttle.Limiter)
+}
+
+// modTimePreserver is implemented by providers that can give uploaded files
+// the local modification time
+type modTimePreserver interface {
+	setPreserveModTime(preserve bool)
+}
+
+// uploadVerifier is implemented by providers that can check an upload
+// against the hash their upload response reports
+type uploadVerifier interface {
+	setVerifyUploads(verify bool)
+}
+
+// syncRun holds the per-run state shared while syncing to one provider
+type syncRun struct {
+	provider  Provider
+	name      string // Key of the run's sync state
+	tag       string
+	advanced  config.AdvancedConfig
+	state     *SyncState        // nil when state tracking is disabled
+	clockSkew time.Duration     // How far the provider's clock runs ahead of ours
+	retries   int               // How many times to retry a transient failure
+	stabilize time.Duration     // How long a file must stay unchanged before upload
+	source    string            // Local source directory
+	partial   bool              // Only some paths of the source are being synced
+	renames   map[string]string // Old source-relative paths of renamed files, by new path
+	movedFrom map[string]bool   // Source-relative paths whose remote copies were moved away
+
+	processed atomic.Int64 // Files synced, skipped or failed so far
+
+	mu         gosync.Mutex
+	uploaded   []scanner.FileInfo
+	downloaded []scanner.FileInfo
+	skipped    []scanner.SkippedFile
+	failed     []FileFailure
+	warnings   []FileFailure
+}
+
+// upload records a file the run uploaded
+func (r *syncRun) upload(file scanner.FileInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uploaded = append(r.uploaded, file)
+}
+
+// download records a file the run downloaded
+func (r *syncRun) download(file scanner.FileInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downloaded = append(r.downloaded, file)
+}
+
+// skip records a file the run decided not to upload
+func (r *syncRun) skip(file scanner.FileInfo, reason scanner.SkipReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped = append(r.skipped, scanner.SkippedFile{Path: file.Path, IsDir: file.IsDir, Reason: reason})
+}
+
+// syncProvider scans the source directory and mirrors it to the named
+// provider. With non-nil paths only those source-relative paths are scanned
+// and synced, and renames lists the files among them renamed from another
+// path. The sync hooks run around it unless it's a dry run.
+func (m *Manager) syncProvider(ctx context.Context, name, sourcePath string, paths []string, renames map[string]string, dryRun bool) (err error) {
+	m.budget.reset(m.config.GetAdvanced().APICallBudget)
+	start := time.Now()
+
+	if !dryRun {
+		defer func() {
+			m.runPostSyncHook(ctx, name, sourcePath, NewSyncReport(start, m.report), err)
+		}()
+		if err := m.runPreSyncHook(ctx, name, sourcePath); err != nil {
+			m.record(stoppedEarly(name, start, err), dryRun)
+			return err
 		}
-		m.gdriveClient = client
 	}
 
+	p, err := m.provider(ctx, name)
+	if err != nil {
+		m.record(stoppedEarly(name, start, err), dryRun)
+		return err
+	}
+
+	src, err := m.scanSource(p, sourcePath, paths, dryRun)
+	if err != nil {
+		m.record(stoppedEarly(name, start, err), dryRun)
+		return err
+	}
+	src.renames = renames
+
+	res, err := m.runSync(ctx, name, p, src, start, dryRun)
+	m.record(res, dryRun)
+	return err
+}
+
+// sourceScan is the scanned source tree a sync works from. SyncAll shares
+// one between the providers that hash files alike.
+type sourceScan struct {
+	scn     *scanner.Scanner
+	files   []scanner.FileInfo
+	flatten *FlattenMap // nil unless flatten_structure is enabled
+	source  string
+	paths   []string          // The source-relative paths scanned, nil for the whole tree
+	renames map[string]string // Old source-relative paths of renamed files, by new path
+	state   *SyncState        // Sync state shared by concurrent runs, nil to load it per run
+}
+
+// runResult is what a sync to one provider leaves behind for LastSummary,
+// LastReport, FailedFiles, SkippedFiles and LastDryRun
+type runResult struct {
+	skipped []scanner.SkippedFile
+	failed  []FileFailure
+	summary RunSummary
+	report  ProviderReport
+	dryRun  DryRunReport
+}
+
+// stoppedEarly is the result of a sync that failed before it started
+func stoppedEarly(name string, start time.Time, err error) runResult {
+	return runResult{report: ProviderReport{Provider: name, Duration: time.Since(start), Error: err.Error(), Files: []FileReport{}}}
+}
+
+// record makes res the outcome of the most recent sync
+func (m *Manager) record(res runResult, dryRun bool) {
+	m.skipped = res.skipped
+	m.failed = res.failed
+	m.summary = res.summary
+	m.report = res.report
 	if dryRun {
-		return m.gdriveClient.DryRun(ctx, sourcePath)
+		m.dryRun = res.dryRun
+	}
+}
+
+// scanSource scans sourcePath, or only paths when they aren't nil, hashing
+// files the way provider p compares them
+func (m *Manager) scanSource(p Provider, sourcePath string, paths []string, dryRun bool) (*sourceScan, error) {
+	if dryRun {
+		utils.LogVerbose("DRY RUN: %s sync from: %s", p.Name(), sourcePath)
+	} else {
+		utils.LogVerbose("Starting %s sync from: %s", p.Name(), sourcePath)
+	}
+
+	ignore, include, err := m.config.ResolvePatterns(m.patternProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	scn := scanner.NewScanner(ignore, include)
+	scn.SetForceInclude(m.config.General.ForceInclude)
+	scn.SetNarrowing(m.options.Include, m.options.Exclude)
+	// Dry runs compare files with their remote copies, so they always hash
+	scn.SetHashing(dryRun || !m.hashesWhileUploading())
+	scn.SetExcludeFolders(m.config.GetAdvanced().ExcludeFolders)
+	scn.SetInProgress(m.config.GetInProgressPatterns(), m.config.GetLockSuffixes())
+	scn.SetSizeFilter(m.config.General.MinFileSize, m.config.General.MaxFileSize)
+	since, err := m.config.GetModifiedSince(time.Now())
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	scn.SetModifiedSince(since)
+	scn.SetFollowSymlinks(m.config.General.FollowSymlinks)
+	if err := scn.SetHashAlgorithm(m.hashAlgorithm(p)); err != nil {
+		return nil, Permanent(err)
+	}
+	if err := scn.SetContentTypeFilter(m.config.General.IncludeContentTypes, m.config.General.ExcludeContentTypes); err != nil {
+		return nil, Permanent(err)
+	}
+	if statePath := m.config.GetAdvanced().StatePath; statePath != "" {
+		if m.hashCache == nil {
+			cache, err := scanner.LoadHashCache(hashCachePath(statePath))
+			if err != nil {
+				return nil, err
+			}
+			m.hashCache = cache
+		}
+		scn.SetHashCache(m.hashCache)
+	}
+
+	var files []scanner.FileInfo
+	if paths == nil {
+		files, err = scn.Scan(sourcePath)
+	} else {
+		files, err = scn.ScanPaths(sourcePath, paths)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", sourcePath, err)
+	}
+
+	if m.hashCache != nil && !dryRun {
+		if err := m.hashCache.Save(); err != nil {
+			utils.LogError("Failed to save hash cache: %v", err)
+		}
 	}
 
-	return m.gdriveClient.Sync(ctx, sourcePath)
+	flatten, err := m.loadFlattenMap()
+	if err != nil {
+		return nil, err
+	}
+	if flatten != nil {
+		var filePaths []string
+		for _, file := range files {
+			if !file.IsDir {
+				filePaths = append(filePaths, file.Path)
+			}
+		}
+		flatten.Assign(filePaths)
+		if !dryRun {
+			if err := flatten.Save(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &sourceScan{scn: scn, files: files, flatten: flatten, source: sourcePath, paths: paths}, nil
 }
 
-// SyncToPCloud syncs files to pCloud
-func (m *Manager) SyncToPCloud(ctx context.Context, sourcePath string, dryRun bool) error {
-	if m.pcloudClient == nil {
-		client, err := pcloud.NewClient(&m.config.PCloud)
-		if err != nil {
-			return fmt.Errorf("failed to create pCloud client: %w", err)
+// runSync mirrors a scanned source to provider p. It only reads the scan
+// and the manager, so runs to different providers can share them.
+func (m *Manager) runSync(ctx context.Context, name string, p Provider, src *sourceScan, start time.Time, dryRun bool) (res runResult, runErr error) {
+	scn, files, flatten, paths := src.scn, src.files, src.flatten, src.paths
+
+	stabilize, err := m.config.GetStabilizeWindow()
+	if err != nil {
+		return stoppedEarly(name, start, err), Permanent(err)
+	}
+
+	run := &syncRun{
+		provider:  p,
+		stabilize: stabilize,
+		name:      m.stateKey(name),
+		tag:       strings.ToUpper(name),
+		source:    src.source,
+		partial:   paths != nil,
+		renames:   src.renames,
+		advanced:  m.config.GetAdvanced(),
+		retries:   m.config.General.RetryAttempts,
+		skipped:   slices.Clone(scn.Skipped()),
+	}
+	defer func() {
+		res.skipped = run.skipped
+		res.failed = run.failed
+		res.summary = run.summarize(runErr)
+		res.summary.APICalls = m.providerAPICalls(name)
+		res.report = run.report(name, time.Since(start), runErr)
+		utils.LogVerbose("[%s] %d API calls", run.tag, res.summary.APICalls)
+		if !dryRun {
+			logSummary(res.summary, m.config.GetLogFormat())
+		}
+	}()
+
+	if run.advanced.StatePath != "" && !dryRun {
+		state := src.state
+		if state == nil {
+			state, err = LoadState(run.advanced.StatePath)
+			if err != nil {
+				return res, err
+			}
+		}
+		run.state = state
+		defer func() {
+			if err := state.Save(); err != nil {
+				utils.LogError("Failed to save sync state: %v", err)
+				run.warn(run.advanced.StatePath, err)
+			}
+		}()
+	}
+
+	// Split the scan into the folders to create, the files to upload and
+	// hardlinks whose content is already among those files
+	var items, links []syncItem
+	folders := make(map[string]bool)
+	remotePaths := make(map[string]string)
+	for _, file := range files {
+		if flatten != nil && file.IsDir {
+			continue // Flattened files all live in the destination root
 		}
-		m.pcloudClient = client
+		remotePath := m.RemotePathFor(file)
+
+		if file.IsDir {
+			addFolder(folders, remotePath)
+			continue
+		}
+
+		// A custom PathMapper may place files in folders that weren't scanned
+		addFolder(folders, path.Dir(remotePath))
+		item := syncItem{file: file, remotePath: remotePath}
+		if _, ok := remotePaths[file.HardlinkOf]; ok {
+			links = append(links, item)
+			continue
+		}
+		remotePaths[file.Path] = remotePath
+		items = append(items, item)
+	}
+
+	if flatten != nil && !dryRun {
+		m.uploadFlattenMap(ctx, run, flatten)
+	}
+
+	if mode := run.advanced.SyncMode; mode == config.SyncPull || mode == config.SyncBidirectional {
+		return res, m.reconcile(ctx, run, scn, append(items, links...), folders, dryRun)
 	}
 
 	if dryRun {
-		return m.pcloudClient.DryRun(ctx, sourcePath)
+		report, err := m.previewSync(ctx, run, files)
+		res.dryRun = report
+		if err != nil {
+			return res, err
+		}
+		logDryRun(run.tag, report, run.advanced.SkipExisting)
+		return res, nil
+	}
+
+	if run.advanced.SkipExisting && run.advanced.DetectClockSkew {
+		skew, err := estimateClockSkew(ctx, p)
+		if err != nil {
+			utils.LogError("Clock skew detection failed for %s, comparing timestamps as-is: %v", p.Name(), err)
+			run.warn(clockProbeName, err)
+		} else if skew != 0 {
+			utils.LogInfo("Detected clock skew with %s: provider is %s ahead of local clock", p.Name(), skew)
+			run.clockSkew = skew
+		} else {
+			utils.LogVerbose("No significant clock skew detected with %s", p.Name())
+		}
+	}
+
+	total := len(items) + len(links)
+
+	if err := m.createFolders(ctx, run, folders); err != nil {
+		return res, run.budgetStop(err, total)
+	}
+
+	// Small files of a folder travel together in one archive
+	var packs []filePack
+	if run.advanced.PackSmallFiles {
+		items, packs = splitPacks(items, links, m.config.GetPackThreshold())
+	}
+
+	// Turn local renames into remote renames before uploading anything
+	before := len(items)
+	items = m.applyMoves(ctx, run, items)
+	run.processed.Add(int64(before - len(items)))
+
+	// Transfer file contents on the upload pool
+	err = runPool(ctx, m.config.GetUploadConcurrency(), items, func(ctx context.Context, item syncItem) error {
+		return run.recordFailure(ctx, item, m.syncFile(ctx, run, item.file, item.remotePath))
+	})
+	if err != nil {
+		return res, run.budgetStop(err, total)
+	}
+
+	err = runPool(ctx, m.config.GetUploadConcurrency(), packs, func(ctx context.Context, pack filePack) error {
+		packErr := m.syncPack(ctx, run, pack)
+		for _, item := range pack.items {
+			if err := run.recordFailure(ctx, item, packErr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return res, run.budgetStop(err, total)
+	}
+
+	// Hardlinks go last so the content they share is already remote
+	err = runPool(ctx, m.config.GetUploadConcurrency(), links, func(ctx context.Context, item syncItem) error {
+		return run.recordFailure(ctx, item, m.syncLink(ctx, run, item, remotePaths[item.file.HardlinkOf]))
+	})
+	if err != nil {
+		return res, run.budgetStop(err, total)
+	}
+
+	if run.advanced.DeleteRemoved && run.partial {
+		if err := m.deleteMissing(ctx, run, paths); err != nil {
+			return res, run.budgetStop(err, total)
+		}
+	} else if run.advanced.DeleteRemoved {
+		if err := m.deleteRemoved(ctx, run, files); err != nil {
+			return res, run.budgetStop(err, total)
+		}
+	}
+
+	if len(run.failed) > 0 {
+		utils.LogError("[%s] %d of %d files failed to sync", run.tag, len(run.failed), total)
+	} else {
+		utils.LogVerbose("[%s] 0 of %d files failed to sync", run.tag, total)
+	}
+	return res, run.failureError(total, run.advanced.FailOnAnyError)
+}
+
+// createFolders creates remote folders level by level on the metadata pool
+// so parents always exist before their children and no folder is created
+// twice concurrently
+func (m *Manager) createFolders(ctx context.Context, run *syncRun, folders map[string]bool) error {
+	for _, level := range folderLevels(folders) {
+		err := runPool(ctx, m.config.GetMetadataConcurrency(), level, func(ctx context.Context, folder string) error {
+			err := run.retry(ctx, "create folder "+folder, func() error {
+				return run.provider.CreateFolder(ctx, folder)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create folder %s: %w", folder, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadUploadSessions returns the interrupted upload sessions, kept next to
+// the state file (or only in memory without one)
+func (m *Manager) loadUploadSessions() (*uploadSessions, error) {
+	if m.uploadSessions != nil {
+		return m.uploadSessions, nil
+	}
+
+	var sessionsPath string
+	if statePath := m.config.GetAdvanced().StatePath; statePath != "" {
+		sessionsPath = statePath + ".uploads"
+	}
+
+	sessions, err := loadUploadSessions(sessionsPath)
+	if err != nil {
+		return nil, err
+	}
+	m.uploadSessions = sessions
+	return sessions, nil
+}
+
+// hashCachePath returns where the content hash cache is kept, next to the state file
+func hashCachePath(statePath string) string {
+	return statePath + ".hashes"
+}
+
+// syncLink syncs a hardlink to an already synced file. Providers with
+// server-side copy duplicate the remote copy instead of uploading the
+// same content again.
+func (m *Manager) syncLink(ctx context.Context, run *syncRun, item syncItem, srcRemotePath string) error {
+	if !run.provider.Capabilities().Supports(FeatureServerSideCopy) {
+		return m.syncFile(ctx, run, item.file, item.remotePath)
+	}
+
+	if run.advanced.SkipExisting {
+		upload, err := shouldUpload(ctx, run, item.file, item.remotePath)
+		if err != nil {
+			return err
+		}
+		if !upload {
+			utils.LogVerbose("Skipping unchanged file: %s", item.remotePath)
+			run.skip(item.file, scanner.SkipUnchanged)
+			return nil
+		}
+	}
+
+	if err := run.provider.Copy(ctx, srcRemotePath, item.remotePath); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", item.file.Path, err)
+	}
+	if algo := run.advanced.ChecksumSidecars; algo != "" {
+		if err := uploadSidecar(ctx, run.provider, item.file, item.remotePath, algo); err != nil {
+			return fmt.Errorf("failed to copy checksum for %s: %w", item.file.Path, err)
+		}
+	}
+	utils.LogInfo("[%s] ✓ %s (copy of %s)", run.tag, item.remotePath, srcRemotePath)
+
+	if run.state != nil {
+		run.state.Set(run.name, item.file, item.remotePath)
+	}
+
+	return nil
+}
+
+// syncItem is a scanned file paired with its mapped remote path
+type syncItem struct {
+	file       scanner.FileInfo
+	remotePath string
+}
+
+// addFolder records a remote folder and all of its ancestors
+func addFolder(folders map[string]bool, dir string) {
+	for dir != "." && dir != "/" && dir != "" && !folders[dir] {
+		folders[dir] = true
+		dir = path.Dir(dir)
+	}
+}
+
+// folderLevels groups folders by depth, shallowest first
+func folderLevels(folders map[string]bool) [][]string {
+	var levels [][]string
+	for folder := range folders {
+		depth := strings.Count(strings.Trim(folder, "/"), "/")
+		for len(levels) <= depth {
+			levels = append(levels, nil)
+		}
+		levels[depth] = append(levels[depth], folder)
+	}
+	for _, level := range levels {
+		sort.Strings(level)
+	}
+	return levels
+}
+
+// syncFile uploads a single file, or only its metadata when the content is
+// unchanged since the last run and just the mode or owner differs
+func (m *Manager) syncFile(ctx context.Context, run *syncRun, file scanner.FileInfo, remotePath string) error {
+	p := run.provider
+
+	if run.state != nil && run.advanced.PreservePermissions {
+		if entry, ok := run.state.Get(run.name, file.Path); ok && metadataOnlyChange(entry, file) {
+			switch run.advanced.PermissionChanges {
+			case "ignore":
+				utils.LogVerbose("Ignoring permission-only change: %s", file.Path)
+				run.skip(file, scanner.SkipUnchanged)
+				return nil
+			case "reupload":
+				// Fall through to a full upload below
+			default:
+				if err := RequireFeature(p, FeatureMetadata); err != nil {
+					utils.LogVerbose("Skipping permission-only change for %s: %v", file.Path, err)
+					run.skip(file, scanner.SkipUnchanged)
+					return nil
+				}
+				if err := p.UpdateMetadata(ctx, file, remotePath); err != nil {
+					return fmt.Errorf("failed to update metadata for %s: %w", file.Path, err)
+				}
+				utils.LogInfo("[%s] ✓ %s (metadata only)", run.tag, remotePath)
+				run.state.Set(run.name, file, remotePath)
+				return nil
+			}
+		}
+	}
+
+	if run.advanced.SkipExisting && run.state != nil {
+		// Unchanged since the last sync: no need to ask the provider
+		if entry, ok := run.state.Get(run.name, file.Path); ok && stateUnchanged(entry, file, remotePath, run.advanced.PreservePermissions) {
+			utils.LogVerbose("Skipping file unchanged since last sync: %s", remotePath)
+			run.skip(file, scanner.SkipUnchanged)
+			return nil
+		}
+	}
+
+	if run.advanced.SkipExisting {
+		upload, err := shouldUpload(ctx, run, file, remotePath)
+		if err != nil {
+			return err
+		}
+		if !upload {
+			utils.LogVerbose("Skipping unchanged file: %s", remotePath)
+			run.skip(file, scanner.SkipUnchanged)
+			return nil
+		}
+	}
+
+	if run.stabilize > 0 {
+		stable, err := fileStable(ctx, file, run.stabilize)
+		if err != nil {
+			return err
+		}
+		if !stable {
+			utils.LogVerbose("Skipping file still being written, retrying next pass: %s", file.Path)
+			run.skip(file, scanner.SkipInProgress)
+			return nil
+		}
+	}
+
+	utils.LogInfo("[%s] → %s (%d bytes)", run.tag, remotePath, file.Size)
+	if err := run.uploadVerified(ctx, file, remotePath); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", file.Path, err)
+	}
+
+	if run.advanced.PreservePermissions && p.Capabilities().Supports(FeatureMetadata) {
+		if err := p.UpdateMetadata(ctx, file, remotePath); err != nil {
+			return fmt.Errorf("failed to store metadata for %s: %w", file.Path, err)
+		}
+	}
+	if algo := run.advanced.ChecksumSidecars; algo != "" {
+		if err := uploadSidecar(ctx, p, file, remotePath, algo); err != nil {
+			return fmt.Errorf("failed to upload checksum for %s: %w", file.Path, err)
+		}
+	}
+	utils.LogInfo("[%s] ✓ %s (%d bytes)", run.tag, remotePath, file.Size)
+	run.upload(file)
+
+	if run.state != nil {
+		run.state.Set(run.name, file, remotePath)
+	}
+
+	return nil
+}
+
+// fileStable reports whether a file is done being written: it must still
+// match the scan and then not change in size or modification time for
+// window
+func fileStable(ctx context.Context, file scanner.FileInfo, window time.Duration) (bool, error) {
+	before, err := os.Stat(file.AbsolutePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", file.Path, err)
+	}
+	if before.Size() != file.Size || !before.ModTime().Equal(file.ModTime) {
+		return false, nil
+	}
+
+	timer := time.NewTimer(window)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return false, ctx.Err()
+	case <-timer.C:
+	}
+
+	after, err := os.Stat(file.AbsolutePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", file.Path, err)
+	}
+	return after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()), nil
+}
+
+// shouldUpload decides whether a file needs uploading: it does when there
+// is no remote copy, or when remoteDiffers finds the copy out of date
+func shouldUpload(ctx context.Context, run *syncRun, file scanner.FileInfo, remotePath string) (bool, error) {
+	remote, err := run.provider.GetFileInfo(ctx, remotePath)
+	if err != nil {
+		// Missing files (or folders) simply need uploading
+		utils.LogDebug("shouldUpload: no remote copy of %s: %v", remotePath, err)
+		return true, nil
+	}
+	return remoteDiffers(run, file, remote), nil
+}
+
+// hashesWhileUploading reports whether files are left for uploads to hash
+// as they read them: hash_during_upload is set and nothing compares files
+// with their remote copies or a previous sync before uploading them
+func (m *Manager) hashesWhileUploading() bool {
+	advanced := m.config.GetAdvanced()
+	if !advanced.HashDuringUpload {
+		return false
+	}
+	if advanced.SkipExisting || advanced.StatePath != "" || advanced.ChecksumSidecars != "" || advanced.PackSmallFiles ||
+		(advanced.SyncMode != "" && advanced.SyncMode != config.SyncPush) {
+		utils.LogVerbose("hash_during_upload doesn't apply with skip_existing, state_path, checksum_sidecars, pack_small_files or pull syncs, hashing while scanning")
+		return false
+	}
+	return true
+}
+
+// hashAlgorithm returns the configured hash algorithm or, when none is set,
+// the first one p reports so unchanged files are recognized by content
+func (m *Manager) hashAlgorithm(p Provider) string {
+	if m.config.General.HashAlgorithm == "" {
+		if hashes := p.Capabilities().Hashes; len(hashes) > 0 {
+			return string(hashes[0])
+		}
+	}
+	return m.config.GetHashAlgorithm()
+}
+
+// remoteDiffers reports whether remote is out of date with file. Copies of
+// a different size differ. When the provider reports a hash of the content
+// with the scanner's algorithm they differ if the hashes do; otherwise the
+// copy is out of date when the local file is newer, after correcting the
+// remote timestamp for any detected clock skew. Times are compared to the
+// second, as pCloud keeps no finer modification times.
+func remoteDiffers(run *syncRun, file scanner.FileInfo, remote *RemoteFileInfo) bool {
+	if remote.Size != SizeUnknown && remote.Size != file.Size {
+		return true
+	}
+	// Empty files have no hash to compare, but can't differ either
+	if remote.Size == 0 && file.Size == 0 {
+		return false
+	}
+
+	algo := HashAlgorithm(file.HashAlgorithm)
+	if sum := remote.Checksum(algo); sum != "" && file.Checksum != "" && run.provider.Capabilities().ReportsHash(algo) {
+		return !strings.EqualFold(sum, file.Checksum)
+	}
+
+	remoteTime, err := parseRemoteTime(remote.Modified)
+	if err != nil {
+		utils.LogDebug("remoteDiffers: %s: %v", remote.Path, err)
+		return true
 	}
 
-	return m.pcloudClient.Sync(ctx, sourcePath)
+	return file.ModTime.Truncate(time.Second).After(remoteTime.Add(-run.clockSkew))
 }
@@ -0,0 +1,354 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestSetProfile(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.General.SourcePath = "/home/me/documents"
+	cfg.General.Provider = "gdrive"
+	cfg.GoogleDrive.DestinationPath = "/backups/documents"
+	cfg.Profiles = map[string]config.ProfileConfig{
+		"photos": {
+			SourcePath:  "/home/me/photos",
+			GoogleDrive: config.FolderDestination{FolderID: "photos-folder"},
+		},
+	}
+
+	m := NewManager(cfg)
+	if err := m.SetProfile("photos"); err != nil {
+		t.Fatalf("SetProfile failed: %v", err)
+	}
+
+	got := m.GetConfig()
+	if got.General.SourcePath != "/home/me/photos" || got.General.Provider != "gdrive" {
+		t.Errorf("Expected the profile's source and the top-level provider, got %q and %q",
+			got.General.SourcePath, got.General.Provider)
+	}
+	if got.GoogleDrive.FolderID != "photos-folder" || got.GoogleDrive.DestinationPath != "" {
+		t.Errorf("Expected the profile's folder to replace the destination path, got %+v", got.GoogleDrive)
+	}
+	if cfg.General.SourcePath != "/home/me/documents" {
+		t.Errorf("SetProfile changed the loaded config: %q", cfg.General.SourcePath)
+	}
+	if key := m.stateKey("gdrive"); key != "photos/gdrive" {
+		t.Errorf("Expected state key photos/gdrive, got %q", key)
+	}
+
+	if err := m.SetProfile("music"); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+
+	if err := m.SetProfile(""); err != nil || m.GetConfig() != cfg {
+		t.Errorf("Expected an empty name to restore the loaded config (err %v)", err)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.GoogleDrive.DestinationPath = "/backups"
+	cfg.Profiles = map[string]config.ProfileConfig{
+		"photos": {SourcePath: "/home/me/photos"},
+	}
+
+	m := NewManager(cfg)
+	if err := m.SetProfile("photos"); err != nil {
+		t.Fatalf("SetProfile failed: %v", err)
+	}
+	m.providers["gdrive"] = nil
+	m.providers["s3"] = nil
+
+	reloaded := config.DefaultConfig()
+	reloaded.GoogleDrive.DestinationPath = "/backups/new"
+	reloaded.Profiles = cfg.Profiles
+	if err := m.SetConfig(reloaded); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+
+	if _, ok := m.providers["gdrive"]; ok {
+		t.Error("Expected the Google Drive provider to be rebuilt after its settings changed")
+	}
+	if _, ok := m.providers["s3"]; !ok {
+		t.Error("Expected the unchanged S3 provider to be kept")
+	}
+	got := m.GetConfig()
+	if got.General.SourcePath != "/home/me/photos" || got.GoogleDrive.DestinationPath != "/backups/new" {
+		t.Errorf("Expected the profile applied to the new config, got %q and %q",
+			got.General.SourcePath, got.GoogleDrive.DestinationPath)
+	}
+
+	if err := m.SetConfig(config.DefaultConfig()); err == nil {
+		t.Error("Expected an error when the selected profile is gone")
+	}
+	if m.GetConfig() != got {
+		t.Error("A failed SetConfig changed the configuration")
+	}
+}
+
+func TestFileStable(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "video.mp4")
+	os.WriteFile(localPath, []byte("frame"), 0644)
+	info, _ := os.Stat(localPath)
+	file := scanner.FileInfo{Path: "video.mp4", AbsolutePath: localPath, Size: info.Size(), ModTime: info.ModTime()}
+
+	if stable, err := fileStable(context.Background(), file, 10*time.Millisecond); err != nil || !stable {
+		t.Errorf("Expected an untouched file to be stable, got %v, %v", stable, err)
+	}
+
+	// Still growing while the window passes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(20 * time.Millisecond)
+		f, _ := os.OpenFile(localPath, os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString("frame")
+		f.Close()
+	}()
+	if stable, err := fileStable(context.Background(), file, 100*time.Millisecond); err != nil || stable {
+		t.Errorf("Expected a file written during the window to be unstable, got %v, %v", stable, err)
+	}
+	<-done
+
+	// Changed since the scan
+	if stable, _ := fileStable(context.Background(), file, 0); stable {
+		t.Error("Expected a file that changed since the scan to be unstable")
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	var created int
+	RegisterProvider("test-listing", ProviderFactory{
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			created++
+			return &listingProvider{}, nil
+		},
+		Configured: func(*config.Config) bool { return false },
+	})
+
+	m := NewManager(config.DefaultConfig())
+	for range 2 {
+		if p, err := m.provider(context.Background(), "test-listing"); err != nil || p.Name() != "Test" {
+			t.Fatalf("Expected the registered provider, got %v, %v", p, err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected the provider to be created once, got %d", created)
+	}
+	for _, name := range m.ConfiguredProviders() {
+		if name == "test-listing" {
+			t.Error("Expected an unconfigured provider to be left out of all")
+		}
+	}
+	if _, err := m.provider(context.Background(), "missing"); !IsPermanent(err) {
+		t.Errorf("Expected a permanent error for an unknown provider, got %v", err)
+	}
+}
+
+func TestConfiguredProviders(t *testing.T) {
+	// "all" syncs to exactly the providers validation checks
+	cfg := config.DefaultConfig()
+	cfg.General.Provider = "all"
+	cfg.GoogleDrive = config.GoogleDriveConfig{}
+	cfg.S3 = config.S3Config{Bucket: "backups", Region: "eu-west-1"}
+	cfg.SFTP = config.SFTPConfig{Host: "backup.example.com", User: "me", Password: "secret", RemoteBasePath: "/srv/backups"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected the config to be valid, got %v", err)
+	}
+	if got := NewManager(cfg).ConfiguredProviders(); !slices.Equal(got, []string{"s3", "sftp"}) {
+		t.Errorf("Expected s3 and sftp, got %v", got)
+	}
+
+	cfg.GoogleDrive = config.GoogleDriveConfig{CredentialsPath: "credentials.json", TokenPath: "token.json"}
+	cfg.PCloud = config.PCloudConfig{Username: "me@example.com", Password: "secret"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected the config to be valid, got %v", err)
+	}
+	if got := NewManager(cfg).ConfiguredProviders(); !slices.Contains(got, "gdrive") || !slices.Contains(got, "pcloud") {
+		t.Errorf("Expected Google Drive and pCloud to be included, got %v", got)
+	}
+}
+
+func TestRemotePathSeparators(t *testing.T) {
+	// Windows paths lose their backslashes; elsewhere a backslash is part of a name
+	if got := replaceSeparator(`docs\2024\a.txt`, '\\'); got != "docs/2024/a.txt" {
+		t.Errorf("Expected docs/2024/a.txt, got %q", got)
+	}
+	if got := replaceSeparator(`odd\name.txt`, '/'); got != `odd\name.txt` {
+		t.Errorf("Expected the name to be kept, got %q", got)
+	}
+
+	manager := NewManager(&config.Config{})
+	manager.SetPathMapper(func(localRelPath string, info scanner.FileInfo) string {
+		return filepath.Join("archive", filepath.FromSlash(localRelPath))
+	}, nil)
+	if got := manager.RemotePathFor(scanner.FileInfo{Path: "docs/a.txt"}); got != "archive/docs/a.txt" {
+		t.Errorf("Expected archive/docs/a.txt, got %q", got)
+	}
+}
+
+func TestSyncPathsMovesRenamedFiles(t *testing.T) {
+	ctx := context.Background()
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "a.txt"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(source, "c.txt"), []byte("one"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(source, "a.txt"), old, old)
+	os.Chtimes(filepath.Join(source, "c.txt"), old, old)
+
+	// No state file: the renames alone say where the files came from
+	cfg := &config.Config{}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{DeleteRemoved: true}}
+	manager := NewManager(cfg)
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+	provider, err := newWebDAVProvider(ctx, &config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager.providers["webdav"] = provider
+
+	if err := manager.Sync(ctx, "webdav", source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	// b.txt is a.txt renamed and is moved; d.txt changed after its rename
+	// and is uploaded over the moved copy
+	os.Rename(filepath.Join(source, "a.txt"), filepath.Join(source, "b.txt"))
+	os.Rename(filepath.Join(source, "c.txt"), filepath.Join(source, "d.txt"))
+	os.WriteFile(filepath.Join(source, "d.txt"), []byte("changed"), 0644)
+	paths := []string{"a.txt", "b.txt", "c.txt", "d.txt"}
+	renames := map[string]string{"b.txt": "a.txt", "d.txt": "c.txt"}
+	if err := manager.SyncPaths(ctx, "webdav", source, paths, renames); err != nil {
+		t.Fatalf("Failed to sync paths: %v", err)
+	}
+	if uploaded := manager.LastSummary().Uploaded; uploaded != 1 {
+		t.Errorf("Expected only d.txt to be uploaded, got %d uploads", uploaded)
+	}
+	for name, size := range map[string]int64{"b.txt": 5, "d.txt": 7} {
+		if info, err := provider.GetFileInfo(ctx, name); err != nil || info.Size != size {
+			t.Errorf("Expected remote %s of %d bytes, got %+v, %v", name, size, info, err)
+		}
+	}
+	for _, name := range []string{"a.txt", "c.txt"} {
+		if _, err := provider.GetFileInfo(ctx, name); err == nil {
+			t.Errorf("Expected remote %s to be gone", name)
+		}
+	}
+}
+
+func TestSyncHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Hooks in this test are sh commands")
+	}
+	ctx := context.Background()
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "a.txt"), []byte("hello"), 0644)
+	out := filepath.Join(t.TempDir(), "hooks.log")
+
+	cfg := &config.Config{}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		PreSyncHook:  `echo "pre $CSYNC_PROVIDER $CSYNC_SOURCE" >> ` + out,
+		PostSyncHook: `echo "post $CSYNC_RESULT $CSYNC_UPLOADED $CSYNC_FAILED $CSYNC_BYTES" >> ` + out,
+	}}
+	manager := NewManager(cfg)
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+	provider, err := newWebDAVProvider(ctx, &config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager.providers["webdav"] = provider
+
+	if err := manager.Sync(ctx, "webdav", source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	data, _ := os.ReadFile(out)
+	if want := "pre webdav " + source + "\npost success 1 0 5\n"; string(data) != want {
+		t.Errorf("Expected hooks to log %q, got %q", want, data)
+	}
+
+	// A failing pre-sync hook aborts the sync, and the post-sync hook hears of it
+	os.Remove(out)
+	os.WriteFile(filepath.Join(source, "b.txt"), []byte("world"), 0644)
+	cfg.Optional.Advanced.PreSyncHook = "echo database busy; exit 3"
+	err = manager.Sync(ctx, "webdav", source, false)
+	if err == nil || !strings.Contains(err.Error(), "pre-sync hook failed") || !strings.Contains(err.Error(), "database busy") {
+		t.Fatalf("Expected the pre-sync hook to abort the sync, got %v", err)
+	}
+	if _, err := provider.GetFileInfo(ctx, "b.txt"); err == nil {
+		t.Error("Expected b.txt not to be uploaded")
+	}
+	if data, _ := os.ReadFile(out); string(data) != "post failure 0 0 0\n" {
+		t.Errorf("Expected the post-sync hook to report the failure, got %q", data)
+	}
+
+	// A failing post-sync hook doesn't fail the sync
+	cfg.Optional.Advanced.PreSyncHook = ""
+	cfg.Optional.Advanced.PostSyncHook = "exit 1"
+	result, err := manager.SyncWithResult(ctx, "webdav", source, false)
+	if err != nil {
+		t.Errorf("Expected a failing post-sync hook to be ignored, got %v", err)
+	}
+	if result.Provider != "webdav" || result.Uploaded != 2 || result.BytesTransferred != 10 {
+		t.Errorf("Expected both files in the result, got %+v", result)
+	}
+}
+
+func TestSyncWithOptions(t *testing.T) {
+	ctx := context.Background()
+	source := t.TempDir()
+	for _, name := range []string{"top.txt", "docs/a.md", "docs/b.txt", "docs/old/c.md", "photos/d.jpg"} {
+		os.MkdirAll(filepath.Join(source, filepath.Dir(name)), 0755)
+		os.WriteFile(filepath.Join(source, name), []byte(name), 0644)
+	}
+
+	cfg := &config.Config{}
+	cfg.General.IgnorePatterns = []string{"*.txt"}
+	manager := NewManager(cfg)
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+	provider, err := newWebDAVProvider(ctx, &config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager.providers["webdav"] = provider
+
+	// Only docs is synced, to the same remote paths a full sync uses, and
+	// the ad-hoc patterns compose with ignore_patterns
+	opts := SyncOptions{Subpath: "docs", Include: []string{"*.md"}, Exclude: []string{"old/"}}
+	result, err := manager.SyncWithOptions(ctx, "webdav", source, opts)
+	if err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if result.Uploaded != 1 {
+		t.Errorf("Expected only docs/a.md to be uploaded, got %+v", result)
+	}
+	if _, err := provider.GetFileInfo(ctx, "docs/a.md"); err != nil {
+		t.Errorf("Expected docs/a.md on the remote: %v", err)
+	}
+	for _, name := range []string{"docs/b.txt", "docs/old/c.md", "photos/d.jpg"} {
+		if _, err := provider.GetFileInfo(ctx, name); err == nil {
+			t.Errorf("Expected %s not to be synced", name)
+		}
+	}
+
+	for _, opts := range []SyncOptions{{Subpath: "../elsewhere"}, {Subpath: "missing"}, {Exclude: []string{"!keep.md"}}} {
+		if _, err := manager.SyncWithOptions(ctx, "webdav", source, opts); err == nil {
+			t.Errorf("Expected %+v to be rejected", opts)
+		}
+	}
+}
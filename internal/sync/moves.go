@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// syncMove is a new local file whose content was previously synced from a
+// path that no longer exists locally
+type syncMove struct {
+	item     syncItem
+	from     string     // Previous source-relative path
+	old      StateEntry // What was synced from that path
+	reported bool       // Reported renamed rather than matched by content
+}
+
+// detectMoves pairs files that disappeared since the last run with new files
+// of identical content. Only unambiguous pairs are returned: a hash shared by
+// several vanished or several new files could be a copy rather than a move.
+func detectMoves(state *SyncState, provider string, items []syncItem) []syncMove {
+	entries := state.Entries(provider)
+
+	current := make(map[string]bool, len(items))
+	for _, item := range items {
+		current[item.file.Path] = true
+	}
+
+	// Vanished files by content hash
+	vanished := make(map[string][]string)
+	for path, entry := range entries {
+		if sum := entry.checksum(); !current[path] && sum != "" {
+			vanished[sum] = append(vanished[sum], path)
+		}
+	}
+	if len(vanished) == 0 {
+		return nil
+	}
+
+	// New files by content hash
+	added := make(map[string][]syncItem)
+	for _, item := range items {
+		if _, known := entries[item.file.Path]; known || item.file.Checksum == "" {
+			continue
+		}
+		added[item.file.Checksum] = append(added[item.file.Checksum], item)
+	}
+
+	var moves []syncMove
+	for hash, paths := range vanished {
+		candidates := added[hash]
+		if len(candidates) == 0 {
+			continue
+		}
+		if len(paths) > 1 || len(candidates) > 1 {
+			utils.LogVerbose("Ambiguous move for content %s (%d old, %d new paths), uploading instead", hash, len(paths), len(candidates))
+			continue
+		}
+		old := entries[paths[0]]
+		if old.Size != candidates[0].file.Size {
+			continue
+		}
+		moves = append(moves, syncMove{item: candidates[0], from: paths[0], old: old})
+	}
+
+	return moves
+}
+
+// reportedMoves turns renames reported for the run, such as the file
+// watcher's, into moves for the items not already in moves. Nothing shows
+// what was synced from the old path, so the remote copy is assumed to be
+// the file's until checked after the move.
+func (m *Manager) reportedMoves(run *syncRun, items []syncItem, moves []syncMove) []syncMove {
+	if len(run.renames) == 0 {
+		return moves
+	}
+	detected := make(map[string]bool, len(moves))
+	for _, mv := range moves {
+		detected[mv.item.file.Path] = true
+	}
+
+	for _, item := range items {
+		from, ok := run.renames[item.file.Path]
+		if !ok || detected[item.file.Path] {
+			continue
+		}
+		old := item.file
+		old.Path = from
+		moves = append(moves, syncMove{item: item, from: from, old: StateEntry{RemotePath: m.RemotePathFor(old)}, reported: true})
+	}
+	return moves
+}
+
+// vanishedMoves drops moves whose source still exists locally. A partial
+// sync only scans some paths, so files outside them look vanished to
+// detectMoves without being gone.
+func vanishedMoves(sourcePath string, moves []syncMove) []syncMove {
+	kept := moves[:0]
+	for _, mv := range moves {
+		if _, err := os.Lstat(filepath.Join(sourcePath, filepath.FromSlash(mv.from))); os.IsNotExist(err) {
+			kept = append(kept, mv)
+		}
+	}
+	return kept
+}
+
+// applyMoves renames remote files for detected and reported local moves and
+// returns the items that still need uploading. A failed move falls back to
+// an upload, as does a reported move whose remote copy turns out to differ
+// from the file.
+func (m *Manager) applyMoves(ctx context.Context, run *syncRun, items []syncItem) []syncItem {
+	if (run.state == nil && len(run.renames) == 0) || !run.advanced.DeleteRemoved {
+		return items
+	}
+	if err := RequireFeature(run.provider, FeatureAtomicRename); err != nil {
+		utils.LogVerbose("Move detection disabled: %v", err)
+		return items
+	}
+
+	var moves []syncMove
+	if run.state != nil {
+		moves = detectMoves(run.state, run.name, items)
+	}
+	moves = m.reportedMoves(run, items, moves)
+	if run.partial {
+		moves = vanishedMoves(run.source, moves)
+	}
+	if len(moves) == 0 {
+		return items
+	}
+
+	moved := make(map[string]bool, len(moves))
+	for _, mv := range moves {
+		if ctx.Err() != nil {
+			break
+		}
+
+		from := mv.old.RemotePath
+		if from == "" {
+			from = mv.from
+		}
+		if isPack(from) {
+			continue // Moving the pack would move the whole folder's small files
+		}
+
+		if err := run.provider.Move(ctx, from, mv.item.remotePath); err != nil {
+			if mv.reported {
+				// The old name may never have been synced
+				utils.LogVerbose("Failed to move %s to %s, uploading instead: %v", from, mv.item.remotePath, err)
+				continue
+			}
+			utils.LogError("Failed to move %s to %s, uploading instead: %v", from, mv.item.remotePath, err)
+			run.warn(mv.item.file.Path, err)
+			continue
+		}
+		if run.state != nil {
+			run.state.Delete(run.name, mv.from)
+		}
+		if run.movedFrom == nil {
+			run.movedFrom = make(map[string]bool)
+		}
+		run.movedFrom[mv.from] = true
+		if mv.reported {
+			upload, err := shouldUpload(ctx, run, mv.item.file, mv.item.remotePath)
+			if err != nil || upload {
+				utils.LogVerbose("Moved %s to %s, but it differs from the local file, uploading", from, mv.item.remotePath)
+				continue
+			}
+		}
+		utils.LogInfo("[%s] ✓ %s (moved from %s)", run.tag, mv.item.remotePath, from)
+
+		// The sidecar records the file name, so write a fresh one
+		if algo := run.advanced.ChecksumSidecars; algo != "" {
+			if err := uploadSidecar(ctx, run.provider, mv.item.file, mv.item.remotePath, algo); err != nil {
+				utils.LogError("Failed to upload checksum for %s: %v", mv.item.remotePath, err)
+				run.warn(mv.item.file.Path, err)
+			}
+			if err := run.provider.Delete(ctx, from+"."+algo); err != nil {
+				utils.LogVerbose("Failed to delete old checksum %s: %v", from+"."+algo, err)
+			}
+		}
+
+		if run.state != nil {
+			run.state.Set(run.name, mv.item.file, mv.item.remotePath)
+		}
+		moved[mv.item.file.Path] = true
+	}
+
+	remaining := items[:0:0]
+	for _, item := range items {
+		if !moved[item.file.Path] {
+			remaining = append(remaining, item)
+		}
+	}
+	return remaining
+}
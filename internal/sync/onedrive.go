@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	onedriveclient "github.com/svosadtsia/csync/internal/providers/onedrive"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+)
+
+// OneDriveProvider implements the Provider interface for Microsoft OneDrive
+type OneDriveProvider struct {
+	client *onedriveclient.Client
+}
+
+// NewOneDriveProvider creates a new OneDrive provider
+func NewOneDriveProvider(ctx context.Context, cfg *config.OneDriveConfig) (*OneDriveProvider, error) {
+	return newOneDriveProvider(ctx, cfg, nil)
+}
+
+func init() {
+	RegisterProvider("onedrive", ProviderFactory{
+		DisplayName: "OneDrive",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := newOneDriveProvider(ctx, &m.config.OneDrive, m.transport("onedrive"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create OneDrive client: %w", err)
+			}
+			return client, nil
+		},
+		Settings:    func(cfg *config.Config) any { return cfg.OneDrive },
+		Configured:  func(cfg *config.Config) bool { return cfg.OneDrive.ClientID != "" },
+		Destination: func(cfg *config.Config) string { return cfg.OneDrive.DestinationPath },
+	})
+}
+
+// newOneDriveProvider creates a OneDrive provider whose API requests go
+// through transport (nil for the default)
+func newOneDriveProvider(ctx context.Context, cfg *config.OneDriveConfig, transport http.RoundTripper) (*OneDriveProvider, error) {
+	client, err := onedriveclient.NewClientWithTransport(ctx, cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &OneDriveProvider{client: client}, nil
+}
+
+// Name returns the provider name
+func (p *OneDriveProvider) Name() string {
+	return "OneDrive"
+}
+
+// Capabilities reports the optional features OneDrive supports
+func (p *OneDriveProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		// No Hashes: business accounts only report quickXorHash
+		Versioning:     true,
+		AtomicRename:   true,
+		AtomicUpload:   true,
+		RangedDownload: true,
+	}
+}
+
+// Upload uploads a file to OneDrive, creating parent folders as needed
+func (p *OneDriveProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.client.Upload(ctx, file.AbsolutePath, remotePath)
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *OneDriveProvider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.client.SetUploadLimiter(limiter)
+}
+
+// CreateFolder creates a folder and any missing parents
+func (p *OneDriveProvider) CreateFolder(ctx context.Context, remotePath string) error {
+	return p.client.CreateFolder(ctx, remotePath)
+}
+
+// FileExists checks if a file exists in OneDrive
+func (p *OneDriveProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	item, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return false, err
+	}
+	return item != nil, nil
+}
+
+// GetFileInfo gets information about a file in OneDrive
+func (p *OneDriveProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	item, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	info := oneDriveFileInfo(*item)
+	return &info, nil
+}
+
+// Delete deletes a file or folder from OneDrive
+func (p *OneDriveProvider) Delete(ctx context.Context, remotePath string) error {
+	return p.client.Delete(ctx, remotePath)
+}
+
+// Copy is not supported: Graph copies run asynchronously and have to be
+// polled for completion
+func (p *OneDriveProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureServerSideCopy}
+}
+
+// Move moves or renames a file in OneDrive
+func (p *OneDriveProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Move(ctx, srcRemotePath, dstRemotePath)
+}
+
+// PublicLink is not supported
+func (p *OneDriveProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return "", &UnsupportedError{Provider: p.Name(), Feature: FeaturePublicLinks}
+}
+
+// List recursively lists items below remotePath ("" for the destination
+// root). Returned paths are relative to the destination root.
+func (p *OneDriveProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	items, err := p.client.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]RemoteFileInfo, 0, len(items))
+	for _, item := range items {
+		files = append(files, oneDriveFileInfo(item))
+	}
+	return files, nil
+}
+
+// Download writes the content of a file to localPath
+func (p *OneDriveProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of a file from offset onwards,
+// appending to the existing localPath
+func (p *OneDriveProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	body, err := p.client.Open(ctx, remotePath, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if offset == 0 {
+		return writeLocalFile(localPath, body)
+	}
+
+	return appendLocalFile(localPath, body)
+}
+
+// UpdateMetadata is not supported: OneDrive has no place for mode or
+// ownership
+func (p *OneDriveProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureMetadata}
+}
+
+// oneDriveFileInfo converts a OneDrive item to a RemoteFileInfo
+func oneDriveFileInfo(item onedriveclient.Item) RemoteFileInfo {
+	return RemoteFileInfo{
+		Path:     item.Path,
+		Size:     item.Size,
+		Modified: item.LastModified.UTC().Format(time.RFC3339Nano),
+		IsDir:    item.IsDir,
+	}
+}
@@ -0,0 +1,153 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// fakeGraph serves the part of the Microsoft Graph drive API the OneDrive
+// provider uses, keeping items by path
+type fakeGraph struct {
+	mu      gosync.Mutex
+	folders map[string]string // Folder IDs by path, "" for the root
+	files   map[string][]byte
+	chunks  []string // Content-Range of each upload session chunk
+}
+
+func (g *fakeGraph) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/v1.0/me/drive")
+	switch {
+	case p == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]string{"id": "drive"})
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		itemPath := strings.TrimPrefix(r.URL.Path, "/upload/")
+		body, _ := io.ReadAll(r.Body)
+		g.chunks = append(g.chunks, r.Header.Get("Content-Range"))
+		g.files[itemPath] = append(g.files[itemPath], body...)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(p, "/items/") && strings.HasSuffix(p, "/children") && r.Method == http.MethodPost:
+		parentID := strings.TrimSuffix(strings.TrimPrefix(p, "/items/"), "/children")
+		var request struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&request)
+		for folder, id := range g.folders {
+			if id != parentID {
+				continue
+			}
+			child := strings.TrimPrefix(folder+"/"+request.Name, "/")
+			if _, ok := g.folders[child]; ok {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"code": "nameAlreadyExists"}})
+				return
+			}
+			g.folders[child] = fmt.Sprintf("id%d", len(g.folders))
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"id": g.folders[child], "name": request.Name, "folder": map[string]any{}})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		// Path addressing: /root or /root:/a/b:[/action]
+		p = strings.TrimPrefix(p, "/root")
+		itemPath, action := "", ""
+		if strings.HasPrefix(p, ":/") {
+			itemPath, action, _ = strings.Cut(strings.TrimPrefix(p, ":/"), ":")
+		}
+		switch {
+		case action == "/content" && r.Method == http.MethodPut:
+			g.files[itemPath], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]any{"id": "file"})
+		case action == "/createUploadSession":
+			json.NewEncoder(w).Encode(map[string]string{"uploadUrl": "http://" + r.Host + "/upload/" + itemPath})
+		case action == "" && r.Method == http.MethodGet:
+			if id, ok := g.folders[itemPath]; ok {
+				json.NewEncoder(w).Encode(map[string]any{"id": id, "folder": map[string]any{}})
+			} else if data, ok := g.files[itemPath]; ok {
+				json.NewEncoder(w).Encode(map[string]any{"id": "file", "size": len(data)})
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+// rewriteTransport sends every request to a test server
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestOneDriveUpload(t *testing.T) {
+	graph := &fakeGraph{
+		folders: map[string]string{"": "root", "backups": "existing"},
+		files:   make(map[string][]byte),
+	}
+	server := httptest.NewServer(graph)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "token.json")
+	token, _ := json.Marshal(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)})
+	os.WriteFile(tokenPath, token, 0600)
+
+	cfg := &config.OneDriveConfig{ClientID: "client", TokenPath: tokenPath, DestinationPath: "/backups"}
+	provider, err := newOneDriveProvider(context.Background(), cfg, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	small := bytes.Repeat([]byte("a"), 1000)
+	large := bytes.Repeat([]byte("b"), 10<<20+5)
+	for name, data := range map[string][]byte{"docs/small.txt": small, "docs/large.bin": large} {
+		localPath := filepath.Join(dir, filepath.Base(name))
+		os.WriteFile(localPath, data, 0644)
+		if err := provider.Upload(context.Background(), scanner.FileInfo{AbsolutePath: localPath}, name); err != nil {
+			t.Fatalf("Failed to upload %s: %v", name, err)
+		}
+		if !bytes.Equal(graph.files["backups/"+name], data) {
+			t.Errorf("Unexpected content of %s: %d bytes", name, len(graph.files["backups/"+name]))
+		}
+	}
+
+	expected := []string{"bytes 0-10485759/10485765", "bytes 10485760-10485764/10485765"}
+	if fmt.Sprint(graph.chunks) != fmt.Sprint(expected) {
+		t.Errorf("Expected chunks %v, got %v", expected, graph.chunks)
+	}
+
+	// "backups" already existed; creating folders again succeeds
+	if err := provider.CreateFolder(context.Background(), "docs"); err != nil {
+		t.Errorf("Failed to create existing folder: %v", err)
+	}
+	info, err := provider.GetFileInfo(context.Background(), "docs/small.txt")
+	if err != nil || info.Size != int64(len(small)) {
+		t.Errorf("Unexpected file info %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SyncOptions narrows a single sync without changing the configuration.
+// The options only ever leave files out: they apply after ignore_patterns,
+// include_patterns, the pattern profile, ignore files and force_include.
+type SyncOptions struct {
+	// Subpath, a file or folder relative to the source, is the only part
+	// of it synced. Remote paths stay relative to the source, so its files
+	// land where a full sync would put them.
+	Subpath string
+
+	// Include patterns a file must match, besides the configured include
+	// patterns; empty keeps every file
+	Include []string
+
+	// Exclude patterns leave out matching files and folders, even ones
+	// force_include or a "!" rule would sync. They can't re-include files.
+	Exclude []string
+
+	DryRun bool
+}
+
+// SyncWithOptions syncs sourcePath to the named provider, or with "all" to
+// every configured one at once, narrowed by opts. The result is returned
+// with an error as well, counting what was done before the sync stopped.
+func (m *Manager) SyncWithOptions(ctx context.Context, providerName, sourcePath string, opts SyncOptions) (*SyncResult, error) {
+	var paths []string
+	if opts.Subpath != "" {
+		subpath, err := cleanSubpath(sourcePath, opts.Subpath)
+		if err != nil {
+			return nil, err
+		}
+		paths = []string{subpath}
+	}
+	for _, pattern := range opts.Exclude {
+		if strings.HasPrefix(pattern, "!") {
+			return nil, fmt.Errorf("exclude pattern %q can't re-include files", pattern)
+		}
+	}
+
+	m.options = opts
+	defer func() { m.options = SyncOptions{} }()
+
+	started := time.Now()
+	if providerName == "all" {
+		err := m.syncAll(ctx, sourcePath, paths, nil, opts.DryRun)
+		var reports []ProviderReport
+		for _, result := range m.results {
+			reports = append(reports, result.Report)
+		}
+		return NewSyncReport(started, reports...).Result(providerName), err
+	}
+
+	err := m.syncProvider(ctx, providerName, sourcePath, paths, nil, opts.DryRun)
+	return NewSyncReport(started, m.report).Result(providerName), err
+}
+
+// cleanSubpath returns subpath relative to sourcePath with forward slashes,
+// checking that it exists inside the source
+func cleanSubpath(sourcePath, subpath string) (string, error) {
+	subpath = filepath.Clean(filepath.FromSlash(subpath))
+	if filepath.IsAbs(subpath) {
+		rel, err := filepath.Rel(sourcePath, subpath)
+		if err != nil {
+			return "", fmt.Errorf("subpath %s is not inside %s", subpath, sourcePath)
+		}
+		subpath = rel
+	}
+	if subpath == ".." || strings.HasPrefix(subpath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("subpath %s is not inside %s", subpath, sourcePath)
+	}
+	if _, err := os.Stat(filepath.Join(sourcePath, subpath)); err != nil {
+		return "", fmt.Errorf("subpath %s: %w", subpath, err)
+	}
+	return filepath.ToSlash(subpath), nil
+}
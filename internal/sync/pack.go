@@ -0,0 +1,287 @@
+package sync
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+const (
+	// packName is the remote name of the archive bundling a folder's small files
+	packName = ".csync-pack.tar"
+
+	// packManifestName is the first member of every pack, listing the files in it
+	packManifestName = ".csync-manifest.json"
+)
+
+// packManifest describes the files bundled in a pack
+type packManifest struct {
+	Files []packEntry `json:"files"`
+}
+
+// packEntry is one file in a pack, stored under its remote base name
+type packEntry struct {
+	Name          string      `json:"name"`
+	Size          int64       `json:"size"`
+	ModTime       time.Time   `json:"mod_time"`
+	Mode          os.FileMode `json:"mode,omitempty"`
+	Checksum      string      `json:"checksum,omitempty"`
+	HashAlgorithm string      `json:"hash_algorithm,omitempty"`
+}
+
+// filePack is the small files of one remote folder, uploaded as one archive
+type filePack struct {
+	remotePath string
+	items      []syncItem
+}
+
+// isPack reports whether remotePath is a pack archive
+func isPack(remotePath string) bool {
+	return path.Base(remotePath) == packName
+}
+
+// packPath returns the remote path of the pack for a remote folder
+func packPath(dir string) string {
+	if dir == "." || dir == "" {
+		return packName
+	}
+	return dir + "/" + packName
+}
+
+// splitPacks takes the files of at most threshold bytes out of items and
+// groups them by remote folder. Folders with a single small file and files
+// other files are hardlinked to keep uploading file by file.
+func splitPacks(items, links []syncItem, threshold int64) ([]syncItem, []filePack) {
+	linked := make(map[string]bool, len(links))
+	for _, link := range links {
+		linked[link.file.HardlinkOf] = true
+	}
+
+	byFolder := make(map[string][]syncItem)
+	for _, item := range items {
+		if item.file.Size <= threshold && !linked[item.file.Path] {
+			dir := path.Dir(item.remotePath)
+			byFolder[dir] = append(byFolder[dir], item)
+		}
+	}
+
+	packed := make(map[string]bool)
+	var packs []filePack
+	for dir, members := range byFolder {
+		if len(members) < 2 {
+			continue
+		}
+		for _, item := range members {
+			packed[item.file.Path] = true
+		}
+		packs = append(packs, filePack{remotePath: packPath(dir), items: members})
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].remotePath < packs[j].remotePath })
+
+	rest := items[:0:0]
+	for _, item := range items {
+		if !packed[item.file.Path] {
+			rest = append(rest, item)
+		}
+	}
+	return rest, packs
+}
+
+// syncPack uploads the files of a pack as one archive, unless the state
+// shows none of them changed since the last run
+func (m *Manager) syncPack(ctx context.Context, run *syncRun, pack filePack) error {
+	if run.advanced.SkipExisting && run.state != nil && packUnchanged(run, pack) {
+		utils.LogVerbose("Skipping unchanged pack: %s", pack.remotePath)
+		for _, item := range pack.items {
+			run.skip(item.file, scanner.SkipUnchanged)
+		}
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "csync-*.tar")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = writePack(tmp, pack)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pack %s: %w", pack.remotePath, err)
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to stat pack: %w", err)
+	}
+	file := scanner.FileInfo{Path: pack.remotePath, AbsolutePath: tmp.Name(), Size: info.Size(), ModTime: time.Now()}
+
+	utils.LogInfo("[%s] → %s (%d files, %d bytes)", run.tag, pack.remotePath, len(pack.items), file.Size)
+	if err := run.uploadVerified(ctx, file, pack.remotePath); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", pack.remotePath, err)
+	}
+	utils.LogInfo("[%s] ✓ %s (%d files, %d bytes)", run.tag, pack.remotePath, len(pack.items), file.Size)
+
+	for _, item := range pack.items {
+		run.upload(item.file)
+		if run.state != nil {
+			run.state.Set(run.name, item.file, pack.remotePath)
+		}
+	}
+	return nil
+}
+
+// packUnchanged reports whether every file of a pack is recorded as synced
+// into it and unchanged since
+func packUnchanged(run *syncRun, pack filePack) bool {
+	for _, item := range pack.items {
+		entry, ok := run.state.Get(run.name, item.file.Path)
+		if !ok || !stateUnchanged(entry, item.file, pack.remotePath, run.advanced.PreservePermissions) {
+			return false
+		}
+	}
+	return true
+}
+
+// writePack writes the manifest and then every file of a pack as a tar archive
+func writePack(w io.Writer, pack filePack) error {
+	var manifest packManifest
+	for _, item := range pack.items {
+		manifest.Files = append(manifest.Files, packEntry{
+			Name:          path.Base(item.remotePath),
+			Size:          item.file.Size,
+			ModTime:       item.file.ModTime,
+			Mode:          item.file.Mode,
+			Checksum:      item.file.Checksum,
+			HashAlgorithm: item.file.HashAlgorithm,
+		})
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	header := &tar.Header{Name: packManifestName, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	for i, item := range pack.items {
+		entry := manifest.Files[i]
+		header := &tar.Header{Name: entry.Name, Mode: int64(entry.Mode.Perm()), Size: entry.Size, ModTime: entry.ModTime}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		f, err := os.Open(item.file.AbsolutePath)
+		if err != nil {
+			return err
+		}
+		// A file that changed size since the scan fails the pack
+		_, err = io.CopyN(tw, f, entry.Size)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", item.file.Path, err)
+		}
+	}
+	return tw.Close()
+}
+
+// restorePack downloads a pack and extracts the files in it below destDir,
+// through the same path mapping and filters as single files. It returns the
+// number of files restored.
+func (m *Manager) restorePack(ctx context.Context, p Provider, remotePath, destDir string, scn *scanner.Scanner) (int, error) {
+	tmp, err := os.CreateTemp("", "csync-*.tar")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := m.download(ctx, p, remotePath, tmp.Name()); err != nil {
+		return 0, err
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return 0, fmt.Errorf("failed to open pack: %w", err)
+	}
+	defer f.Close()
+
+	dir := path.Dir(remotePath)
+	tr := tar.NewReader(f)
+	manifest := make(map[string]packEntry)
+	restored := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return restored, nil
+		}
+		if err != nil {
+			return restored, fmt.Errorf("failed to read pack %s: %w", remotePath, err)
+		}
+
+		if header.Name == packManifestName {
+			var contents packManifest
+			if err := json.NewDecoder(tr).Decode(&contents); err != nil {
+				return restored, fmt.Errorf("failed to read manifest of %s: %w", remotePath, err)
+			}
+			for _, entry := range contents.Files {
+				manifest[entry.Name] = entry
+			}
+			continue
+		}
+
+		entry, ok := manifest[header.Name]
+		if !ok || strings.Contains(header.Name, "/") {
+			return restored, fmt.Errorf("pack %s has unexpected member %s", remotePath, header.Name)
+		}
+
+		member := header.Name
+		if dir != "." {
+			member = dir + "/" + header.Name
+		}
+		localPath, _ := m.LocalPathFor(member)
+		if skipped, ok := scn.Explain(localPath, false); ok {
+			utils.LogVerbose("Not restoring %s: %s", localPath, skipped.Reason)
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(localPath)) {
+			return restored, fmt.Errorf("refusing to restore %s outside %s", localPath, destDir)
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(localPath))
+		if err := writeLocalFile(target, tr); err != nil {
+			return restored, err
+		}
+		if entry.Checksum != "" {
+			sum, err := scanner.CalculateChecksum(target, entry.HashAlgorithm)
+			if err != nil {
+				return restored, err
+			}
+			if !strings.EqualFold(sum, entry.Checksum) {
+				return restored, fmt.Errorf("%s mismatch for %s in %s", entry.HashAlgorithm, header.Name, remotePath)
+			}
+		}
+		if entry.Mode != 0 {
+			os.Chmod(target, entry.Mode.Perm())
+		}
+		os.Chtimes(target, entry.ModTime, entry.ModTime)
+		restored++
+	}
+}
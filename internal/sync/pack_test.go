@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+func TestPackSmallFiles(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		PackSmallFiles:     true,
+		PackThresholdBytes: 100,
+		SkipExisting:       true,
+		DeleteRemoved:      true,
+		StatePath:          filepath.Join(t.TempDir(), "state.json"),
+	}}
+	provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	files := map[string]string{
+		"docs/a.txt":   "a",
+		"docs/b.txt":   "b",
+		"docs/c.txt":   "c",
+		"docs/big.bin": strings.Repeat("x", 200),
+		"top.txt":      "alone in its folder",
+	}
+	source := t.TempDir()
+	write := func(name, content string) {
+		localPath := filepath.Join(source, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, []byte(content), 0644)
+	}
+	for name, content := range files {
+		write(name, content)
+	}
+	runSync := func(uploaded int) {
+		t.Helper()
+		if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if got := manager.LastSummary().Uploaded; got != uploaded {
+			t.Errorf("Expected %d files uploaded, got %d", uploaded, got)
+		}
+	}
+
+	runSync(5)
+	listing, _ := provider.List(ctx, "")
+	var stored []string
+	for _, info := range listing {
+		if !info.IsDir {
+			stored = append(stored, info.Path)
+		}
+	}
+	sort.Strings(stored)
+	if got := strings.Join(stored, ","); got != "docs/.csync-pack.tar,docs/big.bin,top.txt" {
+		t.Errorf("Unexpected remote files: %s", got)
+	}
+
+	// Unchanged packs are skipped and never deleted as stale
+	runSync(0)
+	if exists, _ := provider.FileExists(ctx, "docs/"+packName); !exists {
+		t.Error("Expected the pack to survive delete_removed")
+	}
+
+	// One changed file uploads its whole pack again
+	files["docs/b.txt"] = "b, edited"
+	write("docs/b.txt", files["docs/b.txt"])
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(source, "docs", "b.txt"), later, later)
+	runSync(3)
+
+	dest := t.TempDir()
+	if err := manager.Restore(ctx, "webdav", dest); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	for name, content := range files {
+		if data, _ := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name))); string(data) != content {
+			t.Errorf("%s restored as %q", name, data)
+		}
+	}
+}
@@ -3,20 +3,27 @@ package sync
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	gosync "sync"
 	"time"
 
 	"github.com/svosadtsia/csync/internal/config"
 	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
 )
 
 // PCloudProvider implements the Provider interface for pCloud
@@ -24,7 +31,19 @@ type PCloudProvider struct {
 	client   *http.Client
 	config   *config.PCloudConfig
 	folderID string
+	apiHost  string // API host of the account's region
 	auth     string // Authentication token
+
+	mu      gosync.Mutex
+	folders map[string]string // Folder IDs by path, for the life of the provider
+
+	chunkSize int64 // Files larger than this upload in chunks
+	sessions  *uploadSessions
+
+	limiter *throttle.Limiter // Shared upload rate limit, nil for none
+
+	preserveModTime bool // Give uploads the local modification time
+	verifyUploads   bool // Compare each upload's SHA-1 with the local file
 }
 
 // PCloudResponse represents a generic pCloud API response
@@ -35,15 +54,50 @@ type PCloudResponse struct {
 	Metadata json.RawMessage `json:"metadata,omitempty"`
 }
 
+// pcloudChecksums are the hashes pCloud reports for a file. Only SHA-1 is
+// reported in both regions: US accounts also get MD5, EU ones SHA-256.
+type pcloudChecksums struct {
+	SHA1 string `json:"sha1"`
+}
+
+// PCloudError is a non-zero result returned by the pCloud API
+type PCloudError struct {
+	Op      string
+	Result  int
+	Message string
+}
+
+func (e *PCloudError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Op, e.Message)
+}
+
+// Permanent reports whether retrying the call can't succeed without user
+// action: bad credentials, a missing destination folder or a full account
+func (e *PCloudError) Permanent() bool {
+	switch e.Result {
+	case 1000, 2000, 2005, 2008, 2094:
+		return true
+	default:
+		return false
+	}
+}
+
+func newPCloudError(op string, result int, message string) error {
+	return &PCloudError{Op: op, Result: result, Message: message}
+}
+
 // PCloudFileMetadata represents file metadata from pCloud
 type PCloudFileMetadata struct {
-	FileID   int64  `json:"fileid"`
-	Name     string `json:"name"`
-	Size     int64  `json:"size"`
-	Hash     string `json:"hash"`
-	Modified string `json:"modified"`
-	IsFolder bool   `json:"isfolder"`
-	FolderID int64  `json:"parentfolderid"`
+	FileID         int64  `json:"fileid"`
+	FolderID       int64  `json:"folderid"`
+	Name           string `json:"name"`
+	Size           int64  `json:"size"`
+	Hash           uint64 `json:"hash"` // pCloud's own 64-bit content hash, not MD5
+	Modified       string `json:"modified"`
+	IsFolder       bool   `json:"isfolder"`
+	ParentFolderID int64  `json:"parentfolderid"`
+
+	Contents []PCloudFileMetadata `json:"contents,omitempty"` // Folder children
 }
 
 // PCloudFolderMetadata represents folder contents from pCloud
@@ -53,16 +107,45 @@ type PCloudFolderContents struct {
 
 // NewPCloudProvider creates a new pCloud provider
 func NewPCloudProvider(cfg *config.PCloudConfig) (*PCloudProvider, error) {
+	return newPCloudProvider(context.Background(), cfg, nil)
+}
+
+func init() {
+	RegisterProvider("pcloud", ProviderFactory{
+		DisplayName: "pCloud",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := newPCloudProvider(ctx, &m.config.PCloud, m.transport("pcloud"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create pCloud client: %w", err)
+			}
+			sessions, err := m.loadUploadSessions()
+			if err != nil {
+				return nil, err
+			}
+			client.setChunked(m.config.General.ChunkSizeBytes, sessions)
+			return client, nil
+		},
+		Settings:    func(cfg *config.Config) any { return cfg.PCloud },
+		Configured:  func(cfg *config.Config) bool { return cfg.PCloud.Username != "" },
+		Destination: func(cfg *config.Config) string { return cfg.PCloud.DestinationPath },
+	})
+}
+
+// newPCloudProvider creates a pCloud provider whose API requests go through
+// transport (nil for the default)
+func newPCloudProvider(ctx context.Context, cfg *config.PCloudConfig, transport http.RoundTripper) (*PCloudProvider, error) {
 	provider := &PCloudProvider{
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: transport,
 		},
 		config:   cfg,
 		folderID: cfg.FolderID,
+		folders:  make(map[string]string),
 	}
 
-	// Authenticate
-	if err := provider.authenticate(); err != nil {
+	// Authenticate against the configured host, or find the account's region
+	if err := provider.resolveHost(ctx); err != nil {
 		return nil, fmt.Errorf("failed to authenticate with pCloud: %w", err)
 	}
 
@@ -79,13 +162,69 @@ func (p *PCloudProvider) Name() string {
 	return "pCloud"
 }
 
-// authenticate performs authentication with pCloud
-func (p *PCloudProvider) authenticate() error {
+// Capabilities reports the optional features pCloud supports
+func (p *PCloudProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		// No Hashes: listfolder only exposes pCloud's internal hash
+		ServerSideCopy: true,
+		PublicLinks:    true,
+		Versioning:     true,
+		AtomicRename:   true,
+		AtomicUpload:   true,
+		RangedDownload: true,
+	}
+}
+
+// fullPath prefixes a remote path with the configured destination path
+func (p *PCloudProvider) fullPath(remotePath string) string {
+	return path.Join(p.config.DestinationPath, filepath.ToSlash(remotePath))
+}
+
+// resolveHost picks the API host and authenticates against it. An explicit
+// api_host or region is used as is; otherwise the US host is tried first and
+// the EU host when the US one rejects the account.
+func (p *PCloudProvider) resolveHost(ctx context.Context) error {
+	if p.config.APIHost != "" {
+		p.apiHost = p.config.APIHost
+		return p.authenticate(ctx)
+	}
+	if p.config.Region != "" {
+		p.apiHost = config.PCloudHosts[p.config.Region]
+		return p.authenticate(ctx)
+	}
+
+	p.apiHost = config.PCloudHosts["us"]
+	err := p.authenticate(ctx)
+	var apiErr *PCloudError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	p.apiHost = config.PCloudHosts["eu"]
+	if euErr := p.authenticate(ctx); euErr != nil {
+		return err
+	}
+	utils.LogVerbose("Using pCloud EU API host %s", p.apiHost)
+	return nil
+}
+
+// authenticate performs authentication with pCloud. The password itself is
+// never sent; instead a one-time digest from /getdigest is combined with it
+// as described in pCloud's passworddigest scheme.
+func (p *PCloudProvider) authenticate(ctx context.Context) error {
+	digest, err := p.getDigest(ctx)
+	if err != nil {
+		return err
+	}
+
 	data := url.Values{}
 	data.Set("username", p.config.Username)
-	data.Set("password", p.config.Password)
+	data.Set("digest", digest)
+	data.Set("passworddigest", passwordDigest(p.config.Username, p.config.Password, digest))
+	data.Set("getauth", "1")
+	data.Set("logout", "1")
 
-	resp, err := p.client.PostForm(p.config.APIHost+"/userinfo", data)
+	resp, err := p.postForm(ctx, "/userinfo", data)
 	if err != nil {
 		return fmt.Errorf("authentication request failed: %w", err)
 	}
@@ -97,22 +236,74 @@ func (p *PCloudProvider) authenticate() error {
 	}
 
 	if authResp.Result != 0 {
-		return fmt.Errorf("authentication failed: %s", authResp.Error)
+		return newPCloudError("authentication", authResp.Result, authResp.Error)
 	}
 
 	p.auth = authResp.Auth
 	return nil
 }
 
+// getDigest requests a single-use digest for password authentication
+func (p *PCloudProvider) getDigest(ctx context.Context) (string, error) {
+	resp, err := p.postForm(ctx, "/getdigest", url.Values{})
+	if err != nil {
+		return "", fmt.Errorf("digest request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var digestResp struct {
+		Result int    `json:"result"`
+		Error  string `json:"error,omitempty"`
+		Digest string `json:"digest"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&digestResp); err != nil {
+		return "", fmt.Errorf("failed to decode digest response: %w", err)
+	}
+
+	if digestResp.Result != 0 {
+		return "", newPCloudError("get digest", digestResp.Result, digestResp.Error)
+	}
+
+	return digestResp.Digest, nil
+}
+
+// postForm calls an API endpoint with form data. The request is abandoned
+// when ctx is cancelled.
+func (p *PCloudProvider) postForm(ctx context.Context, endpoint string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiHost+endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return p.client.Do(req)
+}
+
+// passwordDigest computes sha1(password + sha1(lowercase(username)) + digest)
+// as hex, the value pCloud expects in the passworddigest parameter
+func passwordDigest(username, password, digest string) string {
+	userHash := sha1.Sum([]byte(strings.ToLower(username)))
+	sum := sha1.Sum([]byte(password + hex.EncodeToString(userHash[:]) + digest))
+	return hex.EncodeToString(sum[:])
+}
+
 // Upload uploads a file to pCloud
 func (p *PCloudProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	remotePath = p.fullPath(remotePath)
+
 	// Ensure parent folders exist
 	parentFolderID, err := p.ensureParentFolders(ctx, remotePath)
 	if err != nil {
 		return fmt.Errorf("failed to ensure parent folders: %w", err)
 	}
 
-	// Open local file
+	if p.sessions != nil && p.chunkSize > 0 && file.Size > p.chunkSize {
+		return p.chunkedUpload(ctx, file, remotePath, parentFolderID)
+	}
+
+	// The form is built afresh from the file on every call, and held in
+	// memory so the HTTP client can resend it whole, so a retried upload
+	// never sends a partly read body
 	localFile, err := os.Open(file.AbsolutePath)
 	if err != nil {
 		return fmt.Errorf("failed to open local file: %w", err)
@@ -126,22 +317,28 @@ func (p *PCloudProvider) Upload(ctx context.Context, file scanner.FileInfo, remo
 	// Add auth
 	writer.WriteField("auth", p.auth)
 	writer.WriteField("folderid", parentFolderID)
-	writer.WriteField("filename", filepath.Base(remotePath))
+	writer.WriteField("filename", path.Base(remotePath))
+	if p.preserveModTime {
+		writer.WriteField("mtime", strconv.FormatInt(file.ModTime.Unix(), 10))
+	}
 
 	// Add file
-	fileWriter, err := writer.CreateFormFile("file", filepath.Base(remotePath))
+	fileWriter, err := writer.CreateFormFile("file", path.Base(remotePath))
 	if err != nil {
 		return fmt.Errorf("failed to create form file: %w", err)
 	}
 
-	if _, err := io.Copy(fileWriter, localFile); err != nil {
+	progress := utils.NewProgress(remotePath, file.Size)
+	defer progress.Done()
+	hash := newUploadHash(file, HashSHA1, p.verifyUploads)
+	if _, err := io.Copy(fileWriter, progress.Reader(p.limiter.Reader(ctx, hash.reader(localFile, 0)))); err != nil {
 		return fmt.Errorf("failed to copy file data: %w", err)
 	}
 
 	writer.Close()
 
 	// Make request
-	req, err := http.NewRequestWithContext(ctx, "POST", p.config.APIHost+"/uploadfile", &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", p.apiHost+"/uploadfile", &buf)
 	if err != nil {
 		return fmt.Errorf("failed to create upload request: %w", err)
 	}
@@ -153,32 +350,43 @@ func (p *PCloudProvider) Upload(ctx context.Context, file scanner.FileInfo, remo
 	}
 	defer resp.Body.Close()
 
-	var uploadResp PCloudResponse
+	var uploadResp struct {
+		PCloudResponse
+		Checksums []pcloudChecksums `json:"checksums"`
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&uploadResp); err != nil {
 		return fmt.Errorf("failed to decode upload response: %w", err)
 	}
 
 	if uploadResp.Result != 0 {
-		return fmt.Errorf("upload failed: %s", uploadResp.Error)
+		return newPCloudError("upload", uploadResp.Result, uploadResp.Error)
 	}
 
+	if p.verifyUploads {
+		var sum string
+		if len(uploadResp.Checksums) > 0 {
+			sum = uploadResp.Checksums[0].SHA1
+		}
+		return verifyUpload(hash.hashed(file), remotePath, HashSHA1, sum)
+	}
 	return nil
 }
 
 // CreateFolder creates a folder in pCloud
 func (p *PCloudProvider) CreateFolder(ctx context.Context, remotePath string) error {
+	remotePath = p.fullPath(remotePath)
 	_, err := p.ensureParentFolders(ctx, remotePath+"/dummy")
 	return err
 }
 
 // FileExists checks if a file exists in pCloud
 func (p *PCloudProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
-	parentFolderID, err := p.getParentFolderID(ctx, remotePath)
+	parentFolderID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
 	if err != nil {
 		return false, err
 	}
 
-	fileName := filepath.Base(remotePath)
+	fileName := path.Base(remotePath)
 	_, err = p.findFile(ctx, fileName, parentFolderID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
@@ -192,12 +400,12 @@ func (p *PCloudProvider) FileExists(ctx context.Context, remotePath string) (boo
 
 // GetFileInfo retrieves information about a remote file
 func (p *PCloudProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
-	parentFolderID, err := p.getParentFolderID(ctx, remotePath)
+	parentFolderID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
 	if err != nil {
 		return nil, err
 	}
 
-	fileName := filepath.Base(remotePath)
+	fileName := path.Base(remotePath)
 	metadata, err := p.findFile(ctx, fileName, parentFolderID)
 	if err != nil {
 		return nil, err
@@ -206,19 +414,18 @@ func (p *PCloudProvider) GetFileInfo(ctx context.Context, remotePath string) (*R
 	return &RemoteFileInfo{
 		Path:     remotePath,
 		Size:     metadata.Size,
-		MD5Hash:  metadata.Hash,
 		Modified: metadata.Modified,
 	}, nil
 }
 
 // Delete removes a file or folder from pCloud
 func (p *PCloudProvider) Delete(ctx context.Context, remotePath string) error {
-	parentFolderID, err := p.getParentFolderID(ctx, remotePath)
+	parentFolderID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
 	if err != nil {
 		return err
 	}
 
-	fileName := filepath.Base(remotePath)
+	fileName := path.Base(remotePath)
 	metadata, err := p.findFile(ctx, fileName, parentFolderID)
 	if err != nil {
 		return err
@@ -230,14 +437,15 @@ func (p *PCloudProvider) Delete(ctx context.Context, remotePath string) error {
 
 	var endpoint string
 	if metadata.IsFolder {
-		endpoint = "/deletefolder"
-		data.Set("folderid", strconv.FormatInt(metadata.FileID, 10))
+		// deletefolder only removes empty folders
+		endpoint = "/deletefolderrecursive"
+		data.Set("folderid", strconv.FormatInt(metadata.FolderID, 10))
 	} else {
 		endpoint = "/deletefile"
 		data.Set("fileid", strconv.FormatInt(metadata.FileID, 10))
 	}
 
-	resp, err := p.client.PostForm(p.config.APIHost+endpoint, data)
+	resp, err := p.postForm(ctx, endpoint, data)
 	if err != nil {
 		return fmt.Errorf("delete request failed: %w", err)
 	}
@@ -249,81 +457,382 @@ func (p *PCloudProvider) Delete(ctx context.Context, remotePath string) error {
 	}
 
 	if deleteResp.Result != 0 {
-		return fmt.Errorf("delete failed: %s", deleteResp.Error)
+		return newPCloudError("delete", deleteResp.Result, deleteResp.Error)
+	}
+
+	if metadata.IsFolder {
+		p.forgetFolders(p.fullPath(remotePath))
 	}
 
 	return nil
 }
 
-// ensureParentFolders ensures all parent directories exist for a given path
-func (p *PCloudProvider) ensureParentFolders(ctx context.Context, remotePath string) (string, error) {
-	dir := filepath.Dir(remotePath)
-	if dir == "." || dir == "/" {
-		return p.folderID, nil
+// Copy duplicates a remote file server-side, overwriting any existing file
+// at the destination
+func (p *PCloudProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	srcParentID, err := p.getParentFolderID(ctx, p.fullPath(srcRemotePath))
+	if err != nil {
+		return err
 	}
 
-	parentFolderID := p.folderID
-	parts := strings.Split(filepath.ToSlash(dir), "/")
+	metadata, err := p.findFile(ctx, path.Base(srcRemotePath), srcParentID)
+	if err != nil {
+		return err
+	}
 
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
+	dstFullPath := p.fullPath(dstRemotePath)
+	dstParentID, err := p.ensureParentFolders(ctx, dstFullPath)
+	if err != nil {
+		return fmt.Errorf("failed to ensure parent folders: %w", err)
+	}
 
-		// Check if folder already exists
-		metadata, err := p.findFile(ctx, part, parentFolderID)
-		if err != nil && !strings.Contains(err.Error(), "not found") {
-			return "", fmt.Errorf("failed to check folder existence: %w", err)
+	data := url.Values{}
+	data.Set("auth", p.auth)
+	data.Set("fileid", strconv.FormatInt(metadata.FileID, 10))
+	data.Set("tofolderid", dstParentID)
+	data.Set("toname", path.Base(dstFullPath))
+
+	resp, err := p.postForm(ctx, "/copyfile", data)
+	if err != nil {
+		return fmt.Errorf("copy request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var copyResp PCloudResponse
+	if err := json.NewDecoder(resp.Body).Decode(&copyResp); err != nil {
+		return fmt.Errorf("failed to decode copy response: %w", err)
+	}
+
+	if copyResp.Result != 0 {
+		return newPCloudError("copy", copyResp.Result, copyResp.Error)
+	}
+
+	return nil
+}
+
+// Move renames a remote file, moving it to another folder if needed
+func (p *PCloudProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	srcParentID, err := p.getParentFolderID(ctx, p.fullPath(srcRemotePath))
+	if err != nil {
+		return err
+	}
+
+	metadata, err := p.findFile(ctx, path.Base(srcRemotePath), srcParentID)
+	if err != nil {
+		return err
+	}
+
+	dstFullPath := p.fullPath(dstRemotePath)
+	dstParentID, err := p.ensureParentFolders(ctx, dstFullPath)
+	if err != nil {
+		return fmt.Errorf("failed to ensure parent folders: %w", err)
+	}
+
+	data := url.Values{}
+	data.Set("auth", p.auth)
+	data.Set("fileid", strconv.FormatInt(metadata.FileID, 10))
+	data.Set("tofolderid", dstParentID)
+	data.Set("toname", path.Base(dstFullPath))
+
+	resp, err := p.postForm(ctx, "/renamefile", data)
+	if err != nil {
+		return fmt.Errorf("rename request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var renameResp PCloudResponse
+	if err := json.NewDecoder(resp.Body).Decode(&renameResp); err != nil {
+		return fmt.Errorf("failed to decode rename response: %w", err)
+	}
+
+	if renameResp.Result != 0 {
+		return newPCloudError("rename", renameResp.Result, renameResp.Error)
+	}
+
+	return nil
+}
+
+// PublicLink returns a public link to a remote folder ("" for the destination
+// root), reusing the folder's existing link if it already has one
+func (p *PCloudProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	folderID, err := p.getParentFolderID(ctx, path.Join(p.fullPath(remotePath), "dummy"))
+	if err != nil {
+		return "", err
+	}
+
+	link, err := p.existingFolderLink(ctx, folderID)
+	if err != nil {
+		return "", err
+	}
+	if link != "" {
+		return link, nil
+	}
+
+	data := url.Values{}
+	data.Set("auth", p.auth)
+	data.Set("folderid", folderID)
+
+	resp, err := p.postForm(ctx, "/getfolderpublink", data)
+	if err != nil {
+		return "", fmt.Errorf("public link request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var linkResp struct {
+		Result int    `json:"result"`
+		Error  string `json:"error,omitempty"`
+		Link   string `json:"link"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&linkResp); err != nil {
+		return "", fmt.Errorf("failed to decode public link response: %w", err)
+	}
+
+	if linkResp.Result != 0 {
+		return "", newPCloudError("public link", linkResp.Result, linkResp.Error)
+	}
+
+	return linkResp.Link, nil
+}
+
+// existingFolderLink returns the public link already created for a folder, if any
+func (p *PCloudProvider) existingFolderLink(ctx context.Context, folderID string) (string, error) {
+	data := url.Values{}
+	data.Set("auth", p.auth)
+
+	resp, err := p.postForm(ctx, "/listpublinks", data)
+	if err != nil {
+		return "", fmt.Errorf("list public links request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var listResp struct {
+		Result   int    `json:"result"`
+		Error    string `json:"error,omitempty"`
+		PubLinks []struct {
+			Link     string             `json:"link"`
+			Metadata PCloudFileMetadata `json:"metadata"`
+		} `json:"publinks"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return "", fmt.Errorf("failed to decode public links response: %w", err)
+	}
+
+	if listResp.Result != 0 {
+		return "", newPCloudError("list public links", listResp.Result, listResp.Error)
+	}
+
+	for _, publink := range listResp.PubLinks {
+		if publink.Metadata.IsFolder && strconv.FormatInt(publink.Metadata.FolderID, 10) == folderID {
+			return publink.Link, nil
 		}
+	}
 
-		if err != nil && strings.Contains(err.Error(), "not found") {
-			// Create folder
-			folderID, err := p.createFolder(ctx, part, parentFolderID)
-			if err != nil {
-				return "", fmt.Errorf("failed to create folder %s: %w", part, err)
+	return "", nil
+}
+
+// List recursively lists files and folders below remotePath ("" for the
+// destination root). Returned paths are relative to the destination root.
+func (p *PCloudProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	folderID, err := p.getParentFolderID(ctx, path.Join(p.fullPath(remotePath), "dummy"))
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("auth", p.auth)
+	data.Set("folderid", folderID)
+	data.Set("recursive", "1")
+
+	resp, err := p.postForm(ctx, "/listfolder", data)
+	if err != nil {
+		return nil, fmt.Errorf("list folder request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var listResp struct {
+		Result   int                `json:"result"`
+		Error    string             `json:"error,omitempty"`
+		Metadata PCloudFileMetadata `json:"metadata"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return nil, fmt.Errorf("failed to decode list folder response: %w", err)
+	}
+
+	if listResp.Result != 0 {
+		return nil, newPCloudError("list folder", listResp.Result, listResp.Error)
+	}
+
+	var files []RemoteFileInfo
+	var walk func(prefix string, items []PCloudFileMetadata)
+	walk = func(prefix string, items []PCloudFileMetadata) {
+		for _, item := range items {
+			info := RemoteFileInfo{
+				Path:     path.Join(prefix, item.Name),
+				Size:     item.Size,
+				Modified: item.Modified,
+				IsDir:    item.IsFolder,
 			}
-			parentFolderID = folderID
-		} else {
-			if !metadata.IsFolder {
-				return "", fmt.Errorf("path conflict: %s is a file, not a folder", part)
+			files = append(files, info)
+			if item.IsFolder {
+				walk(info.Path, item.Contents)
 			}
-			parentFolderID = strconv.FormatInt(metadata.FileID, 10)
 		}
 	}
+	walk(strings.Trim(remotePath, "/"), listResp.Metadata.Contents)
 
-	return parentFolderID, nil
+	return files, nil
+}
+
+// Download writes the content of a remote file to localPath
+func (p *PCloudProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of a remote file from offset onwards,
+// appending to the existing localPath
+func (p *PCloudProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	parentFolderID, err := p.getParentFolderID(ctx, p.fullPath(remotePath))
+	if err != nil {
+		return err
+	}
+
+	metadata, err := p.findFile(ctx, path.Base(remotePath), parentFolderID)
+	if err != nil {
+		return err
+	}
+
+	if metadata.IsFolder {
+		return fmt.Errorf("cannot download folder: %s", remotePath)
+	}
+
+	// Ask pCloud for a temporary download link
+	data := url.Values{}
+	data.Set("auth", p.auth)
+	data.Set("fileid", strconv.FormatInt(metadata.FileID, 10))
+
+	resp, err := p.postForm(ctx, "/getfilelink", data)
+	if err != nil {
+		return fmt.Errorf("file link request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var linkResp struct {
+		Result int      `json:"result"`
+		Error  string   `json:"error,omitempty"`
+		Path   string   `json:"path"`
+		Hosts  []string `json:"hosts"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&linkResp); err != nil {
+		return fmt.Errorf("failed to decode file link response: %w", err)
+	}
+
+	if linkResp.Result != 0 {
+		return newPCloudError("file link", linkResp.Result, linkResp.Error)
+	}
+
+	if len(linkResp.Hosts) == 0 {
+		return fmt.Errorf("file link failed: no download hosts returned")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+linkResp.Hosts[0]+linkResp.Path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create download request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Downloads can take far longer than the API timeout
+	downloadClient := &http.Client{Transport: p.client.Transport}
+	fileResp, err := downloadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("download request failed: %w", err)
+	}
+	defer fileResp.Body.Close()
+
+	return writeDownload(localPath, offset, fileResp)
+}
+
+// UpdateMetadata is not supported: pCloud has no custom file properties
+func (p *PCloudProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureMetadata}
+}
+
+// ensureParentFolders ensures all parent directories exist for a given path
+func (p *PCloudProvider) ensureParentFolders(ctx context.Context, remotePath string) (string, error) {
+	return p.resolveFolder(ctx, path.Dir(remotePath), true)
 }
 
 // getParentFolderID gets the parent folder ID for a given path
 func (p *PCloudProvider) getParentFolderID(ctx context.Context, remotePath string) (string, error) {
-	dir := filepath.Dir(remotePath)
-	if dir == "." || dir == "/" {
-		return p.folderID, nil
-	}
+	return p.resolveFolder(ctx, path.Dir(remotePath), false)
+}
 
+// resolveFolder walks dir from the destination folder and returns its ID,
+// creating missing folders when create is set. IDs found along the way are
+// cached so files in the same folders don't list their parents again.
+func (p *PCloudProvider) resolveFolder(ctx context.Context, dir string, create bool) (string, error) {
 	parentFolderID := p.folderID
-	parts := strings.Split(filepath.ToSlash(dir), "/")
+	prefix := ""
 
-	for _, part := range parts {
-		if part == "" {
+	for _, part := range strings.Split(dir, "/") {
+		if part == "" || part == "." {
 			continue
 		}
+		prefix = path.Join(prefix, part)
 
-		metadata, err := p.findFile(ctx, part, parentFolderID)
-		if err != nil {
-			return "", fmt.Errorf("failed to find folder %s: %w", part, err)
+		p.mu.Lock()
+		folderID, ok := p.folders[prefix]
+		p.mu.Unlock()
+		if ok {
+			parentFolderID = folderID
+			continue
 		}
 
-		if !metadata.IsFolder {
+		metadata, err := p.findFolder(ctx, part, parentFolderID)
+		switch {
+		case err == nil && !metadata.IsFolder:
 			return "", fmt.Errorf("path conflict: %s is a file, not a folder", part)
+		case err == nil:
+			folderID = strconv.FormatInt(metadata.FolderID, 10)
+		case create && strings.Contains(err.Error(), "not found"):
+			folderID, err = p.createFolder(ctx, part, parentFolderID)
+			if err != nil {
+				return "", fmt.Errorf("failed to create folder %s: %w", part, err)
+			}
+		case create:
+			return "", fmt.Errorf("failed to check folder existence: %w", err)
+		default:
+			return "", fmt.Errorf("failed to find folder %s: %w", part, err)
 		}
 
-		parentFolderID = strconv.FormatInt(metadata.FileID, 10)
+		p.mu.Lock()
+		p.folders[prefix] = folderID
+		p.mu.Unlock()
+		parentFolderID = folderID
 	}
 
 	return parentFolderID, nil
 }
 
+// forgetFolders drops a deleted folder and everything below it from the
+// folder ID cache
+func (p *PCloudProvider) forgetFolders(fullPath string) {
+	prefix := strings.Trim(fullPath, "/")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for folder := range p.folders {
+		if folder == prefix || strings.HasPrefix(folder, prefix+"/") {
+			delete(p.folders, folder)
+		}
+	}
+}
+
 // createFolder creates a new folder in pCloud
 func (p *PCloudProvider) createFolder(ctx context.Context, name, parentFolderID string) (string, error) {
 	data := url.Values{}
@@ -331,7 +840,7 @@ func (p *PCloudProvider) createFolder(ctx context.Context, name, parentFolderID
 	data.Set("folderid", parentFolderID)
 	data.Set("name", name)
 
-	resp, err := p.client.PostForm(p.config.APIHost+"/createfolder", data)
+	resp, err := p.postForm(ctx, "/createfolder", data)
 	if err != nil {
 		return "", fmt.Errorf("create folder request failed: %w", err)
 	}
@@ -350,7 +859,7 @@ func (p *PCloudProvider) createFolder(ctx context.Context, name, parentFolderID
 	}
 
 	if createResp.Result != 0 {
-		return "", fmt.Errorf("create folder failed: %s", createResp.Error)
+		return "", newPCloudError("create folder", createResp.Result, createResp.Error)
 	}
 
 	return strconv.FormatInt(createResp.Metadata.FolderID, 10), nil
@@ -358,36 +867,129 @@ func (p *PCloudProvider) createFolder(ctx context.Context, name, parentFolderID
 
 // findFile finds a file or folder by name in the specified parent folder
 func (p *PCloudProvider) findFile(ctx context.Context, name, parentFolderID string) (*PCloudFileMetadata, error) {
+	return p.findEntry(ctx, name, parentFolderID, false)
+}
+
+// findFolder finds a folder by name in the specified parent folder, asking
+// pCloud to leave files out of the listing
+func (p *PCloudProvider) findFolder(ctx context.Context, name, parentFolderID string) (*PCloudFileMetadata, error) {
+	return p.findEntry(ctx, name, parentFolderID, true)
+}
+
+// findEntry lists the parent folder and returns the entry called name. The
+// listing is decoded one entry at a time and abandoned at the first match,
+// so folders with thousands of entries are never held in memory at once.
+func (p *PCloudProvider) findEntry(ctx context.Context, name, parentFolderID string, foldersOnly bool) (*PCloudFileMetadata, error) {
 	data := url.Values{}
 	data.Set("auth", p.auth)
 	data.Set("folderid", parentFolderID)
+	if foldersOnly {
+		data.Set("nofiles", "1")
+	}
 
-	resp, err := p.client.PostForm(p.config.APIHost+"/listfolder", data)
+	resp, err := p.postForm(ctx, "/listfolder", data)
 	if err != nil {
 		return nil, fmt.Errorf("list folder request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	var listResp struct {
-		Result   int                  `json:"result"`
-		Error    string               `json:"error,omitempty"`
-		Metadata PCloudFolderContents `json:"metadata"`
+	var found *PCloudFileMetadata
+	err = scanFolderContents(resp.Body, func(item PCloudFileMetadata) bool {
+		if item.Name == name {
+			found = &item
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
-		return nil, fmt.Errorf("failed to decode list folder response: %w", err)
+	if found == nil {
+		return nil, fmt.Errorf("file not found: %s", name)
 	}
 
-	if listResp.Result != 0 {
-		return nil, fmt.Errorf("list folder failed: %s", listResp.Error)
+	return found, nil
+}
+
+// scanFolderContents streams a listfolder response, calling fn for each
+// entry of the folder until it returns false
+func scanFolderContents(body io.Reader, fn func(PCloudFileMetadata) bool) error {
+	dec := json.NewDecoder(body)
+	var result int
+	var message string
+
+	// readObject walks the keys of an object, handing each to field, which
+	// must consume the value
+	readObject := func(field func(key string) (bool, error)) (bool, error) {
+		tok, err := dec.Token()
+		if err != nil {
+			return false, fmt.Errorf("failed to decode list folder response: %w", err)
+		}
+		if tok != json.Delim('{') {
+			return false, fmt.Errorf("failed to decode list folder response: unexpected %v", tok)
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return false, fmt.Errorf("failed to decode list folder response: %w", err)
+			}
+			key, _ := tok.(string)
+			more, err := field(key)
+			if err != nil || !more {
+				return more, err
+			}
+		}
+		_, err = dec.Token()
+		return true, err
 	}
 
-	// Search for the file/folder by name
-	for _, item := range listResp.Metadata.Contents {
-		if item.Name == name {
-			return &item, nil
+	decode := func(v any) error {
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("failed to decode list folder response: %w", err)
+		}
+		return nil
+	}
+
+	_, err := readObject(func(key string) (bool, error) {
+		switch key {
+		case "result":
+			return true, decode(&result)
+		case "error":
+			return true, decode(&message)
+		case "metadata":
+			return readObject(func(key string) (bool, error) {
+				if key != "contents" {
+					return true, decode(&json.RawMessage{})
+				}
+				tok, err := dec.Token()
+				if err != nil {
+					return false, fmt.Errorf("failed to decode list folder response: %w", err)
+				}
+				if tok != json.Delim('[') {
+					return true, nil // An empty folder can have null contents
+				}
+				for dec.More() {
+					var item PCloudFileMetadata
+					if err := decode(&item); err != nil {
+						return false, err
+					}
+					if !fn(item) {
+						return false, nil
+					}
+				}
+				_, err = dec.Token()
+				return true, err
+			})
+		default:
+			return true, decode(&json.RawMessage{})
 		}
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil, fmt.Errorf("file not found: %s", name)
+	if result != 0 {
+		return newPCloudError("list folder", result, message)
+	}
+	return nil
 }
@@ -0,0 +1,713 @@
+package sync
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// fakePCloud serves the part of the pCloud API the pCloud provider uses for
+// an account in the EU region
+type fakePCloud struct {
+	mu       gosync.Mutex
+	contents map[int64][]PCloudFileMetadata // Folder contents by folder ID
+	nextID   int64
+	lists    int               // listfolder calls
+	mtimes   map[string]string // mtime sent with each upload, by name
+	data     map[string][]byte // Content of each upload, by name
+	uploads  map[int64][]byte  // Chunked uploads in progress, by upload ID
+	corrupt  map[string]int    // How many more uploads of each name to store damaged
+	deletes  int               // deletefile calls
+}
+
+func (f *fakePCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseMultipartForm(1 << 20)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	folderID, _ := strconv.ParseInt(r.FormValue("folderid"), 10, 64)
+	switch r.URL.Path {
+	case "/getdigest":
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "digest": "digest"})
+	case "/userinfo":
+		// The digest of "me@example.com" and "secret" with the digest above
+		if r.FormValue("passworddigest") != "ec0c2b18d117bda61d03f972bab9d5d4c9694629" {
+			json.NewEncoder(w).Encode(map[string]any{"result": 2000, "error": "Log in failed."})
+			return
+		}
+		if r.FormValue("getauth") != "1" {
+			json.NewEncoder(w).Encode(map[string]any{"result": 1000, "error": "Log in required."})
+			return
+		}
+		if r.Host != "eapi.pcloud.com" {
+			json.NewEncoder(w).Encode(map[string]any{"result": 2000, "error": "Log in failed."})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "auth": "token"})
+	case "/listfolder":
+		f.lists++
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "metadata": map[string]any{"contents": f.contents[folderID]}})
+	case "/createfolder":
+		f.nextID++
+		f.contents[folderID] = append(f.contents[folderID], PCloudFileMetadata{Name: r.FormValue("name"), IsFolder: true, FolderID: f.nextID})
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "metadata": map[string]any{"folderid": f.nextID}})
+	case "/uploadfile":
+		var data []byte
+		if file, _, err := r.FormFile("file"); err == nil {
+			data, _ = io.ReadAll(file)
+		}
+		id := f.save(folderID, r.FormValue("filename"), r.FormValue("mtime"), data)
+		json.NewEncoder(w).Encode(map[string]any{
+			"result":    0,
+			"metadata":  []any{map[string]any{"fileid": id}},
+			"checksums": []any{map[string]any{"sha1": sha1Hex(f.data[r.FormValue("filename")])}},
+		})
+	case "/upload_create":
+		f.nextID++
+		if f.uploads == nil {
+			f.uploads = make(map[int64][]byte)
+		}
+		f.uploads[f.nextID] = nil
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "uploadid": f.nextID})
+	case "/upload_write":
+		id, _ := strconv.ParseInt(r.FormValue("uploadid"), 10, 64)
+		offset, _ := strconv.ParseInt(r.FormValue("uploadoffset"), 10, 64)
+		chunk, _ := io.ReadAll(r.Body)
+		f.uploads[id] = append(f.uploads[id][:offset], chunk...)
+		json.NewEncoder(w).Encode(map[string]any{"result": 0})
+	case "/upload_info":
+		id, _ := strconv.ParseInt(r.FormValue("uploadid"), 10, 64)
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "size": len(f.uploads[id])})
+	case "/upload_save":
+		id, _ := strconv.ParseInt(r.FormValue("uploadid"), 10, 64)
+		fileID := f.save(folderID, r.FormValue("name"), r.FormValue("mtime"), f.uploads[id])
+		delete(f.uploads, id)
+		json.NewEncoder(w).Encode(map[string]any{"result": 0, "metadata": map[string]any{"fileid": fileID}})
+	case "/checksumfile":
+		fileID, _ := strconv.ParseInt(r.FormValue("fileid"), 10, 64)
+		for _, items := range f.contents {
+			for _, item := range items {
+				if !item.IsFolder && item.FileID == fileID {
+					json.NewEncoder(w).Encode(map[string]any{"result": 0, "sha1": sha1Hex(f.data[item.Name])})
+					return
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": 2009, "error": "File not found."})
+	case "/deletefile":
+		fileID, _ := strconv.ParseInt(r.FormValue("fileid"), 10, 64)
+		f.deletes++
+		for id, items := range f.contents {
+			for i, item := range items {
+				if !item.IsFolder && item.FileID == fileID {
+					f.contents[id] = append(items[:i], items[i+1:]...)
+					delete(f.data, item.Name)
+					break
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": 0})
+	case "/deletefolderrecursive":
+		for id, items := range f.contents {
+			for i, item := range items {
+				if item.IsFolder && item.FolderID == folderID {
+					f.contents[id] = append(items[:i], items[i+1:]...)
+					break
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": 0})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// save adds an uploaded file to a folder and returns its ID
+func (f *fakePCloud) save(folderID int64, name, mtime string, data []byte) int64 {
+	f.nextID++
+	modified := time.Now()
+	if seconds, err := strconv.ParseInt(mtime, 10, 64); err == nil {
+		modified = time.Unix(seconds, 0)
+	}
+	f.contents[folderID] = append(f.contents[folderID], PCloudFileMetadata{
+		Name: name, FileID: f.nextID, Size: int64(len(data)), Modified: modified.UTC().Format(time.RFC1123Z),
+	})
+	f.mtimes[name] = mtime
+	if f.data == nil {
+		f.data = make(map[string][]byte)
+	}
+	if f.corrupt[name] > 0 && len(data) > 0 {
+		f.corrupt[name]--
+		data = append([]byte(nil), data...)
+		data[0] ^= 0xff
+	}
+	f.data[name] = data
+	return f.nextID
+}
+
+// sha1Hex returns the SHA-1 of data as pCloud reports it
+func sha1Hex(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPasswordDigest(t *testing.T) {
+	// sha1(password + sha1(lowercase username) + digest), in hex
+	tests := []struct {
+		username, password, digest string
+		want                       string
+	}{
+		{"me@example.com", "secret", "digest", "ec0c2b18d117bda61d03f972bab9d5d4c9694629"},
+		{"User@Example.com", "p4ss", "YDzH0hQv", "063dd2cf34b2a39634a8083a09da556279720c3a"},
+	}
+	for _, tt := range tests {
+		if got := passwordDigest(tt.username, tt.password, tt.digest); got != tt.want {
+			t.Errorf("passwordDigest(%q, %q, %q) = %s, want %s", tt.username, tt.password, tt.digest, got, tt.want)
+		}
+	}
+
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.PCloudConfig{Username: "me@example.com", Password: "wrong"}
+	if _, err := newPCloudProvider(context.Background(), cfg, rewriteTransport{target}); err == nil {
+		t.Error("Expected a wrong password to fail the login")
+	}
+}
+
+func TestPCloudFolderCache(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.PCloudConfig{Username: "me@example.com", Password: "secret", DestinationPath: "/backups"}
+	provider, err := newPCloudProvider(context.Background(), cfg, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if provider.apiHost != config.PCloudHosts["eu"] {
+		t.Errorf("Expected the EU API host, got %s", provider.apiHost)
+	}
+	provider.setPreserveModTime(true)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		localPath := filepath.Join(dir, name)
+		os.WriteFile(localPath, []byte("data"), 0644)
+		file := scanner.FileInfo{AbsolutePath: localPath, Size: 4, ModTime: modTime}
+		if err := provider.Upload(ctx, file, "docs/2024/"+name); err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+	}
+
+	if got := fake.mtimes["b.txt"]; got != strconv.FormatInt(modTime.Unix(), 10) {
+		t.Errorf("Expected the local modification time to be sent, got %q", got)
+	}
+
+	// Only the first upload looks up backups, docs and 2024
+	if fake.lists != 3 {
+		t.Errorf("Expected 3 listfolder calls, got %d", fake.lists)
+	}
+
+	// Deleting a folder drops it from the cache, so it's created again
+	if err := provider.Delete(ctx, "docs"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if err := provider.CreateFolder(ctx, "docs/2024"); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if exists, err := provider.FileExists(ctx, "docs/2024/a.txt"); err != nil || exists {
+		t.Errorf("Expected the recreated folder to be empty, got %v, %v", exists, err)
+	}
+}
+
+func TestScanFolderContents(t *testing.T) {
+	var listing strings.Builder
+	listing.WriteString(`{"result": 0, "metadata": {"name": "big", "contents": [`)
+	for i := range 5000 {
+		if i > 0 {
+			listing.WriteString(",")
+		}
+		fmt.Fprintf(&listing, `{"name": "file%d.txt", "fileid": %d}`, i, i)
+	}
+	listing.WriteString(`], "folderid": 1}}`)
+
+	var seen int
+	var found PCloudFileMetadata
+	err := scanFolderContents(strings.NewReader(listing.String()), func(item PCloudFileMetadata) bool {
+		seen++
+		found = item
+		return item.Name != "file42.txt"
+	})
+	if err != nil || found.FileID != 42 || seen != 43 {
+		t.Errorf("Expected to stop at file42.txt, got %+v after %d entries, %v", found, seen, err)
+	}
+
+	err = scanFolderContents(strings.NewReader(`{"result": 2005, "error": "Directory does not exist."}`), func(PCloudFileMetadata) bool { return true })
+	var apiErr *PCloudError
+	if !errors.As(err, &apiErr) || apiErr.Result != 2005 {
+		t.Errorf("Expected a pCloud error, got %v", err)
+	}
+}
+
+func TestPCloudSyncThroughManager(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.Config{PCloud: config.PCloudConfig{Username: "me@example.com", Password: "secret", DestinationPath: "/backups"}}
+	provider, err := newPCloudProvider(context.Background(), &cfg.PCloud, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["pcloud"] = provider
+
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "docs", "2024"), 0755)
+	os.WriteFile(filepath.Join(source, "top.txt"), []byte("top"), 0644)
+	os.WriteFile(filepath.Join(source, "docs", "2024", "report.txt"), []byte("report"), 0644)
+
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if s := manager.LastSummary(); s.Uploaded != 2 {
+		t.Errorf("Expected 2 uploads, got %d", s.Uploaded)
+	}
+
+	// Files land below the destination path, as the old pCloud client put them
+	var walk func(folderID int64, prefix string)
+	found := make(map[string]bool)
+	walk = func(folderID int64, prefix string) {
+		for _, item := range fake.contents[folderID] {
+			if item.IsFolder {
+				walk(item.FolderID, prefix+item.Name+"/")
+			} else {
+				found[prefix+item.Name] = true
+			}
+		}
+	}
+	walk(0, "/")
+	for _, want := range []string{"/backups/top.txt", "/backups/docs/2024/report.txt"} {
+		if !found[want] {
+			t.Errorf("Expected %s to be uploaded, got %v", want, found)
+		}
+	}
+}
+
+func TestPCloudSkipUnchanged(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.Config{PCloud: config.PCloudConfig{Username: "me@example.com", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{SkipExisting: true, PreserveModTime: true}}
+	provider, err := newPCloudProvider(context.Background(), &cfg.PCloud, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.setPreserveModTime(true)
+	manager := NewManager(cfg)
+	manager.providers["pcloud"] = provider
+
+	// pCloud keeps whole seconds of the mtime sent with an upload
+	source := t.TempDir()
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 750_000_000, time.Local)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(source, name)
+		os.WriteFile(path, []byte(name), 0644)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if s := manager.LastSummary(); s.Uploaded != 2 {
+		t.Fatalf("Expected 2 uploads, got %d", s.Uploaded)
+	}
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync again: %v", err)
+	}
+	if s := manager.LastSummary(); s.Uploaded != 0 {
+		t.Errorf("Expected the unchanged files to be skipped, got %d uploads", s.Uploaded)
+	}
+
+	// A file changed a second later is uploaded again
+	changed := modTime.Add(time.Second)
+	os.Chtimes(filepath.Join(source, "b.txt"), changed, changed)
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync again: %v", err)
+	}
+	if s := manager.LastSummary(); s.Uploaded != 1 {
+		t.Errorf("Expected b.txt to be uploaded again, got %d uploads", s.Uploaded)
+	}
+}
+
+func TestPCloudMetadata(t *testing.T) {
+	// pCloud's hash is an unsigned 64-bit number, above the range of int64
+	data := `{"fileid": 12, "folderid": 3, "name": "a.txt", "hash": 18446744073709551615, "isfolder": false, "parentfolderid": 0}`
+	var meta PCloudFileMetadata
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		t.Fatalf("Failed to decode metadata: %v", err)
+	}
+	if meta.Hash != 18446744073709551615 || meta.FileID != 12 || meta.FolderID != 3 {
+		t.Errorf("Unexpected metadata: %+v", meta)
+	}
+
+	provider := &PCloudProvider{config: &config.PCloudConfig{DestinationPath: "/backups"}}
+	if got := provider.fullPath("docs/a.txt"); got != "/backups/docs/a.txt" {
+		t.Errorf("Expected the destination path prefixed, got %s", got)
+	}
+	drive := &GoogleDriveProvider{config: &config.GoogleDriveConfig{DestinationPath: "backups"}}
+	if got := drive.fullPath(filepath.Join("docs", "a.txt")); got != "backups/docs/a.txt" {
+		t.Errorf("Expected the destination path prefixed, got %s", got)
+	}
+}
+
+func TestPCloudCancel(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Creating the first folder hangs until the sync gives up on it
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/createfolder" {
+			r.ParseForm() // The server notices the client leaving once the body is read
+			cancel()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.Config{PCloud: config.PCloudConfig{Username: "me@example.com", Password: "secret"}}
+	provider, err := newPCloudProvider(ctx, &cfg.PCloud, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["pcloud"] = provider
+
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "docs"), 0755)
+	os.WriteFile(filepath.Join(source, "docs", "a.txt"), []byte("a"), 0644)
+
+	start := time.Now()
+	err = manager.SyncToPCloud(ctx, source, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the sync to be cancelled, got %v", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("Expected the sync to stop at once, took %s", took)
+	}
+	if len(fake.mtimes) != 0 {
+		t.Errorf("Expected nothing to be uploaded, got %v", fake.mtimes)
+	}
+}
+
+func TestPCloudUploadRetry(t *testing.T) {
+	defer func(delay time.Duration) { retryBaseDelay = delay }(retryBaseDelay)
+	retryBaseDelay = time.Millisecond
+
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	var failed gosync.Map
+
+	// The first request to each upload endpoint reads part of the body and
+	// then fails, so a resent body that doesn't start over arrives truncated
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/uploadfile":
+			if _, seen := failed.LoadOrStore(r.URL.Path, true); !seen {
+				io.CopyN(io.Discard, r.Body, 100)
+				json.NewEncoder(w).Encode(map[string]any{"result": 5000, "error": "Internal error."})
+				return
+			}
+		case "/upload_write":
+			// Redirected requests are resent by the HTTP client itself
+			if _, seen := failed.LoadOrStore(r.URL.Path, true); !seen {
+				io.CopyN(io.Discard, r.Body, 100)
+				http.Redirect(w, r, r.URL.String(), http.StatusTemporaryRedirect)
+				return
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.Config{
+		General: config.GeneralConfig{RetryAttempts: 2},
+		PCloud:  config.PCloudConfig{Username: "me@example.com", Password: "secret"},
+	}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{HashDuringUpload: true}}
+	provider, err := newPCloudProvider(context.Background(), &cfg.PCloud, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	sessions, err := loadUploadSessions(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatalf("Failed to load upload sessions: %v", err)
+	}
+	// Verification uses the hash taken while sending, which has to count
+	// a resent chunk once
+	provider.setChunked(4096, sessions)
+	provider.setVerifyUploads(true)
+	manager := NewManager(cfg)
+	manager.providers["pcloud"] = provider
+
+	small := []byte(strings.Repeat("small file ", 300))
+	large := []byte(strings.Repeat("large file ", 1000))
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "small.txt"), small, 0644)
+	os.WriteFile(filepath.Join(source, "large.txt"), large, 0644)
+
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	for name, want := range map[string][]byte{"small.txt": small, "large.txt": large} {
+		if got := fake.data[name]; string(got) != string(want) {
+			t.Errorf("Expected %s to arrive whole (%d bytes), got %d bytes", name, len(want), len(got))
+		}
+	}
+	for _, path := range []string{"/uploadfile", "/upload_write"} {
+		if _, ok := failed.Load(path); !ok {
+			t.Errorf("Expected %s to fail once", path)
+		}
+	}
+}
+
+func TestPCloudVerifyUploads(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.Config{PCloud: config.PCloudConfig{Username: "me@example.com", Password: "secret"}}
+	provider, err := newPCloudProvider(context.Background(), &cfg.PCloud, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	sessions, err := loadUploadSessions(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatalf("Failed to load upload sessions: %v", err)
+	}
+	provider.setChunked(4096, sessions)
+	provider.setVerifyUploads(true)
+	manager := NewManager(cfg)
+	manager.providers["pcloud"] = provider
+
+	small := []byte(strings.Repeat("small file ", 300))
+	large := []byte(strings.Repeat("large file ", 1000))
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "small.txt"), small, 0644)
+	os.WriteFile(filepath.Join(source, "large.txt"), large, 0644)
+
+	// Each file arrives damaged once: it's deleted and uploaded again
+	fake.corrupt = map[string]int{"small.txt": 1, "large.txt": 1}
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	for name, want := range map[string][]byte{"small.txt": small, "large.txt": large} {
+		if got := fake.data[name]; string(got) != string(want) {
+			t.Errorf("Expected %s to be uploaded intact, got %q", name, got)
+		}
+	}
+	if fake.deletes != 2 || len(fake.contents[0]) != 2 {
+		t.Errorf("Expected both damaged uploads to be deleted, got %d deletes and %v", fake.deletes, fake.contents[0])
+	}
+
+	// A file that arrives damaged twice fails
+	fake.corrupt = map[string]int{"small.txt": 2}
+	os.Remove(filepath.Join(source, "large.txt"))
+	if err := manager.SyncToPCloud(context.Background(), source, false); err == nil {
+		t.Fatal("Expected the sync to fail")
+	}
+	var mismatch *UploadMismatchError
+	if failed := manager.FailedFiles(); len(failed) != 1 || !errors.As(failed[0].Err, &mismatch) || mismatch.Algorithm != HashSHA1 {
+		t.Errorf("Expected small.txt to fail verification, got %v", failed)
+	}
+	if _, ok := fake.data["small.txt"]; ok {
+		t.Error("Expected the damaged upload to be deleted")
+	}
+}
+
+func TestPCloudHashDuringUpload(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	cfg := &config.Config{PCloud: config.PCloudConfig{Username: "me@example.com", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{HashDuringUpload: true}}
+	provider, err := newPCloudProvider(context.Background(), &cfg.PCloud, rewriteTransport{target})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.setVerifyUploads(true)
+	manager := NewManager(cfg)
+	manager.providers["pcloud"] = provider
+	if !manager.hashesWhileUploading() {
+		t.Fatal("Expected files to be hashed while uploading")
+	}
+
+	data := []byte(strings.Repeat("hashed once ", 500))
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "file.txt"), data, 0644)
+
+	// The damaged upload is caught with the hash taken while sending it
+	fake.corrupt = map[string]int{"file.txt": 2}
+	if err := manager.SyncToPCloud(context.Background(), source, false); err == nil {
+		t.Fatal("Expected the sync to fail")
+	}
+	sum := sha1.Sum(data)
+	var mismatch *UploadMismatchError
+	if failed := manager.FailedFiles(); len(failed) != 1 || !errors.As(failed[0].Err, &mismatch) || mismatch.Local != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected a mismatch against the file's SHA-1, got %v", failed)
+	}
+
+	fake.corrupt = nil
+	if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if got := fake.data["file.txt"]; string(got) != string(data) {
+		t.Errorf("Expected file.txt to be uploaded intact, got %d bytes", len(got))
+	}
+
+	// Skipping unchanged files needs the hashes before uploading
+	cfg.Optional.Advanced.SkipExisting = true
+	if manager.hashesWhileUploading() {
+		t.Error("Expected skip_existing to hash while scanning")
+	}
+}
+
+func TestUploadHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	data := []byte("some file content")
+	os.WriteFile(path, data, 0644)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	file := scanner.FileInfo{Path: "file.txt", AbsolutePath: path, Size: int64(len(data))}
+	sum := sha1.Sum(data)
+	want := hex.EncodeToString(sum[:])
+	chunk := func(h *uploadHash, start, end int64) io.Reader {
+		return h.reader(io.NewSectionReader(f, start, end-start), start)
+	}
+
+	// Nothing is hashed without verification or when the scan hashed the file
+	if h := newUploadHash(file, HashSHA1, false); h != nil {
+		t.Error("Expected no hash without verification")
+	}
+	scanned := file
+	scanned.Checksum, scanned.HashAlgorithm = want, string(HashSHA1)
+	if h := newUploadHash(scanned, HashSHA1, true); h != nil {
+		t.Error("Expected no hash for a file the scan hashed")
+	}
+	if got := (*uploadHash)(nil).hashed(file); got.Checksum != "" {
+		t.Errorf("Expected the file as it is, got %+v", got)
+	}
+
+	// A chunk sent again after part of it was read is hashed once, and a
+	// stale reader of the first attempt doesn't count
+	h := newUploadHash(file, HashSHA1, true)
+	io.Copy(io.Discard, chunk(h, 0, 8))
+	stale := chunk(h, 8, 16)
+	io.CopyN(io.Discard, stale, 3)
+	resent := chunk(h, 8, 16)
+	io.Copy(io.Discard, stale)
+	io.Copy(io.Discard, resent)
+	io.Copy(io.Discard, chunk(h, 16, file.Size))
+	if got := h.hashed(file); got.Checksum != want || got.HashAlgorithm != string(HashSHA1) {
+		t.Errorf("Expected sha1 %s, got %+v", want, got)
+	}
+
+	// So is a resumed upload, whose skipped bytes are hashed first
+	h = newUploadHash(file, HashSHA1, true)
+	h.skip(f, 10)
+	io.Copy(io.Discard, chunk(h, 10, file.Size))
+	if got := h.hashed(file); got.Checksum != want {
+		t.Errorf("Expected sha1 %s after resuming, got %s", want, got.Checksum)
+	}
+
+	// Skipping part of the file leaves it unhashed
+	h = newUploadHash(file, HashSHA1, true)
+	io.Copy(io.Discard, chunk(h, 3, file.Size))
+	if got := h.hashed(file); got.Checksum != "" {
+		t.Errorf("Expected no checksum after skipping ahead, got %s", got.Checksum)
+	}
+}
+
+func TestPCloudUserAgent(t *testing.T) {
+	fake := &fakePCloud{contents: make(map[int64][]PCloudFileMetadata), mtimes: make(map[string]string)}
+	var mu gosync.Mutex
+	agents := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents[r.UserAgent()] = true
+		mu.Unlock()
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+
+	for _, custom := range []string{"", "backup-box/2.1"} {
+		clear(agents)
+		cfg := &config.Config{
+			PCloud:   config.PCloudConfig{Username: "me@example.com", Password: "secret"},
+			Optional: &config.OptionalConfig{Advanced: &config.AdvancedConfig{CustomUserAgent: custom}},
+		}
+		manager := NewManager(cfg)
+		transport := &userAgentTransport{base: rewriteTransport{target}, agent: manager.userAgent()}
+		provider, err := newPCloudProvider(context.Background(), &cfg.PCloud, transport)
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		manager.providers["pcloud"] = provider
+
+		source := t.TempDir()
+		os.WriteFile(filepath.Join(source, "a.txt"), []byte("a"), 0644)
+		if err := manager.SyncToPCloud(context.Background(), source, false); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+
+		want := custom
+		if want == "" {
+			want = defaultUserAgent()
+		}
+		if len(agents) != 1 || !agents[want] || !strings.HasPrefix(defaultUserAgent(), "csync/") {
+			t.Errorf("Expected every request to send User-Agent %q, got %v", want, agents)
+		}
+	}
+}
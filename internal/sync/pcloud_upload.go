@@ -0,0 +1,229 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// setChunked makes uploads larger than chunkSize go through pCloud's
+// upload_create/upload_write/upload_save calls one chunk at a time,
+// recording sessions so an interrupted upload continues where it stopped
+func (p *PCloudProvider) setChunked(chunkSize int64, sessions *uploadSessions) {
+	p.chunkSize = chunkSize
+	p.sessions = sessions
+}
+
+// setPreserveModTime makes uploads keep the local modification time
+func (p *PCloudProvider) setPreserveModTime(preserve bool) {
+	p.preserveModTime = preserve
+}
+
+// setVerifyUploads makes uploads compare pCloud's SHA-1 of the uploaded
+// content with the local file
+func (p *PCloudProvider) setVerifyUploads(verify bool) {
+	p.verifyUploads = verify
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *PCloudProvider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.limiter = limiter
+}
+
+// chunkedUpload uploads a file into parentFolderID in chunks, continuing a
+// previously interrupted upload of the same file if there is one
+func (p *PCloudProvider) chunkedUpload(ctx context.Context, file scanner.FileInfo, remotePath, parentFolderID string) error {
+	var offset int64
+	session, ok := p.sessions.get("pcloud", file, remotePath)
+	if ok {
+		written, err := p.uploadInfo(ctx, session.ID)
+		switch {
+		case err != nil:
+			utils.LogVerbose("Restarting upload of %s: %v", remotePath, err)
+			ok = false
+		case written > file.Size:
+			utils.LogVerbose("Restarting upload of %s: %d bytes written of %d", remotePath, written, file.Size)
+			ok = false
+		default:
+			offset = written
+			utils.LogVerbose("Resuming upload of %s at %d of %d bytes", remotePath, offset, file.Size)
+		}
+	}
+
+	if !ok {
+		id, err := p.uploadCreate(ctx)
+		if err != nil {
+			return err
+		}
+		session.ID = id
+		if err := p.sessions.set("pcloud", file, remotePath, id); err != nil {
+			utils.LogError("Failed to record upload session for %s: %v", remotePath, err)
+		}
+	}
+
+	localFile, err := os.Open(file.AbsolutePath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer localFile.Close()
+
+	progress := utils.NewProgress(remotePath, file.Size)
+	defer progress.Done()
+	progress.Skip(offset)
+	hash := newUploadHash(file, HashSHA1, p.verifyUploads)
+	hash.skip(localFile, offset)
+
+	for offset < file.Size {
+		start, end := offset, min(offset+p.chunkSize, file.Size)
+		// A chunk that has to be sent again is read again from the file
+		chunk := func() io.Reader {
+			return p.limiter.Reader(ctx, hash.reader(io.NewSectionReader(localFile, start, end-start), start))
+		}
+		if err := p.uploadWrite(ctx, session.ID, offset, progress.Reader(chunk()), chunk, end-offset); err != nil {
+			return fmt.Errorf("failed to upload %s at byte %d: %w", remotePath, offset, err)
+		}
+		offset = end
+	}
+
+	fileID, err := p.uploadSave(ctx, session.ID, path.Base(remotePath), parentFolderID, file.ModTime)
+	if err != nil {
+		return err
+	}
+	if err := p.sessions.remove("pcloud", file); err != nil {
+		return err
+	}
+
+	if p.verifyUploads {
+		sums, err := p.checksumFile(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		return verifyUpload(hash.hashed(file), remotePath, HashSHA1, sums.SHA1)
+	}
+	return nil
+}
+
+// uploadCreate starts a chunked upload and returns its ID
+func (p *PCloudProvider) uploadCreate(ctx context.Context) (string, error) {
+	var resp struct {
+		UploadID int64 `json:"uploadid"`
+	}
+	if err := p.uploadCall(ctx, http.MethodPost, "/upload_create", url.Values{}, nil, nil, 0, &resp, "upload create"); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(resp.UploadID, 10), nil
+}
+
+// uploadInfo returns how many bytes a chunked upload has received
+func (p *PCloudProvider) uploadInfo(ctx context.Context, uploadID string) (int64, error) {
+	var resp struct {
+		Size int64 `json:"size"`
+	}
+	params := url.Values{"uploadid": {uploadID}}
+	if err := p.uploadCall(ctx, http.MethodPost, "/upload_info", params, nil, nil, 0, &resp, "upload info"); err != nil {
+		return 0, err
+	}
+	return resp.Size, nil
+}
+
+// uploadWrite writes a chunk of length bytes at offset into a chunked
+// upload. rewind returns the chunk from its start again for a resend.
+func (p *PCloudProvider) uploadWrite(ctx context.Context, uploadID string, offset int64, chunk io.Reader, rewind func() io.Reader, length int64) error {
+	params := url.Values{
+		"uploadid":     {uploadID},
+		"uploadoffset": {strconv.FormatInt(offset, 10)},
+	}
+	return p.uploadCall(ctx, http.MethodPut, "/upload_write", params, chunk, rewind, length, nil, "upload write")
+}
+
+// uploadSave turns a finished chunked upload into a file named name in
+// folderID, replacing any existing file of that name, and returns the
+// file's ID. modTime is kept when modification times are preserved.
+func (p *PCloudProvider) uploadSave(ctx context.Context, uploadID, name, folderID string, modTime time.Time) (int64, error) {
+	params := url.Values{
+		"uploadid": {uploadID},
+		"name":     {name},
+		"folderid": {folderID},
+	}
+	if p.preserveModTime {
+		params.Set("mtime", strconv.FormatInt(modTime.Unix(), 10))
+	}
+	var resp struct {
+		Metadata PCloudFileMetadata `json:"metadata"`
+	}
+	if err := p.uploadCall(ctx, http.MethodPost, "/upload_save", params, nil, nil, 0, &resp, "upload save"); err != nil {
+		return 0, err
+	}
+	return resp.Metadata.FileID, nil
+}
+
+// checksumFile returns the hashes pCloud computed for a file
+func (p *PCloudProvider) checksumFile(ctx context.Context, fileID int64) (pcloudChecksums, error) {
+	var sums pcloudChecksums
+	params := url.Values{"fileid": {strconv.FormatInt(fileID, 10)}}
+	if err := p.uploadCall(ctx, http.MethodPost, "/checksumfile", params, nil, nil, 0, &sums, "checksum"); err != nil {
+		return pcloudChecksums{}, err
+	}
+	return sums, nil
+}
+
+// uploadCall makes an authenticated upload API call with params in the
+// query string, so body can carry length bytes of raw file data, and
+// decodes the response into out if it isn't nil. When the HTTP client has
+// to send the request again, after a redirect or a dropped keep-alive
+// connection, rewind supplies the whole body anew.
+func (p *PCloudProvider) uploadCall(ctx context.Context, method, endpoint string, params url.Values, body io.Reader, rewind func() io.Reader, length int64, out any, op string) error {
+	params.Set("auth", p.auth)
+
+	req, err := http.NewRequestWithContext(ctx, method, p.apiHost+endpoint+"?"+params.Encode(), body)
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", op, err)
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	if rewind != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(rewind()), nil
+		}
+	}
+
+	// Chunks can take far longer than the API timeout
+	client := &http.Client{Transport: p.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s response: %w", op, err)
+	}
+
+	var result PCloudResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", op, err)
+	}
+	if result.Result != 0 {
+		return newPCloudError(op, result.Result, result.Error)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode %s response: %w", op, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package sync
+
+import (
+	"context"
+	gosync "sync"
+)
+
+// runPool calls fn for every item using at most workers goroutines. It stops
+// dispatching new items as soon as ctx is cancelled or fn fails, waits for
+// in-flight calls to return and reports the first error.
+func runPool[T any](ctx context.Context, workers int, items []T, fn func(context.Context, T) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	poolCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       gosync.WaitGroup
+		once     gosync.Once
+		firstErr error
+	)
+
+	jobs := make(chan T)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				if err := fn(poolCtx, item); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for _, item := range items {
+		select {
+		case <-poolCtx.Done():
+			break dispatch
+		case jobs <- item:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunPoolBoundsConcurrency(t *testing.T) {
+	items := make([]int, 50)
+	var running, peak atomic.Int32
+
+	err := runPool(context.Background(), 4, items, func(ctx context.Context, _ int) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runPool() error = %v", err)
+	}
+	if got := peak.Load(); got > 4 {
+		t.Errorf("peak concurrency = %d, expected at most 4", got)
+	}
+}
+
+func TestRunPoolStopsOnError(t *testing.T) {
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	failure := errors.New("upload failed")
+	var calls atomic.Int32
+
+	err := runPool(context.Background(), 2, items, func(ctx context.Context, item int) error {
+		calls.Add(1)
+		if item == 3 {
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("runPool() error = %v, expected %v", err, failure)
+	}
+	if got := calls.Load(); got >= int32(len(items)) {
+		t.Errorf("runPool() called fn for all %d items after a failure", got)
+	}
+}
+
+func TestRunPoolStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	items := make([]int, 100)
+	var calls atomic.Int32
+
+	err := runPool(ctx, 1, items, func(ctx context.Context, _ int) error {
+		if calls.Add(1) == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("runPool() error = %v, expected context.Canceled", err)
+	}
+	// Dispatch races with cancellation, so allow a few extra items
+	if got := calls.Load(); got >= 20 {
+		t.Errorf("runPool() dispatched %d items after cancellation, expected it to stop promptly", got)
+	}
+}
@@ -0,0 +1,231 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// remoteKeepSet records which remote paths a run must leave in place when
+// mirroring local deletions
+type remoteKeepSet struct {
+	paths    map[string]bool // Synced files and folders and their ancestors
+	trees    []string        // Skipped local folders, kept with everything in them
+	excluded []string        // ExcludeFolders entries
+	source   string          // Local source, which absolute entries are relative to
+	sidecars string          // Checksum sidecar extension, if enabled
+}
+
+// keeps reports whether remotePath must not be deleted
+func (k *remoteKeepSet) keeps(remotePath string) bool {
+	if remotePath == clockProbeName || remotePath == flattenMapName || k.paths[remotePath] {
+		return true
+	}
+	if isPack(remotePath) {
+		dir := path.Dir(remotePath)
+		return dir == "." || k.paths[dir]
+	}
+	if k.sidecars != "" && isSidecar(remotePath, k.sidecars) {
+		return k.paths[strings.TrimSuffix(remotePath, "."+k.sidecars)]
+	}
+	for _, tree := range k.trees {
+		if remotePath == tree || strings.HasPrefix(remotePath, tree+"/") {
+			return true
+		}
+	}
+	_, excluded := utils.ExcludedFolder(k.source, remotePath, k.excluded)
+	return excluded
+}
+
+// newRemoteKeepSet builds the keep set for a run from the scanned files and
+// the files the scanner skipped
+func (m *Manager) newRemoteKeepSet(run *syncRun, files []scanner.FileInfo) *remoteKeepSet {
+	keep := &remoteKeepSet{
+		paths:    make(map[string]bool),
+		excluded: run.advanced.ExcludeFolders,
+		source:   run.source,
+		sidecars: run.advanced.ChecksumSidecars,
+	}
+
+	for _, file := range files {
+		addFolder(keep.paths, m.RemotePathFor(file))
+	}
+
+	// Files that exist locally but weren't synced (ignored, still being
+	// written, filtered out) keep their remote copies
+	for _, skipped := range run.skipped {
+		remotePath := m.RemotePathFor(scanner.FileInfo{Path: skipped.Path, IsDir: skipped.IsDir})
+		if skipped.IsDir {
+			keep.trees = append(keep.trees, remotePath)
+		}
+		addFolder(keep.paths, remotePath)
+	}
+
+	return keep
+}
+
+// deleteRemoved deletes remote files and folders that no longer exist
+// locally. Folders are deleted as a whole, so their contents aren't
+// deleted one by one.
+func (m *Manager) deleteRemoved(ctx context.Context, run *syncRun, files []scanner.FileInfo) error {
+	count := 0
+	for _, file := range files {
+		if !file.IsDir {
+			count++
+		}
+	}
+	if count == 0 {
+		err := fmt.Errorf("local scan found no files, not deleting anything")
+		utils.LogError("[%s] %v", run.tag, err)
+		run.warn("", err)
+		return nil
+	}
+
+	remote, err := run.provider.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list remote files: %w", err)
+	}
+
+	keep := m.newRemoteKeepSet(run, files)
+
+	// Shallowest first, so a deleted folder's contents can be skipped
+	sort.Slice(remote, func(i, j int) bool {
+		return strings.Count(remote[i].Path, "/") < strings.Count(remote[j].Path, "/")
+	})
+
+	deletedFolders := make(map[string]bool)
+	deleted := make(map[string]bool)
+	for _, r := range remote {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if keep.keeps(r.Path) || insideDeletedFolder(r.Path, deletedFolders) {
+			continue
+		}
+
+		err := run.retry(ctx, "delete "+r.Path, func() error {
+			return run.provider.Delete(ctx, r.Path)
+		})
+		if err != nil {
+			if errors.Is(err, ErrBudgetExhausted) {
+				return err
+			}
+			utils.LogError("Failed to delete %s: %v", r.Path, err)
+			run.warn(r.Path, err)
+			continue
+		}
+		utils.LogInfo("[%s] ✗ %s (deleted)", run.tag, r.Path)
+
+		deleted[r.Path] = true
+		if r.IsDir {
+			deletedFolders[r.Path] = true
+		}
+	}
+
+	if run.state != nil {
+		for localPath, entry := range run.state.Entries(run.name) {
+			remotePath := entry.RemotePath
+			if remotePath == "" {
+				remotePath = localPath
+			}
+			if deleted[remotePath] || insideDeletedFolder(remotePath, deletedFolders) {
+				run.state.Delete(run.name, localPath)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteMissing deletes the remote copies of paths, relative to the source,
+// that no longer exist locally. Unlike deleteRemoved it never lists the
+// remote, so it suits syncs of a few changed paths.
+func (m *Manager) deleteMissing(ctx context.Context, run *syncRun, paths []string) error {
+	for _, localPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(run.source, filepath.FromSlash(localPath))); !os.IsNotExist(err) {
+			continue
+		}
+		if run.movedFrom[localPath] {
+			continue // Its remote copy now lives under the new name
+		}
+
+		// With state tracking, only delete what was actually synced
+		var synced []string
+		targets := []string{m.RemotePathFor(scanner.FileInfo{Path: localPath})}
+		if run.state != nil {
+			entries := run.state.Entries(run.name)
+			for path := range entries {
+				if path == localPath || strings.HasPrefix(path, localPath+"/") {
+					synced = append(synced, path)
+				}
+			}
+			if len(synced) == 0 {
+				continue
+			}
+
+			if entry, ok := entries[localPath]; ok && entry.RemotePath != "" {
+				targets = []string{entry.RemotePath}
+			} else if !ok && run.advanced.FlattenStructure {
+				// A flattened folder's files aren't in a remote folder
+				targets = targets[:0]
+				for _, path := range synced {
+					remotePath := entries[path].RemotePath
+					if remotePath == "" {
+						remotePath = path
+					}
+					targets = append(targets, remotePath)
+				}
+			}
+		}
+
+		failed := false
+		for _, remotePath := range targets {
+			if _, excluded := utils.ExcludedFolder(run.source, remotePath, run.advanced.ExcludeFolders); excluded || remotePath == clockProbeName {
+				continue
+			}
+
+			err := run.retry(ctx, "delete "+remotePath, func() error {
+				return run.provider.Delete(ctx, remotePath)
+			})
+			if err != nil {
+				if errors.Is(err, ErrBudgetExhausted) {
+					return err
+				}
+				utils.LogError("Failed to delete %s: %v", remotePath, err)
+				run.warn(localPath, err)
+				failed = true
+				continue
+			}
+			utils.LogInfo("[%s] ✗ %s (deleted)", run.tag, remotePath)
+		}
+
+		if !failed {
+			for _, path := range synced {
+				run.state.Delete(run.name, path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// insideDeletedFolder reports whether p lies below a folder deleted this run
+func insideDeletedFolder(p string, folders map[string]bool) bool {
+	for dir := path.Dir(p); dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+		if folders[dir] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestDeleteRemoved(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.General.IgnorePatterns = []string{"*.log", "cache/"}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		DeleteRemoved:    true,
+		ChecksumSidecars: "md5",
+		ExcludeFolders:   []string{"archive"},
+	}}
+	provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	// Put files on the remote as an earlier sync would have
+	seedDir := t.TempDir()
+	seed := func(name string) {
+		t.Helper()
+		localPath := filepath.Join(seedDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, []byte(name), 0644)
+		if err := provider.Upload(ctx, scanner.FileInfo{Path: name, AbsolutePath: localPath, Size: int64(len(name))}, name); err != nil {
+			t.Fatalf("Failed to seed %s: %v", name, err)
+		}
+	}
+	kept := []string{
+		"archive/old.txt",  // In an excluded folder
+		"docs/notes.log",   // Ignored, but still there locally
+		"cache/blob.bin",   // In an ignored folder
+		"video.part",       // Still being written
+		"docs/" + packName, // Pack of a folder that still exists
+		"docs/a.txt.md5",   // Sidecar of a file that still exists
+		clockProbeName,     // Clock skew probe
+	}
+	removed := []string{
+		"gone.txt",
+		"gone.txt.md5",
+		"old/" + packName,
+		"old/b.txt",
+	}
+	for _, name := range append(append([]string{}, kept...), removed...) {
+		seed(name)
+	}
+	remoteHas := func(name string) bool {
+		exists, err := provider.FileExists(ctx, name)
+		if err != nil {
+			t.Fatalf("Failed to check %s: %v", name, err)
+		}
+		return exists
+	}
+
+	// A source with no files, as when a drive isn't mounted, deletes nothing
+	source := t.TempDir()
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	for _, name := range append(append([]string{}, kept...), removed...) {
+		if !remoteHas(name) {
+			t.Errorf("Expected %s to survive a sync of an empty source", name)
+		}
+	}
+
+	write := func(name string) {
+		localPath := filepath.Join(source, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, []byte(name), 0644)
+	}
+	for _, name := range []string{"docs/a.txt", "docs/notes.log", "cache/blob.bin", "video.part", "archive/new.txt"} {
+		write(name)
+	}
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	for _, name := range kept {
+		if !remoteHas(name) {
+			t.Errorf("Expected %s to be kept", name)
+		}
+	}
+	for _, name := range removed {
+		if remoteHas(name) {
+			t.Errorf("Expected %s to be deleted", name)
+		}
+	}
+	if !remoteHas("docs/a.txt") {
+		t.Error("Expected docs/a.txt to be uploaded")
+	}
+}
@@ -0,0 +1,374 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// conflictSuffix marks the local copy of a file that changed on both sides
+const conflictSuffix = ".conflict"
+
+// syncAction is what reconciling one path decided to do
+type syncAction string
+
+const (
+	actionNone     syncAction = "none"     // Leave both sides alone
+	actionRecord   syncAction = "record"   // Both sides match; only record them in the state
+	actionUpload   syncAction = "upload"   // The local copy is newer
+	actionDownload syncAction = "download" // The remote copy is newer
+	actionConflict syncAction = "conflict" // Both sides changed since the last sync
+)
+
+// reconcileItem is one path present on either side of a pull or
+// bidirectional sync. remote is nil for local-only paths.
+type reconcileItem struct {
+	syncItem
+	remote *RemoteFileInfo
+	action syncAction
+}
+
+// decide picks the direction for one path from the local file, the remote
+// copy and the state recorded at the last sync. Either side may be nil.
+func decide(mode string, file *scanner.FileInfo, remote *RemoteFileInfo, entry *StateEntry) syncAction {
+	upload := actionUpload
+	if mode == config.SyncPull {
+		upload = actionNone
+	}
+
+	switch {
+	case file == nil && remote == nil:
+		return actionNone
+	case file == nil:
+		return actionDownload
+	case remote == nil:
+		return upload
+	}
+
+	if entry != nil && entry.RemoteVersion != "" {
+		localChanged := localDiffers(*entry, *file)
+		remoteChanged := remoteVersion(remote) != entry.RemoteVersion
+		switch {
+		case localChanged && remoteChanged:
+			if same, known := sameContent(*file, remote); known && same {
+				return actionRecord
+			}
+			return actionConflict
+		case localChanged:
+			return upload
+		case remoteChanged:
+			return actionDownload
+		default:
+			return actionNone
+		}
+	}
+
+	// Without a recorded remote version, a file synced by an earlier push
+	// run that hasn't changed locally is taken to match its remote copy
+	if entry != nil && !localDiffers(*entry, *file) && (remote.Size == SizeUnknown || entry.Size == remote.Size) {
+		return actionRecord
+	}
+	if same, known := sameContent(*file, remote); same || (!known && file.Size == remote.Size) {
+		return actionRecord
+	}
+
+	// Never synced before: the newer side wins
+	remoteTime, err := parseRemoteTime(remote.Modified)
+	if err == nil && remoteTime.After(file.ModTime) {
+		return actionDownload
+	}
+	return upload
+}
+
+// localDiffers reports whether a local file changed since entry was recorded
+func localDiffers(entry StateEntry, file scanner.FileInfo) bool {
+	if entry.Size != file.Size {
+		return true
+	}
+	if file.Size == 0 {
+		return false // Touching an empty file doesn't change its content
+	}
+	if sum := entry.checksum(); sum != "" && file.Checksum != "" {
+		return !strings.EqualFold(sum, file.Checksum)
+	}
+	return !entry.ModTime.Equal(file.ModTime)
+}
+
+// sameContent compares a local file with its remote copy by hash. known is
+// false when the provider didn't report the scanner's algorithm.
+func sameContent(file scanner.FileInfo, remote *RemoteFileInfo) (same, known bool) {
+	if file.Size == 0 && remote.Size == 0 {
+		return true, true
+	}
+	sum := remote.Checksum(HashAlgorithm(file.HashAlgorithm))
+	if sum == "" || file.Checksum == "" {
+		return false, false
+	}
+	return file.Size == remote.Size && strings.EqualFold(sum, file.Checksum), true
+}
+
+// remoteVersion identifies the content of a remote file: its first
+// reported hash, or its size and modification time
+func remoteVersion(remote *RemoteFileInfo) string {
+	for _, sum := range []string{remote.MD5Hash, remote.SHA1Hash, remote.SHA256Hash} {
+		if sum != "" {
+			return sum
+		}
+	}
+	return fmt.Sprintf("%d@%s", remote.Size, remote.Modified)
+}
+
+// reconcile runs a pull or bidirectional sync: every path on either side
+// is compared with the state of the last sync and copied in the direction
+// that brings both sides up to date. Paths changed on both sides keep the
+// local version as a .conflict copy next to the downloaded remote one.
+// Folders are created on the side that lacks them, so empty ones are kept
+// too. Deletions are not propagated in either direction.
+func (m *Manager) reconcile(ctx context.Context, run *syncRun, scn *scanner.Scanner, items []syncItem, localFolders map[string]bool, dryRun bool) error {
+	p := run.provider
+	mode := run.advanced.SyncMode
+
+	listing, err := p.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", p.Name(), err)
+	}
+	remotes := make(map[string]*RemoteFileInfo, len(listing))
+	var remoteFolders []string
+	for i, remote := range listing {
+		if remote.IsDir {
+			remoteFolders = append(remoteFolders, remote.Path)
+			continue
+		}
+		if remote.Path == clockProbeName || remote.Path == flattenMapName || isPack(remote.Path) || isSidecar(remote.Path, run.advanced.ChecksumSidecars) {
+			continue
+		}
+		remotes[remote.Path] = &listing[i]
+	}
+
+	// A dry run plans from the saved state without loading it for writing
+	state := run.state
+	if state == nil && dryRun && run.advanced.StatePath != "" {
+		if state, err = LoadState(run.advanced.StatePath); err != nil {
+			return err
+		}
+	}
+	entryFor := func(localPath string) *StateEntry {
+		if state == nil {
+			return nil
+		}
+		if entry, ok := state.Get(run.name, localPath); ok {
+			return &entry
+		}
+		return nil
+	}
+
+	var plan []reconcileItem
+	for _, item := range items {
+		remote := remotes[item.remotePath]
+		delete(remotes, item.remotePath)
+		action := decide(mode, &item.file, remote, entryFor(item.file.Path))
+		plan = append(plan, reconcileItem{syncItem: item, remote: remote, action: action})
+	}
+
+	// Remote-only files are downloaded unless a partial run didn't ask for
+	// them or something the scan left out already exists locally
+	if !run.partial {
+		var remoteOnly []string
+		for remotePath := range remotes {
+			remoteOnly = append(remoteOnly, remotePath)
+		}
+		sort.Strings(remoteOnly)
+		for _, remotePath := range remoteOnly {
+			localPath, ok := m.LocalPathFor(remotePath)
+			if !ok {
+				utils.LogVerbose("No reverse mapping for %s, not downloading", remotePath)
+				continue
+			}
+			if !filepath.IsLocal(filepath.FromSlash(localPath)) {
+				utils.LogError("Not downloading %s outside %s", localPath, run.source)
+				continue
+			}
+			if _, ok := scn.Explain(localPath, false); ok {
+				continue
+			}
+			absPath := filepath.Join(run.source, filepath.FromSlash(localPath))
+			if _, err := os.Lstat(absPath); err == nil {
+				utils.LogVerbose("Not downloading %s over a local file that wasn't scanned", localPath)
+				continue
+			}
+			file := scanner.FileInfo{Path: localPath, AbsolutePath: absPath}
+			plan = append(plan, reconcileItem{
+				syncItem: syncItem{file: file, remotePath: remotePath},
+				remote:   remotes[remotePath],
+				action:   decide(mode, nil, remotes[remotePath], nil),
+			})
+		}
+	}
+
+	if dryRun {
+		for _, item := range plan {
+			if item.action != actionNone && item.action != actionRecord {
+				utils.LogInfo("[%s] DRY RUN: would %s %s", run.tag, item.action, item.file.Path)
+			}
+		}
+		return nil
+	}
+
+	// Uploads may need remote folders that don't exist yet, and a two-way
+	// sync creates the local folders that are still empty as well
+	folders := make(map[string]bool)
+	if mode == config.SyncBidirectional {
+		for folder := range localFolders {
+			folders[folder] = true
+		}
+	}
+	for _, item := range plan {
+		if item.action == actionUpload {
+			addFolder(folders, path.Dir(item.remotePath))
+		}
+	}
+	if err := m.createFolders(ctx, run, folders); err != nil {
+		return run.budgetStop(err, len(plan))
+	}
+	if !run.partial {
+		if err := m.createLocalFolders(run.source, remoteFolders, scn); err != nil {
+			return err
+		}
+	}
+
+	err = runPool(ctx, m.config.GetUploadConcurrency(), plan, func(ctx context.Context, item reconcileItem) error {
+		return run.recordFailure(ctx, item.syncItem, m.reconcileFile(ctx, run, item))
+	})
+	if err != nil {
+		return run.budgetStop(err, len(plan))
+	}
+
+	if len(run.failed) > 0 {
+		utils.LogError("[%s] %d of %d files failed to sync", run.tag, len(run.failed), len(plan))
+	}
+	return run.failureError(len(plan), run.advanced.FailOnAnyError)
+}
+
+// reconcileFile applies the action decided for one path
+func (m *Manager) reconcileFile(ctx context.Context, run *syncRun, item reconcileItem) error {
+	switch item.action {
+	case actionUpload:
+		if err := m.syncFile(ctx, run, item.file, item.remotePath); err != nil {
+			return err
+		}
+		return m.recordRemoteVersion(ctx, run, item.file.Path, item.remotePath)
+	case actionDownload:
+		return m.pullFile(ctx, run, item)
+	case actionConflict:
+		conflictPath, err := conflictCopy(item.file.AbsolutePath)
+		if err != nil {
+			return err
+		}
+		utils.LogInfo("[%s] ! %s changed on both sides, keeping the local version as %s", run.tag, item.file.Path, filepath.Base(conflictPath))
+		run.warn(item.file.Path, fmt.Errorf("changed locally and remotely, local version kept as %s", filepath.Base(conflictPath)))
+		return m.pullFile(ctx, run, item)
+	case actionRecord:
+		run.skip(item.file, scanner.SkipUnchanged)
+		if run.state != nil {
+			run.state.Set(run.name, item.file, item.remotePath)
+			run.state.SetRemoteVersion(run.name, item.file.Path, remoteVersion(item.remote))
+		}
+		return nil
+	default:
+		run.skip(item.file, scanner.SkipUnchanged)
+		return nil
+	}
+}
+
+// pullFile downloads the remote copy of a path over the local file, gives it
+// the remote modification time and records it in the state
+func (m *Manager) pullFile(ctx context.Context, run *syncRun, item reconcileItem) error {
+	localPath := item.file.AbsolutePath
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	utils.LogInfo("[%s] ← %s (%d bytes)", run.tag, item.remotePath, item.remote.Size)
+	err := run.retry(ctx, "download "+item.remotePath, func() error {
+		return m.download(ctx, run.provider, item.remotePath, localPath)
+	})
+	if err != nil {
+		return err
+	}
+	if modTime, err := parseRemoteTime(item.remote.Modified); err == nil {
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			utils.LogVerbose("Failed to set modification time of %s: %v", localPath, err)
+		}
+	}
+
+	file, err := localFileInfo(item.file.Path, localPath, m.hashAlgorithm(run.provider))
+	if err != nil {
+		return err
+	}
+	run.download(file)
+	if run.state != nil {
+		run.state.Set(run.name, file, item.remotePath)
+		run.state.SetRemoteVersion(run.name, file.Path, remoteVersion(item.remote))
+	}
+	return nil
+}
+
+// recordRemoteVersion stores the version of a just uploaded file so the
+// next run can tell whether it changed remotely
+func (m *Manager) recordRemoteVersion(ctx context.Context, run *syncRun, localPath, remotePath string) error {
+	if run.state == nil {
+		return nil
+	}
+	remote, err := run.provider.GetFileInfo(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to get remote file info for %s: %w", remotePath, err)
+	}
+	run.state.SetRemoteVersion(run.name, localPath, remoteVersion(remote))
+	return nil
+}
+
+// localFileInfo describes a downloaded file the way the scanner would
+func localFileInfo(relPath, absPath, algo string) (scanner.FileInfo, error) {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return scanner.FileInfo{}, fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+	sum, err := scanner.CalculateChecksum(absPath, algo)
+	if err != nil {
+		return scanner.FileInfo{}, err
+	}
+	return scanner.FileInfo{
+		Path:          relPath,
+		AbsolutePath:  absPath,
+		Size:          info.Size(),
+		ModTime:       info.ModTime(),
+		MD5Hash:       sum,
+		Checksum:      sum,
+		HashAlgorithm: algo,
+		Mode:          info.Mode().Perm(),
+	}, nil
+}
+
+// conflictCopy moves a local file aside to path.conflict, or
+// path.conflict.2 and so on when that is taken, and returns the new path
+func conflictCopy(localPath string) (string, error) {
+	target := localPath + conflictSuffix
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = fmt.Sprintf("%s%s.%d", localPath, conflictSuffix, n)
+	}
+	if err := os.Rename(localPath, target); err != nil {
+		return "", fmt.Errorf("failed to keep conflicting copy of %s: %w", localPath, err)
+	}
+	return target, nil
+}
@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestDecide(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	file := &scanner.FileInfo{Size: 4, ModTime: modTime, Checksum: "aaaa", HashAlgorithm: "md5"}
+	synced := &StateEntry{Size: 4, ModTime: modTime, Checksum: "aaaa", RemoteVersion: "aaaa"}
+	changed := &scanner.FileInfo{Size: 5, ModTime: modTime.Add(time.Hour), Checksum: "cccc", HashAlgorithm: "md5"}
+	remote := &RemoteFileInfo{Size: 4, MD5Hash: "aaaa", Modified: modTime.Format(time.RFC3339)}
+	remoteChanged := &RemoteFileInfo{Size: 6, MD5Hash: "bbbb", Modified: modTime.Add(time.Hour).Format(time.RFC3339)}
+	older := &RemoteFileInfo{Size: 6, MD5Hash: "bbbb", Modified: modTime.Add(-time.Hour).Format(time.RFC3339)}
+
+	tests := []struct {
+		name   string
+		mode   string
+		file   *scanner.FileInfo
+		remote *RemoteFileInfo
+		entry  *StateEntry
+		want   syncAction
+	}{
+		{"remote only", config.SyncBidirectional, nil, remote, nil, actionDownload},
+		{"local only", config.SyncBidirectional, file, nil, nil, actionUpload},
+		{"local only pull", config.SyncPull, file, nil, nil, actionNone},
+		{"unchanged", config.SyncBidirectional, file, remote, synced, actionNone},
+		{"local changed", config.SyncBidirectional, changed, remote, synced, actionUpload},
+		{"local changed pull", config.SyncPull, changed, remote, synced, actionNone},
+		{"remote changed", config.SyncBidirectional, file, remoteChanged, synced, actionDownload},
+		{"both changed", config.SyncBidirectional, changed, remoteChanged, synced, actionConflict},
+		{"both changed alike", config.SyncBidirectional, changed, &RemoteFileInfo{Size: 5, MD5Hash: "cccc"}, synced, actionRecord},
+		{"first sync, same", config.SyncBidirectional, file, remote, nil, actionRecord},
+		{"first sync, remote newer", config.SyncBidirectional, file, remoteChanged, nil, actionDownload},
+		{"first sync, local newer", config.SyncBidirectional, file, older, nil, actionUpload},
+		{"first sync, local newer pull", config.SyncPull, file, older, nil, actionNone},
+	}
+	for _, tt := range tests {
+		if got := decide(tt.mode, tt.file, tt.remote, tt.entry); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBidirectionalSync(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	source := t.TempDir()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		SyncMode:  config.SyncBidirectional,
+		StatePath: filepath.Join(t.TempDir(), "state.json"),
+	}}
+	provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	writeLocal := func(name, content string) {
+		os.WriteFile(filepath.Join(source, name), []byte(content), 0644)
+	}
+	writeRemote := func(name, content string) {
+		tmp := filepath.Join(t.TempDir(), name)
+		os.WriteFile(tmp, []byte(content), 0644)
+		if err := provider.Upload(ctx, scanner.FileInfo{AbsolutePath: tmp}, name); err != nil {
+			t.Fatalf("Failed to upload %s: %v", name, err)
+		}
+	}
+	readLocal := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(source, name))
+		return string(data)
+	}
+	runSync := func(uploaded, downloaded int) {
+		t.Helper()
+		if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+			t.Fatalf("Failed to sync: %v", err)
+		}
+		if s := manager.LastSummary(); s.Uploaded != uploaded || s.Downloaded != downloaded {
+			t.Errorf("Expected %d uploaded and %d downloaded, got %d and %d", uploaded, downloaded, s.Uploaded, s.Downloaded)
+		}
+	}
+
+	writeLocal("a.txt", "local a")
+	writeLocal("b.txt", "local b")
+	writeRemote("c.txt", "remote c")
+	runSync(2, 1)
+	if got := readLocal("c.txt"); got != "remote c" {
+		t.Errorf("Expected c.txt to be pulled, got %q", got)
+	}
+
+	runSync(0, 0)
+
+	writeLocal("a.txt", "local a, edited")
+	writeRemote("b.txt", "remote b, edited")
+	writeLocal("c.txt", "local c, edited")
+	writeRemote("c.txt", "remote c, edited too")
+	runSync(1, 2)
+
+	if got := readLocal("b.txt"); got != "remote b, edited" {
+		t.Errorf("Expected the remote edit of b.txt, got %q", got)
+	}
+	if got := readLocal("c.txt"); got != "remote c, edited too" {
+		t.Errorf("Expected the remote version of c.txt, got %q", got)
+	}
+	if got := readLocal("c.txt" + conflictSuffix); got != "local c, edited" {
+		t.Errorf("Expected the local version of c.txt in a conflict copy, got %q", got)
+	}
+	if warnings := manager.LastSummary().Warnings; warnings != 1 {
+		t.Errorf("Expected the conflict to be reported, got %d warnings", warnings)
+	}
+}
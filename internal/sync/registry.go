@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	gosync "sync"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+// ProviderFactory describes how the Manager builds a provider and reports
+// on it. Providers register one under their name with RegisterProvider.
+type ProviderFactory struct {
+	// DisplayName is the provider's name in log messages
+	DisplayName string
+
+	// New creates the provider from the manager's configuration
+	New func(ctx context.Context, m *Manager) (Provider, error)
+
+	// Settings returns the configuration section the provider is built
+	// from, so a changed section recreates the provider
+	Settings func(cfg *config.Config) any
+
+	// Configured reports whether "all" syncs to the provider
+	Configured func(cfg *config.Config) bool
+
+	// Destination describes where files are synced to, or "" if unset
+	Destination func(cfg *config.Config) string
+}
+
+var (
+	registryMu gosync.RWMutex
+	registry   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider makes a provider available under name. It panics if
+// the name is taken or the factory can't create providers.
+func RegisterProvider(name string, factory ProviderFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory.New == nil {
+		panic("sync: RegisterProvider factory for " + name + " has no New")
+	}
+	if _, taken := registry[name]; taken {
+		panic("sync: RegisterProvider called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// lookupProvider returns the factory registered under name
+func lookupProvider(name string) (ProviderFactory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	factory, ok := registry[name]
+	return factory, ok
+}
+
+// ProviderNames returns the names of all registered providers, sorted
+func ProviderNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProviderDisplayName returns the name of a provider for log messages
+func ProviderDisplayName(name string) string {
+	if factory, ok := lookupProvider(name); ok && factory.DisplayName != "" {
+		return factory.DisplayName
+	}
+	return name
+}
+
+// ProviderDestination describes where cfg syncs the named provider's files
+// to, or returns "" if that isn't set
+func ProviderDestination(cfg *config.Config, name string) string {
+	if factory, ok := lookupProvider(name); ok && factory.Destination != nil {
+		return factory.Destination(cfg)
+	}
+	return ""
+}
+
+// providerSettings returns the configuration section a provider is built from
+func providerSettings(cfg *config.Config, name string) any {
+	if factory, ok := lookupProvider(name); ok && factory.Settings != nil {
+		return factory.Settings(cfg)
+	}
+	return nil
+}
+
+// ConfiguredProviders returns the providers "all" syncs to
+func (m *Manager) ConfiguredProviders() []string {
+	var names []string
+	for _, name := range ProviderNames() {
+		factory, _ := lookupProvider(name)
+		if factory.Configured == nil || factory.Configured(m.config) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Sync scans sourcePath and mirrors it to the named provider, or only
+// previews the sync with dryRun. SyncWithResult also returns the counts.
+func (m *Manager) Sync(ctx context.Context, providerName, sourcePath string, dryRun bool) error {
+	_, err := m.SyncWithResult(ctx, providerName, sourcePath, dryRun)
+	return err
+}
+
+// newProvider creates the named provider through its registered factory
+func (m *Manager) newProvider(ctx context.Context, name string) (Provider, error) {
+	factory, ok := lookupProvider(name)
+	if !ok {
+		return nil, Permanent(fmt.Errorf("unsupported provider: %s", name))
+	}
+	return factory.New(ctx, m)
+}
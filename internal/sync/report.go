@@ -0,0 +1,205 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// Statuses of a file in a sync report
+const (
+	FileUploaded   = "uploaded"
+	FileDownloaded = "downloaded"
+	FileSkipped    = "skipped"
+	FileFailed     = "failed"
+)
+
+// FileReport is the outcome of one file in a sync
+type FileReport struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Size   int64  `json:"size,omitempty"`
+	Reason string `json:"reason,omitempty"` // Why a file was skipped
+	Error  string `json:"error,omitempty"`  // Why a file failed
+}
+
+// ProviderReport is the outcome of a sync to one provider
+type ProviderReport struct {
+	Provider   string        `json:"provider"` // gdrive, pcloud, s3, sftp, onedrive, webdav or b2
+	Duration   time.Duration `json:"duration_ns"`
+	Uploaded   int           `json:"uploaded"`
+	Downloaded int           `json:"downloaded,omitempty"` // Pull and bidirectional syncs
+	Skipped    int           `json:"skipped"`
+	Failed     int           `json:"failed"`
+	Bytes      int64         `json:"bytes"`
+	Error      string        `json:"error,omitempty"` // Error that stopped the run early
+	Files      []FileReport  `json:"files"`
+}
+
+// SyncReport is the machine-readable result of a sync to one or more
+// providers, for CI pipelines and scripts
+type SyncReport struct {
+	Started    time.Time        `json:"started"`
+	Duration   time.Duration    `json:"duration_ns"`
+	Uploaded   int              `json:"uploaded"`
+	Downloaded int              `json:"downloaded,omitempty"`
+	Skipped    int              `json:"skipped"`
+	Failed     int              `json:"failed"`
+	Bytes      int64            `json:"bytes"`
+	Providers  []ProviderReport `json:"providers"`
+}
+
+// SyncResult is the outcome of a sync in numbers, for callers that log or
+// record a summary
+type SyncResult struct {
+	Provider         string // Provider synced to, or "all"
+	Uploaded         int
+	Downloaded       int // Pull and bidirectional syncs
+	Skipped          int
+	Failed           int
+	BytesTransferred int64
+	Duration         time.Duration
+}
+
+// String summarizes the result in one line
+func (r *SyncResult) String() string {
+	summary := fmt.Sprintf("%d uploaded (%s)", r.Uploaded, utils.FormatBytes(r.BytesTransferred))
+	if r.Downloaded > 0 {
+		summary += fmt.Sprintf(", %d downloaded", r.Downloaded)
+	}
+	return summary + fmt.Sprintf(", %d skipped, %d failed", r.Skipped, r.Failed)
+}
+
+// LastReport returns the report of the most recent sync
+func (m *Manager) LastReport() ProviderReport {
+	return m.report
+}
+
+// report lists every file of the run to provider with its outcome
+func (r *syncRun) report(provider string, took time.Duration, runErr error) ProviderReport {
+	summary := r.summarize(runErr)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := ProviderReport{
+		Provider:   provider,
+		Duration:   took,
+		Uploaded:   summary.Uploaded,
+		Downloaded: summary.Downloaded,
+		Skipped:    summary.Skipped,
+		Failed:     summary.Failed,
+		Bytes:      summary.UploadedBytes,
+		Error:      summary.Error,
+		Files:      []FileReport{},
+	}
+	for _, file := range r.uploaded {
+		report.Files = append(report.Files, FileReport{Path: file.Path, Status: FileUploaded, Size: file.Size})
+	}
+	for _, file := range r.downloaded {
+		report.Files = append(report.Files, FileReport{Path: file.Path, Status: FileDownloaded, Size: file.Size})
+	}
+	for _, skipped := range r.skipped {
+		report.Files = append(report.Files, FileReport{Path: skipped.Path, Status: FileSkipped, Reason: string(skipped.Reason)})
+	}
+	for _, failed := range r.failed {
+		report.Files = append(report.Files, FileReport{Path: failed.Path, Status: FileFailed, Error: failed.Err.Error()})
+	}
+	sort.SliceStable(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	return report
+}
+
+// NewSyncReport aggregates the reports of the providers synced in one run
+func NewSyncReport(started time.Time, providers ...ProviderReport) SyncReport {
+	report := SyncReport{
+		Started:   started,
+		Duration:  time.Since(started),
+		Providers: []ProviderReport{},
+	}
+	for _, p := range providers {
+		report.Uploaded += p.Uploaded
+		report.Downloaded += p.Downloaded
+		report.Skipped += p.Skipped
+		report.Failed += p.Failed
+		report.Bytes += p.Bytes
+		report.Providers = append(report.Providers, p)
+	}
+	return report
+}
+
+// Result returns the report's totals as the result of a sync to provider
+func (r SyncReport) Result(provider string) *SyncResult {
+	return &SyncResult{
+		Provider:         provider,
+		Uploaded:         r.Uploaded,
+		Downloaded:       r.Downloaded,
+		Skipped:          r.Skipped,
+		Failed:           r.Failed,
+		BytesTransferred: r.Bytes,
+		Duration:         r.Duration,
+	}
+}
+
+// Save writes the report to path as JSON
+func (r SyncReport) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync report: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create report directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write sync report: %w", err)
+	}
+	return nil
+}
+
+// SyncWithReport syncs sourcePath to the named provider, or with "all" to
+// every configured one, and returns the combined report. The report is also
+// written to report_path when that is set. The error is the first
+// provider's error; the others still run.
+func (m *Manager) SyncWithReport(ctx context.Context, providerName, sourcePath string) (SyncReport, error) {
+	started := time.Now()
+
+	names := []string{providerName}
+	if providerName == "all" {
+		names = m.ConfiguredProviders()
+	}
+
+	var reports []ProviderReport
+	var firstErr error
+	for _, name := range names {
+		err := m.syncProvider(ctx, name, sourcePath, nil, nil, false)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		reports = append(reports, m.LastReport())
+	}
+
+	report := NewSyncReport(started, reports...)
+	if path := m.config.GetAdvanced().ReportPath; path != "" {
+		if err := report.Save(path); err != nil {
+			return report, err
+		}
+	}
+	return report, firstErr
+}
+
+// SyncWithResult syncs sourcePath to the named provider, or with "all" to
+// every configured one at once, or only previews the sync with dryRun. The
+// result is returned with an error as well, counting what was done before
+// the sync stopped.
+func (m *Manager) SyncWithResult(ctx context.Context, providerName, sourcePath string, dryRun bool) (*SyncResult, error) {
+	return m.SyncWithOptions(ctx, providerName, sourcePath, SyncOptions{DryRun: dryRun})
+}
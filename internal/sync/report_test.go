@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestSyncReport(t *testing.T) {
+	run := &syncRun{
+		provider: &listingProvider{},
+		uploaded: []scanner.FileInfo{{Path: "b.txt", Size: 100}},
+		skipped:  []scanner.SkippedFile{{Path: "a.txt", Reason: scanner.SkipUnchanged}},
+		failed:   []FileFailure{{Path: "c.txt", Err: errors.New("quota exceeded")}},
+	}
+	gdrive := run.report("gdrive", time.Second, nil)
+
+	expected := []FileReport{
+		{Path: "a.txt", Status: FileSkipped, Reason: string(scanner.SkipUnchanged)},
+		{Path: "b.txt", Status: FileUploaded, Size: 100},
+		{Path: "c.txt", Status: FileFailed, Error: "quota exceeded"},
+	}
+	if !reflect.DeepEqual(gdrive.Files, expected) {
+		t.Errorf("Expected files %+v, got %+v", expected, gdrive.Files)
+	}
+
+	s3 := ProviderReport{Provider: "s3", Uploaded: 2, Bytes: 50, Error: "access denied", Files: []FileReport{}}
+	report := NewSyncReport(time.Now(), gdrive, s3)
+	if report.Uploaded != 3 || report.Skipped != 1 || report.Failed != 1 || report.Bytes != 150 || len(report.Providers) != 2 {
+		t.Errorf("Unexpected totals: %+v", report)
+	}
+}
+
+func TestSyncResult(t *testing.T) {
+	gdrive := ProviderReport{Provider: "gdrive", Uploaded: 1, Skipped: 4, Bytes: 2048}
+	s3 := ProviderReport{Provider: "s3", Uploaded: 2, Failed: 1, Bytes: 1024}
+	result := NewSyncReport(time.Now(), gdrive, s3).Result("all")
+
+	expected := &SyncResult{Provider: "all", Uploaded: 3, Skipped: 4, Failed: 1, BytesTransferred: 3072, Duration: result.Duration}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+	if summary := result.String(); summary != "3 uploaded (3.0 KiB), 4 skipped, 1 failed" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
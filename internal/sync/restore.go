@@ -0,0 +1,417 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// partialSuffix marks a download that has not been verified yet
+const partialSuffix = ".partial"
+
+// DownloadFile downloads a single remote file from the named provider to
+// localPath. With verify_downloads enabled the written file is checked
+// against the provider's reported hash (or size) and re-downloaded once on
+// mismatch before failing.
+func (m *Manager) DownloadFile(ctx context.Context, providerName, remotePath, localPath string) error {
+	p, err := m.provider(ctx, providerName)
+	if err != nil {
+		return err
+	}
+	return m.download(ctx, p, remotePath, localPath)
+}
+
+// download downloads remotePath from p to localPath as DownloadFile does
+func (m *Manager) download(ctx context.Context, p Provider, remotePath, localPath string) error {
+	verify := m.config.GetAdvanced().VerifyDownloads
+	tmpPath := localPath + partialSuffix
+
+	resumable := p.Capabilities().Supports(FeatureRangedDownload)
+
+	const maxAttempts = 2
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err := fetchPartial(ctx, p, remotePath, tmpPath, resumable); err != nil {
+			// Keep what was downloaded so far when a later run can resume it
+			if !resumable {
+				os.Remove(tmpPath)
+			}
+			return fmt.Errorf("failed to download %s: %w", remotePath, err)
+		}
+
+		if !verify {
+			break
+		}
+
+		err := verifyDownload(ctx, p, remotePath, tmpPath)
+		if err == nil {
+			utils.LogVerbose("Verified download: %s", remotePath)
+			break
+		}
+
+		os.Remove(tmpPath)
+		if attempt == maxAttempts {
+			return fmt.Errorf("download verification failed for %s: %w", remotePath, err)
+		}
+		utils.LogError("Download verification failed for %s, retrying: %v", remotePath, err)
+	}
+
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+
+	return nil
+}
+
+// fetchPartial downloads remotePath into tmpPath, continuing from the end of
+// an existing partial file when the provider supports ranged downloads
+func fetchPartial(ctx context.Context, p Provider, remotePath, tmpPath string, resumable bool) error {
+	info, err := os.Stat(tmpPath)
+	if !resumable || err != nil || info.Size() == 0 {
+		return p.Download(ctx, remotePath, tmpPath)
+	}
+
+	remote, err := p.GetFileInfo(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to get remote file info: %w", err)
+	}
+
+	switch {
+	case info.Size() == remote.Size:
+		utils.LogVerbose("Partial download of %s is already complete", remotePath)
+		return nil
+	case info.Size() > remote.Size:
+		// The remote file changed since the partial download started
+		return p.Download(ctx, remotePath, tmpPath)
+	}
+
+	utils.LogVerbose("Resuming download of %s at %d of %d bytes", remotePath, info.Size(), remote.Size)
+	return p.DownloadRange(ctx, remotePath, tmpPath, info.Size())
+}
+
+// restoreCheckpoint records which remote files a restore has fully
+// downloaded, so an interrupted restore can resume where it stopped
+type restoreCheckpoint struct {
+	path      string
+	saved     time.Time
+	Completed map[string]int64 `json:"completed"` // Remote path -> size
+}
+
+// restoreCheckpointInterval limits how often the checkpoint is rewritten
+const restoreCheckpointInterval = 2 * time.Second
+
+// loadRestoreCheckpoint reads the checkpoint for restoring a provider into destDir
+func loadRestoreCheckpoint(destDir, providerName string) (*restoreCheckpoint, error) {
+	cp := &restoreCheckpoint{
+		path:      filepath.Join(destDir, ".csync-restore-"+providerName+".json"),
+		Completed: make(map[string]int64),
+	}
+
+	data, err := os.ReadFile(cp.path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read restore checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("failed to parse restore checkpoint: %w", err)
+	}
+	if cp.Completed == nil {
+		cp.Completed = make(map[string]int64)
+	}
+
+	return cp, nil
+}
+
+// done reports whether a remote file was already restored at its current size
+func (cp *restoreCheckpoint) done(remote RemoteFileInfo) bool {
+	size, ok := cp.Completed[remote.Path]
+	return ok && size == remote.Size
+}
+
+// complete records a restored file, saving the checkpoint periodically
+func (cp *restoreCheckpoint) complete(remote RemoteFileInfo) error {
+	cp.Completed[remote.Path] = remote.Size
+	if time.Since(cp.saved) < restoreCheckpointInterval {
+		return nil
+	}
+	return cp.save()
+}
+
+// save writes the checkpoint to disk
+func (cp *restoreCheckpoint) save() error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal restore checkpoint: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cp.path), 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+
+	if err := os.WriteFile(cp.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write restore checkpoint: %w", err)
+	}
+
+	cp.saved = time.Now()
+	return nil
+}
+
+// Restore downloads every remote file from the named provider into destDir,
+// recreating the original tree through the path mapping (including the
+// flatten map) where it can be reversed. Progress is checkpointed in destDir
+// so re-running an interrupted restore skips the files already restored
+// and resumes partially downloaded ones.
+func (m *Manager) Restore(ctx context.Context, providerName, destDir string) error {
+	return m.RestoreFromProvider(ctx, providerName, "", destDir)
+}
+
+// RestoreFromProvider restores the files below remotePath ("" for the whole
+// destination) like Restore. Files whose local path the ignore and include
+// patterns filter out are left on the remote.
+func (m *Manager) RestoreFromProvider(ctx context.Context, providerName, remotePath, destDir string) error {
+	p, err := m.provider(ctx, providerName)
+	if err != nil {
+		return err
+	}
+
+	listing, err := p.List(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", p.Name(), err)
+	}
+
+	ignore, include, err := m.config.ResolvePatterns(m.patternProfile)
+	if err != nil {
+		return err
+	}
+	scn := scanner.NewScanner(ignore, include)
+	scn.SetForceInclude(m.config.General.ForceInclude)
+
+	checkpoint, err := loadRestoreCheckpoint(destDir, providerName)
+	if err != nil {
+		return err
+	}
+
+	// A flattened destination holds a copy of the map, which a machine
+	// restoring what another one synced needs
+	flatten, err := m.loadFlattenMap()
+	if err != nil {
+		return err
+	}
+	if flatten != nil && slices.ContainsFunc(listing, func(remote RemoteFileInfo) bool { return remote.Path == flattenMapName }) {
+		if err := m.fetchFlattenMap(ctx, p, flatten); err != nil {
+			return fmt.Errorf("failed to fetch flatten map: %w", err)
+		}
+	}
+
+	sidecars := m.config.GetAdvanced().ChecksumSidecars
+	var files []RemoteFileInfo
+	var folders []string
+	for _, remote := range listing {
+		if remote.IsDir {
+			folders = append(folders, remote.Path)
+			continue
+		}
+		if remote.Path == clockProbeName || remote.Path == flattenMapName || isSidecar(remote.Path, sidecars) {
+			continue
+		}
+		if isPack(remote.Path) {
+			files = append(files, remote) // Its files are filtered on extraction
+			continue
+		}
+		if localPath, _ := m.LocalPathFor(remote.Path); localPath != "" {
+			if skipped, ok := scn.Explain(localPath, false); ok {
+				utils.LogVerbose("Not restoring %s: %s", localPath, skipped.Reason)
+				continue
+			}
+		}
+		files = append(files, remote)
+	}
+
+	restored := 0
+	for _, remote := range files {
+		if checkpoint.done(remote) {
+			restored++
+		}
+	}
+	if restored > 0 {
+		utils.LogInfo("Resuming restore: %d of %d files already restored", restored, len(files))
+	}
+
+	for _, remote := range files {
+		if checkpoint.done(remote) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			if saveErr := checkpoint.save(); saveErr != nil {
+				utils.LogError("Failed to save restore checkpoint: %v", saveErr)
+			}
+			return err
+		}
+
+		if isPack(remote.Path) {
+			count, err := m.restorePack(ctx, p, remote.Path, destDir, scn)
+			if err != nil {
+				if saveErr := checkpoint.save(); saveErr != nil {
+					utils.LogError("Failed to save restore checkpoint: %v", saveErr)
+				}
+				return err
+			}
+			restored++
+			utils.LogInfo("← %s (%d files, restored %d of %d)", remote.Path, count, restored, len(files))
+			if err := checkpoint.complete(remote); err != nil {
+				return err
+			}
+			continue
+		}
+
+		localPath, ok := m.LocalPathFor(remote.Path)
+		if !ok {
+			utils.LogVerbose("No reverse mapping for %s, restoring at its remote path", remote.Path)
+		}
+
+		if !filepath.IsLocal(filepath.FromSlash(localPath)) {
+			return fmt.Errorf("refusing to restore %s outside %s", localPath, destDir)
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(localPath))
+		if err := m.DownloadFile(ctx, providerName, remote.Path, target); err != nil {
+			if saveErr := checkpoint.save(); saveErr != nil {
+				utils.LogError("Failed to save restore checkpoint: %v", saveErr)
+			}
+			return err
+		}
+
+		restored++
+		utils.LogInfo("← %s (restored %d of %d)", localPath, restored, len(files))
+		if err := checkpoint.complete(remote); err != nil {
+			return err
+		}
+	}
+
+	// Folders without files come back too
+	if err := m.createLocalFolders(destDir, folders, scn); err != nil {
+		return err
+	}
+
+	// A finished restore starts from scratch next time
+	if err := os.Remove(checkpoint.path); err != nil && !os.IsNotExist(err) {
+		utils.LogVerbose("Failed to remove restore checkpoint: %v", err)
+	}
+	utils.LogInfo("Restored %d of %d files", restored, len(files))
+
+	return nil
+}
+
+// createLocalFolders creates the local counterparts of remote folders below
+// root, leaving out the ones the ignore and include patterns filter out
+func (m *Manager) createLocalFolders(root string, folders []string, scn *scanner.Scanner) error {
+	for _, remotePath := range folders {
+		localPath, ok := m.LocalPathFor(remotePath)
+		if !ok || !filepath.IsLocal(filepath.FromSlash(localPath)) {
+			continue
+		}
+		if _, skipped := scn.Explain(localPath, true); skipped {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(localPath)), 0755); err != nil {
+			return fmt.Errorf("failed to create folder %s: %w", localPath, err)
+		}
+	}
+	return nil
+}
+
+// verifyDownload compares a downloaded file with the provider's metadata.
+// The first content hash the provider reports is used; otherwise only the
+// size is checked.
+func verifyDownload(ctx context.Context, p Provider, remotePath, localPath string) error {
+	remote, err := p.GetFileInfo(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to get remote file info: %w", err)
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat downloaded file: %w", err)
+	}
+
+	if remote.Size != SizeUnknown && info.Size() != remote.Size {
+		return fmt.Errorf("size mismatch: local %d bytes, remote %d bytes", info.Size(), remote.Size)
+	}
+
+	for _, algo := range p.Capabilities().Hashes {
+		want := remote.Checksum(algo)
+		if want == "" {
+			continue
+		}
+
+		hash, err := scanner.CalculateChecksum(localPath, string(algo))
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(hash, want) {
+			return fmt.Errorf("%s mismatch: local %s, remote %s", algo, hash, want)
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// writeDownload stores a download response. A 206 response to a ranged
+// request is appended to the partial file; a full 200 response replaces it.
+func writeDownload(localPath string, offset int64, resp *http.Response) error {
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		return appendLocalFile(localPath, resp.Body)
+	case resp.StatusCode == http.StatusOK:
+		return writeLocalFile(localPath, resp.Body)
+	default:
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+}
+
+// appendLocalFile streams r onto the end of an existing partial file
+func appendLocalFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+	return f.Close()
+}
+
+// writeLocalFile streams r into a newly created file at path, creating
+// parent directories as needed
+func writeLocalFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,105 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestRestoreFromProvider(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.General.IgnorePatterns = []string{"*.tmp"}
+	provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	source := t.TempDir()
+	for _, name := range []string{"docs/a.txt", "docs/sub/b.txt", "docs/scratch.tmp", "photos/c.jpg"} {
+		localPath := filepath.Join(source, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(localPath), 0755)
+		os.WriteFile(localPath, []byte(name), 0644)
+		if err := provider.Upload(ctx, scanner.FileInfo{AbsolutePath: localPath}, name); err != nil {
+			t.Fatalf("Failed to upload %s: %v", name, err)
+		}
+	}
+
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+	dest := t.TempDir()
+	if err := manager.RestoreFromProvider(ctx, "webdav", "docs", dest); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	for name, want := range map[string]bool{"docs/a.txt": true, "docs/sub/b.txt": true, "docs/scratch.tmp": false, "photos/c.jpg": false} {
+		data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if got := err == nil; got != want {
+			t.Errorf("%s restored: got %v, want %v", name, got, want)
+		} else if got && string(data) != name {
+			t.Errorf("%s has content %q", name, data)
+		}
+	}
+}
+
+func TestEmptyFilesAndFolders(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{SkipExisting: true}}
+	provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	source := t.TempDir()
+	os.MkdirAll(filepath.Join(source, "empty", "nested"), 0755)
+	blank := filepath.Join(source, "blank.txt")
+	os.WriteFile(blank, nil, 0644)
+
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if info, err := provider.GetFileInfo(ctx, "empty/nested"); err != nil || !info.IsDir {
+		t.Errorf("Expected the empty folder on the remote, got %v", err)
+	}
+	if info, err := provider.GetFileInfo(ctx, "blank.txt"); err != nil || info.Size != 0 {
+		t.Errorf("Expected the empty file on the remote, got %v", err)
+	}
+
+	// A touched empty file has no hash, but still matches its empty remote copy
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(blank, later, later)
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if uploaded := manager.LastSummary().Uploaded; uploaded != 0 {
+		t.Errorf("Expected the empty file to be skipped, got %d uploads", uploaded)
+	}
+
+	dest := t.TempDir()
+	if err := manager.Restore(ctx, "webdav", dest); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "empty", "nested")); err != nil || !info.IsDir() {
+		t.Errorf("Expected the empty folder to be restored, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "blank.txt")); err != nil || info.Size() != 0 {
+		t.Errorf("Expected the empty file to be restored, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// Backoff between retries starts at retryBaseDelay and doubles up to
+// retryMaxDelay
+var (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retry calls fn, retrying transient failures up to retries more times with
+// exponential backoff and jitter. Permanent failures return immediately.
+// Every call to fn must start over, rebuilding any request body it sends.
+func retry(ctx context.Context, retries int, op string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !isRetryable(err) {
+			return err
+		}
+
+		delay := retryDelay(attempt, err)
+		utils.LogVerbose("Retrying %s in %s (attempt %d of %d): %v", op, delay.Round(time.Millisecond), attempt+2, retries+1, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay returns how long to wait before retry number attempt+1: the
+// server's Retry-After if it sent one, otherwise a jittered exponential
+// backoff
+func retryDelay(attempt int, err error) time.Duration {
+	if after, ok := retryAfter(err); ok {
+		return min(after, retryMaxDelay)
+	}
+
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// Somewhere between half and all of the delay, so concurrent uploads
+	// that failed together don't retry together
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryAfter returns the delay requested by a 429 response's Retry-After
+// header, given either in seconds or as an HTTP date
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// isRetryable reports whether err is a transient failure worth retrying:
+// a network error, an HTTP 429 or 5xx, or a pCloud rate-limit or internal
+// error. Anything not known to be transient fails straight away.
+func isRetryable(err error) bool {
+	if err == nil || IsPermanent(err) || errors.Is(err, ErrBudgetExhausted) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pcloudErr *PCloudError
+	if errors.As(err, &pcloudErr) {
+		return pcloudErr.Result >= 4000 && pcloudErr.Result < 6000
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		// Rate-limit 403s are already excluded by IsPermanent
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500 ||
+			apiErr.Code == http.StatusForbidden
+	}
+
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.HTTPStatusCode()
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retry runs a provider operation under the run's retry_attempts setting
+func (r *syncRun) retry(ctx context.Context, op string, fn func() error) error {
+	return retry(ctx, r.retries, op, fn)
+}
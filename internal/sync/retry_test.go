@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryRetriesTransientErrors(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	calls := 0
+	err := retry(context.Background(), 3, "upload", func() error {
+		calls++
+		if calls < 3 {
+			return &googleapi.Error{Code: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retry() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("retry() made %d calls, expected 3", calls)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = time.Second }()
+
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"unauthorized", &googleapi.Error{Code: http.StatusUnauthorized}, 1},
+		{"forbidden", &googleapi.Error{Code: http.StatusForbidden}, 1},
+		{"pcloud auth", newPCloudError("upload", 2000, "Log in failed."), 1},
+		{"unknown", errors.New("failed to decode upload response"), 1},
+		{"budget", ErrBudgetExhausted, 1},
+		{"attempts exhausted", newPCloudError("upload", 4000, "Too many login tries"), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retry(context.Background(), 2, "upload", func() error {
+				calls++
+				return tt.err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("retry() error = %v, expected %v", err, tt.err)
+			}
+			if calls != tt.calls {
+				t.Errorf("retry() made %d calls, expected %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryDelayHonorsRetryAfter(t *testing.T) {
+	err := &googleapi.Error{
+		Code:   http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After": {"7"}},
+	}
+	if got := retryDelay(0, err); got != 7*time.Second {
+		t.Errorf("retryDelay() = %s, expected 7s", got)
+	}
+}
@@ -0,0 +1,192 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	s3client "github.com/svosadtsia/csync/internal/providers/s3"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+)
+
+// S3Provider implements the Provider interface for Amazon S3 and
+// S3-compatible object stores
+type S3Provider struct {
+	client *s3client.Client
+}
+
+// NewS3Provider creates a new S3 provider
+func NewS3Provider(ctx context.Context, cfg *config.S3Config) (*S3Provider, error) {
+	return newS3Provider(ctx, cfg, nil)
+}
+
+func init() {
+	RegisterProvider("s3", ProviderFactory{
+		DisplayName: "S3",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := newS3Provider(ctx, &m.config.S3, m.transport("s3"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create S3 client: %w", err)
+			}
+			return client, nil
+		},
+		Settings:   func(cfg *config.Config) any { return cfg.S3 },
+		Configured: func(cfg *config.Config) bool { return cfg.S3.Bucket != "" },
+		Destination: func(cfg *config.Config) string {
+			if cfg.S3.Bucket == "" {
+				return ""
+			}
+			return fmt.Sprintf("s3://%s/%s", cfg.S3.Bucket, cfg.S3.Prefix)
+		},
+	})
+}
+
+// newS3Provider creates an S3 provider whose API requests go through
+// transport (nil for the default)
+func newS3Provider(ctx context.Context, cfg *config.S3Config, transport http.RoundTripper) (*S3Provider, error) {
+	client, err := s3client.NewClientWithTransport(ctx, cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Provider{client: client}, nil
+}
+
+// Name returns the provider name
+func (p *S3Provider) Name() string {
+	return "S3"
+}
+
+// Capabilities reports the optional features S3 supports
+func (p *S3Provider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		Hashes:         []HashAlgorithm{HashMD5}, // The ETag of a single-part upload
+		ServerSideCopy: true,
+		AtomicUpload:   true,
+		Metadata:       true,
+		RangedDownload: true,
+	}
+}
+
+// Upload uploads a file to S3. Parent "folders" are implied by the key.
+func (p *S3Provider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.client.Upload(ctx, file.AbsolutePath, remotePath)
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *S3Provider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.client.SetUploadLimiter(limiter)
+}
+
+// CreateFolder creates a zero-byte folder key
+func (p *S3Provider) CreateFolder(ctx context.Context, remotePath string) error {
+	return p.client.CreateFolder(ctx, remotePath)
+}
+
+// FileExists checks if an object exists in S3
+func (p *S3Provider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	obj, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return false, err
+	}
+	return obj != nil, nil
+}
+
+// GetFileInfo gets information about an object in S3
+func (p *S3Provider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	obj, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	info := s3FileInfo(*obj)
+	return &info, nil
+}
+
+// Delete deletes an object from S3
+func (p *S3Provider) Delete(ctx context.Context, remotePath string) error {
+	return p.client.Delete(ctx, remotePath)
+}
+
+// Copy copies an object on the server side
+func (p *S3Provider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Copy(ctx, srcRemotePath, dstRemotePath)
+}
+
+// Move copies an object to its new key and deletes the original
+func (p *S3Provider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Move(ctx, srcRemotePath, dstRemotePath)
+}
+
+// PublicLink is not supported: bucket policies decide what's public
+func (p *S3Provider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return "", &UnsupportedError{Provider: p.Name(), Feature: FeaturePublicLinks}
+}
+
+// List recursively lists objects below remotePath ("" for the destination
+// root). Returned paths are relative to the destination root.
+func (p *S3Provider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	objects, err := p.client.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]RemoteFileInfo, 0, len(objects))
+	for _, obj := range objects {
+		files = append(files, s3FileInfo(obj))
+	}
+	return files, nil
+}
+
+// Download writes the content of an object to localPath
+func (p *S3Provider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of an object from offset onwards,
+// appending to the existing localPath
+func (p *S3Provider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	body, err := p.client.Open(ctx, remotePath, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if offset == 0 {
+		return writeLocalFile(localPath, body)
+	}
+
+	return appendLocalFile(localPath, body)
+}
+
+// UpdateMetadata stores the file's mode and ownership as object metadata
+// without re-uploading its content
+func (p *S3Provider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.client.SetMetadata(ctx, remotePath, map[string]string{
+		"csync-mode": fmt.Sprintf("%04o", file.Mode.Perm()),
+		"csync-uid":  strconv.Itoa(file.UID),
+		"csync-gid":  strconv.Itoa(file.GID),
+	})
+}
+
+// s3FileInfo converts an S3 object to a RemoteFileInfo. Multipart ETags
+// ("<hash>-<parts>") are not an MD5 of the content and are dropped.
+func s3FileInfo(obj s3client.Object) RemoteFileInfo {
+	info := RemoteFileInfo{
+		Path:     obj.Path,
+		Size:     obj.Size,
+		Modified: obj.LastModified.UTC().Format(time.RFC3339Nano),
+		IsDir:    obj.IsDir,
+	}
+	if !obj.IsDir && !strings.Contains(obj.ETag, "-") {
+		info.MD5Hash = obj.ETag
+	}
+	return info
+}
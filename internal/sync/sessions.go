@@ -0,0 +1,132 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// uploadSessionMaxAge is how long an interrupted upload session is worth
+// resuming; Google Drive expires resumable sessions after a week
+const uploadSessionMaxAge = 6 * 24 * time.Hour
+
+// uploadSession is an interrupted chunked upload that a later attempt can
+// continue. ID is the provider's handle for it (a session URI or upload ID).
+type uploadSession struct {
+	ID         string    `json:"id"`
+	RemotePath string    `json:"remote_path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Created    time.Time `json:"created"`
+}
+
+// uploadSessions persists in-progress chunked uploads, keyed by provider
+// and local file, so they survive restarts
+type uploadSessions struct {
+	path     string
+	mu       gosync.Mutex
+	Sessions map[string]uploadSession `json:"sessions"`
+}
+
+// loadUploadSessions reads the sessions stored at path. With an empty path
+// sessions are only kept in memory.
+func loadUploadSessions(path string) (*uploadSessions, error) {
+	sessions := &uploadSessions{
+		path:     path,
+		Sessions: make(map[string]uploadSession),
+	}
+	if path == "" {
+		return sessions, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload sessions: %w", err)
+	}
+
+	if err := json.Unmarshal(data, sessions); err != nil {
+		return nil, fmt.Errorf("failed to parse upload sessions: %w", err)
+	}
+	if sessions.Sessions == nil {
+		sessions.Sessions = make(map[string]uploadSession)
+	}
+
+	return sessions, nil
+}
+
+// sessionKey identifies an upload of a local file to a provider
+func sessionKey(provider string, file scanner.FileInfo) string {
+	return provider + ":" + file.AbsolutePath
+}
+
+// get returns the session for uploading file to remotePath, if one exists
+// for the same file content and hasn't expired
+func (s *uploadSessions) get(provider string, file scanner.FileInfo, remotePath string) (uploadSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.Sessions[sessionKey(provider, file)]
+	if !ok || session.RemotePath != remotePath || session.Size != file.Size ||
+		!session.ModTime.Equal(file.ModTime) || time.Since(session.Created) > uploadSessionMaxAge {
+		return uploadSession{}, false
+	}
+	return session, true
+}
+
+// set records a newly started session
+func (s *uploadSessions) set(provider string, file scanner.FileInfo, remotePath, id string) error {
+	s.mu.Lock()
+	s.Sessions[sessionKey(provider, file)] = uploadSession{
+		ID:         id,
+		RemotePath: remotePath,
+		Size:       file.Size,
+		ModTime:    file.ModTime,
+		Created:    time.Now(),
+	}
+	s.mu.Unlock()
+
+	return s.save()
+}
+
+// remove forgets a finished or abandoned session
+func (s *uploadSessions) remove(provider string, file scanner.FileInfo) error {
+	s.mu.Lock()
+	delete(s.Sessions, sessionKey(provider, file))
+	s.mu.Unlock()
+
+	return s.save()
+}
+
+// save writes the sessions back to disk, dropping expired ones
+func (s *uploadSessions) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	// Held while writing so concurrent uploads can't save stale snapshots
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, session := range s.Sessions {
+		if time.Since(session.Created) > uploadSessionMaxAge {
+			delete(s.Sessions, key)
+		}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload sessions: %w", err)
+	}
+
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write upload sessions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	sftpclient "github.com/svosadtsia/csync/internal/providers/sftp"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+)
+
+// SFTPProvider implements the Provider interface for SFTP servers
+type SFTPProvider struct {
+	client *sftpclient.Client
+}
+
+func init() {
+	RegisterProvider("sftp", ProviderFactory{
+		DisplayName: "SFTP",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := NewSFTPProvider(&m.config.SFTP)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create SFTP client: %w", err)
+			}
+			return client, nil
+		},
+		Settings:   func(cfg *config.Config) any { return cfg.SFTP },
+		Configured: func(cfg *config.Config) bool { return cfg.SFTP.Host != "" },
+		Destination: func(cfg *config.Config) string {
+			if cfg.SFTP.Host == "" {
+				return ""
+			}
+			return fmt.Sprintf("%s:%s", cfg.SFTP.Host, cfg.SFTP.RemoteBasePath)
+		},
+	})
+}
+
+// NewSFTPProvider creates a new SFTP provider
+func NewSFTPProvider(cfg *config.SFTPConfig) (*SFTPProvider, error) {
+	client, err := sftpclient.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &SFTPProvider{client: client}, nil
+}
+
+// Name returns the provider name
+func (p *SFTPProvider) Name() string {
+	return "SFTP"
+}
+
+// Capabilities reports the optional features SFTP supports
+func (p *SFTPProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		// No Hashes: SFTP has no standard checksum request
+		AtomicRename:   true,
+		AtomicUpload:   true,
+		Metadata:       true,
+		RangedDownload: true,
+	}
+}
+
+// Upload uploads a file to the server, creating parent folders as needed
+func (p *SFTPProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.client.Upload(ctx, file.AbsolutePath, remotePath)
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *SFTPProvider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.client.SetUploadLimiter(limiter)
+}
+
+// CreateFolder creates a folder and any missing parents
+func (p *SFTPProvider) CreateFolder(ctx context.Context, remotePath string) error {
+	return p.client.CreateFolder(ctx, remotePath)
+}
+
+// FileExists checks if a file exists on the server
+func (p *SFTPProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	return p.client.FileExists(ctx, remotePath)
+}
+
+// GetFileInfo gets information about a file on the server
+func (p *SFTPProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	info, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	remote := sftpFileInfo(remotePath, info)
+	return &remote, nil
+}
+
+// Delete removes a file or folder from the server
+func (p *SFTPProvider) Delete(ctx context.Context, remotePath string) error {
+	return p.client.Delete(ctx, remotePath)
+}
+
+// Copy is not supported: SFTP has no server-side copy
+func (p *SFTPProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureServerSideCopy}
+}
+
+// Move renames a file or folder on the server
+func (p *SFTPProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Rename(ctx, srcRemotePath, dstRemotePath)
+}
+
+// PublicLink is not supported: SFTP servers have no sharing API
+func (p *SFTPProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return "", &UnsupportedError{Provider: p.Name(), Feature: FeaturePublicLinks}
+}
+
+// List recursively lists files and folders below remotePath ("" for the
+// destination root). Returned paths are relative to the destination root.
+func (p *SFTPProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	entries, err := p.client.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]RemoteFileInfo, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, sftpFileInfo(entry.Path, entry.Info))
+	}
+	return files, nil
+}
+
+// Download writes the content of a remote file to localPath
+func (p *SFTPProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of a remote file from offset onwards,
+// appending to the existing localPath
+func (p *SFTPProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	f, err := p.client.Open(ctx, remotePath, offset)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if offset == 0 {
+		return writeLocalFile(localPath, f)
+	}
+	return appendLocalFile(localPath, f)
+}
+
+// UpdateMetadata applies the file's mode and ownership on the server
+func (p *SFTPProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.client.SetMetadata(ctx, remotePath, file.Mode, file.UID, file.GID)
+}
+
+// sftpFileInfo converts a remote file's stat result to a RemoteFileInfo
+func sftpFileInfo(remotePath string, info os.FileInfo) RemoteFileInfo {
+	return RemoteFileInfo{
+		Path:     remotePath,
+		Size:     info.Size(),
+		Modified: info.ModTime().UTC().Format(time.RFC3339Nano),
+		IsDir:    info.IsDir(),
+	}
+}
@@ -0,0 +1,257 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// IntegrityIssue is a file whose remote copy doesn't match its checksum sidecar
+type IntegrityIssue struct {
+	Path    string
+	Problem string
+}
+
+// newChecksum returns a hash for a sidecar algorithm ("md5" or "sha256")
+func newChecksum(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %s", algo)
+	}
+}
+
+// fileChecksum returns the hex-encoded checksum of a local file
+func fileChecksum(filePath, algo string) (string, error) {
+	h, err := newChecksum(algo)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read file for hashing: %w", err)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// isSidecar reports whether a remote path is a checksum sidecar
+func isSidecar(remotePath, algo string) bool {
+	return algo != "" && strings.HasSuffix(remotePath, "."+algo)
+}
+
+// uploadSidecar uploads "<remotePath>.<algo>" in md5sum/sha256sum format
+// so the sidecars can also be checked with those tools
+func uploadSidecar(ctx context.Context, p Provider, file scanner.FileInfo, remotePath, algo string) error {
+	sum := file.Checksum
+	if file.HashAlgorithm != algo || sum == "" {
+		var err error
+		if sum, err = fileChecksum(file.AbsolutePath, algo); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "csync-sidecar-*")
+	if err != nil {
+		return fmt.Errorf("failed to create sidecar file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintf(tmp, "%s  %s\n", sum, path.Base(remotePath)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write sidecar file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write sidecar file: %w", err)
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return fmt.Errorf("failed to stat sidecar file: %w", err)
+	}
+
+	sidecarPath := remotePath + "." + algo
+	sidecar := scanner.FileInfo{
+		Path:         sidecarPath,
+		AbsolutePath: tmp.Name(),
+		Size:         info.Size(),
+		ModTime:      time.Now(),
+		Mode:         info.Mode().Perm(),
+	}
+
+	if err := p.Upload(ctx, sidecar, sidecarPath); err != nil {
+		return fmt.Errorf("failed to upload checksum sidecar: %w", err)
+	}
+
+	return nil
+}
+
+// readSidecar downloads a sidecar and returns the checksum it records
+func readSidecar(ctx context.Context, p Provider, sidecarPath string) (string, error) {
+	tmp, err := os.CreateTemp("", "csync-sidecar-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := p.Download(ctx, sidecarPath, tmp.Name()); err != nil {
+		return "", fmt.Errorf("failed to download sidecar: %w", err)
+	}
+
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to open sidecar: %w", err)
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read sidecar: %w", err)
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty sidecar: %s", sidecarPath)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// VerifySidecars checks every remote file on the named provider against its
+// checksum sidecar. With download set each file is downloaded and re-hashed;
+// otherwise the provider's own MD5 is compared where it has one, and the
+// size recorded in the sync state where it doesn't.
+func (m *Manager) VerifySidecars(ctx context.Context, providerName string, download bool) ([]IntegrityIssue, error) {
+	algo := m.config.GetAdvanced().ChecksumSidecars
+	if algo == "" {
+		return nil, fmt.Errorf("checksum_sidecars is not enabled")
+	}
+
+	p, err := m.provider(ctx, providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := p.List(ctx, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", p.Name(), err)
+	}
+
+	var state *SyncState
+	if statePath := m.config.GetAdvanced().StatePath; statePath != "" {
+		if state, err = LoadState(statePath); err != nil {
+			return nil, err
+		}
+	}
+
+	sidecars := make(map[string]bool)
+	for _, remote := range listing {
+		if isSidecar(remote.Path, algo) {
+			sidecars[remote.Path] = true
+		}
+	}
+
+	var issues []IntegrityIssue
+	for _, remote := range listing {
+		if remote.IsDir || isSidecar(remote.Path, algo) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return issues, err
+		}
+
+		sidecarPath := remote.Path + "." + algo
+		if !sidecars[sidecarPath] {
+			issues = append(issues, IntegrityIssue{Path: remote.Path, Problem: "missing checksum sidecar"})
+			continue
+		}
+
+		want, err := readSidecar(ctx, p, sidecarPath)
+		if err != nil {
+			issues = append(issues, IntegrityIssue{Path: remote.Path, Problem: err.Error()})
+			continue
+		}
+
+		var synced *StateEntry
+		if state != nil {
+			localPath, _ := m.LocalPathFor(remote.Path)
+			if entry, ok := state.Get(providerName, localPath); ok {
+				synced = &entry
+			}
+		}
+
+		problem, err := checkIntegrity(ctx, p, remote, want, algo, download, synced)
+		if err != nil {
+			issues = append(issues, IntegrityIssue{Path: remote.Path, Problem: err.Error()})
+			continue
+		}
+		if problem != "" {
+			utils.LogError("Integrity check failed for %s: %s", remote.Path, problem)
+			issues = append(issues, IntegrityIssue{Path: remote.Path, Problem: problem})
+			continue
+		}
+		utils.LogVerbose("Verified: %s", remote.Path)
+	}
+
+	return issues, nil
+}
+
+// checkIntegrity compares one remote file with the checksum from its
+// sidecar, or with the synced state entry when that's all there is, and
+// returns a description of any mismatch
+func checkIntegrity(ctx context.Context, p Provider, remote RemoteFileInfo, want, algo string, download bool, synced *StateEntry) (string, error) {
+	if download {
+		tmp, err := os.CreateTemp("", "csync-verify-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		if err := p.Download(ctx, remote.Path, tmp.Name()); err != nil {
+			return "", fmt.Errorf("failed to download: %w", err)
+		}
+
+		got, err := fileChecksum(tmp.Name(), algo)
+		if err != nil {
+			return "", err
+		}
+		if got != want {
+			return fmt.Sprintf("%s mismatch: sidecar %s, content %s", algo, want, got), nil
+		}
+		return "", nil
+	}
+
+	if sum := remote.Checksum(HashAlgorithm(algo)); sum != "" && p.Capabilities().ReportsHash(HashAlgorithm(algo)) {
+		if !strings.EqualFold(sum, want) {
+			return fmt.Sprintf("%s mismatch: sidecar %s, provider %s", algo, want, sum), nil
+		}
+		return "", nil
+	}
+
+	if synced != nil && remote.Size != SizeUnknown && synced.Size != remote.Size {
+		return fmt.Sprintf("size mismatch: synced %d bytes, provider reports %d", synced.Size, remote.Size), nil
+	}
+
+	return "", nil
+}
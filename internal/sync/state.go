@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// StateEntry records what was last synced for a single file
+type StateEntry struct {
+	RemotePath string      `json:"remote_path,omitempty"`
+	Size       int64       `json:"size"`
+	ModTime    time.Time   `json:"mod_time"`
+	MD5Hash    string      `json:"md5,omitempty"`
+	Checksum   string      `json:"checksum,omitempty"`
+	Mode       os.FileMode `json:"mode,omitempty"`
+	UID        int         `json:"uid,omitempty"`
+	GID        int         `json:"gid,omitempty"`
+
+	// RemoteVersion identifies the remote copy as it was after the sync, so
+	// a two-way sync can tell whether it changed since
+	RemoteVersion string `json:"remote_version,omitempty"`
+}
+
+// SyncState is the persisted record of previously synced files, keyed by
+// provider name and then by relative path
+type SyncState struct {
+	path      string
+	mu        gosync.Mutex
+	Providers map[string]map[string]StateEntry `json:"providers"`
+}
+
+// LoadState reads the sync state from path. A missing file yields an empty state.
+func LoadState(path string) (*SyncState, error) {
+	state := &SyncState{
+		path:      path,
+		Providers: make(map[string]map[string]StateEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if state.Providers == nil {
+		state.Providers = make(map[string]map[string]StateEntry)
+	}
+
+	return state, nil
+}
+
+// Get returns the recorded entry for a file synced to the given provider
+func (s *SyncState) Get(provider, path string) (StateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.Providers[provider][path]
+	return entry, ok
+}
+
+// Set records a successfully synced file and the remote path it was written to
+func (s *SyncState) Set(provider string, file scanner.FileInfo, remotePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Providers[provider] == nil {
+		s.Providers[provider] = make(map[string]StateEntry)
+	}
+	s.Providers[provider][file.Path] = StateEntry{
+		RemotePath: remotePath,
+		Size:       file.Size,
+		ModTime:    file.ModTime,
+		MD5Hash:    file.MD5Hash,
+		Checksum:   file.Checksum,
+		Mode:       file.Mode,
+		UID:        file.UID,
+		GID:        file.GID,
+	}
+}
+
+// SetRemoteVersion records the version of the remote copy of a synced file
+func (s *SyncState) SetRemoteVersion(provider, path, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.Providers[provider][path]; ok {
+		entry.RemoteVersion = version
+		s.Providers[provider][path] = entry
+	}
+}
+
+// Delete forgets a file synced to the given provider
+func (s *SyncState) Delete(provider, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.Providers[provider], path)
+}
+
+// Entries returns a copy of every entry recorded for the given provider
+func (s *SyncState) Entries(provider string) map[string]StateEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make(map[string]StateEntry, len(s.Providers[provider]))
+	for path, entry := range s.Providers[provider] {
+		entries[path] = entry
+	}
+	return entries
+}
+
+// Save writes the state back to the file it was loaded from
+func (s *SyncState) Save() error {
+	// Held for the write too, so concurrent saves don't interleave
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// checksum returns the recorded content hash. Entries written before other
+// algorithms were supported only have the MD5.
+func (e StateEntry) checksum() string {
+	if e.Checksum != "" {
+		return e.Checksum
+	}
+	return e.MD5Hash
+}
+
+// stateUnchanged reports whether a file still has the size and modification
+// time recorded when it was synced to remotePath, and with permissions the
+// same mode and owner
+func stateUnchanged(entry StateEntry, file scanner.FileInfo, remotePath string, permissions bool) bool {
+	if entry.RemotePath != remotePath || entry.Size != file.Size || !entry.ModTime.Equal(file.ModTime) {
+		return false
+	}
+	return !permissions || (entry.Mode == file.Mode && entry.UID == file.UID && entry.GID == file.GID)
+}
+
+// metadataOnlyChange reports whether a file's content matches the recorded
+// entry while its mode or ownership differs
+func metadataOnlyChange(entry StateEntry, file scanner.FileInfo) bool {
+	sameContent := entry.Size == file.Size &&
+		entry.ModTime.Equal(file.ModTime) &&
+		entry.checksum() == file.Checksum
+	if !sameContent {
+		return false
+	}
+	return entry.Mode != file.Mode || entry.UID != file.UID || entry.GID != file.GID
+}
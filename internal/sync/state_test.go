@@ -0,0 +1,108 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+// lookupCounter counts how often a sync asks the provider about a file
+type lookupCounter struct {
+	Provider
+	lookups atomic.Int64
+}
+
+func (p *lookupCounter) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	p.lookups.Add(1)
+	return p.Provider.GetFileInfo(ctx, remotePath)
+}
+
+func TestStateSkipsUnchangedFiles(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		SkipExisting: true,
+		StatePath:    filepath.Join(t.TempDir(), "state.json"),
+	}}
+	webdavProvider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider := &lookupCounter{Provider: webdavProvider}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	source := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		os.WriteFile(filepath.Join(source, name), []byte(name), 0644)
+	}
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	// Only the changed file is looked up and uploaded
+	provider.lookups.Store(0)
+	later := time.Now().Add(time.Minute)
+	os.WriteFile(filepath.Join(source, "b.txt"), []byte("b.txt, edited"), 0644)
+	os.Chtimes(filepath.Join(source, "b.txt"), later, later)
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if lookups := provider.lookups.Load(); lookups != 1 {
+		t.Errorf("Expected 1 remote lookup, got %d", lookups)
+	}
+	if uploaded := manager.LastSummary().Uploaded; uploaded != 1 {
+		t.Errorf("Expected 1 upload, got %d", uploaded)
+	}
+}
+
+func TestPermissionChangeWithoutMetadata(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File modes can't be changed on Windows")
+	}
+	server := httptest.NewServer(&webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()})
+	defer server.Close()
+
+	// WebDAV can't store modes, so a mode change has nothing to update
+	ctx := context.Background()
+	cfg := &config.Config{WebDAV: config.WebDAVConfig{URL: server.URL, Username: "me", Password: "secret"}}
+	cfg.Optional = &config.OptionalConfig{Advanced: &config.AdvancedConfig{
+		PreservePermissions: true,
+		StatePath:           filepath.Join(t.TempDir(), "state.json"),
+	}}
+	provider, err := newWebDAVProvider(ctx, &cfg.WebDAV, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	manager := NewManager(cfg)
+	manager.providers["webdav"] = provider
+
+	source := t.TempDir()
+	os.WriteFile(filepath.Join(source, "script.sh"), []byte("echo hi"), 0644)
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+
+	os.Chmod(filepath.Join(source, "script.sh"), 0755)
+	if err := manager.SyncToWebDAV(ctx, source, false); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if s := manager.LastSummary(); s.Uploaded != 0 || s.Skipped != 1 {
+		t.Errorf("Expected the file to be skipped, got %d uploaded and %d skipped", s.Uploaded, s.Skipped)
+	}
+	if files := manager.LastReport().Files; len(files) != 1 || files[0].Path != "script.sh" {
+		t.Errorf("Expected script.sh in the report, got %+v", files)
+	}
+}
@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// RunSummary collects the errors and warnings of one sync, grouped by kind
+type RunSummary struct {
+	Provider      string         `json:"provider"`
+	Uploaded      int            `json:"uploaded"`
+	UploadedBytes int64          `json:"uploaded_bytes"`
+	Downloaded    int            `json:"downloaded,omitempty"`
+	Skipped       int            `json:"skipped"`
+	Failed        int            `json:"failed"`
+	Warnings      int            `json:"warnings"`
+	APICalls      int64          `json:"api_calls"`
+	Error         string         `json:"error,omitempty"` // Error that stopped the run early
+	Groups        []SummaryGroup `json:"groups,omitempty"`
+}
+
+// SummaryGroup is every error or warning of one kind
+type SummaryGroup struct {
+	Severity string    `json:"severity"` // "error" or "warning"
+	Kind     ErrorKind `json:"kind"`
+	Count    int       `json:"count"`
+	Paths    []string  `json:"paths"`
+}
+
+// LastSummary returns the error and warning summary of the most recent sync
+func (m *Manager) LastSummary() RunSummary {
+	return m.summary
+}
+
+// warn records a non-fatal problem to report in the run summary
+func (r *syncRun) warn(path string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, FileFailure{Path: path, Err: err})
+}
+
+// summarize counts the run's uploads and skips and groups its failures and
+// warnings by kind. runErr is the
+// error the run returned, if any.
+func (r *syncRun) summarize(runErr error) RunSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := RunSummary{
+		Provider:      r.provider.Name(),
+		Uploaded:      len(r.uploaded),
+		UploadedBytes: uploadedBytes(r.uploaded),
+		Downloaded:    len(r.downloaded),
+		Skipped:       len(r.skipped),
+		Failed:        len(r.failed),
+		Warnings:      len(r.warnings),
+	}
+
+	// Per-file failures are already listed file by file
+	var failures *SyncFailuresError
+	if runErr != nil && !errors.As(runErr, &failures) {
+		summary.Error = runErr.Error()
+	}
+
+	summary.Groups = append(groupFailures("error", r.failed), groupFailures("warning", r.warnings)...)
+	return summary
+}
+
+// groupFailures groups failures by ErrorKind, largest group first
+func groupFailures(severity string, failures []FileFailure) []SummaryGroup {
+	byKind := make(map[ErrorKind]*SummaryGroup)
+	for _, f := range failures {
+		kind := ClassifyError(f.Err)
+		group, ok := byKind[kind]
+		if !ok {
+			group = &SummaryGroup{Severity: severity, Kind: kind}
+			byKind[kind] = group
+		}
+		group.Count++
+		group.Paths = append(group.Paths, f.Path)
+	}
+
+	groups := make([]SummaryGroup, 0, len(byKind))
+	for _, group := range byKind {
+		sort.Strings(group.Paths)
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Kind < groups[j].Kind
+	})
+	return groups
+}
+
+// logSummary prints the summary block in the configured log format. A
+// clean run is only mentioned in verbose mode.
+func logSummary(summary RunSummary, format string) {
+	if format == "json" {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			utils.LogError("Failed to encode run summary: %v", err)
+			return
+		}
+		utils.Print("%s", data)
+		return
+	}
+
+	if summary.Failed == 0 && summary.Warnings == 0 && summary.Error == "" {
+		utils.LogVerbose("%s: no errors or warnings", summary.Provider)
+		return
+	}
+
+	utils.Print("")
+	utils.Print("==== %s summary: %d failed, %d warnings ====", summary.Provider, summary.Failed, summary.Warnings)
+	if summary.Error != "" {
+		utils.Print("Run stopped: %s", summary.Error)
+	}
+	for _, group := range summary.Groups {
+		utils.Print("%s %s (%d):", group.Kind, group.Severity+"s", group.Count)
+		for _, path := range group.Paths {
+			utils.Print("  %s", path)
+		}
+	}
+}
+
+// uploadedBytes returns the total size of files
+func uploadedBytes(files []scanner.FileInfo) int64 {
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+	return total
+}
@@ -1,9 +1,144 @@
 package sync
 
+import (
+	"context"
+	"fmt"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+// SizeUnknown is the size of a remote file whose original size can't be
+// told from the stored copy, such as a compressed one
+const SizeUnknown = -1
+
 // RemoteFileInfo represents information about a file in cloud storage
 type RemoteFileInfo struct {
-	Path     string
-	Size     int64
-	MD5Hash  string
-	Modified string
+	Path       string `json:"path"`
+	Size       int64  `json:"size"` // SizeUnknown if unknown
+	MD5Hash    string `json:"md5,omitempty"`
+	SHA1Hash   string `json:"sha1,omitempty"`
+	SHA256Hash string `json:"sha256,omitempty"`
+	Modified   string `json:"modified,omitempty"`
+	IsDir      bool   `json:"is_dir,omitempty"`
+}
+
+// Provider is implemented by every cloud storage backend the Manager can sync to
+type Provider interface {
+	Name() string
+	Capabilities() ProviderCapabilities
+	Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error
+	CreateFolder(ctx context.Context, remotePath string) error
+	FileExists(ctx context.Context, remotePath string) (bool, error)
+	GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error)
+	Delete(ctx context.Context, remotePath string) error
+	Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error
+	Move(ctx context.Context, srcRemotePath, dstRemotePath string) error
+	PublicLink(ctx context.Context, remotePath string) (string, error)
+	List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error)
+	Download(ctx context.Context, remotePath, localPath string) error
+	DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error
+	UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error
+}
+
+// HashAlgorithm identifies the content hash a provider reports for remote files
+type HashAlgorithm string
+
+const (
+	HashNone   HashAlgorithm = ""       // No hash, or one that can't be compared with local content
+	HashMD5    HashAlgorithm = "md5"    // RemoteFileInfo.MD5Hash is a true MD5 of the content
+	HashSHA1   HashAlgorithm = "sha1"   // RemoteFileInfo.SHA1Hash is a SHA-1 of the content
+	HashSHA256 HashAlgorithm = "sha256" // RemoteFileInfo.SHA256Hash is a SHA-256 of the content
+)
+
+// Checksum returns the remote content hash for algo, or "" if the
+// provider didn't report one
+func (r *RemoteFileInfo) Checksum(algo HashAlgorithm) string {
+	switch algo {
+	case HashMD5:
+		return r.MD5Hash
+	case HashSHA1:
+		return r.SHA1Hash
+	case HashSHA256:
+		return r.SHA256Hash
+	default:
+		return ""
+	}
+}
+
+// ProviderCapabilities describes the optional features a provider supports
+type ProviderCapabilities struct {
+	Hashes         []HashAlgorithm // Content hashes reported by GetFileInfo, preferred first
+	ServerSideCopy bool            // Remote files can be copied without re-uploading
+	PublicLinks    bool            // Shareable public links can be created
+	Versioning     bool            // Overwritten files keep previous revisions
+	AtomicRename   bool            // Files can be moved/renamed in a single call
+	AtomicUpload   bool            // Uploads never leave a partially written file visible
+	Metadata       bool            // Mode/ownership can be stored without re-uploading
+	RangedDownload bool            // Downloads can resume from a byte offset
+}
+
+// Feature names a capability that higher-level sync features depend on
+type Feature string
+
+const (
+	FeatureContentHash    Feature = "content hash"
+	FeatureServerSideCopy Feature = "server-side copy"
+	FeaturePublicLinks    Feature = "public links"
+	FeatureVersioning     Feature = "versioning"
+	FeatureAtomicRename   Feature = "atomic rename"
+	FeatureAtomicUpload   Feature = "atomic upload"
+	FeatureMetadata       Feature = "metadata updates"
+	FeatureRangedDownload Feature = "ranged download"
+)
+
+// ReportsHash reports whether GetFileInfo returns content hashes of algo
+func (c ProviderCapabilities) ReportsHash(algo HashAlgorithm) bool {
+	for _, hash := range c.Hashes {
+		if hash == algo {
+			return true
+		}
+	}
+	return false
+}
+
+// Supports reports whether the capability set includes the given feature
+func (c ProviderCapabilities) Supports(feature Feature) bool {
+	switch feature {
+	case FeatureContentHash:
+		return len(c.Hashes) > 0
+	case FeatureServerSideCopy:
+		return c.ServerSideCopy
+	case FeaturePublicLinks:
+		return c.PublicLinks
+	case FeatureVersioning:
+		return c.Versioning
+	case FeatureAtomicRename:
+		return c.AtomicRename
+	case FeatureAtomicUpload:
+		return c.AtomicUpload
+	case FeatureMetadata:
+		return c.Metadata
+	case FeatureRangedDownload:
+		return c.RangedDownload
+	default:
+		return false
+	}
+}
+
+// UnsupportedError is returned when a feature needs a capability the provider lacks
+type UnsupportedError struct {
+	Provider string
+	Feature  Feature
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s does not support %s", e.Provider, e.Feature)
+}
+
+// RequireFeature returns an UnsupportedError if the provider lacks the given feature
+func RequireFeature(p Provider, feature Feature) error {
+	if p.Capabilities().Supports(feature) {
+		return nil
+	}
+	return &UnsupportedError{Provider: p.Name(), Feature: feature}
 }
@@ -0,0 +1,69 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProviderCapabilities(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		hash     HashAlgorithm
+		has      []Feature
+		lacks    []Feature
+	}{
+		{&GoogleDriveProvider{}, HashMD5, []Feature{FeatureContentHash, FeaturePublicLinks, FeatureMetadata, FeatureAtomicRename}, nil},
+		{&PCloudProvider{}, HashNone, []Feature{FeatureServerSideCopy, FeaturePublicLinks, FeatureVersioning}, []Feature{FeatureContentHash, FeatureMetadata}},
+		{&B2Provider{}, HashSHA1, []Feature{FeatureContentHash, FeatureVersioning}, []Feature{FeaturePublicLinks, FeatureAtomicRename}},
+		{&S3Provider{}, HashMD5, []Feature{FeatureContentHash, FeatureMetadata}, []Feature{FeatureAtomicRename}},
+		{&SFTPProvider{}, HashNone, []Feature{FeatureAtomicRename, FeatureMetadata}, []Feature{FeatureContentHash, FeatureServerSideCopy}},
+		{&WebDAVProvider{}, HashNone, []Feature{FeatureServerSideCopy}, []Feature{FeatureContentHash, FeatureAtomicUpload}},
+		{&OneDriveProvider{}, HashNone, []Feature{FeatureVersioning}, []Feature{FeatureContentHash, FeaturePublicLinks}},
+	}
+	for _, tt := range tests {
+		caps := tt.provider.Capabilities()
+		if tt.hash != HashNone && (len(caps.Hashes) == 0 || caps.Hashes[0] != tt.hash) {
+			t.Errorf("%T: expected %s to be the preferred hash, got %v", tt.provider, tt.hash, caps.Hashes)
+		}
+		for _, feature := range tt.has {
+			if !caps.Supports(feature) {
+				t.Errorf("%T: expected support for %s", tt.provider, feature)
+			}
+		}
+		for _, feature := range tt.lacks {
+			if caps.Supports(feature) {
+				t.Errorf("%T: expected no support for %s", tt.provider, feature)
+			}
+		}
+	}
+
+	// Stored content differs from the local file, so its hashes can't be compared
+	for _, wrapped := range []Provider{&compressedProvider{Provider: &GoogleDriveProvider{}}, &encryptedProvider{Provider: &GoogleDriveProvider{}}} {
+		if caps := wrapped.Capabilities(); caps.Supports(FeatureContentHash) || caps.Supports(FeatureRangedDownload) {
+			t.Errorf("%T: expected no content hash or ranged download, got %+v", wrapped, caps)
+		}
+	}
+}
+
+func TestRequireFeature(t *testing.T) {
+	provider := &PCloudProvider{}
+	if err := RequireFeature(provider, FeaturePublicLinks); err != nil {
+		t.Errorf("Expected public links to be supported, got %v", err)
+	}
+
+	err := RequireFeature(provider, FeatureMetadata)
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) || unsupported.Feature != FeatureMetadata || unsupported.Provider != provider.Name() {
+		t.Fatalf("Expected an UnsupportedError for metadata updates, got %v", err)
+	}
+	if want := provider.Name() + " does not support metadata updates"; err.Error() != want {
+		t.Errorf("Expected %q, got %q", want, err.Error())
+	}
+
+	if (ProviderCapabilities{}).Supports(Feature("teleport")) {
+		t.Error("Expected unknown features to be unsupported")
+	}
+	if caps := (ProviderCapabilities{Hashes: []HashAlgorithm{HashSHA1, HashMD5}}); !caps.ReportsHash(HashMD5) || caps.ReportsHash(HashSHA256) {
+		t.Errorf("Expected md5 and sha1 only, got %v", caps.Hashes)
+	}
+}
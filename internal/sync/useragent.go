@@ -0,0 +1,39 @@
+package sync
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// defaultUserAgent identifies csync and its version in requests when no
+// custom_user_agent is set
+func defaultUserAgent() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	return "csync/" + version
+}
+
+// userAgent returns the User-Agent providers send with their requests
+func (m *Manager) userAgent() string {
+	if agent := m.config.GetAdvanced().CustomUserAgent; agent != "" {
+		return agent
+	}
+	return defaultUserAgent()
+}
+
+// userAgentTransport sets the User-Agent of requests that don't have one.
+// Client libraries that name themselves, like Google's, keep their own.
+type userAgentTransport struct {
+	base  http.RoundTripper
+	agent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.agent)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	gosync "sync"
+
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/pkg/utils"
+)
+
+// UploadMismatchError is returned when the hash a provider reports for an
+// upload differs from the local file's
+type UploadMismatchError struct {
+	Path      string
+	Algorithm HashAlgorithm
+	Local     string
+	Remote    string
+}
+
+func (e *UploadMismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch after uploading %s: local %s, remote %s", e.Algorithm, e.Path, e.Local, e.Remote)
+}
+
+// verifyUpload compares remoteSum, the algo hash a provider reported for an
+// upload, with the local file. An empty remoteSum can't be checked and
+// passes.
+func verifyUpload(file scanner.FileInfo, remotePath string, algo HashAlgorithm, remoteSum string) error {
+	if remoteSum == "" {
+		utils.LogVerbose("No %s reported for %s, not verifying the upload", algo, remotePath)
+		return nil
+	}
+
+	sum := file.Checksum
+	if file.HashAlgorithm != string(algo) || sum == "" {
+		var err error
+		if sum, err = scanner.CalculateChecksum(file.AbsolutePath, string(algo)); err != nil {
+			return fmt.Errorf("failed to hash %s: %w", file.Path, err)
+		}
+	}
+	if !strings.EqualFold(sum, remoteSum) {
+		return &UploadMismatchError{Path: remotePath, Algorithm: algo, Local: sum, Remote: remoteSum}
+	}
+	utils.LogVerbose("Verified upload: %s", remotePath)
+	return nil
+}
+
+// uploadHash hashes a file as an upload reads it, so the upload can be
+// verified without reading the file again. Chunked uploads read the file
+// a chunk at a time, and a chunk sent again is hashed again from its start.
+type uploadHash struct {
+	mu     gosync.Mutex
+	algo   HashAlgorithm
+	hash   hash.Hash
+	next   int64  // Bytes of the file hashed so far
+	start  int64  // Offset of the chunk being sent
+	state  []byte // Hash state at start
+	reads  int    // Readers handed out; only the newest one is hashed
+	broken bool   // The upload skipped around, so the hash isn't the file's
+}
+
+// newUploadHash returns an uploadHash for uploading file if verifying the
+// upload needs one: verify is set and the scan didn't hash the file with
+// algo. Otherwise it returns nil, whose readers don't hash.
+func newUploadHash(file scanner.FileInfo, algo HashAlgorithm, verify bool) *uploadHash {
+	if !verify || (file.HashAlgorithm == string(algo) && file.Checksum != "") {
+		return nil
+	}
+	h, err := scanner.NewHash(string(algo))
+	if err != nil {
+		return nil
+	}
+	return &uploadHash{algo: algo, hash: h}
+}
+
+// reader returns r, the file from offset on, hashing what is read from it.
+// Reading from the start of the chunk last read rolls the hash back to
+// there, so a resent chunk isn't hashed twice.
+func (u *uploadHash) reader(r io.Reader, offset int64) io.Reader {
+	if u == nil {
+		return r
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.reads++
+	switch {
+	case u.broken:
+	case offset == u.next:
+		marshaler, ok := u.hash.(encoding.BinaryMarshaler)
+		if !ok {
+			u.broken = true
+			break
+		}
+		state, err := marshaler.MarshalBinary()
+		u.start, u.state, u.broken = offset, state, err != nil
+	case offset == u.start:
+		unmarshaler, ok := u.hash.(encoding.BinaryUnmarshaler)
+		u.next, u.broken = offset, !ok || unmarshaler.UnmarshalBinary(u.state) != nil
+	default:
+		u.broken = true
+	}
+	return &hashedReader{r: r, hash: u, read: u.reads}
+}
+
+// skip hashes the first n bytes of file, which an upload continuing an
+// earlier one doesn't send
+func (u *uploadHash) skip(file io.ReaderAt, n int64) {
+	if u != nil && n > 0 {
+		io.Copy(io.Discard, u.reader(io.NewSectionReader(file, 0, n), 0))
+	}
+}
+
+// hashed returns file with the hash of what the upload read as its
+// checksum, or file as it is unless the upload read all of it
+func (u *uploadHash) hashed(file scanner.FileInfo) scanner.FileInfo {
+	if u == nil {
+		return file
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.broken || u.next != file.Size {
+		return file
+	}
+	file.Checksum = fmt.Sprintf("%x", u.hash.Sum(nil))
+	file.HashAlgorithm = string(u.algo)
+	if u.algo == HashMD5 {
+		file.MD5Hash = file.Checksum
+	}
+	return file
+}
+
+// hashedReader feeds what is read from r to its uploadHash, unless a newer
+// reader has taken over, as when the HTTP client resends a body while the
+// old one is still being read
+type hashedReader struct {
+	r    io.Reader
+	hash *uploadHash
+	read int
+}
+
+func (r *hashedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	u := r.hash
+	u.mu.Lock()
+	if r.read == u.reads && !u.broken {
+		u.hash.Write(p[:n])
+		u.next += int64(n)
+	}
+	u.mu.Unlock()
+	return n, err
+}
+
+// uploadVerified uploads a file with the run's retries. An upload the
+// provider reports as corrupt is deleted and uploaded once more before
+// failing.
+func (r *syncRun) uploadVerified(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	const maxAttempts = 2
+	for attempt := 1; ; attempt++ {
+		err := r.retry(ctx, "upload "+remotePath, func() error {
+			return r.provider.Upload(ctx, file, remotePath)
+		})
+		var mismatch *UploadMismatchError
+		if !errors.As(err, &mismatch) {
+			return err
+		}
+
+		if err := r.provider.Delete(ctx, remotePath); err != nil {
+			utils.LogError("Failed to delete corrupt upload %s: %v", remotePath, err)
+		}
+		if attempt == maxAttempts {
+			return fmt.Errorf("upload verification failed: %w", err)
+		}
+		utils.LogError("Upload verification failed for %s, retrying: %v", remotePath, err)
+	}
+}
@@ -0,0 +1,178 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+	webdavclient "github.com/svosadtsia/csync/internal/providers/webdav"
+	"github.com/svosadtsia/csync/internal/scanner"
+	"github.com/svosadtsia/csync/internal/throttle"
+)
+
+// WebDAVProvider implements the Provider interface for WebDAV servers such
+// as Nextcloud and ownCloud
+type WebDAVProvider struct {
+	client *webdavclient.Client
+}
+
+// NewWebDAVProvider creates a new WebDAV provider
+func NewWebDAVProvider(ctx context.Context, cfg *config.WebDAVConfig) (*WebDAVProvider, error) {
+	return newWebDAVProvider(ctx, cfg, nil)
+}
+
+func init() {
+	RegisterProvider("webdav", ProviderFactory{
+		DisplayName: "WebDAV",
+		New: func(ctx context.Context, m *Manager) (Provider, error) {
+			client, err := newWebDAVProvider(ctx, &m.config.WebDAV, m.transport("webdav"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create WebDAV client: %w", err)
+			}
+			return client, nil
+		},
+		Settings:   func(cfg *config.Config) any { return cfg.WebDAV },
+		Configured: func(cfg *config.Config) bool { return cfg.WebDAV.URL != "" },
+		Destination: func(cfg *config.Config) string {
+			if cfg.WebDAV.URL == "" {
+				return ""
+			}
+			return cfg.WebDAV.URL + cfg.WebDAV.RemoteBasePath
+		},
+	})
+}
+
+// newWebDAVProvider creates a WebDAV provider whose requests go through
+// transport (nil for the default)
+func newWebDAVProvider(ctx context.Context, cfg *config.WebDAVConfig, transport http.RoundTripper) (*WebDAVProvider, error) {
+	client, err := webdavclient.NewClientWithTransport(ctx, cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAVProvider{client: client}, nil
+}
+
+// Name returns the provider name
+func (p *WebDAVProvider) Name() string {
+	return "WebDAV"
+}
+
+// Capabilities reports the optional features WebDAV supports
+func (p *WebDAVProvider) Capabilities() ProviderCapabilities {
+	return ProviderCapabilities{
+		// No Hashes: checksums are a server-specific extension
+		ServerSideCopy: true,
+		AtomicRename:   true,
+		RangedDownload: true,
+	}
+}
+
+// Upload uploads a file to the server, creating parent folders as needed
+func (p *WebDAVProvider) Upload(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return p.client.Upload(ctx, file.AbsolutePath, remotePath)
+}
+
+// setUploadLimiter limits the rate at which uploads send data
+func (p *WebDAVProvider) setUploadLimiter(limiter *throttle.Limiter) {
+	p.client.SetUploadLimiter(limiter)
+}
+
+// CreateFolder creates a folder and any missing parents
+func (p *WebDAVProvider) CreateFolder(ctx context.Context, remotePath string) error {
+	return p.client.CreateFolder(ctx, remotePath)
+}
+
+// FileExists checks if a file exists on the server
+func (p *WebDAVProvider) FileExists(ctx context.Context, remotePath string) (bool, error) {
+	return p.client.FileExists(ctx, remotePath, -1)
+}
+
+// GetFileInfo gets information about a file on the server
+func (p *WebDAVProvider) GetFileInfo(ctx context.Context, remotePath string) (*RemoteFileInfo, error) {
+	resource, err := p.client.Stat(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+	if resource == nil {
+		return nil, fmt.Errorf("file not found: %s", remotePath)
+	}
+
+	info := webDAVFileInfo(*resource)
+	return &info, nil
+}
+
+// Delete removes a file or folder from the server
+func (p *WebDAVProvider) Delete(ctx context.Context, remotePath string) error {
+	return p.client.Delete(ctx, remotePath)
+}
+
+// Copy copies a file or folder on the server
+func (p *WebDAVProvider) Copy(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Copy(ctx, srcRemotePath, dstRemotePath)
+}
+
+// Move moves a file or folder on the server
+func (p *WebDAVProvider) Move(ctx context.Context, srcRemotePath, dstRemotePath string) error {
+	return p.client.Move(ctx, srcRemotePath, dstRemotePath)
+}
+
+// PublicLink is not supported: sharing is not part of WebDAV
+func (p *WebDAVProvider) PublicLink(ctx context.Context, remotePath string) (string, error) {
+	return "", &UnsupportedError{Provider: p.Name(), Feature: FeaturePublicLinks}
+}
+
+// List recursively lists files and folders below remotePath ("" for the
+// destination root). Returned paths are relative to the destination root.
+func (p *WebDAVProvider) List(ctx context.Context, remotePath string) ([]RemoteFileInfo, error) {
+	resources, err := p.client.List(ctx, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]RemoteFileInfo, 0, len(resources))
+	for _, resource := range resources {
+		files = append(files, webDAVFileInfo(resource))
+	}
+	return files, nil
+}
+
+// Download writes the content of a remote file to localPath
+func (p *WebDAVProvider) Download(ctx context.Context, remotePath, localPath string) error {
+	return p.DownloadRange(ctx, remotePath, localPath, 0)
+}
+
+// DownloadRange writes the content of a remote file from offset onwards,
+// appending to the existing localPath
+func (p *WebDAVProvider) DownloadRange(ctx context.Context, remotePath, localPath string, offset int64) error {
+	body, err := p.client.Open(ctx, remotePath, offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if offset == 0 {
+		return writeLocalFile(localPath, body)
+	}
+	return appendLocalFile(localPath, body)
+}
+
+// UpdateMetadata is not supported: WebDAV has no standard mode or
+// ownership properties
+func (p *WebDAVProvider) UpdateMetadata(ctx context.Context, file scanner.FileInfo, remotePath string) error {
+	return &UnsupportedError{Provider: p.Name(), Feature: FeatureMetadata}
+}
+
+// webDAVFileInfo converts a WebDAV resource to a RemoteFileInfo
+func webDAVFileInfo(resource webdavclient.Resource) RemoteFileInfo {
+	info := RemoteFileInfo{
+		Path:  resource.Path,
+		Size:  resource.Size,
+		IsDir: resource.IsDir,
+	}
+	if !resource.LastModified.IsZero() {
+		info.Modified = resource.LastModified.UTC().Format(time.RFC3339Nano)
+	}
+	return info
+}
@@ -0,0 +1,70 @@
+package sync
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/svosadtsia/csync/internal/config"
+	"github.com/svosadtsia/csync/internal/scanner"
+)
+
+func TestWebDAVProvider(t *testing.T) {
+	server := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav/files/me",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+	cfg := &config.WebDAVConfig{URL: server.URL + "/dav/files/me", Username: "me", Password: "secret", RemoteBasePath: "/backups"}
+	provider, err := newWebDAVProvider(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	localPath := filepath.Join(t.TempDir(), "a b.txt")
+	os.WriteFile(localPath, []byte("hello"), 0644)
+	if err := provider.Upload(ctx, scanner.FileInfo{AbsolutePath: localPath}, "docs/2024/a b.txt"); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+
+	// MKCOL on existing folders succeeds
+	if err := provider.CreateFolder(ctx, "docs/2024"); err != nil {
+		t.Errorf("Failed to create existing folder: %v", err)
+	}
+
+	if exists, err := provider.client.FileExists(ctx, "docs/2024/a b.txt", 5); err != nil || !exists {
+		t.Errorf("Expected file with matching size to exist, got %v, %v", exists, err)
+	}
+	if exists, _ := provider.client.FileExists(ctx, "docs/2024/a b.txt", 6); exists {
+		t.Error("Expected file with different size to not match")
+	}
+
+	if err := provider.Move(ctx, "docs/2024/a b.txt", "docs/b.txt"); err != nil {
+		t.Fatalf("Failed to move: %v", err)
+	}
+	files, err := provider.List(ctx, "")
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	listed := make(map[string]int64)
+	for _, f := range files {
+		listed[f.Path] = f.Size
+	}
+	if size, ok := listed["docs/b.txt"]; !ok || size != 5 || len(listed) != 3 {
+		t.Errorf("Unexpected listing: %v", listed)
+	}
+
+	if err := provider.Delete(ctx, "docs"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if exists, err := provider.FileExists(ctx, "docs/b.txt"); err != nil || exists {
+		t.Errorf("Expected deleted file to be gone, got %v, %v", exists, err)
+	}
+}
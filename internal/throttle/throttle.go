@@ -0,0 +1,69 @@
+// Package throttle limits the combined rate at which uploads read data
+package throttle
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter is a byte rate shared by every reader it wraps. A nil Limiter
+// doesn't limit anything.
+type Limiter struct {
+	limiter *rate.Limiter
+}
+
+// NewLimiter returns a Limiter allowing bytesPerSec bytes per second in
+// total, or nil when bytesPerSec is 0 (unlimited)
+func NewLimiter(bytesPerSec int64) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := int(min(bytesPerSec, 1<<30))
+	return &Limiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), burst)}
+}
+
+// Reader returns r limited to l's rate until ctx is done. Readers that can
+// seek still can, so upload code that rewinds its body keeps working.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	reader := &reader{ctx: ctx, r: r, limiter: l.limiter}
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		return &readSeeker{reader: reader, seeker: seeker}
+	}
+	return reader
+}
+
+// reader waits for the limiter after each read
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	// A single wait can't exceed the burst
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// readSeeker is a reader whose underlying reader can seek
+type readSeeker struct {
+	*reader
+	seeker io.Seeker
+}
+
+func (r *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
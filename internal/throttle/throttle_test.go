@@ -0,0 +1,97 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnlimited(t *testing.T) {
+	l := NewLimiter(0)
+	if l != nil {
+		t.Fatalf("Expected no limiter for 0 bytes per second, got %v", l)
+	}
+	r := bytes.NewReader([]byte("data"))
+	if got := l.Reader(context.Background(), r); got != io.Reader(r) {
+		t.Errorf("Expected a nil limiter to return the reader itself, got %T", got)
+	}
+}
+
+func TestReadCappedAtBurst(t *testing.T) {
+	l := NewLimiter(100)
+	r := l.Reader(context.Background(), bytes.NewReader(make([]byte, 1000)))
+
+	n, err := r.Read(make([]byte, 1000))
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if n != 100 {
+		t.Errorf("Expected a read of at most the 100 byte burst, got %d", n)
+	}
+}
+
+func TestRateSharedAcrossReaders(t *testing.T) {
+	// The burst covers the first 4000 bytes; the other 2000 take half a
+	// second at 4000 bytes per second, whichever reader reads them
+	l := NewLimiter(4000)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := l.Reader(context.Background(), bytes.NewReader(make([]byte, 3000)))
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				t.Errorf("Failed to read: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected 6000 bytes to take about 500ms in total, took %s", elapsed)
+	}
+}
+
+func TestReadCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := NewLimiter(10).Reader(ctx, bytes.NewReader(make([]byte, 100)))
+
+	if _, err := r.Read(make([]byte, 10)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestSeekPassesThrough(t *testing.T) {
+	l := NewLimiter(1 << 20)
+	r := l.Reader(context.Background(), bytes.NewReader([]byte("hello world")))
+
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok {
+		t.Fatalf("Expected a seekable reader to stay seekable, got %T", r)
+	}
+	if _, err := io.ReadAll(seeker); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if pos, err := seeker.Seek(6, io.SeekStart); err != nil || pos != 6 {
+		t.Fatalf("Expected to seek to 6, got %d, %v", pos, err)
+	}
+	data, err := io.ReadAll(seeker)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(data) != "world" {
+		t.Errorf("Expected world after seeking, got %q", data)
+	}
+
+	// A reader that can't seek isn't made to look like one
+	plain := l.Reader(context.Background(), io.MultiReader(bytes.NewReader([]byte("data"))))
+	if _, ok := plain.(io.Seeker); ok {
+		t.Error("Expected a reader that can't seek not to implement io.Seeker")
+	}
+}
@@ -0,0 +1,92 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// maxQueuedPaths bounds the paths with events waiting to be read from the
+// Events channel. Past it the queue collapses into one event per watch
+// root, which covers every change below it.
+const maxQueuedPaths = 10000
+
+// eventQueue holds the events not yet delivered, at most one per path. A
+// newer event for a queued path takes the queued one's place in line.
+type eventQueue struct {
+	mu      sync.Mutex
+	order   []string
+	pending map[string]FileEvent
+	ready   chan struct{} // Signalled when an event is queued
+}
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		pending: make(map[string]FileEvent),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// push queues event and reports whether it was merged with an event already
+// queued for the same path. The merged event keeps the name a renamed file
+// had, and a renamed folder stays queued as one, since it calls for a full
+// sync anyway.
+func (q *eventQueue) push(event FileEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued, merged := q.pending[event.Name]
+	switch {
+	case !merged:
+		q.order = append(q.order, event.Name)
+		q.pending[event.Name] = event
+	case queued.Op == Rename && queued.IsDir:
+	default:
+		if event.From == "" {
+			event.From = queued.From
+		}
+		q.pending[event.Name] = event
+	}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return merged
+}
+
+// pop removes and returns the oldest queued event
+func (q *eventQueue) pop() (FileEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		return FileEvent{}, false
+	}
+	name := q.order[0]
+	q.order = q.order[1:]
+	if len(q.order) == 0 {
+		q.order = nil
+	}
+	event := q.pending[name]
+	delete(q.pending, name)
+	return event, true
+}
+
+// overflowed reports whether more paths than maxQueuedPaths are queued
+func (q *eventQueue) overflowed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.order) > maxQueuedPaths
+}
+
+// collapse replaces everything queued with a change to each of roots
+func (q *eventQueue) collapse(roots []string, now time.Time) {
+	q.mu.Lock()
+	q.order = nil
+	q.pending = make(map[string]FileEvent, len(roots))
+	q.mu.Unlock()
+
+	for _, root := range roots {
+		q.push(FileEvent{Name: root, Op: Write, Time: now, IsDir: true})
+	}
+}
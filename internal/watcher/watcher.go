@@ -5,18 +5,25 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+
 	"github.com/svosadtsia/csync/internal/config"
 	"github.com/svosadtsia/csync/pkg/utils"
 )
 
-// FileEvent represents a file system event
+// FileEvent represents a file system event. A file renamed within the
+// watched tree is one Rename under its new name, with From set to the old
+// one; other renames are reported under the old name alone.
 type FileEvent struct {
-	Name string    // File path
-	Op   Operation // Operation type
-	Time time.Time // Event timestamp
+	Name  string    // File path
+	From  string    // For a Rename, the path the file was renamed from, if known
+	Op    Operation // Operation type
+	Time  time.Time // Event timestamp
+	IsDir bool      // Whether the path is (or, for removals and renames, was) a directory
 }
 
 // Operation represents the type of file operation
@@ -30,30 +37,85 @@ const (
 	Chmod  Operation = "CHMOD"
 )
 
+// renameWindow is how long a file's Rename is held back waiting for the
+// Create of its new name
+const renameWindow = 100 * time.Millisecond
+
 // FileWatcher watches for file system changes
 type FileWatcher struct {
 	config      *config.Config
-	watchPaths  map[string]bool
+	notify      *fsnotify.Watcher
+	watchPaths  map[string]bool // Roots added with AddPath
+	watchedDirs map[string]bool // Every directory watched below the roots
 	events      chan FileEvent
+	queue       *eventQueue // Events waiting for a reader of events
 	errors      chan error
 	stopChan    chan struct{}
+	stopped     bool // Set by Stop under mu, after which nothing may send on errors
 	wg          sync.WaitGroup
 	mu          sync.RWMutex
 	debounceMap map[string]time.Time
-	debounce    time.Duration
+	watch       WatchConfig
+
+	statsMu sync.Mutex
+	stats   Stats
 }
 
-// NewFileWatcher creates a new file watcher
-func NewFileWatcher(cfg *config.Config) (*FileWatcher, error) {
-	return &FileWatcher{
+// Stats describes the watcher's workload so its cost on large trees is visible
+type Stats struct {
+	PathsWatched    int           // Directories watched across all watch roots
+	Scans           int64         // Tree walks done to add watches (roots and new folders)
+	EventsEmitted   int64         // Events delivered on the Events channel
+	EventsCoalesced int64         // Events merged into one still queued for the same path
+	Overflows       int64         // Times the queue grew too long and was collapsed into its watch roots
+	LastScan        time.Duration // Duration of the most recent walk
+	MaxScan         time.Duration // Longest walk so far
+}
+
+// NewFileWatcher creates a new file watcher. watch's ignore patterns apply
+// together with cfg's.
+func NewFileWatcher(cfg *config.Config, watch WatchConfig) (*FileWatcher, error) {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	fw := &FileWatcher{
 		config:      cfg,
+		notify:      notify,
 		watchPaths:  make(map[string]bool),
-		events:      make(chan FileEvent, 100),
+		watchedDirs: make(map[string]bool),
+		events:      make(chan FileEvent),
+		queue:       newEventQueue(),
 		errors:      make(chan error, 10),
 		stopChan:    make(chan struct{}),
 		debounceMap: make(map[string]time.Time),
-		debounce:    2 * time.Second, // Debounce events for 2 seconds
-	}, nil
+		watch:       watch,
+	}
+
+	fw.wg.Add(2)
+	go fw.run()
+	go fw.deliver()
+
+	return fw, nil
+}
+
+// Stats returns a snapshot of the watcher's resource usage
+func (fw *FileWatcher) Stats() Stats {
+	fw.statsMu.Lock()
+	defer fw.statsMu.Unlock()
+	return fw.stats
+}
+
+// recordScan updates the stats after walking a tree to add watches.
+// dirsDelta is the change in the number of directories watched.
+func (fw *FileWatcher) recordScan(took time.Duration, dirsDelta int) {
+	fw.statsMu.Lock()
+	defer fw.statsMu.Unlock()
+	fw.stats.Scans++
+	fw.stats.LastScan = took
+	fw.stats.MaxScan = max(fw.stats.MaxScan, took)
+	fw.stats.PathsWatched += dirsDelta
 }
 
 // AddPath adds a path to watch for changes
@@ -61,6 +123,12 @@ func (fw *FileWatcher) AddPath(path string) error {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
+	// Watching reports errors for subfolders, which mustn't race with Stop
+	// closing the channel
+	if fw.stopped {
+		return fmt.Errorf("file watcher is stopped")
+	}
+
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path: %w", err)
@@ -75,13 +143,13 @@ func (fw *FileWatcher) AddPath(path string) error {
 		return fmt.Errorf("path does not exist: %s", absPath)
 	}
 
+	if _, err := fw.watchPath(absPath, absPath); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", absPath, err)
+	}
+
 	fw.watchPaths[absPath] = true
 	log.Printf("Added watch path: %s", absPath)
 
-	// Start watching this path
-	fw.wg.Add(1)
-	go fw.watchPath(absPath)
-
 	return nil
 }
 
@@ -100,11 +168,21 @@ func (fw *FileWatcher) RemovePath(path string) error {
 	}
 
 	delete(fw.watchPaths, absPath)
+	fw.unwatchTree(absPath)
 	log.Printf("Removed watch path: %s", absPath)
 
 	return nil
 }
 
+// SetConfig replaces the configuration, so new ignore patterns and
+// debounce time apply to the events and folders seen from now on
+func (fw *FileWatcher) SetConfig(cfg *config.Config, watch WatchConfig) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.config = cfg
+	fw.watch = watch
+}
+
 // Events returns the events channel
 func (fw *FileWatcher) Events() <-chan FileEvent {
 	return fw.events
@@ -115,154 +193,306 @@ func (fw *FileWatcher) Errors() <-chan error {
 	return fw.errors
 }
 
-// Stop stops the file watcher
+// Stop stops the file watcher and closes its channels. It is safe to call
+// more than once and concurrently with the other methods.
 func (fw *FileWatcher) Stop() {
+	fw.mu.Lock()
+	if fw.stopped {
+		fw.mu.Unlock()
+		return
+	}
+	fw.stopped = true
 	close(fw.stopChan)
+	fw.mu.Unlock()
+
+	// Only the goroutines waited for here send on the channels once stopped
+	fw.notify.Close()
 	fw.wg.Wait()
 	close(fw.events)
 	close(fw.errors)
 }
 
-// watchPath watches a specific path for changes using polling
-// Note: This is a simplified implementation using polling since Go's standard library
-// doesn't include file system notifications. For production use, consider using
-// a third-party library like fsnotify.
-func (fw *FileWatcher) watchPath(path string) {
-	defer fw.wg.Done()
-
-	// Keep track of file states
-	fileStates := make(map[string]os.FileInfo)
+// watchPath adds a watch for dir and every directory below it that isn't
+// ignored, and returns the files and folders found inside. fsnotify only
+// watches single directories, so each one needs its own watch. The caller
+// must hold fw.mu.
+func (fw *FileWatcher) watchPath(root, dir string) ([]string, error) {
+	var found []string
+	start := time.Now()
+	before := len(fw.watchedDirs)
 
-	// Initial scan
-	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip files we can't access
 		}
 
-		// Skip ignored files
-		relPath, _ := filepath.Rel(path, filePath)
-		if utils.ShouldIgnore(relPath, fw.config.General.IgnorePatterns) {
+		// Skip ignored files and excluded folders
+		relPath, _ := filepath.Rel(root, filePath)
+		if filePath != root && fw.ignored(root, relPath) {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		fileStates[filePath] = info
+		if filePath != dir {
+			found = append(found, filePath)
+		}
+		if !info.IsDir() || fw.watchedDirs[filePath] {
+			return nil
+		}
+		if filePath != root && !fw.watch.Recursive {
+			return filepath.SkipDir
+		}
+
+		if err := fw.notify.Add(filePath); err != nil {
+			if filePath == dir {
+				return err
+			}
+			fw.sendError(fmt.Errorf("failed to watch %s: %w", filePath, err))
+			return filepath.SkipDir
+		}
+		fw.watchedDirs[filePath] = true
 		return nil
 	})
 
-	if err != nil {
-		fw.errors <- fmt.Errorf("initial scan failed for %s: %w", path, err)
-		return
+	fw.recordScan(time.Since(start), len(fw.watchedDirs)-before)
+	return found, err
+}
+
+// unwatchTree drops the watches for dir and every directory below it. The
+// caller must hold fw.mu.
+func (fw *FileWatcher) unwatchTree(dir string) {
+	removed := 0
+	for watched := range fw.watchedDirs {
+		if watched == dir || strings.HasPrefix(watched, dir+string(filepath.Separator)) {
+			fw.notify.Remove(watched) // Fails harmlessly if the directory is already gone
+			delete(fw.watchedDirs, watched)
+			removed++
+		}
 	}
 
-	ticker := time.NewTicker(1 * time.Second) // Poll every second
-	defer ticker.Stop()
+	fw.statsMu.Lock()
+	fw.stats.PathsWatched -= removed
+	fw.statsMu.Unlock()
+}
+
+// run delivers fsnotify events and errors until the watcher is stopped.
+// fsnotify reports a rename as a Rename of the old name followed by a
+// Create of the new one, so a file's Rename waits up to renameWindow for
+// that Create to be reported together with it.
+func (fw *FileWatcher) run() {
+	defer fw.wg.Done()
 
+	var renamed *FileEvent
+	flush := time.NewTimer(renameWindow)
+	flush.Stop()
 	for {
 		select {
 		case <-fw.stopChan:
 			return
-		case <-ticker.C:
-			fw.checkForChanges(path, fileStates)
+		case <-flush.C:
+			if renamed != nil {
+				fw.sendEvent(*renamed)
+				renamed = nil
+			}
+		case event, ok := <-fw.notify.Events:
+			if !ok {
+				return
+			}
+			events := fw.checkForChanges(event)
+			if renamed != nil {
+				flush.Stop()
+				if len(events) > 0 && fw.isRenameOf(*renamed, events[0]) {
+					events[0].Op, events[0].From = Rename, renamed.Name
+				} else {
+					fw.sendEvent(*renamed)
+				}
+				renamed = nil
+			}
+			if len(events) == 1 && events[0].Op == Rename && events[0].From == "" && !events[0].IsDir {
+				renamed = &events[0]
+				flush.Reset(renameWindow)
+				continue
+			}
+			for _, event := range events {
+				fw.sendEvent(event)
+			}
+		case err, ok := <-fw.notify.Errors:
+			if !ok {
+				return
+			}
+			fw.sendError(fmt.Errorf("watch failed: %w", err))
 		}
 	}
 }
 
-// checkForChanges checks for file system changes by comparing current state with previous state
-func (fw *FileWatcher) checkForChanges(basePath string, fileStates map[string]os.FileInfo) {
-	currentStates := make(map[string]os.FileInfo)
-
-	// Scan current state
-	err := filepath.Walk(basePath, func(filePath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files we can't access
-		}
+// checkForChanges turns an fsnotify event into the FileEvents to report,
+// watching new directories (and reporting what's already in them, since
+// files may have been created before the watch was added) and forgetting
+// removed ones
+func (fw *FileWatcher) checkForChanges(event fsnotify.Event) []FileEvent {
+	fw.mu.Lock()
+	root, ok := fw.rootFor(event.Name)
+	if !ok {
+		fw.mu.Unlock()
+		return nil // Left over from a removed watch path
+	}
+	relPath, _ := filepath.Rel(root, event.Name)
+	if fw.ignored(root, relPath) {
+		fw.mu.Unlock()
+		return nil
+	}
 
-		// Skip ignored files
-		relPath, _ := filepath.Rel(basePath, filePath)
-		if utils.ShouldIgnore(relPath, fw.config.General.IgnorePatterns) {
-			if info.IsDir() {
-				return filepath.SkipDir
+	var found []string
+	isDir := fw.watchedDirs[event.Name]
+	switch {
+	case event.Has(fsnotify.Create):
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			isDir = true
+			var err error
+			found, err = fw.watchPath(root, event.Name)
+			if err != nil {
+				fw.sendError(fmt.Errorf("failed to watch %s: %w", event.Name, err))
 			}
-			return nil
 		}
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		fw.unwatchTree(event.Name)
+	}
+	fw.mu.Unlock()
 
-		currentStates[filePath] = info
-		return nil
-	})
+	now := time.Now()
+	events := []FileEvent{{Name: event.Name, Op: operation(event.Op), Time: now, IsDir: isDir}}
+	for _, name := range found {
+		events = append(events, FileEvent{Name: name, Op: Create, Time: now})
+	}
+	return events
+}
 
-	if err != nil {
-		fw.errors <- fmt.Errorf("scan failed for %s: %w", basePath, err)
-		return
+// isRenameOf reports whether created is the new name of the file renamed
+// reports gone: a file created in the same watch path
+func (fw *FileWatcher) isRenameOf(renamed, created FileEvent) bool {
+	if created.Op != Create || created.IsDir {
+		return false
 	}
+	fw.mu.RLock()
+	defer fw.mu.RUnlock()
+	from, ok := fw.rootFor(renamed.Name)
+	to, _ := fw.rootFor(created.Name)
+	return ok && from == to
+}
 
-	// Check for new or modified files
-	for filePath, currentInfo := range currentStates {
-		if previousInfo, exists := fileStates[filePath]; exists {
-			// File existed before, check if modified
-			if !currentInfo.ModTime().Equal(previousInfo.ModTime()) ||
-				currentInfo.Size() != previousInfo.Size() {
-				fw.sendEvent(FileEvent{
-					Name: filePath,
-					Op:   Write,
-					Time: time.Now(),
-				})
-			}
-		} else {
-			// New file
-			fw.sendEvent(FileEvent{
-				Name: filePath,
-				Op:   Create,
-				Time: time.Now(),
-			})
-		}
+// ignored reports whether relPath below root matches a general or
+// watch-specific ignore pattern or lies in an excluded folder. The caller
+// must hold fw.mu.
+func (fw *FileWatcher) ignored(root, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	if utils.ShouldIgnore(relPath, fw.config.General.IgnorePatterns) || utils.ShouldIgnore(relPath, fw.watch.IgnorePatterns) {
+		return true
 	}
+	_, excluded := utils.ExcludedFolder(root, relPath, fw.config.GetAdvanced().ExcludeFolders)
+	return excluded
+}
 
-	// Check for deleted files
-	for filePath := range fileStates {
-		if _, exists := currentStates[filePath]; !exists {
-			fw.sendEvent(FileEvent{
-				Name: filePath,
-				Op:   Remove,
-				Time: time.Now(),
-			})
+// rootFor returns the watch path that name lies in. The caller must hold
+// fw.mu.
+func (fw *FileWatcher) rootFor(name string) (string, bool) {
+	for root := range fw.watchPaths {
+		if name == root || strings.HasPrefix(name, root+string(filepath.Separator)) {
+			return root, true
 		}
 	}
+	return "", false
+}
+
+// operation maps an fsnotify op to an Operation. fsnotify may combine
+// several ops in one event; the most significant one wins.
+func operation(op fsnotify.Op) Operation {
+	switch {
+	case op.Has(fsnotify.Remove):
+		return Remove
+	case op.Has(fsnotify.Rename):
+		return Rename
+	case op.Has(fsnotify.Create):
+		return Create
+	case op.Has(fsnotify.Write):
+		return Write
+	default:
+		return Chmod
+	}
+}
 
-	// Update file states
-	for filePath, info := range currentStates {
-		fileStates[filePath] = info
+// sendError reports an error without blocking the event loop
+func (fw *FileWatcher) sendError(err error) {
+	select {
+	case fw.errors <- err:
+	default:
+		log.Printf("Warning: Error channel full, dropping error: %v", err)
 	}
+}
+
+// deliver hands queued events to the reader of the Events channel until the
+// watcher is stopped
+func (fw *FileWatcher) deliver() {
+	defer fw.wg.Done()
+
+	for {
+		event, ok := fw.queue.pop()
+		if !ok {
+			select {
+			case <-fw.queue.ready:
+				continue
+			case <-fw.stopChan:
+				return
+			}
+		}
 
-	// Remove deleted files from tracking
-	for filePath := range fileStates {
-		if _, exists := currentStates[filePath]; !exists {
-			delete(fileStates, filePath)
+		select {
+		case fw.events <- event:
+			fw.statsMu.Lock()
+			fw.stats.EventsEmitted++
+			fw.statsMu.Unlock()
+		case <-fw.stopChan:
+			return
 		}
 	}
 }
 
-// sendEvent sends an event with debouncing
+// sendEvent queues an event for delivery, with debouncing. While nobody
+// reads the Events channel, events for the same path are merged, and a
+// queue holding more than maxQueuedPaths paths is replaced by a change to
+// each watch root, so a burst costs a full sync rather than lost changes.
 func (fw *FileWatcher) sendEvent(event FileEvent) {
 	fw.mu.Lock()
 	defer fw.mu.Unlock()
 
 	// Debounce events for the same file
 	if lastTime, exists := fw.debounceMap[event.Name]; exists {
-		if time.Since(lastTime) < fw.debounce {
+		if time.Since(lastTime) < fw.watch.DebounceTime {
 			return // Skip this event due to debouncing
 		}
 	}
 
 	fw.debounceMap[event.Name] = event.Time
 
-	select {
-	case fw.events <- event:
-	default:
-		// Channel is full, drop the event
-		log.Printf("Warning: Event channel full, dropping event for %s", event.Name)
+	merged := fw.queue.push(event)
+	overflowed := fw.queue.overflowed()
+	if overflowed {
+		roots := make([]string, 0, len(fw.watchPaths))
+		for root := range fw.watchPaths {
+			roots = append(roots, root)
+		}
+		log.Printf("Warning: More than %d paths changed before events were read, reporting the watch paths instead", maxQueuedPaths)
+		fw.queue.collapse(roots, event.Time)
+	}
+
+	fw.statsMu.Lock()
+	defer fw.statsMu.Unlock()
+	if merged {
+		fw.stats.EventsCoalesced++
+	}
+	if overflowed {
+		fw.stats.Overflows++
 	}
 }
 
@@ -274,7 +504,6 @@ func (op Operation) String() string {
 // WatchConfig represents configuration for file watching
 type WatchConfig struct {
 	Recursive      bool          // Watch subdirectories recursively
-	PollInterval   time.Duration // Polling interval for changes
 	DebounceTime   time.Duration // Debounce time for events
 	IgnorePatterns []string      // Patterns to ignore
 }
@@ -283,7 +512,6 @@ type WatchConfig struct {
 func DefaultWatchConfig() WatchConfig {
 	return WatchConfig{
 		Recursive:    true,
-		PollInterval: 1 * time.Second,
 		DebounceTime: 2 * time.Second,
 		IgnorePatterns: []string{
 			".git/",
@@ -0,0 +1,250 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/svosadtsia/csync/internal/config"
+)
+
+func TestWatcherPairsRenames(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "old.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := NewFileWatcher(config.DefaultConfig(), DefaultWatchConfig())
+	if err != nil {
+		t.Fatalf("NewFileWatcher() failed: %v", err)
+	}
+	defer fw.Stop()
+	if err := fw.AddPath(dir); err != nil {
+		t.Fatalf("AddPath() failed: %v", err)
+	}
+
+	if err := os.Rename(filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-fw.Events():
+		if event.Op != Rename || filepath.Base(event.Name) != "new.txt" || filepath.Base(event.From) != "old.txt" {
+			t.Errorf("Expected one rename from old.txt to new.txt, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event for the rename")
+	}
+	select {
+	case event := <-fw.Events():
+		t.Errorf("Unexpected second event %+v", event)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWatcherQueuesBursts(t *testing.T) {
+	dir := t.TempDir()
+	watch := DefaultWatchConfig()
+	watch.DebounceTime = 0
+	fw, err := NewFileWatcher(config.DefaultConfig(), watch)
+	if err != nil {
+		t.Fatalf("NewFileWatcher() failed: %v", err)
+	}
+	defer fw.Stop()
+	if err := fw.AddPath(dir); err != nil {
+		t.Fatalf("AddPath() failed: %v", err)
+	}
+
+	// Nothing reads events during the burst, and none of them is lost
+	const files = 300
+	for i := range files {
+		name := filepath.Join(dir, fmt.Sprintf("%03d.txt", i))
+		for range 3 {
+			if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	seen := make(map[string]bool)
+	for len(seen) < files {
+		select {
+		case event := <-fw.Events():
+			seen[filepath.Base(event.Name)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Got events for %d of %d files", len(seen), files)
+		}
+	}
+	if stats := fw.Stats(); stats.EventsCoalesced == 0 || stats.Overflows != 0 {
+		t.Errorf("Expected repeated writes to be coalesced without overflowing, got %+v", stats)
+	}
+}
+
+func TestWatcherStopUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	for round := range 20 {
+		fw, err := NewFileWatcher(config.DefaultConfig(), DefaultWatchConfig())
+		if err != nil {
+			t.Fatalf("NewFileWatcher() failed: %v", err)
+		}
+		if err := fw.AddPath(dir); err != nil {
+			t.Fatalf("AddPath() failed: %v", err)
+		}
+
+		// New folders make the watcher walk and watch them, reporting
+		// errors, while files keep changing and paths keep being added
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				sub := filepath.Join(dir, fmt.Sprintf("r%d-%d", round, i))
+				os.Mkdir(sub, 0755)
+				os.WriteFile(filepath.Join(sub, "a.txt"), []byte("x"), 0644)
+				os.WriteFile(filepath.Join(dir, "top.txt"), []byte(sub), 0644)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				fw.AddPath(filepath.Join(dir, fmt.Sprintf("r%d-%d", round, i)))
+			}
+		}()
+		go func() {
+			for range fw.Events() {
+			}
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		var stops sync.WaitGroup
+		for range 2 {
+			stops.Add(1)
+			go func() {
+				defer stops.Done()
+				fw.Stop()
+			}()
+		}
+		stops.Wait()
+		close(stop)
+		wg.Wait()
+
+		if err := fw.AddPath(dir); err == nil {
+			t.Error("Expected AddPath() to fail once the watcher is stopped")
+		}
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	q := newEventQueue()
+	now := time.Now()
+
+	// A newer event for a queued path replaces it, keeping its place in line
+	q.push(FileEvent{Name: "a", Op: Create, Time: now})
+	q.push(FileEvent{Name: "b", Op: Write, Time: now})
+	if merged := q.push(FileEvent{Name: "a", Op: Write, Time: now}); !merged {
+		t.Error("Expected the second event for a to be merged")
+	}
+
+	// A merged rename keeps the name the file had
+	q.push(FileEvent{Name: "c", From: "old-c", Op: Rename, Time: now})
+	q.push(FileEvent{Name: "c", Op: Write, Time: now})
+
+	// A renamed folder stays a rename, as it calls for a full sync
+	q.push(FileEvent{Name: "d", From: "old-d", Op: Rename, IsDir: true, Time: now})
+	q.push(FileEvent{Name: "d", Op: Write, IsDir: true, Time: now})
+
+	want := []FileEvent{
+		{Name: "a", Op: Write, Time: now},
+		{Name: "b", Op: Write, Time: now},
+		{Name: "c", From: "old-c", Op: Write, Time: now},
+		{Name: "d", From: "old-d", Op: Rename, IsDir: true, Time: now},
+	}
+	for _, w := range want {
+		got, ok := q.pop()
+		if !ok || got != w {
+			t.Errorf("Expected %+v, got %+v", w, got)
+		}
+	}
+	if event, ok := q.pop(); ok {
+		t.Errorf("Expected an empty queue, got %+v", event)
+	}
+}
+
+func TestEventQueueCollapse(t *testing.T) {
+	q := newEventQueue()
+	now := time.Now()
+	for i := range maxQueuedPaths {
+		q.push(FileEvent{Name: fmt.Sprintf("f%d", i), Op: Write, Time: now})
+	}
+	if q.overflowed() {
+		t.Fatalf("Expected %d paths to fit the queue", maxQueuedPaths)
+	}
+	q.push(FileEvent{Name: "one-more", Op: Write, Time: now})
+	if !q.overflowed() {
+		t.Fatal("Expected the queue to overflow")
+	}
+
+	// Everything queued is replaced by a change to each root
+	q.collapse([]string{"/src", "/docs"}, now)
+	for _, root := range []string{"/src", "/docs"} {
+		event, ok := q.pop()
+		if !ok || event.Name != root || event.Op != Write || !event.IsDir {
+			t.Errorf("Expected a change to %s, got %+v", root, event)
+		}
+	}
+	if event, ok := q.pop(); ok {
+		t.Errorf("Expected only the roots, got %+v", event)
+	}
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	dir := t.TempDir()
+	watch := DefaultWatchConfig()
+	watch.DebounceTime = 0
+	fw, err := NewFileWatcher(config.DefaultConfig(), watch)
+	if err != nil {
+		t.Fatalf("NewFileWatcher() failed: %v", err)
+	}
+	defer fw.Stop()
+	if err := fw.AddPath(dir); err != nil {
+		t.Fatalf("AddPath() failed: %v", err)
+	}
+
+	// More changes than the queue holds, with nobody reading events
+	now := time.Now()
+	for i := range maxQueuedPaths + 2 {
+		fw.sendEvent(FileEvent{Name: filepath.Join(dir, fmt.Sprintf("%d.txt", i)), Op: Write, Time: now})
+	}
+	if stats := fw.Stats(); stats.Overflows != 1 {
+		t.Fatalf("Expected 1 overflow, got %+v", stats)
+	}
+
+	// At most one file event was already on its way; then the root follows
+	for range 2 {
+		select {
+		case event := <-fw.Events():
+			if event.Name == dir {
+				if !event.IsDir {
+					t.Errorf("Expected the watch root as a folder, got %+v", event)
+				}
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("No event for the watch root")
+		}
+	}
+	t.Error("Expected a change to the watch root")
+}
@@ -67,3 +67,58 @@ func FilterPaths(paths []string, excludePatterns, includePatterns []string) []st
 
 	return filtered
 }
+
+// ExcludedFolder reports whether relPath, a path below root, is or lies in
+// one of folders, and returns the entry that matched. A plain folder name
+// matches at any depth; a path matches from root. An absolute path inside
+// root is taken relative to it, any other path starting with a slash is
+// relative to root already.
+func ExcludedFolder(root, relPath string, folders []string) (string, bool) {
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+	if relPath == "" || relPath == "." || len(folders) == 0 {
+		return "", false
+	}
+	segments := strings.Split(relPath, "/")
+
+	for _, entry := range folders {
+		folder := entry
+		if filepath.IsAbs(folder) {
+			if rel, ok := relativeTo(root, folder); ok {
+				folder = rel
+			}
+		}
+		folder = strings.Trim(filepath.ToSlash(folder), "/")
+		if folder == "" || folder == "." {
+			continue
+		}
+
+		if strings.Contains(folder, "/") {
+			if relPath == folder || strings.HasPrefix(relPath, folder+"/") {
+				return entry, true
+			}
+			continue
+		}
+		for _, segment := range segments {
+			if segment == folder {
+				return entry, true
+			}
+		}
+	}
+	return "", false
+}
+
+// relativeTo returns path relative to root if it lies inside it
+func relativeTo(root, path string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absRoot, filepath.Clean(path))
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return rel, true
+}
@@ -1,16 +1,37 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Level is a log severity; messages below the configured level are dropped
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelVerbose
+	LevelInfo
+	LevelWarn
+	LevelError
 )
 
 var (
+	minLevel      = LevelInfo
 	verboseMode   bool
 	debugMode     bool
+	jsonMode      bool
 	cleanLogger   *log.Logger
 	verboseLogger *log.Logger
+	jsonLogger    *log.Logger
+	output        io.Writer = os.Stderr
 )
 
 func init() {
@@ -18,6 +39,8 @@ func init() {
 	cleanLogger = log.New(os.Stderr, "", log.LstdFlags)
 	// Verbose logger with file/line info for detailed output
 	verboseLogger = log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile)
+	// JSON logger writes pre-encoded lines
+	jsonLogger = log.New(os.Stderr, "", 0)
 }
 
 // SetVerbose sets the verbose logging mode
@@ -30,32 +53,153 @@ func SetDebug(d bool) {
 	debugMode = d
 }
 
-// LogInfo logs an info message (always shown)
+// SetLevel drops messages below level: "debug", "verbose", "info" (the
+// default), "warn" or "error". SetVerbose and SetDebug still show verbose
+// and debug messages.
+func SetLevel(level string) error {
+	switch strings.ToLower(level) {
+	case "debug":
+		minLevel = LevelDebug
+	case "verbose":
+		minLevel = LevelVerbose
+	case "", "info":
+		minLevel = LevelInfo
+	case "warn", "warning":
+		minLevel = LevelWarn
+	case "error":
+		minLevel = LevelError
+	default:
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+	return nil
+}
+
+// enabled reports whether messages at level are logged
+func enabled(level Level) bool {
+	switch {
+	case level >= minLevel:
+		return true
+	case level == LevelVerbose:
+		return verboseMode || debugMode
+	case level == LevelDebug:
+		return debugMode
+	default:
+		return false
+	}
+}
+
+// SetFormat selects the log line format: "text" (the default) or "json",
+// which writes one JSON object per line with level, ts and msg fields
+func SetFormat(format string) {
+	jsonMode = format == "json"
+}
+
+// SetOutput redirects all log output to w
+func SetOutput(w io.Writer) {
+	cleanLogger.SetOutput(w)
+	verboseLogger.SetOutput(w)
+	jsonLogger.SetOutput(w)
+	output = w
+	progressBars = isTerminal(w)
+}
+
+// JSONWriter returns a writer that logs every line written to it as a JSON
+// object at level, for redirecting the standard log package in JSON mode
+func JSONWriter(level string) io.Writer {
+	return jsonLineWriter{level: level}
+}
+
+// jsonLineWriter logs each write as one JSON log line
+type jsonLineWriter struct {
+	level string
+}
+
+func (w jsonLineWriter) Write(p []byte) (int, error) {
+	logJSON(w.level, strings.TrimSuffix(string(p), "\n"), -1)
+	return len(p), nil
+}
+
+// logJSON writes one JSON log line. In verbose mode the caller's file and
+// line are added, skip frames above logJSON's caller; -1 leaves them out.
+func logJSON(level, msg string, skip int) {
+	entry := struct {
+		Level  string `json:"level"`
+		TS     string `json:"ts"`
+		Msg    string `json:"msg"`
+		Caller string `json:"caller,omitempty"`
+	}{Level: level, TS: time.Now().Format(time.RFC3339Nano), Msg: msg}
+
+	if verboseMode && skip >= 0 {
+		if _, file, line, ok := runtime.Caller(skip + 2); ok {
+			entry.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	jsonLogger.Print(string(data))
+}
+
+// LogInfo logs an info message (shown unless the level is above info)
 func LogInfo(format string, args ...interface{}) {
-	if verboseMode {
+	if !enabled(LevelInfo) {
+		return
+	}
+	if jsonMode {
+		logJSON("info", fmt.Sprintf(format, args...), 0)
+	} else if verboseMode {
 		verboseLogger.Printf(format, args...)
 	} else {
 		cleanLogger.Printf(format, args...)
 	}
 }
 
-// LogVerbose logs a verbose message (only shown in verbose mode)
+// LogVerbose logs a verbose message (only shown in verbose mode or at the
+// verbose or debug level)
 func LogVerbose(format string, args ...interface{}) {
-	if verboseMode {
+	if !enabled(LevelVerbose) {
+		return
+	}
+	if jsonMode {
+		logJSON("verbose", fmt.Sprintf(format, args...), 0)
+	} else {
 		verboseLogger.Printf("[VERBOSE] "+format, args...)
 	}
 }
 
-// LogDebug logs a debug message (only shown in debug mode)
+// LogDebug logs a debug message (only shown in debug mode or at the debug level)
 func LogDebug(format string, args ...interface{}) {
-	if debugMode {
+	if !enabled(LevelDebug) {
+		return
+	}
+	if jsonMode {
+		logJSON("debug", fmt.Sprintf(format, args...), 0)
+	} else {
 		verboseLogger.Printf("[DEBUG] "+format, args...)
 	}
 }
 
+// LogWarn logs a warning (shown unless the level is error)
+func LogWarn(format string, args ...interface{}) {
+	if !enabled(LevelWarn) {
+		return
+	}
+	if jsonMode {
+		logJSON("warn", fmt.Sprintf(format, args...), 0)
+	} else if verboseMode {
+		verboseLogger.Printf("[WARN] "+format, args...)
+	} else {
+		cleanLogger.Printf("Warning: "+format, args...)
+	}
+}
+
 // LogError logs an error message (always shown)
 func LogError(format string, args ...interface{}) {
-	if verboseMode {
+	if jsonMode {
+		logJSON("error", fmt.Sprintf(format, args...), 0)
+	} else if verboseMode {
 		verboseLogger.Printf("[ERROR] "+format, args...)
 	} else {
 		cleanLogger.Printf("Error: "+format, args...)
@@ -64,5 +208,5 @@ func LogError(format string, args ...interface{}) {
 
 // Print logs a simple message without timestamp (for clean output)
 func Print(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	fmt.Fprintf(output, format+"\n", args...)
 }
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	progressStep  = 10              // Percent between progress lines
+	progressEvery = 5 * time.Second // Longest gap between progress lines
+	progressQuiet = time.Second     // Transfers finishing sooner report nothing
+	barRedraw     = 100 * time.Millisecond
+	barWidth      = 30
+)
+
+// progressBars makes progress render as an in-place bar; it is on when
+// logging to a terminal
+var progressBars = isTerminal(os.Stderr)
+
+// SetProgressBars selects in-place progress bars (for interactive runs) or
+// periodic progress lines, which JSON mode writes as structured records
+func SetProgressBars(enabled bool) {
+	progressBars = enabled
+}
+
+// isTerminal reports whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Progress reports how far a transfer of total bytes has got while it
+// runs. Lines are throttled to one per progressStep percent or
+// progressEvery, and transfers shorter than progressQuiet stay silent.
+type Progress struct {
+	name  string
+	total int64
+	start time.Time
+
+	mu         sync.Mutex
+	done       int64
+	reported   time.Time // When progress was last shown
+	reportedAt int64     // Percent last shown
+	drawn      bool      // A bar is on screen
+}
+
+// NewProgress starts tracking the transfer of name, total bytes long
+func NewProgress(name string, total int64) *Progress {
+	now := time.Now()
+	return &Progress{name: name, total: total, start: now, reported: now}
+}
+
+// Reader returns r counting the bytes read through it. Seeking moves the
+// count with it, so a reader that is rewound and re-sent counts once.
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	reader := &progressReader{r: r, progress: p}
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		return &progressReadSeeker{progressReader: reader, seeker: seeker}
+	}
+	return reader
+}
+
+// Skip counts n bytes that were transferred before, e.g. by an interrupted
+// upload being resumed
+func (p *Progress) Skip(n int64) {
+	p.add(n)
+}
+
+// Done ends the progress display
+func (p *Progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drawn {
+		p.draw()
+		fmt.Fprintln(output)
+		p.drawn = false
+	}
+}
+
+// add counts n more (or, when negative, fewer) bytes and reports progress
+// if it is due
+func (p *Progress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done = max(0, p.done+n)
+	percent := int64(100)
+	if p.total > 0 {
+		percent = min(100, p.done*100/p.total)
+	}
+	if percent < p.reportedAt {
+		p.reportedAt = percent // Rewound to send again
+	}
+
+	now := time.Now()
+	if now.Sub(p.start) < progressQuiet || !enabled(LevelInfo) {
+		return
+	}
+
+	if progressBars && !jsonMode {
+		if p.drawn && now.Sub(p.reported) < barRedraw {
+			return
+		}
+		p.reported = now
+		p.draw()
+		p.drawn = true
+		return
+	}
+
+	if percent < p.reportedAt+progressStep && now.Sub(p.reported) < progressEvery {
+		return
+	}
+	p.reported, p.reportedAt = now, percent
+	p.logLine(percent, now)
+}
+
+// draw renders the bar over the current terminal line
+func (p *Progress) draw() {
+	percent := int64(100)
+	if p.total > 0 {
+		percent = min(100, p.done*100/p.total)
+	}
+	filled := int(percent) * barWidth / 100
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	fmt.Fprintf(output, "\r[%s] %3d%% %s of %s %s", bar, percent, FormatBytes(p.done), FormatBytes(p.total), p.name)
+}
+
+// logLine logs a progress line, or a structured record in JSON mode
+func (p *Progress) logLine(percent int64, now time.Time) {
+	elapsed := now.Sub(p.start)
+	rate := float64(p.done) / elapsed.Seconds()
+
+	if jsonMode {
+		record := struct {
+			Level   string  `json:"level"`
+			TS      string  `json:"ts"`
+			Msg     string  `json:"msg"`
+			File    string  `json:"file"`
+			Bytes   int64   `json:"bytes"`
+			Total   int64   `json:"total"`
+			Percent int64   `json:"percent"`
+			Rate    float64 `json:"bytes_per_sec"`
+		}{"info", now.Format(time.RFC3339Nano), "upload progress", p.name, p.done, p.total, percent, rate}
+		if data, err := json.Marshal(record); err == nil {
+			jsonLogger.Print(string(data))
+		}
+		return
+	}
+
+	cleanLogger.Printf("… %s %d%% (%s of %s, %s/s)", p.name, percent, FormatBytes(p.done), FormatBytes(p.total), FormatBytes(int64(rate)))
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	progress *Progress
+	pos      int64
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.pos += int64(n)
+		r.progress.add(int64(n))
+	}
+	return n, err
+}
+
+// progressReadSeeker is a progressReader whose underlying reader can seek
+type progressReadSeeker struct {
+	*progressReader
+	seeker io.Seeker
+}
+
+func (r *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.seeker.Seek(offset, whence)
+	if err == nil {
+		r.progress.add(pos - r.pos)
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// FormatBytes formats a byte count with a binary unit, e.g. "1.5 MiB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}